@@ -0,0 +1,206 @@
+// Service definition for programmatic metric queries (--grpc.bind), mirrors the probe endpoints and the json probe
+// format (format=json). Go code is generated with protoc-gen-go and protoc-gen-go-grpc (paths=source_relative).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: api/v1/exporter.proto
+
+package apiv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MetricsExporter_QueryResourceMetrics_FullMethodName = "/azuremetricsexporter.v1.MetricsExporter/QueryResourceMetrics"
+	MetricsExporter_ListResources_FullMethodName        = "/azuremetricsexporter.v1.MetricsExporter/ListResources"
+	MetricsExporter_RunResourceGraph_FullMethodName     = "/azuremetricsexporter.v1.MetricsExporter/RunResourceGraph"
+)
+
+// MetricsExporterClient is the client API for MetricsExporter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MetricsExporterClient interface {
+	// QueryResourceMetrics returns the metrics of resources (/probe/metrics/resource and /probe/metrics/list)
+	QueryResourceMetrics(ctx context.Context, in *QueryResourceMetricsRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+	// ListResources returns the resources of the subscriptions (service discovery, /discovery/targets?groupBy=resource)
+	ListResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error)
+	// RunResourceGraph returns the metrics of the resources of a ResourceGraph query (/probe/metrics/resourcegraph)
+	RunResourceGraph(ctx context.Context, in *RunResourceGraphRequest, opts ...grpc.CallOption) (*MetricsResponse, error)
+}
+
+type metricsExporterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMetricsExporterClient(cc grpc.ClientConnInterface) MetricsExporterClient {
+	return &metricsExporterClient{cc}
+}
+
+func (c *metricsExporterClient) QueryResourceMetrics(ctx context.Context, in *QueryResourceMetricsRequest, opts ...grpc.CallOption) (*MetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricsResponse)
+	err := c.cc.Invoke(ctx, MetricsExporter_QueryResourceMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsExporterClient) ListResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResourcesResponse)
+	err := c.cc.Invoke(ctx, MetricsExporter_ListResources_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsExporterClient) RunResourceGraph(ctx context.Context, in *RunResourceGraphRequest, opts ...grpc.CallOption) (*MetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricsResponse)
+	err := c.cc.Invoke(ctx, MetricsExporter_RunResourceGraph_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MetricsExporterServer is the server API for MetricsExporter service.
+// All implementations must embed UnimplementedMetricsExporterServer
+// for forward compatibility.
+type MetricsExporterServer interface {
+	// QueryResourceMetrics returns the metrics of resources (/probe/metrics/resource and /probe/metrics/list)
+	QueryResourceMetrics(context.Context, *QueryResourceMetricsRequest) (*MetricsResponse, error)
+	// ListResources returns the resources of the subscriptions (service discovery, /discovery/targets?groupBy=resource)
+	ListResources(context.Context, *ListResourcesRequest) (*ListResourcesResponse, error)
+	// RunResourceGraph returns the metrics of the resources of a ResourceGraph query (/probe/metrics/resourcegraph)
+	RunResourceGraph(context.Context, *RunResourceGraphRequest) (*MetricsResponse, error)
+	mustEmbedUnimplementedMetricsExporterServer()
+}
+
+// UnimplementedMetricsExporterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMetricsExporterServer struct{}
+
+func (UnimplementedMetricsExporterServer) QueryResourceMetrics(context.Context, *QueryResourceMetricsRequest) (*MetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryResourceMetrics not implemented")
+}
+func (UnimplementedMetricsExporterServer) ListResources(context.Context, *ListResourcesRequest) (*ListResourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListResources not implemented")
+}
+func (UnimplementedMetricsExporterServer) RunResourceGraph(context.Context, *RunResourceGraphRequest) (*MetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunResourceGraph not implemented")
+}
+func (UnimplementedMetricsExporterServer) mustEmbedUnimplementedMetricsExporterServer() {}
+func (UnimplementedMetricsExporterServer) testEmbeddedByValue()                         {}
+
+// UnsafeMetricsExporterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MetricsExporterServer will
+// result in compilation errors.
+type UnsafeMetricsExporterServer interface {
+	mustEmbedUnimplementedMetricsExporterServer()
+}
+
+func RegisterMetricsExporterServer(s grpc.ServiceRegistrar, srv MetricsExporterServer) {
+	// If the following call pancis, it indicates UnimplementedMetricsExporterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MetricsExporter_ServiceDesc, srv)
+}
+
+func _MetricsExporter_QueryResourceMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryResourceMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsExporterServer).QueryResourceMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsExporter_QueryResourceMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsExporterServer).QueryResourceMetrics(ctx, req.(*QueryResourceMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsExporter_ListResources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListResourcesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsExporterServer).ListResources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsExporter_ListResources_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsExporterServer).ListResources(ctx, req.(*ListResourcesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsExporter_RunResourceGraph_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunResourceGraphRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsExporterServer).RunResourceGraph(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsExporter_RunResourceGraph_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsExporterServer).RunResourceGraph(ctx, req.(*RunResourceGraphRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MetricsExporter_ServiceDesc is the grpc.ServiceDesc for MetricsExporter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MetricsExporter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "azuremetricsexporter.v1.MetricsExporter",
+	HandlerType: (*MetricsExporterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "QueryResourceMetrics",
+			Handler:    _MetricsExporter_QueryResourceMetrics_Handler,
+		},
+		{
+			MethodName: "ListResources",
+			Handler:    _MetricsExporter_ListResources_Handler,
+		},
+		{
+			MethodName: "RunResourceGraph",
+			Handler:    _MetricsExporter_RunResourceGraph_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/v1/exporter.proto",
+}
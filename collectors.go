@@ -0,0 +1,6 @@
+package main
+
+// custom collectors compiled into the exporter, served as /probe/collector/<name> (see metrics.RegisterCollector)
+import (
+	_ "github.com/webdevops/azure-metrics-exporter/collectors/resourcegroups"
+)
@@ -0,0 +1,113 @@
+// Package resourcegroups is an example collector (/probe/collector/resourcegroups) exporting the resource groups
+// of the subscriptions, custom collectors are compiled in with a blank import (see collectors.go)
+package resourcegroups
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	Collector struct{}
+
+	resourceGroup struct {
+		Name              string `json:"name"`
+		Location          string `json:"location"`
+		ProvisioningState string `json:"provisioningState"`
+	}
+)
+
+func init() {
+	metrics.RegisterCollector(&Collector{})
+}
+
+func (c *Collector) Name() string {
+	return "resourcegroups"
+}
+
+// Collect exports azurerm_resourcegroup_info for the resource groups of the subscriptions,
+// the resource groups of each subscription are cached with parameter cache
+func (c *Collector) Collect(ctx context.Context, request *metrics.CollectorRequest) error {
+	if len(request.Subscriptions) == 0 {
+		return fmt.Errorf(`parameter "subscription" is missing`)
+	}
+
+	resourceGroupInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_resourcegroup_info",
+			Help: "Azure resource group",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceGroup",
+			"location",
+			"provisioningState",
+		},
+	)
+	request.Registry.MustRegister(resourceGroupInfo)
+
+	for _, subscriptionId := range request.Subscriptions {
+		resourceGroups, err := c.listResourceGroups(ctx, request, subscriptionId)
+		if err != nil {
+			return err
+		}
+
+		for _, resourceGroup := range resourceGroups {
+			resourceGroupInfo.With(prometheus.Labels{
+				"subscriptionID":    subscriptionId,
+				"resourceGroup":     strings.ToLower(resourceGroup.Name),
+				"location":          resourceGroup.Location,
+				"provisioningState": resourceGroup.ProvisioningState,
+			}).Set(1)
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) listResourceGroups(ctx context.Context, request *metrics.CollectorRequest, subscriptionId string) ([]resourceGroup, error) {
+	ret := []resourceGroup{}
+	if data, cached := request.Cache.Get(subscriptionId); cached && request.CacheDuration != nil && json.Unmarshal(data, &ret) == nil {
+		return ret, nil
+	}
+
+	client, err := armresources.NewResourceGroupsClient(subscriptionId, request.Credential, request.ClientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to list resource groups of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range result.Value {
+			group := resourceGroup{
+				Name:     to.String(row.Name),
+				Location: to.String(row.Location),
+			}
+			if row.Properties != nil {
+				group.ProvisioningState = to.String(row.Properties.ProvisioningState)
+			}
+			ret = append(ret, group)
+		}
+	}
+
+	if request.CacheDuration != nil {
+		if data, err := json.Marshal(ret); err == nil {
+			request.Cache.Set(subscriptionId, data, *request.CacheDuration)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AccessLogFormatJson   = "json"
+	AccessLogFormatCommon = "common"
+
+	accessLogRedacted = "REDACTED"
+)
+
+type (
+	// accessLogger writes one line per http request (json or common log format) to stdout
+	accessLogger struct {
+		format string
+		redact map[string]bool
+
+		lock   sync.Mutex
+		output io.Writer
+	}
+
+	// accessLogResponseWriter captures status code and response size for the access log
+	accessLogResponseWriter struct {
+		http.ResponseWriter
+		statusCode int
+		size       int
+	}
+
+	accessLogEntry struct {
+		Time       string  `json:"time"`
+		RemoteAddr string  `json:"remoteAddr"`
+		Method     string  `json:"method"`
+		Path       string  `json:"path"`
+		Query      string  `json:"query,omitempty"`
+		Status     int     `json:"status"`
+		Size       int     `json:"size"`
+		Duration   float64 `json:"duration"`
+		UserAgent  string  `json:"userAgent,omitempty"`
+	}
+)
+
+// buildAccessLogger returns the access logger for the http server, nil if access logging is disabled
+func buildAccessLogger() (*accessLogger, error) {
+	format := strings.ToLower(Opts.Server.AccessLog.Format)
+	switch format {
+	case "":
+		return nil, nil
+	case AccessLogFormatJson, AccessLogFormatCommon:
+	default:
+		return nil, fmt.Errorf(`access log format "%s" not supported (%s, %s)`, Opts.Server.AccessLog.Format, AccessLogFormatJson, AccessLogFormatCommon)
+	}
+
+	accessLog := accessLogger{
+		format: format,
+		redact: map[string]bool{},
+		output: os.Stdout,
+	}
+	for _, name := range Opts.Server.AccessLog.Redact {
+		accessLog.redact[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	return &accessLog, nil
+}
+
+func (w *accessLogResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	size, err := w.ResponseWriter.Write(b)
+	w.size += size
+	return size, err
+}
+
+// Unwrap returns the original response writer (http.ResponseController, eg. flushing of probe responses)
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Handler wraps the handler and logs every request after it is finished
+func (a *accessLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+
+		rw := &accessLogResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		if rw.statusCode == 0 {
+			rw.statusCode = http.StatusOK
+		}
+
+		remoteAddr := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			remoteAddr = host
+		}
+
+		a.write(accessLogEntry{
+			Time:       startTime.Format(time.RFC3339),
+			RemoteAddr: remoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      a.redactQuery(r.URL.Query()),
+			Status:     rw.statusCode,
+			Size:       rw.size,
+			Duration:   time.Since(startTime).Seconds(),
+			UserAgent:  r.UserAgent(),
+		})
+	})
+}
+
+// redactQuery returns the encoded query with the values of sensitive parameters replaced
+func (a *accessLogger) redactQuery(query url.Values) string {
+	for name := range query {
+		if a.redact[strings.ToLower(name)] {
+			query[name] = []string{accessLogRedacted}
+		}
+	}
+	return query.Encode()
+}
+
+func (a *accessLogger) write(entry accessLogEntry) {
+	var line []byte
+	switch a.format {
+	case AccessLogFormatJson:
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			logger.Error(err)
+			return
+		}
+	case AccessLogFormatCommon:
+		requestUri := entry.Path
+		if entry.Query != "" {
+			requestUri += "?" + entry.Query
+		}
+		timestamp, _ := time.Parse(time.RFC3339, entry.Time)
+		line = []byte(fmt.Sprintf(
+			`%s - - [%s] "%s %s HTTP/1.1" %d %d %.3f`,
+			entry.RemoteAddr,
+			timestamp.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.Method,
+			requestUri,
+			entry.Status,
+			entry.Size,
+			entry.Duration,
+		))
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.output.Write(append(line, '\n')); err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	commonAzidentity "github.com/webdevops/go-common/azuresdk/azidentity"
+	"github.com/webdevops/go-common/azuresdk/cloudconfig"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	AzureAuthChainDefault          = "default"
+	AzureAuthChainEnvironment      = "env"
+	AzureAuthChainWorkloadIdentity = "workloadidentity"
+	AzureAuthChainManagedIdentity  = "managedidentity"
+	AzureAuthChainCli              = "cli"
+)
+
+var (
+	AzureCredential azcore.TokenCredential
+
+	// default credential (AZURE_* env) using the http settings (--azure.http.*)
+	azureDefaultCredential azcore.TokenCredential
+
+	// named credentials for probe requests (?credential=name)
+	azureCredentialRegistry     map[string]azcore.TokenCredential
+	azureCredentialRegistryLock sync.RWMutex
+
+	// credentials for probe requests of other tenants (?tenant=id), named service principal credentials
+	// of the tenant
+	azureTenantCredentials     map[string]azcore.TokenCredential
+	azureTenantCredentialsLock sync.RWMutex
+
+	// credentials of the multi-tenant service principal (--azure.auth.multitenant.*) per tenant, created on first use
+	azureMultiTenantCredentials     = map[string]*multiTenantCredential{}
+	azureMultiTenantCredentialsLock sync.Mutex
+
+	subscriptionDiscovery *metrics.SubscriptionDiscovery
+
+	errNoSubscriptionsFound = errors.New("no subscriptions found matching subscriptionFilter and subscriptionTag")
+)
+
+type (
+	multiTenantCredential struct {
+		cred     azcore.TokenCredential
+		lastUsed time.Time
+	}
+)
+
+// initAzureCustomCloud creates the Azure client for the custom cloud definition (--azure.cloud-config),
+// also sets metrics batch and log analytics endpoints if defined
+func initAzureCustomCloud() {
+	cloudConfig, err := config.LoadCloudConfigFile(Opts.Azure.CloudConfig)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Infof(`using custom cloud "%s" from "%s"`, cloudConfig.Name, Opts.Azure.CloudConfig)
+
+	AzureClient = armclient.NewArmClient(
+		cloudconfig.CloudEnvironment{
+			Name:          cloudconfig.CloudName(cloudConfig.Name),
+			Configuration: cloudConfig.AzureCloudConfiguration(),
+		},
+		logger,
+	)
+	if Opts.Cache.AzureTtl > 0 {
+		AzureClient.SetCacheTtl(Opts.Cache.AzureTtl)
+	}
+
+	if cloudConfig.MetricsBatch != nil {
+		Opts.Azure.MetricsBatch.Endpoint = cloudConfig.MetricsBatch.Endpoint
+		if cloudConfig.MetricsBatch.Audience != "" {
+			Opts.Azure.MetricsBatch.Audience = audienceToScope(cloudConfig.MetricsBatch.Audience)
+		}
+	}
+
+	if cloudConfig.LogAnalytics != nil {
+		Opts.Azure.LogAnalytics.Endpoint = cloudConfig.LogAnalytics.Endpoint
+		if cloudConfig.LogAnalytics.Audience != "" {
+			Opts.Azure.LogAnalytics.Audience = audienceToScope(cloudConfig.LogAnalytics.Audience)
+		}
+	}
+
+	if cloudConfig.AppInsights != nil {
+		Opts.Azure.AppInsights.Endpoint = cloudConfig.AppInsights.Endpoint
+		if cloudConfig.AppInsights.Audience != "" {
+			Opts.Azure.AppInsights.Audience = audienceToScope(cloudConfig.AppInsights.Audience)
+		}
+	}
+
+	if cloudConfig.KeyVault != nil {
+		Opts.Azure.KeyVault.Audience = audienceToScope(cloudConfig.KeyVault.Audience)
+	}
+
+	if Opts.Azure.PortalUrl == "" {
+		Opts.Azure.PortalUrl = cloudConfig.PortalUrl
+	}
+}
+
+// initAzurePortalUrl detects the Azure portal url (resource links) from the resource manager endpoint if not set
+func initAzurePortalUrl() {
+	if Opts.Azure.PortalUrl != "" {
+		return
+	}
+
+	resourceManager := AzureClient.NewArmClientOptions().Cloud.Services[cloud.ResourceManager]
+	Opts.Azure.PortalUrl = metrics.PortalUrlFromResourceManager(resourceManager.Endpoint)
+	if Opts.Azure.PortalUrl == "" {
+		logger.Warnf(`unable to detect Azure portal url for resource manager "%s", portal links are disabled (set --azure.portal-url)`, resourceManager.Endpoint)
+	}
+}
+
+// audienceToScope returns the token scope for an audience (appends /.default)
+func audienceToScope(audience string) string {
+	if strings.HasSuffix(audience, "/.default") {
+		return audience
+	}
+	return strings.TrimRight(audience, "/") + "/.default"
+}
+
+// logAzureEndpoints logs the effective endpoints of the Azure cloud
+func logAzureEndpoints() {
+	cloudConfig := AzureClient.NewArmClientOptions().Cloud
+	resourceManager := cloudConfig.Services[cloud.ResourceManager]
+	logger.Infof(
+		"using Azure endpoints: authority=%s, resourceManager=%s (audience %s), metricsBatch=%s (scope %s), logAnalytics=%s (scope %s), appInsights=%s (scope %s), portal=%s",
+		cloudConfig.ActiveDirectoryAuthorityHost,
+		resourceManager.Endpoint,
+		resourceManager.Audience,
+		Opts.Azure.MetricsBatch.Endpoint,
+		Opts.Azure.MetricsBatch.Audience,
+		Opts.Azure.LogAnalytics.Endpoint,
+		Opts.Azure.LogAnalytics.Audience,
+		Opts.Azure.AppInsights.Endpoint,
+		Opts.Azure.AppInsights.Audience,
+		Opts.Azure.PortalUrl,
+	)
+}
+
+// initAzureDefaultCredential builds the default credential (AZURE_* env) with the http transport of the Azure clients,
+// the credential of the Azure client itself is only used for connecting at startup
+func initAzureDefaultCredential() {
+	cred, err := commonAzidentity.NewAzDefaultCredential(&metrics.NewArmClientOptions(AzureClient).ClientOptions)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	azureDefaultCredential = cred
+}
+
+// initAzureCredential builds the credential used by all exporter Azure clients based on --azure.auth.chain
+func initAzureCredential() {
+	if Opts.Azure.Auth.ClientSecretFile != "" || Opts.Azure.Auth.ClientCertificateFile != "" {
+		cred, err := newFileCredential(
+			AzureAuthChainDefault,
+			os.Getenv("AZURE_TENANT_ID"),
+			os.Getenv("AZURE_CLIENT_ID"),
+			Opts.Azure.Auth.ClientSecretFile,
+			Opts.Azure.Auth.ClientCertificateFile,
+			Opts.Azure.Auth.ClientCertificatePasswordFile,
+			Opts.Azure.Auth.SecretFileReload,
+		)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		logger.Infof("using service principal with client secret or certificate file (reload every %s)", Opts.Azure.Auth.SecretFileReload.String())
+		AzureCredential = cred
+		return
+	}
+
+	cred, err := buildAzureCredential(Opts.Azure.Auth.Chain, Opts.Azure.Auth.ManagedIdentityClientId)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	AzureCredential = cred
+}
+
+func buildAzureCredential(chain []string, managedIdentityClientId string) (azcore.TokenCredential, error) {
+	clientOpts := metrics.NewArmClientOptions(AzureClient).ClientOptions
+
+	sources := []azcore.TokenCredential{}
+	for _, name := range chain {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		switch name {
+		case AzureAuthChainDefault:
+			sources = append(sources, azureDefaultCredential)
+		case AzureAuthChainEnvironment:
+			cred, err := azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+				ClientOptions: clientOpts,
+			})
+			if err != nil {
+				return nil, fmt.Errorf(`unable to init credential "%s": %w`, name, err)
+			}
+			sources = append(sources, cred)
+		case AzureAuthChainWorkloadIdentity:
+			cred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+				ClientOptions: clientOpts,
+			})
+			if err != nil {
+				return nil, fmt.Errorf(`unable to init credential "%s": %w`, name, err)
+			}
+			sources = append(sources, cred)
+		case AzureAuthChainManagedIdentity:
+			opts := azidentity.ManagedIdentityCredentialOptions{
+				ClientOptions: clientOpts,
+			}
+			if managedIdentityClientId != "" {
+				opts.ID = azidentity.ClientID(managedIdentityClientId)
+			}
+			cred, err := azidentity.NewManagedIdentityCredential(&opts)
+			if err != nil {
+				return nil, fmt.Errorf(`unable to init credential "%s": %w`, name, err)
+			}
+			if Opts.Azure.Auth.ManagedIdentityRefresh > 0 {
+				sources = append(sources, newManagedIdentityCredential(cred, managedIdentityClientId, Opts.Azure.Auth.ManagedIdentityRefresh))
+			} else {
+				sources = append(sources, cred)
+			}
+		case AzureAuthChainCli:
+			cred, err := azidentity.NewAzureCLICredential(nil)
+			if err != nil {
+				return nil, fmt.Errorf(`unable to init credential "%s": %w`, name, err)
+			}
+			sources = append(sources, cred)
+		default:
+			return nil, fmt.Errorf(`unknown credential "%s" in azure auth chain`, name)
+		}
+	}
+
+	switch len(sources) {
+	case 0:
+		return azureDefaultCredential, nil
+	case 1:
+		return sources[0], nil
+	}
+
+	cred, err := azidentity.NewChainedTokenCredential(sources, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// initAzureCredentialRegistry builds the named credentials from environment and config file
+func initAzureCredentialRegistry(conf *config.Config) error {
+	credentialConfigs := config.CredentialsFromEnvironment()
+	if conf != nil {
+		for name, credentialConfig := range conf.Credentials {
+			credentialConfigs[strings.ToLower(name)] = credentialConfig
+		}
+	}
+
+	registry := map[string]azcore.TokenCredential{}
+	tenantCredentials := map[string]azcore.TokenCredential{}
+	tenantCredentialNames := map[string]string{}
+	for name, credentialConfig := range credentialConfigs {
+		if err := credentialConfig.Validate(); err != nil {
+			return fmt.Errorf(`credential "%s": %w`, name, err)
+		}
+
+		cred, err := buildAzureCredentialFromConfig(name, credentialConfig)
+		if err != nil {
+			return fmt.Errorf(`credential "%s": %w`, name, err)
+		}
+		registry[name] = cred
+
+		// first named credential (by name) is used for the tenant
+		if tenant := strings.ToLower(credentialConfig.TenantId); tenant != "" {
+			if tenantCredentialNames[tenant] == "" || name < tenantCredentialNames[tenant] {
+				tenantCredentialNames[tenant] = name
+				tenantCredentials[tenant] = cred
+			}
+		}
+	}
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		logger.Infof("using named credentials: %s", strings.Join(names, ", "))
+	}
+
+	azureCredentialRegistryLock.Lock()
+	azureCredentialRegistry = registry
+	azureCredentialRegistryLock.Unlock()
+
+	azureTenantCredentialsLock.Lock()
+	azureTenantCredentials = tenantCredentials
+	azureTenantCredentialsLock.Unlock()
+	return nil
+}
+
+func buildAzureCredentialFromConfig(name string, credentialConfig config.CredentialConfig) (azcore.TokenCredential, error) {
+	if !credentialConfig.IsServicePrincipal() {
+		return buildAzureCredential(credentialConfig.Chain, credentialConfig.ManagedIdentityClientId)
+	}
+
+	// client secret files are reloaded on rotation
+	if credentialConfig.ClientSecretFile != "" {
+		return newFileCredential(
+			name,
+			credentialConfig.TenantId,
+			credentialConfig.ClientId,
+			credentialConfig.ClientSecretFile,
+			"",
+			"",
+			Opts.Azure.Auth.SecretFileReload,
+		)
+	}
+
+	clientSecret, err := credentialConfig.GetClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	return azidentity.NewClientSecretCredential(
+		credentialConfig.TenantId,
+		credentialConfig.ClientId,
+		clientSecret,
+		&azidentity.ClientSecretCredentialOptions{
+			ClientOptions: metrics.NewArmClientOptions(AzureClient).ClientOptions,
+		},
+	)
+}
+
+// getAzureCredential returns the named credential or the credential of the tenant,
+// empty name and tenant returns the default credential
+func getAzureCredential(name, tenant string) (azcore.TokenCredential, error) {
+	if tenant != "" {
+		return getAzureTenantCredential(tenant)
+	}
+
+	if name == "" {
+		return AzureCredential, nil
+	}
+
+	azureCredentialRegistryLock.RLock()
+	defer azureCredentialRegistryLock.RUnlock()
+	if cred, exists := azureCredentialRegistry[strings.ToLower(name)]; exists {
+		return cred, nil
+	}
+
+	return nil, fmt.Errorf(`credential "%s" not found`, name)
+}
+
+// getAzureTenantCredential returns the named credential of the tenant or a credential
+// of the multi-tenant service principal, which are created on first use
+func getAzureTenantCredential(tenant string) (azcore.TokenCredential, error) {
+	tenant = strings.ToLower(tenant)
+	if !metrics.IsValidTenant(tenant) {
+		return nil, fmt.Errorf(`tenant "%s" is invalid, expected tenant id or domain`, tenant)
+	}
+
+	azureTenantCredentialsLock.RLock()
+	cred, exists := azureTenantCredentials[tenant]
+	azureTenantCredentialsLock.RUnlock()
+	if exists {
+		return cred, nil
+	}
+
+	if Opts.Azure.Auth.MultiTenantClientId == "" {
+		return nil, fmt.Errorf(`no credential found for tenant "%s"`, tenant)
+	}
+
+	if len(Opts.Azure.Auth.MultiTenantTenants) > 0 {
+		allowed := false
+		for _, val := range Opts.Azure.Auth.MultiTenantTenants {
+			if strings.EqualFold(val, tenant) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf(`tenant "%s" is not allowed for multi-tenant service principal`, tenant)
+		}
+	}
+
+	azureMultiTenantCredentialsLock.Lock()
+	defer azureMultiTenantCredentialsLock.Unlock()
+	if entry, exists := azureMultiTenantCredentials[tenant]; exists {
+		entry.lastUsed = time.Now()
+		return entry.cred, nil
+	}
+
+	var err error
+	if Opts.Azure.Auth.MultiTenantClientSecretFile != "" {
+		cred, err = newFileCredential(
+			"tenant/"+tenant,
+			tenant,
+			Opts.Azure.Auth.MultiTenantClientId,
+			Opts.Azure.Auth.MultiTenantClientSecretFile,
+			"",
+			"",
+			Opts.Azure.Auth.SecretFileReload,
+		)
+	} else {
+		cred, err = azidentity.NewClientSecretCredential(
+			tenant,
+			Opts.Azure.Auth.MultiTenantClientId,
+			Opts.Azure.Auth.MultiTenantClientSecret,
+			&azidentity.ClientSecretCredentialOptions{
+				ClientOptions: metrics.NewArmClientOptions(AzureClient).ClientOptions,
+			},
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf(`unable to init credential for tenant "%s": %w`, tenant, err)
+	}
+
+	// without tenant allowlist any tenant can be requested, the least recently used credential is removed
+	if len(Opts.Azure.Auth.MultiTenantTenants) == 0 && Opts.Azure.Auth.MultiTenantMaxTenants > 0 {
+		for len(azureMultiTenantCredentials) >= Opts.Azure.Auth.MultiTenantMaxTenants {
+			evictLeastRecentlyUsedTenantCredential()
+		}
+	}
+
+	azureMultiTenantCredentials[tenant] = &multiTenantCredential{cred: cred, lastUsed: time.Now()}
+	logger.Infof(`using multi-tenant service principal for tenant "%s"`, tenant)
+	return cred, nil
+}
+
+// evictLeastRecentlyUsedTenantCredential removes the least recently used credential of the multi-tenant
+// service principal (lock must be held)
+func evictLeastRecentlyUsedTenantCredential() {
+	oldestTenant := ""
+	var oldestUsed time.Time
+	for tenant, entry := range azureMultiTenantCredentials {
+		if oldestTenant == "" || entry.lastUsed.Before(oldestUsed) {
+			oldestTenant = tenant
+			oldestUsed = entry.lastUsed
+		}
+	}
+
+	delete(azureMultiTenantCredentials, oldestTenant)
+	logger.Infof(`removed multi-tenant service principal credential of tenant "%s" (--azure.auth.multitenant.max-tenants)`, oldestTenant)
+}
+
+// getResourceTagManager returns the tag manager for the tagLabels parameter, defaults to --azure.resource-tag
+func getResourceTagManager(tagLabels []string) (*armclient.ResourceTagManager, error) {
+	if len(tagLabels) == 0 {
+		return AzureResourceTagManager, nil
+	}
+
+	tagManager, err := AzureClient.TagManager.ParseTagConfig(tagLabels)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid parameter "tagLabels": %w`, err)
+	}
+	return tagManager, nil
+}
+
+// subscriptionDiscoveryStatusCode returns the http status code of failed subscription discovery, failed Azure
+// requests are 502/503
+func subscriptionDiscoveryStatusCode(err error) int {
+	if errors.Is(err, errNoSubscriptionsFound) {
+		return http.StatusBadRequest
+	}
+	return metrics.AzureErrorStatusCode(err)
+}
+
+// discoverSubscriptions sets the probe subscriptions using subscription discovery (subscriptionFilter, subscriptionTag)
+func discoverSubscriptions(ctx context.Context, cred azcore.TokenCredential, settings *metrics.RequestMetricSettings) error {
+	if !settings.SubscriptionDiscoveryEnabled() {
+		return nil
+	}
+
+	subscriptionList, err := subscriptionDiscovery.Find(
+		ctx,
+		cred,
+		metrics.NewArmClientOptions(AzureClient),
+		settings.CredentialKey(),
+		settings.SubscriptionFilter,
+		settings.SubscriptionTags,
+	)
+	if err != nil {
+		return err
+	}
+
+	// discovered subscriptions outside of --restrict.subscriptions are skipped
+	subscriptionList = probeRestrictions.FilterSubscriptions(subscriptionList)
+
+	if len(subscriptionList) == 0 {
+		return errNoSubscriptionsFound
+	}
+
+	settings.Subscriptions = subscriptionList
+	return nil
+}
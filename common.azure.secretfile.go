@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	prometheusAuthSecretFileReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_auth_secret_file_reloads",
+			Help: "Azure metrics exporter reloads of rotated client secret and certificate files with result (error, success)",
+		},
+		[]string{
+			"credential",
+			"result",
+		},
+	)
+)
+
+type (
+	// fileCredential is a service principal credential with the client secret or client certificate read from files
+	// (eg. mounted Kubernetes secrets), the files are checked every reload interval and the credential is rebuilt
+	// if their content changed, so rotated secrets are used without restarting the exporter
+	fileCredential struct {
+		name     string
+		tenantId string
+		clientId string
+
+		secretFile              string
+		certificateFile         string
+		certificatePasswordFile string
+
+		reloadInterval time.Duration
+		clientOpts     azcore.ClientOptions
+
+		lock      sync.Mutex
+		cred      azcore.TokenCredential
+		checksum  string
+		lastCheck time.Time
+	}
+)
+
+func newFileCredential(name, tenantId, clientId, secretFile, certificateFile, certificatePasswordFile string, reloadInterval time.Duration) (*fileCredential, error) {
+	if tenantId == "" || clientId == "" {
+		return nil, fmt.Errorf("tenant id and client id are required for client secret or certificate files")
+	}
+
+	if secretFile != "" && certificateFile != "" {
+		return nil, fmt.Errorf("client secret file and client certificate file can't be used together")
+	}
+
+	c := &fileCredential{
+		name:                    name,
+		tenantId:                tenantId,
+		clientId:                clientId,
+		secretFile:              secretFile,
+		certificateFile:         certificateFile,
+		certificatePasswordFile: certificatePasswordFile,
+		reloadInterval:          reloadInterval,
+		clientOpts:              metrics.NewArmClientOptions(AzureClient).ClientOptions,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetToken returns the token of the current credential, the files are checked for changes every reload interval
+// and after failed token requests (secret may have been rotated)
+func (c *fileCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.lock.Lock()
+	if c.reloadInterval > 0 && time.Since(c.lastCheck) >= c.reloadInterval {
+		c.reloadWithLogging()
+	}
+	cred := c.cred
+	c.lock.Unlock()
+
+	token, err := cred.GetToken(ctx, options)
+	if err == nil || c.reloadInterval <= 0 {
+		return token, err
+	}
+
+	c.lock.Lock()
+	changed := c.reloadWithLogging()
+	cred = c.cred
+	c.lock.Unlock()
+	if !changed {
+		return token, err
+	}
+
+	return cred.GetToken(ctx, options)
+}
+
+// reloadWithLogging reloads the files and keeps the previous credential on errors, must be called with lock
+func (c *fileCredential) reloadWithLogging() bool {
+	changed, err := c.reload()
+	if err != nil {
+		prometheusAuthSecretFileReloads.WithLabelValues(c.name, "error").Inc()
+		logger.Warnf(`unable to reload client secret files of credential "%s", using previous credential: %v`, c.name, err)
+		return false
+	}
+
+	if changed {
+		prometheusAuthSecretFileReloads.WithLabelValues(c.name, "success").Inc()
+		logger.Infof(`reloaded rotated client secret files of credential "%s"`, c.name)
+	}
+	return changed
+}
+
+// reload reads the files and rebuilds the credential if the content changed, must be called with lock
+func (c *fileCredential) reload() (bool, error) {
+	c.lastCheck = time.Now()
+
+	files := []string{c.secretFile, c.certificateFile, c.certificatePasswordFile}
+	contents := map[string][]byte{}
+	hash := sha256.New()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(file) // #nosec G304
+		if err != nil {
+			return false, fmt.Errorf(`unable to read file "%s": %w`, file, err)
+		}
+		contents[file] = content
+		hash.Write([]byte(file))
+		hash.Write(content)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if c.cred != nil && checksum == c.checksum {
+		return false, nil
+	}
+
+	var cred azcore.TokenCredential
+	if c.certificateFile != "" {
+		var password []byte
+		if c.certificatePasswordFile != "" {
+			password = []byte(strings.TrimSpace(string(contents[c.certificatePasswordFile])))
+		}
+
+		certs, key, err := azidentity.ParseCertificates(contents[c.certificateFile], password)
+		if err != nil {
+			return false, fmt.Errorf(`unable to parse client certificate file "%s": %w`, c.certificateFile, err)
+		}
+
+		cred, err = azidentity.NewClientCertificateCredential(c.tenantId, c.clientId, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: c.clientOpts,
+		})
+		if err != nil {
+			return false, err
+		}
+	} else {
+		clientSecret := strings.TrimSpace(string(contents[c.secretFile]))
+		if clientSecret == "" {
+			return false, fmt.Errorf(`client secret file "%s" is empty`, c.secretFile)
+		}
+
+		var err error
+		cred, err = azidentity.NewClientSecretCredential(c.tenantId, c.clientId, clientSecret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions: c.clientOpts,
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	c.cred = cred
+	c.checksum = checksum
+	return true, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ManagedIdentitySystemAssigned = "system-assigned"
+
+	// tokens of scopes not requested anymore are not refreshed in background
+	managedIdentityTokenIdleTimeout = 1 * time.Hour
+
+	managedIdentityTokenRefreshTimeout = 1 * time.Minute
+	managedIdentityBackoffMinimum      = 5 * time.Second
+	managedIdentityBackoffMaximum      = 5 * time.Minute
+)
+
+var (
+	prometheusAuthTokenExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_auth_token_expiry_timestamp_seconds",
+			Help: "Azure metrics exporter expiry of managed identity tokens (unix timestamp)",
+		},
+		[]string{
+			"identity",
+			"scope",
+		},
+	)
+
+	prometheusAuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_auth_failures_total",
+			Help: "Azure metrics exporter failed managed identity token requests (IMDS)",
+		},
+		[]string{
+			"identity",
+		},
+	)
+)
+
+type (
+	// managedIdentityCredential wraps the managed identity credential, tokens are refreshed in background before
+	// expiry and failed token requests are retried with exponential backoff
+	managedIdentityCredential struct {
+		cred          azcore.TokenCredential
+		identity      string
+		refreshBefore time.Duration
+
+		lock   sync.Mutex
+		tokens map[string]*managedIdentityToken
+	}
+
+	managedIdentityToken struct {
+		options   policy.TokenRequestOptions
+		token     *azcore.AccessToken
+		lastUsed  time.Time
+		scheduled bool
+
+		// backoff after failed token requests
+		lastError error
+		backoff   time.Duration
+		nextTry   time.Time
+	}
+)
+
+func newManagedIdentityCredential(cred azcore.TokenCredential, clientId string, refreshBefore time.Duration) *managedIdentityCredential {
+	identity := clientId
+	if identity == "" {
+		identity = ManagedIdentitySystemAssigned
+	}
+
+	return &managedIdentityCredential{
+		cred:          cred,
+		identity:      identity,
+		refreshBefore: refreshBefore,
+		tokens:        map[string]*managedIdentityToken{},
+	}
+}
+
+// GetToken returns the cached token of the scopes, the token is requested if missing or expired
+func (c *managedIdentityCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	key := managedIdentityTokenKey(options)
+
+	c.lock.Lock()
+	entry, exists := c.tokens[key]
+	if !exists {
+		entry = &managedIdentityToken{options: options}
+		c.tokens[key] = entry
+	}
+	entry.lastUsed = time.Now()
+
+	if entry.token != nil && time.Until(entry.token.ExpiresOn) > 0 {
+		token := *entry.token
+		c.lock.Unlock()
+		return token, nil
+	}
+
+	// IMDS failed recently, don't hammer it with every probe request
+	if entry.lastError != nil && time.Now().Before(entry.nextTry) {
+		err := entry.lastError
+		c.lock.Unlock()
+		return azcore.AccessToken{}, err
+	}
+	c.lock.Unlock()
+
+	return c.fetch(ctx, key)
+}
+
+// fetch requests a new token and schedules the background refresh before expiry
+func (c *managedIdentityCredential) fetch(ctx context.Context, key string) (azcore.AccessToken, error) {
+	c.lock.Lock()
+	entry := c.tokens[key]
+	options := entry.options
+	c.lock.Unlock()
+
+	token, err := c.cred.GetToken(ctx, options)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err != nil {
+		prometheusAuthFailures.WithLabelValues(c.identity).Inc()
+
+		entry.lastError = err
+		entry.backoff *= 2
+		if entry.backoff < managedIdentityBackoffMinimum {
+			entry.backoff = managedIdentityBackoffMinimum
+		}
+		if entry.backoff > managedIdentityBackoffMaximum {
+			entry.backoff = managedIdentityBackoffMaximum
+		}
+		entry.nextTry = time.Now().Add(entry.backoff)
+		logger.Warnf(`managed identity token request for "%s" failed, retrying in %s: %v`, key, entry.backoff.String(), err)
+
+		c.scheduleRefresh(key, entry, entry.backoff)
+		return azcore.AccessToken{}, err
+	}
+
+	previousExpiry := time.Time{}
+	if entry.token != nil {
+		previousExpiry = entry.token.ExpiresOn
+	}
+
+	entry.token = &token
+	entry.lastError = nil
+	entry.backoff = 0
+	prometheusAuthTokenExpiry.WithLabelValues(c.identity, key).Set(float64(token.ExpiresOn.Unix()))
+
+	refreshIn := time.Until(token.ExpiresOn) - c.refreshBefore
+	if !token.ExpiresOn.After(previousExpiry) || refreshIn < managedIdentityBackoffMinimum {
+		// token is still cached by the identity library, try again later
+		refreshIn = managedIdentityTokenRefreshTimeout
+	}
+	c.scheduleRefresh(key, entry, refreshIn)
+
+	return token, nil
+}
+
+// scheduleRefresh refreshes the token in background (once per token), tokens which are not used anymore are dropped,
+// must be called with lock
+func (c *managedIdentityCredential) scheduleRefresh(key string, entry *managedIdentityToken, delay time.Duration) {
+	if entry.scheduled {
+		return
+	}
+	entry.scheduled = true
+
+	time.AfterFunc(delay, func() {
+		c.lock.Lock()
+		entry.scheduled = false
+		_, exists := c.tokens[key]
+		if exists && time.Since(entry.lastUsed) > managedIdentityTokenIdleTimeout {
+			delete(c.tokens, key)
+			prometheusAuthTokenExpiry.DeleteLabelValues(c.identity, key)
+			exists = false
+		}
+		c.lock.Unlock()
+
+		if !exists {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), managedIdentityTokenRefreshTimeout)
+		defer cancel()
+		if _, err := c.fetch(ctx, key); err == nil {
+			logger.Debugf(`refreshed managed identity token for "%s"`, key)
+		}
+	})
+}
+
+func managedIdentityTokenKey(options policy.TokenRequestOptions) string {
+	scopes := append([]string{}, options.Scopes...)
+	sort.Strings(scopes)
+
+	key := strings.Join(scopes, " ")
+	if options.TenantID != "" {
+		key = options.TenantID + ":" + key
+	}
+	return key
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	// cacheBackends are the created cache backends by name (/debug/stats)
+	cacheBackends = map[string]metrics.Cache{}
+)
+
+// newCacheBackend creates the cache backend (--cache.backend), redis keys are prefixed with the cache name
+func newCacheBackend(name string) metrics.Cache {
+	cache := buildCacheBackend(name)
+	cacheBackends[name] = cache
+	return cache
+}
+
+func buildCacheBackend(name string) metrics.Cache {
+	switch Opts.Cache.Backend {
+	case metrics.CacheBackendMemory:
+		return metrics.NewMemoryCache(name, Opts.Cache.MaxEntries)
+	case metrics.CacheBackendRedis:
+		cache, err := metrics.NewRedisCache(
+			logger.With("cache", name),
+			metrics.RedisCacheOptions{
+				Address:  Opts.Cache.Redis.Address,
+				Username: Opts.Cache.Redis.Username,
+				Password: Opts.Cache.Redis.Password,
+				DB:       Opts.Cache.Redis.DB,
+				Tls:      Opts.Cache.Redis.Tls,
+				Prefix:   Opts.Cache.Redis.Prefix + name + ":",
+				Timeout:  Opts.Cache.Redis.Timeout,
+			},
+		)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return cache
+	default:
+		logger.Fatalf(`cache backend "%s" not supported`, Opts.Cache.Backend)
+	}
+	return nil
+}
+
+// initCacheMetrics registers the number of items of the in-process caches (azurerm_stats_cache_entries)
+func initCacheMetrics() {
+	for name, cache := range cacheBackends {
+		counter, ok := cache.(interface{ ItemCount() int })
+		if !ok {
+			continue
+		}
+
+		prometheus.MustRegister(prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        "azurerm_stats_cache_entries",
+				Help:        "Azure metrics exporter items of the in-process cache (including expired items not yet cleaned up)",
+				ConstLabels: prometheus.Labels{"cache": name},
+			},
+			func() float64 {
+				return float64(counter.ItemCount())
+			},
+		))
+	}
+}
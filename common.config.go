@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	ConfigFile *config.Config
+
+	// metricRules are applied to all probe responses, replaced on config reload
+	metricRules atomic.Pointer[metrics.MetricRules]
+
+	// resourceGraphQueries are the named queries of the resourcegraph probe, replaced on config reload
+	resourceGraphQueries atomic.Pointer[metrics.ResourceGraphQueries]
+
+	// staticLabels are added to all probe responses (--metrics.extra-label)
+	staticLabels metrics.StaticLabels
+)
+
+// initConfigFile loads the config file (--config) and the named credentials, the config is reloaded on SIGHUP
+func initConfigFile() {
+	if Opts.Config.Path != "" {
+		logger.Infof(`loading config file "%s"`, Opts.Config.Path)
+		conf, err := config.LoadConfigFile(Opts.Config.Path)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		ConfigFile = conf
+
+		rules, err := metrics.NewMetricRules(conf.MetricRules)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		metricRules.Store(rules)
+		resourceGraphQueries.Store(metrics.NewResourceGraphQueries(conf.Queries))
+	}
+
+	if err := initAzureCredentialRegistry(ConfigFile); err != nil {
+		logger.Fatal(err)
+	}
+
+	if Opts.Config.Path == "" {
+		return
+	}
+
+	// reload config file on SIGHUP
+	go func() {
+		signalChannel := make(chan os.Signal, 1)
+		signal.Notify(signalChannel, syscall.SIGHUP)
+		for range signalChannel {
+			logger.Infof(`reloading config file "%s"`, Opts.Config.Path)
+			if err := reloadConfigFile(); err != nil {
+				logger.Errorf(`config reload failed, keeping previous config: %v`, err)
+			}
+		}
+	}()
+}
+
+func reloadConfigFile() error {
+	conf, err := config.LoadConfigFile(Opts.Config.Path)
+	if err != nil {
+		return err
+	}
+
+	rules, err := metrics.NewMetricRules(conf.MetricRules)
+	if err != nil {
+		return err
+	}
+
+	if err := initAzureCredentialRegistry(conf); err != nil {
+		return err
+	}
+	ConfigFile = conf
+	metricRules.Store(rules)
+	resourceGraphQueries.Store(metrics.NewResourceGraphQueries(conf.Queries))
+
+	if jobScheduler != nil {
+		jobScheduler.Start(conf)
+	}
+	return nil
+}
+
+// initStaticLabels parses the static labels (--metrics.extra-label) added to all probe responses
+func initStaticLabels() {
+	labels, err := metrics.ParseStaticLabels(Opts.Metrics.ExtraLabels)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	staticLabels = labels
+}
+
+// probeStaticLabelsHandler validates the label_<name> parameters of probes before the probe is executed, invalid
+// label names are rejected with http 400 instead of failing after all Azure requests when gathering the metrics
+func probeStaticLabelsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, probeUrlPrefix) {
+			if _, err := staticLabels.WithParams(r.URL.Query()); err != nil {
+				buildContextLoggerFromRequest(r).Warnln(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// probeGatherer wraps the probe registry, adds the static labels (--metrics.extra-label, label_<name> parameter)
+// applies the metric rules and limits the series (--metrics.max-series-per-probe)
+func probeGatherer(r *http.Request, registry prometheus.Gatherer) prometheus.Gatherer {
+	labels, err := staticLabels.WithParams(r.URL.Query())
+	if err != nil {
+		return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return nil, err
+		})
+	}
+
+	return seriesLimitGatherer(r.URL.Path, metricRules.Load().Gatherer(labels.Gatherer(registry)))
+}
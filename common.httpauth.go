@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type (
+	// httpAuthenticator protects the exporter endpoints with bearer token, basic auth or a static header
+	httpAuthenticator struct {
+		bearerToken   string
+		basicUsername string
+		basicPassword string
+		headerName    string
+		headerValue   string
+
+		publicPaths map[string]bool
+	}
+
+	// internalRequestKey marks requests created inside the exporter (jobs, prewarm probes), the context of
+	// requests can't be set by clients
+	internalRequestKey struct{}
+
+	// authenticatedRequestKey marks requests which passed the authentication (--server.auth.*)
+	authenticatedRequestKey struct{}
+)
+
+// newInternalRequest creates a GET request of the exporter itself, which is executed through the server handler
+func newInternalRequest(ctx context.Context, url string) (*http.Request, error) {
+	return http.NewRequestWithContext(context.WithValue(ctx, internalRequestKey{}, true), http.MethodGet, url, nil)
+}
+
+// isInternalRequest returns if the request was created by the exporter itself (newInternalRequest)
+func isInternalRequest(r *http.Request) bool {
+	internal, _ := r.Context().Value(internalRequestKey{}).(bool)
+	return internal
+}
+
+// isAuthenticatedRequest returns if the request was authenticated by the http authenticator, false if
+// authentication is disabled
+func isAuthenticatedRequest(r *http.Request) bool {
+	authenticated, _ := r.Context().Value(authenticatedRequestKey{}).(bool)
+	return authenticated
+}
+
+// buildHttpAuthenticator returns the authenticator for the http server, nil if authentication is disabled
+func buildHttpAuthenticator() (*httpAuthenticator, error) {
+	auth := httpAuthenticator{
+		bearerToken:   Opts.Server.Auth.BearerToken,
+		basicUsername: Opts.Server.Auth.BasicUsername,
+		basicPassword: Opts.Server.Auth.BasicPassword,
+		publicPaths: map[string]bool{
+			"/healthz": true,
+			"/readyz":  true,
+		},
+	}
+
+	if Opts.Server.Auth.BearerTokenFile != "" {
+		content, err := os.ReadFile(Opts.Server.Auth.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to read bearer token file "%s": %w`, Opts.Server.Auth.BearerTokenFile, err)
+		}
+		auth.bearerToken = strings.TrimSpace(string(content))
+	}
+
+	if (auth.basicUsername == "") != (auth.basicPassword == "") {
+		return nil, fmt.Errorf("basic auth requires username and password")
+	}
+
+	if Opts.Server.Auth.Header != "" {
+		name, value, found := strings.Cut(Opts.Server.Auth.Header, "=")
+		if !found || strings.TrimSpace(name) == "" || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf(`invalid auth header "%s", expected "name=value"`, Opts.Server.Auth.Header)
+		}
+		auth.headerName = strings.TrimSpace(name)
+		auth.headerValue = strings.TrimSpace(value)
+	}
+
+	if auth.bearerToken == "" && auth.basicUsername == "" && auth.headerName == "" {
+		return nil, nil
+	}
+
+	if Opts.Server.Auth.PublicMetrics {
+		auth.publicPaths["/metrics"] = true
+	}
+
+	return &auth, nil
+}
+
+// Handler wraps the handler and rejects unauthenticated requests, health endpoints and requests of the exporter
+// itself (jobs, prewarm probes) are always allowed
+func (a *httpAuthenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isAuthenticated(r) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatedRequestKey{}, true)))
+			return
+		}
+
+		if a.publicPaths[r.URL.Path] || isInternalRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.basicUsername != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="azure-metrics-exporter", charset="UTF-8"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// isAuthenticated checks if any of the configured authentication methods matches
+func (a *httpAuthenticator) isAuthenticated(r *http.Request) bool {
+	if a.bearerToken != "" {
+		if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && secureCompare(token, a.bearerToken) {
+			return true
+		}
+	}
+
+	if a.basicUsername != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			// compare both to avoid leaking which one is wrong
+			usernameMatch := secureCompare(username, a.basicUsername)
+			passwordMatch := secureCompare(password, a.basicPassword)
+			if usernameMatch && passwordMatch {
+				return true
+			}
+		}
+	}
+
+	if a.headerName != "" {
+		if value := r.Header.Get(a.headerName); value != "" && secureCompare(value, a.headerValue) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
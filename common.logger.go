@@ -11,6 +11,9 @@ import (
 var (
 	logger  *zap.SugaredLogger
 	slogger *slog.Logger
+
+	// logLevel can be changed at runtime (/debug/loglevel)
+	logLevel zap.AtomicLevel
 )
 
 func initLogger() *zap.SugaredLogger {
@@ -29,6 +32,7 @@ func initLogger() *zap.SugaredLogger {
 	if Opts.Logger.Debug {
 		config.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
 	}
+	logLevel = config.Level
 
 	// json log format
 	if Opts.Logger.Json {
@@ -38,8 +42,10 @@ func initLogger() *zap.SugaredLogger {
 		config.EncoderConfig.TimeKey = ""
 	}
 
-	// build logger
-	log, err := config.Build()
+	// build logger, repeated warnings and errors are sampled
+	log, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newLogSamplingCore(core, Opts.Logger.SampleInterval)
+	}))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// entries of the error sampler are pruned above this size
+	LogSamplingMaxEntries = 1000
+)
+
+type (
+	// logSamplingCore logs repeated warnings and errors (eg. a subscription without permissions, failing on every scrape)
+	// only once per interval, the next logged occurrence contains the number of suppressed occurrences
+	logSamplingCore struct {
+		zapcore.Core
+		sampler *logSampler
+	}
+
+	logSampler struct {
+		interval time.Duration
+
+		lock    sync.Mutex
+		entries map[string]*logSamplerEntry
+	}
+
+	logSamplerEntry struct {
+		logged     time.Time
+		suppressed int
+	}
+)
+
+var (
+	logSamplingQueryRegexp     = regexp.MustCompile(`\?\S*`)
+	logSamplingErrorCodeRegexp = regexp.MustCompile(`ERROR CODE: (\S+)`)
+
+	// counters exist before the logger is built, registered in initMetricCollector
+	prometheusLogErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_log_errors",
+			Help: "Azure metrics exporter logged warnings and errors (including suppressed repetitions) by level and Azure error code",
+		},
+		[]string{
+			"level",
+			"code",
+		},
+	)
+
+	prometheusLogSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_log_suppressed",
+			Help: "Azure metrics exporter repeated warnings and errors not logged (--log.sample-interval)",
+		},
+		[]string{
+			"level",
+		},
+	)
+)
+
+func newLogSamplingCore(core zapcore.Core, interval time.Duration) zapcore.Core {
+	return &logSamplingCore{
+		Core: core,
+		sampler: &logSampler{
+			interval: interval,
+			entries:  map[string]*logSamplerEntry{},
+		},
+	}
+}
+
+func (c *logSamplingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &logSamplingCore{
+		Core:    c.Core.With(fields),
+		sampler: c.sampler,
+	}
+}
+
+func (c *logSamplingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *logSamplingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if entry.Level < zapcore.WarnLevel {
+		return c.Core.Write(entry, fields)
+	}
+
+	code := ""
+	if match := logSamplingErrorCodeRegexp.FindStringSubmatch(entry.Message); match != nil {
+		code = match[1]
+	}
+	prometheusLogErrors.WithLabelValues(entry.Level.String(), code).Inc()
+
+	if c.sampler.interval <= 0 {
+		return c.Core.Write(entry, fields)
+	}
+
+	log, suppressed := c.sampler.observe(logSamplingKey(entry, code), entry.Time)
+	if !log {
+		prometheusLogSuppressed.WithLabelValues(entry.Level.String()).Inc()
+		return nil
+	}
+
+	if suppressed > 0 {
+		fields = append(fields, zap.Int("suppressed", suppressed))
+	}
+	return c.Core.Write(entry, fields)
+}
+
+// observe returns if the entry should be logged and the number of suppressed occurrences since it was logged
+func (s *logSampler) observe(key string, now time.Time) (bool, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, exists := s.entries[key]
+	if !exists {
+		if len(s.entries) >= LogSamplingMaxEntries {
+			s.prune(now)
+		}
+		s.entries[key] = &logSamplerEntry{logged: now}
+		return true, 0
+	}
+
+	if now.Sub(entry.logged) < s.interval {
+		entry.suppressed++
+		return false, 0
+	}
+
+	suppressed := entry.suppressed
+	entry.logged = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+// prune removes entries which were not repeated within the interval
+func (s *logSampler) prune(now time.Time) {
+	for key, entry := range s.entries {
+		if now.Sub(entry.logged) >= s.interval {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// logSamplingKey identifies repeated messages, query strings of urls (eg. timespan of metric requests) are ignored,
+// Azure errors are identified by the request (first line) and the error code as the response body may contain request ids
+func logSamplingKey(entry zapcore.Entry, code string) string {
+	message := entry.Message
+	if code != "" {
+		message, _, _ = strings.Cut(message, "\n")
+		message += "\n" + code
+	}
+	return entry.Level.String() + "\xff" + logSamplingQueryRegexp.ReplaceAllString(message, "")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// AzureReadiness validates the credential and the reachability of ARM in background (/readyz),
+	// Kubernetes only routes probes to the exporter if it can authenticate
+	AzureReadiness struct {
+		lock    sync.RWMutex
+		checked time.Time
+		err     error
+	}
+)
+
+var (
+	azureReadiness = &AzureReadiness{}
+
+	prometheusAzureReady prometheus.Gauge
+)
+
+func initReadinessMetrics() {
+	prometheusAzureReady = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_azure_ready",
+			Help: "Azure metrics exporter result of the Azure readiness check (credential token and ARM request)",
+		},
+	)
+	prometheus.MustRegister(prometheusAzureReady)
+}
+
+// Start runs the readiness check every interval until the context is canceled
+func (r *AzureReadiness) Start(ctx context.Context, interval, timeout time.Duration) {
+	logger.Infof("starting Azure readiness check (every %s)", interval.String())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			r.check(ctx, timeout)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Ready returns the error of the last readiness check (nil if ready)
+func (r *AzureReadiness) Ready() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.checked.IsZero() {
+		return errors.New("Azure connectivity not verified yet")
+	}
+	return r.err
+}
+
+func (r *AzureReadiness) check(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := checkAzureConnectivity(ctx)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch {
+	case err != nil && (r.err == nil || r.checked.IsZero()):
+		logger.Warnf("Azure readiness check failed, reporting not ready: %v", err)
+	case err == nil && r.err != nil:
+		logger.Infof("Azure readiness check succeeded, reporting ready")
+	}
+
+	r.checked = time.Now()
+	r.err = err
+
+	if err == nil {
+		prometheusAzureReady.Set(1)
+	} else {
+		prometheusAzureReady.Set(0)
+	}
+}
+
+// checkAzureConnectivity requests a resource manager token and lists the first page of subscriptions
+func checkAzureConnectivity(ctx context.Context) error {
+	clientOpts := metrics.NewArmClientOptions(AzureClient)
+	resourceManager := clientOpts.Cloud.Services[cloud.ResourceManager]
+
+	_, err := AzureCredential.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes: []string{audienceToScope(resourceManager.Audience)},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to get token: %w", err)
+	}
+
+	client, err := armsubscriptions.NewClient(AzureCredential, clientOpts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.NewListPager(nil).NextPage(ctx); err != nil {
+		return fmt.Errorf("unable to reach Azure resource manager: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// tlsCertificateLoader reloads the server certificate if the files have changed (eg. renewed by cert-manager)
+	tlsCertificateLoader struct {
+		certFile string
+		keyFile  string
+
+		lock        sync.Mutex
+		certificate *tls.Certificate
+		modTime     time.Time
+	}
+)
+
+// buildServerTlsConfig returns the tls config for the http server, nil if tls is not enabled
+func buildServerTlsConfig() (*tls.Config, error) {
+	if Opts.Server.Tls.Cert == "" && Opts.Server.Tls.Key == "" {
+		if Opts.Server.Tls.ClientCa != "" {
+			return nil, fmt.Errorf("client ca requires server certificate and key")
+		}
+		return nil, nil
+	}
+
+	if Opts.Server.Tls.Cert == "" || Opts.Server.Tls.Key == "" {
+		return nil, fmt.Errorf("server certificate and key are required for tls")
+	}
+
+	loader := &tlsCertificateLoader{
+		certFile: Opts.Server.Tls.Cert,
+		keyFile:  Opts.Server.Tls.Key,
+	}
+	if _, err := loader.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.GetCertificate,
+	}
+
+	switch strings.ToLower(Opts.Server.Tls.MinVersion) {
+	case "", "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf(`unsupported tls min version "%s"`, Opts.Server.Tls.MinVersion)
+	}
+
+	if Opts.Server.Tls.ClientCa != "" {
+		content, err := os.ReadFile(Opts.Server.Tls.ClientCa)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to read client ca "%s": %w`, Opts.Server.Tls.ClientCa, err)
+		}
+
+		clientCaPool := x509.NewCertPool()
+		if !clientCaPool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf(`no certificates found in client ca "%s"`, Opts.Server.Tls.ClientCa)
+		}
+		tlsConfig.ClientCAs = clientCaPool
+
+		switch strings.ToLower(Opts.Server.Tls.ClientAuth) {
+		case "require":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case "optional":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf(`unsupported client auth "%s" (require or optional)`, Opts.Server.Tls.ClientAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (l *tlsCertificateLoader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	stat, err := os.Stat(l.certFile)
+	if err != nil {
+		if l.certificate != nil {
+			// keep serving with current certificate
+			return l.certificate, nil
+		}
+		return nil, fmt.Errorf(`unable to read server certificate "%s": %w`, l.certFile, err)
+	}
+
+	if l.certificate == nil || !stat.ModTime().Equal(l.modTime) {
+		certificate, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+		if err != nil {
+			if l.certificate != nil {
+				logger.Warnf(`unable to reload server certificate, keeping current certificate: %v`, err)
+				return l.certificate, nil
+			}
+			return nil, fmt.Errorf(`unable to load server certificate: %w`, err)
+		}
+
+		if l.certificate != nil {
+			logger.Infof(`reloaded server certificate "%s"`, l.certFile)
+		}
+		l.certificate = &certificate
+		l.modTime = stat.ModTime()
+	}
+
+	return l.certificate, nil
+}
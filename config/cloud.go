@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// CloudConfig is a custom cloud definition (eg. Azure Stack Hub or disconnected sovereign clouds)
+	CloudConfig struct {
+		Name                         string              `yaml:"name"`
+		ActiveDirectoryAuthorityHost string              `yaml:"activeDirectoryAuthorityHost"`
+		ResourceManager              CloudServiceConfig  `yaml:"resourceManager"`
+		MetricsBatch                 *CloudServiceConfig `yaml:"metricsBatch"`
+		LogAnalytics                 *CloudServiceConfig `yaml:"logAnalytics"`
+		AppInsights                  *CloudServiceConfig `yaml:"appInsights"`
+		KeyVault                     *CloudServiceConfig `yaml:"keyVault"`
+		PortalUrl                    string              `yaml:"portalUrl"`
+	}
+
+	CloudServiceConfig struct {
+		Endpoint string `yaml:"endpoint"`
+		Audience string `yaml:"audience"`
+	}
+)
+
+// LoadCloudConfigFile reads and validates the custom cloud definition (yaml or json)
+func LoadCloudConfigFile(path string) (*CloudConfig, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf(`unable to read cloud config file "%s": %w`, path, err)
+	}
+
+	conf := CloudConfig{}
+	if err := yaml.Unmarshal(content, &conf); err != nil {
+		return nil, fmt.Errorf(`unable to parse cloud config file "%s": %w`, path, err)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf(`invalid cloud config file "%s": %w`, path, err)
+	}
+
+	return &conf, nil
+}
+
+func (c *CloudConfig) Validate() error {
+	if c.Name == "" {
+		c.Name = "AzureCustomCloud"
+	}
+
+	if err := validateCloudUrl("activeDirectoryAuthorityHost", c.ActiveDirectoryAuthorityHost); err != nil {
+		return err
+	}
+
+	if err := c.ResourceManager.Validate("resourceManager", true); err != nil {
+		return err
+	}
+
+	if c.MetricsBatch != nil {
+		if err := c.MetricsBatch.Validate("metricsBatch", false); err != nil {
+			return err
+		}
+	}
+
+	if c.LogAnalytics != nil {
+		if err := c.LogAnalytics.Validate("logAnalytics", false); err != nil {
+			return err
+		}
+	}
+
+	if c.AppInsights != nil {
+		if err := c.AppInsights.Validate("appInsights", false); err != nil {
+			return err
+		}
+	}
+
+	if c.KeyVault != nil {
+		// vault urls are discovered (resource manager), only the audience is used
+		if c.KeyVault.Audience == "" {
+			return fmt.Errorf("keyVault.audience is required")
+		}
+		if err := validateCloudUrl("keyVault.audience", c.KeyVault.Audience); err != nil {
+			return err
+		}
+	}
+
+	if c.PortalUrl != "" {
+		if err := validateCloudUrl("portalUrl", c.PortalUrl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *CloudServiceConfig) Validate(name string, requireAudience bool) error {
+	// metrics batch endpoint contains {region} placeholder
+	if err := validateCloudUrl(name+".endpoint", strings.ReplaceAll(c.Endpoint, "{region}", "region")); err != nil {
+		return err
+	}
+
+	if c.Audience == "" {
+		if requireAudience {
+			return fmt.Errorf("%s.audience is required", name)
+		}
+		return nil
+	}
+
+	return validateCloudUrl(name+".audience", c.Audience)
+}
+
+func validateCloudUrl(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+
+	parsedUrl, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf(`%s "%s" is not a valid url: %w`, name, value, err)
+	}
+
+	if parsedUrl.Scheme != "https" || parsedUrl.Host == "" {
+		return fmt.Errorf(`%s "%s" must be a https url`, name, value)
+	}
+
+	return nil
+}
+
+// AzureCloudConfiguration returns the cloud configuration for the Azure SDK
+func (c *CloudConfig) AzureCloudConfiguration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: c.ActiveDirectoryAuthorityHost,
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Endpoint: c.ResourceManager.Endpoint,
+				Audience: c.ResourceManager.Audience,
+			},
+		},
+	}
+}
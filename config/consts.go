@@ -17,4 +17,64 @@ const (
 
 	ProbeMetricsResourceGraphUrl            = "/probe/metrics/resourcegraph"
 	ProbeMetricsResourceGraphTimeoutDefault = 120
+
+	ProbeMetricsDefinitionsUrl            = "/probe/metrics/definitions"
+	ProbeMetricsDefinitionsTimeoutDefault = 60
+
+	ProbeMetricsNamespacesUrl            = "/probe/metrics/namespaces"
+	ProbeMetricsNamespacesTimeoutDefault = 60
+
+	ProbeMetricsAppInsightsUrl            = "/probe/metrics/appinsights"
+	ProbeMetricsAppInsightsTimeoutDefault = 60
+
+	ProbeCostsUrl            = "/probe/costs"
+	ProbeCostsTimeoutDefault = 120
+
+	ProbeHealthResourceUrl            = "/probe/health/resource"
+	ProbeHealthResourceTimeoutDefault = 60
+
+	ProbeAlertsUrl            = "/probe/alerts"
+	ProbeAlertsTimeoutDefault = 60
+
+	ProbeAdvisorUrl            = "/probe/advisor"
+	ProbeAdvisorTimeoutDefault = 60
+
+	ProbeQuotaUrl            = "/probe/quota"
+	ProbeQuotaTimeoutDefault = 60
+
+	ProbePolicyUrl            = "/probe/policy"
+	ProbePolicyTimeoutDefault = 60
+
+	ProbeActivityLogUrl            = "/probe/activitylog"
+	ProbeActivityLogTimeoutDefault = 60
+
+	ProbeArcUrl            = "/probe/arc"
+	ProbeArcTimeoutDefault = 60
+
+	ProbeKeyVaultUrl            = "/probe/keyvault"
+	ProbeKeyVaultTimeoutDefault = 60
+
+	ProbeVmScheduledEventsUrl            = "/probe/vm/scheduledevents"
+	ProbeVmScheduledEventsTimeoutDefault = 30
+
+	// custom collectors (/probe/collector/<name>)
+	ProbeCollectorUrl            = "/probe/collector/"
+	ProbeCollectorTimeoutDefault = 60
+
+	ProbeLogsQueryUrl            = "/probe/logs/query"
+	ProbeLogsQueryTimeoutDefault = 120
+
+	ProbeValidateUrl            = "/probe/validate"
+	ProbeValidateTimeoutDefault = 60
+
+	DiscoveryTargetsUrl            = "/discovery/targets"
+	DiscoveryTargetsTimeoutDefault = 60
+
+	WebhookEventGridUrl = "/webhook/eventgrid"
+
+	RelayRemoteWriteUrl = "/relay/remotewrite"
+
+	GeneratePrometheusConfigUrl = "/generate/prometheus-config"
+
+	EnvCredentialPrefix = "AZURE_CREDENTIAL_"
 )
@@ -0,0 +1,378 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	JobIntervalDefault = 1 * time.Minute
+
+	MetricRuleActionRename    = "rename"
+	MetricRuleActionLabelDrop = "labeldrop"
+	MetricRuleActionReplace   = "replace"
+)
+
+var (
+	// QueryParamRegexp matches parameters ({name}) of named queries
+	QueryParamRegexp = regexp.MustCompile(`\{([a-zA-Z][a-zA-Z0-9_]*)\}`)
+)
+
+type (
+	Config struct {
+		Credentials map[string]CredentialConfig `yaml:"credentials"`
+		Jobs        []JobConfig                 `yaml:"jobs"`
+		MetricRules []MetricRuleConfig          `yaml:"metricRules"`
+		Queries     map[string]QueryConfig      `yaml:"queries"`
+	}
+
+	// QueryConfig is a named Kusto filter of the resourcegraph probe (parameter query), parameters
+	// are referenced as {name} and inserted as Kusto string literals
+	QueryConfig struct {
+		Description string `yaml:"description"`
+
+		// resource type, used if the probe has no resourceType parameter
+		ResourceType string `yaml:"resourceType"`
+
+		// Kusto filter (eg. where tags.env == {env}) or file with the filter (relative to config file)
+		Filter     string `yaml:"filter"`
+		FilterFile string `yaml:"filterFile"`
+
+		// parameters with default values, parameters with empty default are required
+		Params map[string]string `yaml:"params"`
+	}
+
+	CredentialConfig struct {
+		// service principal
+		TenantId         string `yaml:"tenantId"`
+		ClientId         string `yaml:"clientId"`
+		ClientSecret     string `yaml:"clientSecret"`
+		ClientSecretFile string `yaml:"clientSecretFile"`
+
+		// credential chain (same as --azure.auth.chain), used if no service principal is set
+		Chain                   []string `yaml:"chain"`
+		ManagedIdentityClientId string   `yaml:"managedIdentityClientId"`
+	}
+
+	// MetricRuleConfig rewrites metrics of probe responses before exposition
+	MetricRuleConfig struct {
+		// action: rename (metric name), labeldrop (label names) or replace (label value)
+		Action string `yaml:"action"`
+
+		// metric name regex, rule applies to all metrics if empty
+		Metric string `yaml:"metric"`
+
+		// regex for metric name (rename), label names (labeldrop) or label value (replace)
+		Regex string `yaml:"regex"`
+
+		// source label (replace)
+		Label string `yaml:"label"`
+
+		// target label (replace), defaults to source label
+		TargetLabel string `yaml:"targetLabel"`
+
+		// replacement with regex groups (eg. $1), used by rename and replace
+		Replacement string `yaml:"replacement"`
+	}
+
+	JobConfig struct {
+		// name of job, also used as "job" label for pushed metrics
+		Name string `yaml:"name"`
+
+		// probe endpoint (eg. /probe/metrics/list)
+		Endpoint string `yaml:"endpoint"`
+
+		// collection interval
+		Interval time.Duration `yaml:"interval"`
+
+		// probe timeout (defaults to interval)
+		Timeout time.Duration `yaml:"timeout"`
+
+		// shortcuts for common probe parameters
+		Subscriptions []string `yaml:"subscriptions"`
+		ResourceType  string   `yaml:"resourceType"`
+		Filter        string   `yaml:"filter"`
+		Metrics       []string `yaml:"metrics"`
+		Aggregations  []string `yaml:"aggregations"`
+
+		// probe parameters, same as Prometheus scrape config params
+		Params map[string][]string `yaml:"params"`
+	}
+)
+
+// LoadConfigFile reads and validates the yaml config file
+func LoadConfigFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf(`unable to read config file "%s": %w`, path, err)
+	}
+
+	conf := Config{}
+	if err := yaml.Unmarshal(content, &conf); err != nil {
+		return nil, fmt.Errorf(`unable to parse config file "%s": %w`, path, err)
+	}
+
+	for name, query := range conf.Queries {
+		if query.FilterFile == "" {
+			continue
+		}
+
+		filterFile := query.FilterFile
+		if !filepath.IsAbs(filterFile) {
+			filterFile = filepath.Join(filepath.Dir(path), filterFile)
+		}
+		filter, err := os.ReadFile(filterFile) // #nosec G304
+		if err != nil {
+			return nil, fmt.Errorf(`unable to read filter file of query "%s": %w`, name, err)
+		}
+		query.Filter = strings.TrimSpace(string(filter))
+		conf.Queries[name] = query
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf(`invalid config file "%s": %w`, path, err)
+	}
+
+	return &conf, nil
+}
+
+func (c *Config) Validate() error {
+	for name, credential := range c.Credentials {
+		if err := credential.Validate(); err != nil {
+			return fmt.Errorf(`credential "%s": %w`, name, err)
+		}
+	}
+
+	for i := range c.MetricRules {
+		if err := c.MetricRules[i].Validate(); err != nil {
+			return fmt.Errorf("metric rule #%d: %w", i+1, err)
+		}
+	}
+
+	for name, query := range c.Queries {
+		if err := query.Validate(); err != nil {
+			return fmt.Errorf(`query "%s": %w`, name, err)
+		}
+	}
+
+	jobNames := map[string]bool{}
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+
+		if job.Name == "" {
+			return fmt.Errorf("job #%d has no name", i+1)
+		}
+
+		if _, exists := jobNames[job.Name]; exists {
+			return fmt.Errorf(`job "%s" is defined multiple times`, job.Name)
+		}
+		jobNames[job.Name] = true
+
+		if job.Endpoint == "" {
+			job.Endpoint = ProbeMetricsListUrl
+		}
+
+		if !IsProbeUrl(job.Endpoint) {
+			return fmt.Errorf(`job "%s" has invalid endpoint "%s"`, job.Name, job.Endpoint)
+		}
+
+		if job.Interval <= 0 {
+			job.Interval = JobIntervalDefault
+		}
+
+		if job.Timeout <= 0 {
+			job.Timeout = job.Interval
+		}
+	}
+
+	return nil
+}
+
+func (c *CredentialConfig) Validate() error {
+	if c.IsServicePrincipal() {
+		if c.TenantId == "" || c.ClientId == "" {
+			return fmt.Errorf("tenantId and clientId are required for service principal")
+		}
+
+		if c.ClientSecret == "" && c.ClientSecretFile == "" {
+			return fmt.Errorf("clientSecret or clientSecretFile is required for service principal")
+		}
+		return nil
+	}
+
+	if len(c.Chain) == 0 {
+		return fmt.Errorf("either service principal (tenantId, clientId, clientSecret) or chain is required")
+	}
+
+	return nil
+}
+
+func (q *QueryConfig) Validate() error {
+	if q.Filter == "" {
+		return fmt.Errorf("filter or filterFile is required")
+	}
+
+	for _, match := range QueryParamRegexp.FindAllStringSubmatch(q.Filter, -1) {
+		if _, exists := q.Params[match[1]]; !exists {
+			return fmt.Errorf(`parameter "%s" is used but not defined in params`, match[1])
+		}
+	}
+
+	return nil
+}
+
+func (r *MetricRuleConfig) Validate() error {
+	r.Action = strings.ToLower(r.Action)
+
+	for _, regex := range []string{r.Metric, r.Regex} {
+		if _, err := regexp.Compile(regex); err != nil {
+			return fmt.Errorf(`invalid regex "%s": %w`, regex, err)
+		}
+	}
+
+	switch r.Action {
+	case MetricRuleActionRename:
+		if r.Regex == "" || r.Replacement == "" {
+			return fmt.Errorf("regex and replacement are required for action %s", r.Action)
+		}
+	case MetricRuleActionLabelDrop:
+		if r.Regex == "" {
+			return fmt.Errorf("regex is required for action %s", r.Action)
+		}
+	case MetricRuleActionReplace:
+		if r.Label == "" {
+			return fmt.Errorf("label is required for action %s", r.Action)
+		}
+		if r.Regex == "" {
+			r.Regex = "(.*)"
+		}
+		if r.TargetLabel == "" {
+			r.TargetLabel = r.Label
+		}
+		if !model.LabelName(r.TargetLabel).IsValid() {
+			return fmt.Errorf(`invalid target label "%s"`, r.TargetLabel)
+		}
+	default:
+		return fmt.Errorf(`invalid action "%s" (rename, labeldrop or replace)`, r.Action)
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the config with client secrets replaced (eg. for debug output)
+func (c *Config) Redacted() *Config {
+	ret := *c
+	ret.Credentials = map[string]CredentialConfig{}
+	for name, credential := range c.Credentials {
+		if credential.ClientSecret != "" {
+			credential.ClientSecret = "REDACTED"
+		}
+		ret.Credentials[name] = credential
+	}
+	return &ret
+}
+
+// IsServicePrincipal returns true if the credential is a service principal (client secret)
+func (c *CredentialConfig) IsServicePrincipal() bool {
+	return c.TenantId != "" || c.ClientId != "" || c.ClientSecret != "" || c.ClientSecretFile != ""
+}
+
+// GetClientSecret returns the client secret, read from clientSecretFile if set
+func (c *CredentialConfig) GetClientSecret() (string, error) {
+	if c.ClientSecretFile != "" {
+		content, err := os.ReadFile(c.ClientSecretFile) // #nosec G304
+		if err != nil {
+			return "", fmt.Errorf(`unable to read client secret file "%s": %w`, c.ClientSecretFile, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	return c.ClientSecret, nil
+}
+
+// CredentialsFromEnvironment returns the named credentials defined as
+// AZURE_CREDENTIAL_<NAME>_TENANT_ID, AZURE_CREDENTIAL_<NAME>_CLIENT_ID and AZURE_CREDENTIAL_<NAME>_CLIENT_SECRET(_FILE),
+// names are lowercased
+func CredentialsFromEnvironment() map[string]CredentialConfig {
+	ret := map[string]CredentialConfig{}
+	for _, env := range os.Environ() {
+		key, value, found := strings.Cut(env, "=")
+		if !found || !strings.HasPrefix(key, EnvCredentialPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, EnvCredentialPrefix)
+
+		for _, suffix := range []string{"_TENANT_ID", "_CLIENT_ID", "_CLIENT_SECRET_FILE", "_CLIENT_SECRET"} {
+			if !strings.HasSuffix(key, suffix) || len(key) == len(suffix) {
+				continue
+			}
+
+			name := strings.ToLower(strings.TrimSuffix(key, suffix))
+			credential := ret[name]
+			switch suffix {
+			case "_TENANT_ID":
+				credential.TenantId = value
+			case "_CLIENT_ID":
+				credential.ClientId = value
+			case "_CLIENT_SECRET_FILE":
+				credential.ClientSecretFile = value
+			case "_CLIENT_SECRET":
+				credential.ClientSecret = value
+			}
+			ret[name] = credential
+			break
+		}
+	}
+
+	return ret
+}
+
+// Url returns the probe url (path and query) for the job
+func (j *JobConfig) Url() string {
+	params := url.Values{}
+	for name, values := range j.Params {
+		for _, value := range values {
+			params.Add(name, value)
+		}
+	}
+
+	for _, subscription := range j.Subscriptions {
+		params.Add("subscription", subscription)
+	}
+	if j.ResourceType != "" {
+		params.Set("resourceType", j.ResourceType)
+	}
+	if j.Filter != "" {
+		params.Set("filter", j.Filter)
+	}
+	for _, metric := range j.Metrics {
+		params.Add("metric", metric)
+	}
+	for _, aggregation := range j.Aggregations {
+		params.Add("aggregation", aggregation)
+	}
+
+	return j.Endpoint + "?" + params.Encode()
+}
+
+// IsProbeUrl checks if path is one of the probe endpoints
+func IsProbeUrl(path string) bool {
+	switch strings.TrimRight(path, "/") {
+	case ProbeMetricsResourceUrl,
+		ProbeMetricsListUrl,
+		ProbeMetricsSubscriptionUrl,
+		ProbeMetricsScrapeUrl,
+		ProbeMetricsResourceGraphUrl,
+		ProbeLogsQueryUrl:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	DebugConfigUrl   = "/debug/config"
+	DebugLogLevelUrl = "/debug/loglevel"
+	DebugStatsUrl    = "/debug/stats"
+	DebugPprofUrl    = "/debug/pprof/"
+)
+
+type (
+	debugConfigResponse struct {
+		Opts       config.Opts    `json:"opts"`
+		ConfigFile *config.Config `json:"configFile"`
+	}
+
+	debugStatsResponse struct {
+		Uptime     string                     `json:"uptime"`
+		Goroutines int                        `json:"goroutines"`
+		Memory     debugStatsMemory           `json:"memory"`
+		Caches     map[string]debugStatsCache `json:"caches"`
+		Prewarm    int                        `json:"prewarmJobs"`
+		Probes     []debugStatsProbe          `json:"probes"`
+	}
+
+	debugStatsMemory struct {
+		Alloc       uint64 `json:"alloc"`
+		HeapInuse   uint64 `json:"heapInuse"`
+		HeapObjects uint64 `json:"heapObjects"`
+		Sys         uint64 `json:"sys"`
+		NumGC       uint32 `json:"numGC"`
+	}
+
+	debugStatsCache struct {
+		Backend string `json:"backend"`
+		Items   *int   `json:"items,omitempty"`
+	}
+
+	debugStatsProbe struct {
+		Handler         string  `json:"handler"`
+		Count           uint64  `json:"count"`
+		DurationTotal   float64 `json:"durationTotal"`
+		DurationAverage float64 `json:"durationAverage"`
+	}
+)
+
+var (
+	processStartTime = time.Now()
+)
+
+// initDebugEndpoints registers the debug endpoints, pprof and stats only with --development.debug-endpoints
+func initDebugEndpoints(mux *http.ServeMux) {
+	mux.HandleFunc(DebugConfigUrl, debugConfigHandler)
+	mux.Handle(DebugLogLevelUrl, debugLogLevelHandler())
+
+	if !Opts.Development.DebugEndpoints {
+		return
+	}
+
+	logger.Infof("enabled debug endpoints (%s, %s)", DebugPprofUrl, DebugStatsUrl)
+	mux.HandleFunc(DebugStatsUrl, debugStatsHandler)
+	mux.HandleFunc(DebugPprofUrl, pprof.Index)
+	mux.HandleFunc(DebugPprofUrl+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(DebugPprofUrl+"profile", pprof.Profile)
+	mux.HandleFunc(DebugPprofUrl+"symbol", pprof.Symbol)
+	mux.HandleFunc(DebugPprofUrl+"trace", pprof.Trace)
+}
+
+// debugConfigHandler shows the effective configuration (flags and config file), secrets are redacted
+func debugConfigHandler(w http.ResponseWriter, r *http.Request) {
+	response := debugConfigResponse{
+		Opts: Opts.Redacted(),
+	}
+	if conf := ConfigFile; conf != nil {
+		response.ConfigFile = conf.Redacted()
+	}
+
+	debugWriteJson(w, response)
+}
+
+// debugLogLevelHandler shows (GET) and changes (PUT) the log level at runtime
+//
+//	curl -X PUT -d '{"level":"debug"}' http://localhost:8080/debug/loglevel
+func debugLogLevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		previousLevel := logLevel.Level()
+		logLevel.ServeHTTP(w, r)
+		if level := logLevel.Level(); level != previousLevel {
+			logger.Infof(`changed log level from "%s" to "%s"`, previousLevel.String(), level.String())
+		}
+	})
+}
+
+// debugStatsHandler shows runtime diagnostics (goroutines, memory, cache sizes and probe timings)
+func debugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+
+	response := debugStatsResponse{
+		Uptime:     time.Since(processStartTime).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: debugStatsMemory{
+			Alloc:       memStats.Alloc,
+			HeapInuse:   memStats.HeapInuse,
+			HeapObjects: memStats.HeapObjects,
+			Sys:         memStats.Sys,
+			NumGC:       memStats.NumGC,
+		},
+		Caches:  map[string]debugStatsCache{},
+		Prewarm: prewarmScheduler.JobCount(),
+		Probes:  debugProbeStats(),
+	}
+
+	for name, cache := range cacheBackends {
+		stats := debugStatsCache{Backend: Opts.Cache.Backend}
+		// item count is only available for in-process caches
+		if counter, ok := cache.(interface{ ItemCount() int }); ok {
+			items := counter.ItemCount()
+			stats.Items = &items
+		}
+		response.Caches[name] = stats
+	}
+
+	debugWriteJson(w, response)
+}
+
+// debugProbeStats returns the collect time per probe handler (azurerm_stats_metric_collecttime)
+func debugProbeStats() []debugStatsProbe {
+	ret := []debugStatsProbe{}
+
+	metricFamilies, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		logger.Error(err)
+		return ret
+	}
+
+	probes := map[string]*debugStatsProbe{}
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != "azurerm_stats_metric_collecttime" {
+			continue
+		}
+
+		for _, metric := range metricFamily.GetMetric() {
+			handler := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "handler" {
+					handler = label.GetValue()
+				}
+			}
+
+			if _, exists := probes[handler]; !exists {
+				probes[handler] = &debugStatsProbe{Handler: handler}
+			}
+			probes[handler].Count += metric.GetSummary().GetSampleCount()
+			probes[handler].DurationTotal += metric.GetSummary().GetSampleSum()
+		}
+	}
+
+	for _, probe := range probes {
+		if probe.Count > 0 {
+			probe.DurationAverage = probe.DurationTotal / float64(probe.Count)
+		}
+		ret = append(ret, *probe)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Handler < ret[j].Handler
+	})
+
+	return ret
+}
+
+func debugWriteJson(w http.ResponseWriter, response interface{}) {
+	content, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if _, err := w.Write(content); err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	DiscoveryGroupByResource      = "resource"
+	DiscoveryGroupByResourceGroup = "resourceGroup"
+)
+
+type (
+	// DiscoveryTargetGroup is a target group of the Prometheus HTTP service discovery
+	DiscoveryTargetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
+	}
+)
+
+var (
+	discoveryLabelNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// discoveryTargetsHandler generates Prometheus http_sd targets (one probe target per resource or resource group)
+func discoveryTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.DiscoveryTargetsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.RequestMetricSettings
+	if settings, err = metrics.NewRequestMetricSettings(r, Opts); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// resource type can also be set by profile
+	resourceType := settings.ResourceType
+	if resourceType == "" {
+		err := fmt.Errorf("parameter \"resourceType\" is missing")
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groupBy := paramsGetWithDefault(r.URL.Query(), "groupBy", DiscoveryGroupByResource)
+	if groupBy != DiscoveryGroupByResource && groupBy != DiscoveryGroupByResourceGroup {
+		err := fmt.Errorf(`parameter "groupBy" must be "%s" or "%s"`, DiscoveryGroupByResource, DiscoveryGroupByResourceGroup)
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// address of the exporter used as target, defaults to the host of the discovery request
+	address := paramsGetWithDefault(r.URL.Query(), "address", r.Host)
+
+	prober := metrics.NewMetricProber(ctx, contextLogger, w, &settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureCredential(azureCredential)
+	if resourceGraphCacheDuration := *Opts.Azure.ServiceDiscovery.CacheDuration; resourceGraphCacheDuration.Seconds() > 0 {
+		prober.EnableResourceGraphCache(resourceGraphCache, resourceGraphCacheDuration)
+	}
+
+	resourceList, err := prober.ServiceDiscovery.ListResourceGraph(ctx, settings.Subscriptions, resourceType, settings.Filter)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var targetGroups []DiscoveryTargetGroup
+	switch groupBy {
+	case DiscoveryGroupByResourceGroup:
+		targetGroups = buildResourceGroupTargetGroups(resourceList, address, resourceType, settings)
+	default:
+		targetGroups = buildResourceTargetGroups(resourceList, address, resourceType, settings)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targetGroups); err != nil {
+		contextLogger.Error(err)
+	}
+}
+
+// buildResourceTargetGroups creates one /probe/metrics/resource target per resource
+func buildResourceTargetGroups(resourceList []metrics.AzureResource, address, resourceType string, settings metrics.RequestMetricSettings) []DiscoveryTargetGroup {
+	targetGroups := []DiscoveryTargetGroup{}
+	for _, resource := range resourceList {
+		resourceInfo, err := armclient.ParseResourceId(resource.ID)
+		if err != nil {
+			continue
+		}
+
+		labels := map[string]string{
+			"__metrics_path__":             config.ProbeMetricsResourceUrl,
+			"__param_subscription":         resourceInfo.Subscription,
+			"__param_target":               resource.ID,
+			"__meta_azure_subscription_id": resourceInfo.Subscription,
+			"__meta_azure_resource_group":  resourceInfo.ResourceGroup,
+			"__meta_azure_resource_id":     resource.ID,
+			"__meta_azure_resource_name":   resourceInfo.ResourceName,
+			"__meta_azure_resource_type":   resourceType,
+			"__meta_azure_location":        resource.Location,
+		}
+		if settings.Credential != "" {
+			labels["__param_credential"] = settings.Credential
+		}
+		if settings.Tenant != "" {
+			labels["__param_tenant"] = settings.Tenant
+		}
+		for tagName, tagValue := range resource.Tags {
+			labels["__meta_azure_tag_"+discoveryLabelNameRegexp.ReplaceAllString(tagName, "_")] = tagValue
+		}
+
+		targetGroups = append(targetGroups, DiscoveryTargetGroup{
+			Targets: []string{address},
+			Labels:  labels,
+		})
+	}
+
+	sort.Slice(targetGroups, func(i, j int) bool {
+		return targetGroups[i].Labels["__param_target"] < targetGroups[j].Labels["__param_target"]
+	})
+
+	return targetGroups
+}
+
+// buildResourceGroupTargetGroups creates one /probe/metrics/resourcegraph target per resource group
+func buildResourceGroupTargetGroups(resourceList []metrics.AzureResource, address, resourceType string, settings metrics.RequestMetricSettings) []DiscoveryTargetGroup {
+	targetGroupMap := map[string]DiscoveryTargetGroup{}
+	for _, resource := range resourceList {
+		resourceInfo, err := armclient.ParseResourceId(resource.ID)
+		if err != nil {
+			continue
+		}
+
+		groupKey := strings.ToLower(resourceInfo.Subscription + "/" + resourceInfo.ResourceGroup)
+		if _, exists := targetGroupMap[groupKey]; exists {
+			continue
+		}
+
+		filter := fmt.Sprintf(`where resourceGroup =~ "%s"`, strings.ReplaceAll(resourceInfo.ResourceGroup, `"`, `\"`))
+		if settings.Filter != "" {
+			filter = settings.Filter + " | " + filter
+		}
+
+		labels := map[string]string{
+			"__metrics_path__":             config.ProbeMetricsResourceGraphUrl,
+			"__param_subscription":         resourceInfo.Subscription,
+			"__param_resourceType":         resourceType,
+			"__param_filter":               filter,
+			"__meta_azure_subscription_id": resourceInfo.Subscription,
+			"__meta_azure_resource_group":  resourceInfo.ResourceGroup,
+			"__meta_azure_resource_type":   resourceType,
+		}
+		if settings.Credential != "" {
+			labels["__param_credential"] = settings.Credential
+		}
+		if settings.Tenant != "" {
+			labels["__param_tenant"] = settings.Tenant
+		}
+
+		targetGroupMap[groupKey] = DiscoveryTargetGroup{
+			Targets: []string{address},
+			Labels:  labels,
+		}
+	}
+
+	groupKeys := make([]string, 0, len(targetGroupMap))
+	for groupKey := range targetGroupMap {
+		groupKeys = append(groupKeys, groupKey)
+	}
+	sort.Strings(groupKeys)
+
+	targetGroups := make([]DiscoveryTargetGroup, 0, len(groupKeys))
+	for _, groupKey := range groupKeys {
+		targetGroups = append(targetGroups, targetGroupMap[groupKey])
+	}
+
+	return targetGroups
+}
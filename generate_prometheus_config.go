@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	GenerateScrapeIntervalDefault = 1 * time.Minute
+)
+
+type (
+	prometheusConfig struct {
+		ScrapeConfigs []prometheusScrapeConfig `yaml:"scrape_configs"`
+	}
+
+	prometheusScrapeConfig struct {
+		JobName        string                    `yaml:"job_name"`
+		ScrapeInterval string                    `yaml:"scrape_interval"`
+		ScrapeTimeout  string                    `yaml:"scrape_timeout"`
+		Scheme         string                    `yaml:"scheme,omitempty"`
+		MetricsPath    string                    `yaml:"metrics_path"`
+		Params         map[string][]string       `yaml:"params,omitempty"`
+		StaticConfigs  []prometheusStaticConfig  `yaml:"static_configs"`
+		RelabelConfigs []prometheusRelabelConfig `yaml:"relabel_configs,omitempty"`
+	}
+
+	prometheusStaticConfig struct {
+		Targets []string `yaml:"targets"`
+	}
+
+	prometheusRelabelConfig struct {
+		SourceLabels []string `yaml:"source_labels,omitempty"`
+		TargetLabel  string   `yaml:"target_label"`
+		Replacement  string   `yaml:"replacement,omitempty"`
+	}
+)
+
+var (
+	// parameters of the generator, all other parameters are passed to the probe
+	generatePrometheusConfigParams = map[string]bool{
+		"endpoint":       true,
+		"job":            true,
+		"jobName":        true,
+		"address":        true,
+		"scrapeInterval": true,
+		"scrapeTimeout":  true,
+	}
+
+	// probe timeouts (seconds) used as scrape timeout (limited by the scrape interval)
+	probeTimeoutDefaults = map[string]float64{
+		config.ProbeMetricsResourceUrl:      config.ProbeMetricsResourceTimeoutDefault,
+		config.ProbeMetricsListUrl:          config.ProbeMetricsListTimeoutDefault,
+		config.ProbeMetricsSubscriptionUrl:  config.ProbeMetricsSubscriptionTimeoutDefault,
+		config.ProbeMetricsScrapeUrl:        config.ProbeMetricsScrapeTimeoutDefault,
+		config.ProbeMetricsResourceGraphUrl: config.ProbeMetricsResourceGraphTimeoutDefault,
+		config.ProbeMetricsAppInsightsUrl:   config.ProbeMetricsAppInsightsTimeoutDefault,
+		config.ProbeCostsUrl:                config.ProbeCostsTimeoutDefault,
+		config.ProbeHealthResourceUrl:       config.ProbeHealthResourceTimeoutDefault,
+		config.ProbeAlertsUrl:               config.ProbeAlertsTimeoutDefault,
+		config.ProbeAdvisorUrl:              config.ProbeAdvisorTimeoutDefault,
+		config.ProbeQuotaUrl:                config.ProbeQuotaTimeoutDefault,
+		config.ProbePolicyUrl:               config.ProbePolicyTimeoutDefault,
+		config.ProbeActivityLogUrl:          config.ProbeActivityLogTimeoutDefault,
+		config.ProbeArcUrl:                  config.ProbeArcTimeoutDefault,
+		config.ProbeKeyVaultUrl:             config.ProbeKeyVaultTimeoutDefault,
+		config.ProbeVmScheduledEventsUrl:    config.ProbeVmScheduledEventsTimeoutDefault,
+		config.ProbeLogsQueryUrl:            config.ProbeLogsQueryTimeoutDefault,
+	}
+)
+
+// generatePrometheusConfigHandler generates the Prometheus scrape config of a probe (parameters of the request)
+// or of a job of the config file (parameter job)
+func generatePrometheusConfigHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	scrapeConfig, err := buildPrometheusScrapeConfig(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	content, err := yaml.Marshal(prometheusConfig{ScrapeConfigs: []prometheusScrapeConfig{scrapeConfig}})
+	if err != nil {
+		contextLogger.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(content); err != nil {
+		contextLogger.Error(err)
+	}
+}
+
+func buildPrometheusScrapeConfig(r *http.Request) (prometheusScrapeConfig, error) {
+	query := r.URL.Query()
+
+	scrapeConfig := prometheusScrapeConfig{
+		MetricsPath: query.Get("endpoint"),
+		Params:      map[string][]string{},
+	}
+	interval := GenerateScrapeIntervalDefault
+	var timeout time.Duration
+
+	// job of config file
+	if jobName := query.Get("job"); jobName != "" {
+		job, err := findConfigJob(jobName)
+		if err != nil {
+			return scrapeConfig, err
+		}
+
+		jobUrl, err := url.Parse(job.Url())
+		if err != nil {
+			return scrapeConfig, err
+		}
+		scrapeConfig.JobName = job.Name
+		scrapeConfig.MetricsPath = jobUrl.Path
+		scrapeConfig.Params = jobUrl.Query()
+		interval = job.Interval
+		timeout = job.Timeout
+	}
+
+	if scrapeConfig.MetricsPath == "" {
+		scrapeConfig.MetricsPath = config.ProbeMetricsListUrl
+	}
+	if !config.IsProbeUrl(scrapeConfig.MetricsPath) {
+		return scrapeConfig, fmt.Errorf(`parameter "endpoint" is not a probe endpoint: %s`, scrapeConfig.MetricsPath)
+	}
+
+	// probe parameters of the request (replace parameters of the job)
+	for name, values := range query {
+		if !generatePrometheusConfigParams[name] {
+			scrapeConfig.Params[name] = values
+		}
+	}
+
+	if val := query.Get("jobName"); val != "" {
+		scrapeConfig.JobName = val
+	}
+	if scrapeConfig.JobName == "" {
+		scrapeConfig.JobName = "azure-metrics" + strings.ReplaceAll(strings.TrimPrefix(scrapeConfig.MetricsPath, "/probe/metrics"), "/", "-")
+	}
+
+	if val := query.Get("scrapeInterval"); val != "" {
+		duration, err := time.ParseDuration(val)
+		if err != nil || duration <= 0 {
+			return scrapeConfig, fmt.Errorf(`parameter "scrapeInterval" is invalid: %s`, val)
+		}
+		interval = duration
+	}
+	if val := query.Get("scrapeTimeout"); val != "" {
+		duration, err := time.ParseDuration(val)
+		if err != nil || duration <= 0 {
+			return scrapeConfig, fmt.Errorf(`parameter "scrapeTimeout" is invalid: %s`, val)
+		}
+		timeout = duration
+	}
+	if timeout == 0 {
+		timeout = time.Duration(probeTimeoutDefaults[scrapeConfig.MetricsPath] * float64(time.Second))
+	}
+	if timeout == 0 || timeout > interval {
+		// Prometheus rejects scrape timeouts longer than the interval
+		timeout = interval
+	}
+	scrapeConfig.ScrapeInterval = model.Duration(interval).String()
+	scrapeConfig.ScrapeTimeout = model.Duration(timeout).String()
+
+	// exporter address, defaults to the address of the request
+	address := query.Get("address")
+	if address == "" {
+		address = r.Host
+	}
+	if r.TLS != nil {
+		scrapeConfig.Scheme = "https"
+	}
+
+	if targets, exists := scrapeConfig.Params["target"]; exists && scrapeConfig.MetricsPath == config.ProbeMetricsResourceUrl {
+		// one Prometheus target per resource: resource ids are the targets and passed as parameter target,
+		// the exporter is scraped instead (multi-target exporter pattern)
+		delete(scrapeConfig.Params, "target")
+		sort.Strings(targets)
+		scrapeConfig.StaticConfigs = []prometheusStaticConfig{{Targets: targets}}
+		scrapeConfig.RelabelConfigs = []prometheusRelabelConfig{
+			{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+			{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+			{TargetLabel: "__address__", Replacement: address},
+		}
+	} else {
+		scrapeConfig.StaticConfigs = []prometheusStaticConfig{{Targets: []string{address}}}
+	}
+
+	if len(scrapeConfig.Params) == 0 {
+		scrapeConfig.Params = nil
+	}
+
+	return scrapeConfig, nil
+}
+
+// findConfigJob returns the job of the config file by name
+func findConfigJob(name string) (*config.JobConfig, error) {
+	if ConfigFile == nil {
+		return nil, fmt.Errorf(`job "%s" not found, no config file loaded`, name)
+	}
+
+	for i := range ConfigFile.Jobs {
+		if ConfigFile.Jobs[i].Name == name {
+			return &ConfigFile.Jobs[i], nil
+		}
+	}
+	return nil, fmt.Errorf(`job "%s" not found in config file`, name)
+}
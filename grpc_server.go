@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apiv1 "github.com/webdevops/azure-metrics-exporter/api/v1"
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// grpcMetricsServer implements the gRPC api (api/v1/exporter.proto), calls are executed as probe requests
+	// through the http server handler, so authentication, access log, probe limits, restrictions and caches apply
+	grpcMetricsServer struct {
+		apiv1.UnimplementedMetricsExporterServer
+
+		handler http.Handler
+	}
+)
+
+// startGrpcServer starts the gRPC server (--server.grpc.bind) using the http server handler, the TLS settings of
+// the http server are used
+func startGrpcServer(handler http.Handler) *grpc.Server {
+	if Opts.Server.Grpc.Bind == "" {
+		return nil
+	}
+
+	serverOpts := []grpc.ServerOption{}
+
+	tlsConfig, err := buildServerTlsConfig()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	listener, err := net.Listen("tcp", Opts.Server.Grpc.Bind)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	srv := grpc.NewServer(serverOpts...)
+	apiv1.RegisterMetricsExporterServer(srv, &grpcMetricsServer{handler: handler})
+
+	logger.Infof("starting grpc server on %s", Opts.Server.Grpc.Bind)
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			logger.Fatal(err)
+		}
+	}()
+
+	return srv
+}
+
+// QueryResourceMetrics probes the resources (/probe/metrics/resource) or the resources of the resource type and
+// filter (/probe/metrics/list)
+func (s *grpcMetricsServer) QueryResourceMetrics(ctx context.Context, req *apiv1.QueryResourceMetricsRequest) (*apiv1.MetricsResponse, error) {
+	params := url.Values{}
+	setGrpcListParam(params, "subscription", req.GetSubscriptions())
+	setGrpcCredentialParams(params, req.GetCredential())
+	setGrpcMetricQueryParams(params, req.GetQuery())
+
+	probeUrl := config.ProbeMetricsListUrl
+	if len(req.GetResourceIds()) > 0 {
+		probeUrl = config.ProbeMetricsResourceUrl
+		setGrpcListParam(params, "target", req.GetResourceIds())
+	} else {
+		setGrpcParam(params, "resourceType", req.GetResourceType())
+		setGrpcParam(params, "filter", req.GetFilter())
+	}
+
+	return s.probeMetrics(ctx, probeUrl, params)
+}
+
+// RunResourceGraph probes the resources of a ResourceGraph filter or named query (/probe/metrics/resourcegraph)
+func (s *grpcMetricsServer) RunResourceGraph(ctx context.Context, req *apiv1.RunResourceGraphRequest) (*apiv1.MetricsResponse, error) {
+	params := url.Values{}
+	setGrpcListParam(params, "subscription", req.GetSubscriptions())
+	setGrpcCredentialParams(params, req.GetCredential())
+	setGrpcMetricQueryParams(params, req.GetQuery())
+	setGrpcParam(params, "resourceType", req.GetResourceType())
+	setGrpcParam(params, "filter", req.GetFilter())
+	setGrpcParam(params, "query", req.GetNamedQuery())
+	for name, value := range req.GetNamedQueryParams() {
+		params.Set(metrics.ResourceGraphQueryParamPrefix+name, value)
+	}
+
+	return s.probeMetrics(ctx, config.ProbeMetricsResourceGraphUrl, params)
+}
+
+// ListResources lists the resources of the resource type (/discovery/targets?groupBy=resource)
+func (s *grpcMetricsServer) ListResources(ctx context.Context, req *apiv1.ListResourcesRequest) (*apiv1.ListResourcesResponse, error) {
+	params := url.Values{}
+	setGrpcListParam(params, "subscription", req.GetSubscriptions())
+	setGrpcCredentialParams(params, req.GetCredential())
+	setGrpcParam(params, "resourceType", req.GetResourceType())
+	setGrpcParam(params, "filter", req.GetFilter())
+	params.Set("groupBy", DiscoveryGroupByResource)
+
+	body, err := s.execute(ctx, config.DiscoveryTargetsUrl, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var targetGroups []DiscoveryTargetGroup
+	if err := json.Unmarshal(body, &targetGroups); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to decode discovery result: %v", err)
+	}
+
+	response := &apiv1.ListResourcesResponse{
+		Resources: make([]*apiv1.Resource, 0, len(targetGroups)),
+	}
+	for _, targetGroup := range targetGroups {
+		resource := &apiv1.Resource{
+			ResourceId: targetGroup.Labels["__meta_azure_resource_id"],
+			Location:   targetGroup.Labels["__meta_azure_location"],
+			Tags:       map[string]string{},
+		}
+		for name, value := range targetGroup.Labels {
+			if tagName, found := strings.CutPrefix(name, "__meta_azure_tag_"); found {
+				resource.Tags[tagName] = value
+			}
+		}
+		response.Resources = append(response.Resources, resource)
+	}
+
+	return response, nil
+}
+
+// probeMetrics executes the probe with json output (format=json) and converts the metric families
+func (s *grpcMetricsServer) probeMetrics(ctx context.Context, probeUrl string, params url.Values) (*apiv1.MetricsResponse, error) {
+	params.Set("format", ProbeFormatJson)
+
+	body, err := s.execute(ctx, probeUrl, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var families []ProbeJsonMetric
+	if err := json.Unmarshal(body, &families); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to decode probe result: %v", err)
+	}
+
+	response := &apiv1.MetricsResponse{
+		Metrics: make([]*apiv1.MetricFamily, 0, len(families)),
+	}
+	for _, family := range families {
+		metricFamily := &apiv1.MetricFamily{
+			Name:   family.Name,
+			Type:   family.Type,
+			Help:   family.Help,
+			Series: make([]*apiv1.Series, 0, len(family.Series)),
+		}
+		for _, row := range family.Series {
+			series := &apiv1.Series{
+				ResourceId:  row.ResourceId,
+				Metric:      row.Metric,
+				Aggregation: row.Aggregation,
+				Labels:      row.Labels,
+				Value:       row.Value,
+				Count:       row.Count,
+			}
+			if row.Timestamp != nil {
+				series.Timestamp = timestamppb.New(*row.Timestamp)
+			}
+			metricFamily.Series = append(metricFamily.Series, series)
+		}
+		response.Metrics = append(response.Metrics, metricFamily)
+	}
+
+	return response, nil
+}
+
+// execute runs the request through the http server handler, metadata of the call is passed as http headers
+// (eg. authorization) and the deadline as Prometheus scrape timeout, failed requests are returned as gRPC status
+func (s *grpcMetricsServer) execute(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for name, values := range md {
+			if strings.HasPrefix(name, ":") || strings.HasPrefix(name, "grpc-") || name == "content-type" {
+				continue
+			}
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(time.Until(deadline).Seconds(), 'f', 3, 64))
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	w := newJobResponseWriter()
+	s.handler.ServeHTTP(w, req)
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	if w.statusCode != http.StatusOK {
+		message := strings.TrimSpace(w.body.String())
+		if message == "" {
+			message = http.StatusText(w.statusCode)
+		}
+		return nil, status.Error(grpcStatusCode(w.statusCode), message)
+	}
+
+	return w.body.Bytes(), nil
+}
+
+// grpcStatusCode translates the http status of the probe into the gRPC status code
+func grpcStatusCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+func setGrpcParam(params url.Values, name, value string) {
+	if value != "" {
+		params.Set(name, value)
+	}
+}
+
+func setGrpcListParam(params url.Values, name string, values []string) {
+	for _, value := range values {
+		params.Add(name, value)
+	}
+}
+
+func setGrpcCredentialParams(params url.Values, credential *apiv1.Credential) {
+	setGrpcParam(params, "credential", credential.GetName())
+	setGrpcParam(params, "tenant", credential.GetTenant())
+}
+
+// setGrpcMetricQueryParams sets the metric parameters, additional parameters can't override the typed fields
+func setGrpcMetricQueryParams(params url.Values, query *apiv1.MetricQuery) {
+	setGrpcListParam(params, "metric", query.GetMetrics())
+	setGrpcListParam(params, "aggregation", query.GetAggregations())
+	setGrpcListParam(params, "dimension", query.GetDimensions())
+	setGrpcParam(params, "interval", query.GetInterval())
+	setGrpcParam(params, "timespan", query.GetTimespan())
+	setGrpcParam(params, "metricNamespace", query.GetMetricNamespace())
+	setGrpcParam(params, "metricFilter", query.GetMetricFilter())
+	setGrpcParam(params, "metricTop", query.GetMetricTop())
+
+	for name, value := range query.GetParams() {
+		if !params.Has(name) {
+			params.Set(name, value)
+		}
+	}
+}
+
+// stopGrpcServer waits for in-flight calls until the context is done, remaining calls are aborted
+func stopGrpcServer(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+		logger.Errorf("graceful shutdown of grpc server failed, in-flight calls were aborted: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	IndexUrl = "/{$}"
+)
+
+type (
+	// indexEndpoint is an endpoint of the exporter listed on the index page
+	indexEndpoint struct {
+		Url         string
+		Description string
+	}
+
+	indexPayload struct {
+		Nonce string
+
+		Version   string
+		Commit    string
+		GoVersion string
+		Uptime    string
+
+		Endpoints []indexEndpoint
+
+		ShowJobs bool
+		Jobs     []jobStatus
+		Prewarm  []prewarmStatus
+	}
+)
+
+// indexEndpoints returns the endpoints registered with the current configuration
+func indexEndpoints() []indexEndpoint {
+	endpoints := []indexEndpoint{
+		{"/healthz", "Liveness check"},
+		{"/readyz", "Readiness check"},
+		{config.MetricsUrl, "Exporter metrics and results of scheduled jobs"},
+		{config.ProbeMetricsSubscriptionUrl, "Probe metrics by subscription and region"},
+		{config.ProbeMetricsResourceUrl, "Probe metrics of one resource"},
+		{config.ProbeMetricsListUrl, "Probe metrics of resources by resource type or filter"},
+		{config.ProbeMetricsScrapeUrl, "Probe metrics of resources configured by tag"},
+		{config.ProbeMetricsResourceGraphUrl, "Probe metrics of resources of a Resource Graph query"},
+		{config.ProbeMetricsDefinitionsUrl, "Metric definitions of a resource or resource type"},
+		{config.ProbeMetricsNamespacesUrl, "Metric namespaces of a resource or resource type"},
+		{config.ProbeMetricsAppInsightsUrl, "Probe Application Insights metrics"},
+		{config.ProbeCostsUrl, "Probe Cost Management costs"},
+		{config.ProbeHealthResourceUrl, "Probe Resource Health and Service Health events"},
+		{config.ProbeAlertsUrl, "Probe Azure Monitor metric alert rules"},
+		{config.ProbeAdvisorUrl, "Probe Azure Advisor recommendations"},
+		{config.ProbeQuotaUrl, "Probe subscription quotas"},
+		{config.ProbePolicyUrl, "Probe Azure Policy compliance"},
+		{config.ProbeActivityLogUrl, "Probe activity log events"},
+		{config.ProbeArcUrl, "Probe Azure Arc connectivity status"},
+		{config.ProbeKeyVaultUrl, "Probe Key Vault expiry"},
+		{config.ProbeVmScheduledEventsUrl, "Probe scheduled events and Spot evictions"},
+		{config.ProbeCollectorUrl + "<name>", "Probe custom collectors"},
+		{config.ProbeLogsQueryUrl, "Probe Log Analytics queries"},
+		{config.ProbeValidateUrl, "Validate probe parameters"},
+		{config.DiscoveryTargetsUrl, "Prometheus HTTP service discovery"},
+		{config.GeneratePrometheusConfigUrl, "Prometheus scrape config of probe parameters"},
+		{"/query", "Query web UI"},
+		{DebugConfigUrl, "Effective configuration"},
+		{DebugLogLevelUrl, "Log level"},
+	}
+
+	if Opts.Development.DebugEndpoints {
+		endpoints = append(
+			endpoints,
+			indexEndpoint{DebugStatsUrl, "Runtime diagnostics"},
+			indexEndpoint{DebugPprofUrl, "Go pprof profiling"},
+		)
+	}
+
+	if Opts.Webhook.EventGrid {
+		endpoints = append(endpoints, indexEndpoint{config.WebhookEventGridUrl, "Event Grid webhook (cache invalidation)"})
+	}
+
+	if customMetricsRelay != nil {
+		endpoints = append(endpoints, indexEndpoint{config.RelayRemoteWriteUrl, "Remote-write receiver of the custom metrics relay"})
+	}
+
+	return endpoints
+}
+
+// indexHandler serves the index page with the endpoints, build info and optionally the status of scheduled jobs
+// and prewarm probes (--server.index.jobs)
+func indexHandler(tmpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cspNonce := base64.StdEncoding.EncodeToString([]byte(uuid.New().String()))
+		setHtmlHeaders(w, cspNonce)
+
+		payload := indexPayload{
+			Nonce:     cspNonce,
+			Version:   gitTag,
+			Commit:    gitCommit,
+			GoVersion: runtime.Version(),
+			Uptime:    time.Since(processStartTime).Round(time.Second).String(),
+			Endpoints: indexEndpoints(),
+			ShowJobs:  Opts.Server.Index.Jobs,
+		}
+
+		if payload.ShowJobs {
+			if jobScheduler != nil {
+				payload.Jobs = jobScheduler.Status()
+			}
+			if prewarmScheduler != nil {
+				payload.Prewarm = prewarmScheduler.Status()
+			}
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "index.html", payload); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// setHtmlHeaders sets the content type and security headers of html pages, inline scripts and styles
+// require the nonce
+func setHtmlHeaders(w http.ResponseWriter, cspNonce string) {
+	w.Header().Add("Content-Type", "text/html")
+	w.Header().Add("Referrer-Policy", "same-origin")
+	w.Header().Add("X-Frame-Options", "DENY")
+	w.Header().Add("X-XSS-Protection", "1; mode=block")
+	w.Header().Add("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Content-Security-Policy",
+		fmt.Sprintf(
+			"default-src 'self'; script-src 'nonce-%[1]s'; style-src 'nonce-%[1]s'; img-src 'self' data:",
+			cspNonce,
+		),
+	)
+}
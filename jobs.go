@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/otlp"
+	"github.com/webdevops/azure-metrics-exporter/remotewrite"
+)
+
+type (
+	// JobScheduler runs the collection jobs from the config file and keeps the latest results for /metrics
+	JobScheduler struct {
+		handler http.Handler
+
+		lock    sync.RWMutex
+		conf    *config.Config
+		cancel  context.CancelFunc
+		results map[string][]*dto.MetricFamily
+		status  map[string]jobStatus
+	}
+
+	// jobStatus is the last run of a job (index page)
+	jobStatus struct {
+		Name     string
+		Endpoint string
+		Interval time.Duration
+		LastRun  time.Time
+		Duration time.Duration
+		Result   string
+		Error    string
+	}
+
+	// jobResponseWriter captures the probe response of jobs executed inside the exporter
+	jobResponseWriter struct {
+		header     http.Header
+		body       bytes.Buffer
+		statusCode int
+	}
+)
+
+var (
+	jobScheduler      *JobScheduler
+	remoteWriteClient *remotewrite.Client
+	otlpClient        *otlp.Client
+
+	prometheusJobRuns *prometheus.CounterVec
+)
+
+func newJobResponseWriter() *jobResponseWriter {
+	return &jobResponseWriter{header: http.Header{}}
+}
+
+func (w *jobResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *jobResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *jobResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+// azureRemoteWriteTokenSource returns Azure AD tokens of the named credential (or the exporter credential) for
+// remote-write requests to an Azure Monitor workspace, the credential is looked up for every request so reloaded
+// named credentials are used
+func azureRemoteWriteTokenSource(credentialName, audience string) remotewrite.TokenSource {
+	scope := audienceToScope(audience)
+	return func(ctx context.Context) (string, error) {
+		cred, err := getAzureCredential(credentialName, "")
+		if err != nil {
+			return "", err
+		}
+
+		token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+		if err != nil {
+			return "", err
+		}
+		return token.Token, nil
+	}
+}
+
+// initJobs creates the scheduler of the collection jobs of the config file, jobs are started with startJobs
+func initJobs() {
+	if ConfigFile == nil {
+		return
+	}
+
+	if Opts.Push.RemoteWrite.Url != "" {
+		remoteWriteClient = remotewrite.NewClient(Opts.Push.RemoteWrite.Url, Opts.Push.RemoteWrite.Timeout, Opts.Push.RemoteWrite.Retries)
+		remoteWriteClient.SetUserAgent(UserAgent + gitTag)
+		parsePushHeaders("remote-write", Opts.Push.RemoteWrite.Headers, remoteWriteClient.SetHeader)
+		if Opts.Push.RemoteWrite.BearerToken != "" {
+			remoteWriteClient.SetHeader("Authorization", "Bearer "+Opts.Push.RemoteWrite.BearerToken)
+		}
+		if Opts.Push.RemoteWrite.Azure.Auth {
+			if Opts.Push.RemoteWrite.BearerToken != "" {
+				logger.Fatal("--push.remote-write.bearer-token and --push.remote-write.azure.auth can't be combined")
+			}
+			remoteWriteClient.SetTokenSource(azureRemoteWriteTokenSource(Opts.Push.RemoteWrite.Azure.Credential, Opts.Push.RemoteWrite.Azure.Audience))
+		}
+	}
+
+	if Opts.Push.Otlp.Url != "" {
+		otlpClient = otlp.NewClient(Opts.Push.Otlp.Url, Opts.Push.Otlp.Timeout, Opts.Push.Otlp.Retries)
+		otlpClient.SetUserAgent(UserAgent + gitTag)
+		parsePushHeaders("otlp", Opts.Push.Otlp.Headers, otlpClient.SetHeader)
+	}
+
+	prometheusJobRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_job_runs",
+			Help: "Azure metrics exporter scheduled job runs",
+		},
+		[]string{
+			"job",
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusJobRuns)
+
+	jobScheduler = &JobScheduler{
+		results: map[string][]*dto.MetricFamily{},
+		status:  map[string]jobStatus{},
+	}
+}
+
+// startJobs starts the jobs after all routes are registered, jobs are executed through the server handler
+// (authentication, request limits and stats apply as for probe requests)
+func startJobs(handler http.Handler) {
+	if jobScheduler == nil {
+		return
+	}
+
+	jobScheduler.lock.Lock()
+	jobScheduler.handler = handler
+	jobScheduler.lock.Unlock()
+
+	jobScheduler.Start(ConfigFile)
+}
+
+// parsePushHeaders parses "name=value" headers of push clients
+func parsePushHeaders(client string, headers []string, setHeader func(name, value string)) {
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, "=")
+		if !found {
+			logger.Fatalf(`invalid %s header "%s", expected "name=value"`, client, header)
+		}
+		setHeader(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+}
+
+// Start starts all jobs of the config, running jobs are stopped (config is kept until the handler is set)
+func (s *JobScheduler) Start(conf *config.Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.conf = conf
+	if s.handler == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	// remove results of jobs which don't exist anymore
+	jobNames := map[string]bool{}
+	for _, job := range conf.Jobs {
+		jobNames[job.Name] = true
+	}
+	for name := range s.results {
+		if !jobNames[name] {
+			delete(s.results, name)
+		}
+	}
+	for name := range s.status {
+		if !jobNames[name] {
+			delete(s.status, name)
+		}
+	}
+
+	for _, job := range conf.Jobs {
+		logger.Infof(`starting job "%s" (%s every %s)`, job.Name, job.Endpoint, job.Interval.String())
+		go s.runJobLoop(ctx, job)
+	}
+}
+
+// Stop stops all jobs
+func (s *JobScheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+// Gather implements prometheus.Gatherer and returns the latest job results, families of multiple jobs are merged
+// and series (same labels) or families (different type) produced by multiple jobs are kept from the first job (by name)
+func (s *JobScheduler) Gather() ([]*dto.MetricFamily, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	jobNames := make([]string, 0, len(s.results))
+	for name := range s.results {
+		jobNames = append(jobNames, name)
+	}
+	sort.Strings(jobNames)
+
+	familyMap := map[string]*dto.MetricFamily{}
+	familySeries := map[string]map[string]bool{}
+	for _, jobName := range jobNames {
+		for _, family := range s.results[jobName] {
+			existing, exists := familyMap[family.GetName()]
+			if !exists {
+				existing = &dto.MetricFamily{
+					Name: family.Name,
+					Help: family.Help,
+					Type: family.Type,
+				}
+				familyMap[family.GetName()] = existing
+				familySeries[family.GetName()] = map[string]bool{}
+			} else if existing.GetType() != family.GetType() {
+				continue
+			}
+
+			for _, metric := range family.Metric {
+				signature := metricLabelSignature(metric)
+				if familySeries[family.GetName()][signature] {
+					continue
+				}
+				familySeries[family.GetName()][signature] = true
+				existing.Metric = append(existing.Metric, metric)
+			}
+		}
+	}
+
+	return sortMetricFamilies(familyMap), nil
+}
+
+// metricLabelSignature returns the sorted labels of the series
+func metricLabelSignature(metric *dto.Metric) string {
+	labels := make([]string, 0, len(metric.Label))
+	for _, label := range metric.Label {
+		labels = append(labels, label.GetName()+"="+strconv.Quote(label.GetValue()))
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// Status returns the jobs of the config with the status of their last run
+func (s *JobScheduler) Status() []jobStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := []jobStatus{}
+	if s.conf == nil {
+		return ret
+	}
+
+	for _, job := range s.conf.Jobs {
+		status, exists := s.status[job.Name]
+		if !exists {
+			status.Result = "pending"
+		}
+		status.Name = job.Name
+		status.Endpoint = job.Endpoint
+		status.Interval = job.Interval
+		ret = append(ret, status)
+	}
+	return ret
+}
+
+func (s *JobScheduler) setStatus(name string, startTime time.Time, result string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	status := jobStatus{
+		LastRun:  startTime,
+		Duration: time.Since(startTime),
+		Result:   result,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	s.status[name] = status
+}
+
+func (s *JobScheduler) setResult(name string, families []*dto.MetricFamily) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if families == nil {
+		delete(s.results, name)
+	} else {
+		s.results[name] = families
+	}
+}
+
+func (s *JobScheduler) runJobLoop(ctx context.Context, job config.JobConfig) {
+	contextLogger := logger.With(zap.String("job", job.Name))
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		s.runJob(ctx, job, contextLogger)
+
+		select {
+		case <-ctx.Done():
+			contextLogger.Debug("stopped job")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *JobScheduler) runJob(ctx context.Context, job config.JobConfig, contextLogger *zap.SugaredLogger) {
+	startTime := time.Now()
+
+	families, err := s.collectJob(ctx, job)
+	if ctx.Err() != nil {
+		// job was stopped (config reload)
+		return
+	}
+
+	if err != nil {
+		contextLogger.Error(err)
+		s.setResult(job.Name, nil)
+		s.setStatus(job.Name, startTime, "error", err)
+		prometheusJobRuns.WithLabelValues(job.Name, "error").Inc()
+		return
+	}
+	s.setResult(job.Name, families)
+
+	// sinks are pushed independently, a failed sink doesn't skip the others
+	var pushErr error
+	if remoteWriteClient != nil {
+		series := remotewrite.FromMetricFamilies(families, map[string]string{"job": job.Name}, startTime)
+		if err := s.push(ctx, job, func(pushCtx context.Context) error { return remoteWriteClient.Write(pushCtx, series) }); err != nil {
+			contextLogger.Error(err)
+			pushErr = errors.Join(pushErr, err)
+		} else {
+			contextLogger.Debugf("pushed %d series", len(series))
+		}
+	}
+
+	if otlpClient != nil {
+		request := otlp.FromMetricFamilies(
+			families,
+			otlp.InstrumentationScope{Name: "azure-metrics-exporter", Version: gitTag},
+			map[string]string{"service.name": "azure-metrics-exporter", "job": job.Name},
+			startTime,
+		)
+		if err := s.push(ctx, job, func(pushCtx context.Context) error { return otlpClient.Export(pushCtx, request) }); err != nil {
+			contextLogger.Error(err)
+			pushErr = errors.Join(pushErr, err)
+		} else {
+			contextLogger.Debugf("exported %d data points via otlp", request.DataPointCount())
+		}
+	}
+
+	if pushErr != nil {
+		s.setStatus(job.Name, startTime, "push-error", pushErr)
+		prometheusJobRuns.WithLabelValues(job.Name, "push-error").Inc()
+		return
+	}
+
+	contextLogger.Debugf("finished job run in %s", time.Since(startTime).String())
+	s.setStatus(job.Name, startTime, "success", nil)
+	prometheusJobRuns.WithLabelValues(job.Name, "success").Inc()
+}
+
+// push sends the job result to a push sink, limited to the job interval
+func (s *JobScheduler) push(ctx context.Context, job config.JobConfig, send func(ctx context.Context) error) error {
+	pushCtx, cancel := context.WithTimeout(ctx, job.Interval)
+	defer cancel()
+	return send(pushCtx)
+}
+
+// collectJob executes the probe of the job inside the exporter and returns the parsed metrics
+func (s *JobScheduler) collectJob(ctx context.Context, job config.JobConfig) ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	req, err := newInternalRequest(ctx, job.Url())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(job.Timeout.Seconds(), 'f', -1, 64))
+
+	w := newJobResponseWriter()
+	s.handler.ServeHTTP(w, req)
+	if w.statusCode != http.StatusOK {
+		return nil, fmt.Errorf("probe failed with status %d: %s", w.statusCode, strings.TrimSpace(w.body.String()))
+	}
+
+	parser := expfmt.TextParser{}
+	familyMap, err := parser.TextToMetricFamilies(&w.body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse probe result: %w", err)
+	}
+
+	return sortMetricFamilies(familyMap), nil
+}
+
+func sortMetricFamilies(familyMap map[string]*dto.MetricFamily) []*dto.MetricFamily {
+	names := make([]string, 0, len(familyMap))
+	for name := range familyMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	families := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		families = append(families, familyMap[name])
+	}
+	return families
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/base64"
 	"errors"
@@ -8,12 +9,14 @@ import (
 	"html/template"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jessevdk/go-flags"
-	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/webdevops/go-common/azuresdk/armclient"
@@ -21,6 +24,7 @@ import (
 	"github.com/webdevops/go-common/azuresdk/prometheus/tracing"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
 )
 
 const (
@@ -39,8 +43,22 @@ var (
 	prometheusCollectTime    *prometheus.SummaryVec
 	prometheusMetricRequests *prometheus.CounterVec
 
-	metricsCache *cache.Cache
-	azureCache   *cache.Cache
+	metricsCache          metrics.Cache
+	resourceInventory     *metrics.ResourceInventory
+	resourceInvalidations *metrics.ResourceInvalidations
+	resourceGraphCache    *metrics.ResourceGraphCache
+
+	metricDefinitionCache *metrics.MetricDefinitionCache
+	resourceTagCache      *metrics.ResourceTagCache
+
+	metricsWorkerPool   *metrics.WorkerPool
+	metricsRateLimiter  *metrics.RateLimiter
+	metricsBatchClients *metrics.MetricsBatchClients
+	metricsQuota        *metrics.QuotaCoordinator
+
+	probeRestrictions *metrics.ProbeRestrictions
+
+	serverShuttingDown atomic.Bool
 
 	//go:embed templates/*.html
 	templates embed.FS
@@ -57,11 +75,33 @@ func main() {
 	logger.Infof("starting azure-metrics-exporter v%s (%s; %s; by %v)", gitTag, gitCommit, runtime.Version(), Author)
 	logger.Info(string(Opts.GetJson()))
 	initSystem()
-	metricsCache = cache.New(1*time.Minute, 1*time.Minute)
-	azureCache = cache.New(1*time.Minute, 1*time.Minute)
+	metricsCache = newCacheBackend("metrics")
+	resourceInventory = metrics.NewResourceInventory(newCacheBackend("servicediscovery"), Opts.Azure.Inventory.FullRefresh, Opts.Azure.Inventory.ChangeDetection)
+	resourceGraphCache = metrics.NewResourceGraphCache(newCacheBackend("resourcegraph"), *Opts.Azure.ServiceDiscovery.StaleDuration)
+	if Opts.Webhook.EventGrid {
+		resourceInvalidations = metrics.NewResourceInvalidations(newCacheBackend("invalidation"))
+		resourceInventory.SetInvalidations(resourceInvalidations)
+		resourceGraphCache.SetInvalidations(resourceInvalidations)
+	}
+	subscriptionDiscovery = metrics.NewSubscriptionDiscovery(Opts.Azure.SubscriptionDiscovery.CacheDuration)
+	subscriptionDiscovery.Start(context.Background(), logger)
+	metricDefinitionCache = metrics.NewMetricDefinitionCache(Opts.Azure.MetricDefinitions.CacheDuration)
+	resourceTagCache = metrics.NewResourceTagCache(*Opts.Azure.ServiceDiscovery.CacheDuration)
+	if Opts.Prober.Concurrency > 0 {
+		metricsWorkerPool = metrics.NewWorkerPool(Opts.Prober.Concurrency)
+	}
+	if Opts.Azure.RateLimit.Rate > 0 {
+		metricsRateLimiter = metrics.NewRateLimiter(Opts.Azure.RateLimit.Rate, Opts.Azure.RateLimit.Burst)
+	}
+	metricsBatchClients = metrics.NewMetricsBatchClients(Opts.Azure.MetricsBatch.RateLimit, Opts.Azure.MetricsBatch.RateLimitBurst)
+	metricsQuota = metrics.NewQuotaCoordinator(Opts.Azure.RateLimit.Reserve)
+	probeRestrictions = metrics.NewProbeRestrictions(Opts)
+	probeLimiter = NewProbeLimiter(Opts.Prober.MaxConcurrent, Opts.Prober.MaxQueue, Opts.Prober.QueueTimeout, Opts.Prober.RetryAfter)
 
 	logger.Infof("init Azure connection")
 	initAzureConnection()
+	initConfigFile()
+	initStaticLabels()
 	initMetricCollector()
 
 	logger.Infof("starting http server on %s", Opts.Server.Bind)
@@ -94,16 +134,31 @@ func initAzureConnection() {
 		}
 	}
 
-	AzureClient, err = armclient.NewArmClientFromEnvironment(logger)
-	if err != nil {
-		logger.Fatal(err.Error())
+	if Opts.Azure.CloudConfig != "" {
+		initAzureCustomCloud()
+	} else {
+		AzureClient, err = armclient.NewArmClientFromEnvironment(logger)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
 	}
 	AzureClient.SetUserAgent(UserAgent + gitTag)
+	if err := metrics.InitAzureHttpTransport(Opts); err != nil {
+		logger.Fatal(err.Error())
+	}
+	if err := metrics.InitApiVersions(Opts); err != nil {
+		logger.Fatal(err.Error())
+	}
+	initAzurePortalUrl()
+	logAzureEndpoints()
 
 	if err := AzureClient.Connect(); err != nil {
 		logger.Fatal(err.Error())
 	}
 
+	initAzureDefaultCredential()
+	initAzureCredential()
+
 	AzureResourceTagManager, err = AzureClient.TagManager.ParseTagConfig(Opts.Azure.ResourceTags)
 	if err != nil {
 		logger.Fatalf(`unable to parse resourceTag configuration "%s": %v"`, Opts.Azure.ResourceTags, err.Error())
@@ -114,6 +169,12 @@ func initAzureConnection() {
 func startHttpServer() {
 	mux := http.NewServeMux()
 
+	// scheduled jobs from config file (executed using the probe handlers)
+	initJobs()
+
+	// background collection of probes with prewarm=true
+	initPrewarm()
+
 	// healthz
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
@@ -121,41 +182,89 @@ func startHttpServer() {
 		}
 	})
 
-	// readyz
+	// readyz (unhealthy while shutting down or if Azure is not reachable)
+	if Opts.Server.Readiness.Interval > 0 {
+		azureReadiness.Start(context.Background(), Opts.Server.Readiness.Interval, Opts.Server.Readiness.Timeout)
+	}
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if serverShuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		if Opts.Server.Readiness.Interval > 0 {
+			if err := azureReadiness.Ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
 		if _, err := fmt.Fprint(w, "Ok"); err != nil {
 			logger.Error(err)
 		}
 	})
 
-	mux.Handle(config.MetricsUrl, tracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
+	// debug
+	initDebugEndpoints(mux)
+
+	mux.Handle(config.MetricsUrl, tracing.RegisterAzureMetricAutoClean(metricsHandler()))
+
+	mux.HandleFunc(config.ProbeMetricsResourceUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(metricSetsHandler(probeMetricsResourceHandler)))))
+
+	mux.HandleFunc(config.ProbeMetricsListUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(metricSetsHandler(probeMetricsListHandler)))))
+
+	mux.HandleFunc(config.ProbeMetricsSubscriptionUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(metricSetsHandler(probeMetricsSubscriptionHandler)))))
+
+	mux.HandleFunc(config.ProbeMetricsScrapeUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(metricSetsHandler(probeMetricsScrapeHandler)))))
+
+	mux.HandleFunc(config.ProbeMetricsResourceGraphUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(metricSetsHandler(probeMetricsResourceGraphHandler)))))
+
+	mux.HandleFunc(config.ProbeMetricsDefinitionsUrl, probeMetricsDefinitionsHandler)
+	mux.HandleFunc(config.ProbeMetricsNamespacesUrl, probeMetricsNamespacesHandler)
+
+	mux.HandleFunc(config.ProbeMetricsAppInsightsUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeMetricsAppInsightsHandler))))
+
+	mux.HandleFunc(config.ProbeCostsUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeCostsHandler))))
+
+	mux.HandleFunc(config.ProbeHealthResourceUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeHealthResourceHandler))))
+
+	mux.HandleFunc(config.ProbeAlertsUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeAlertsHandler))))
+
+	mux.HandleFunc(config.ProbeAdvisorUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeAdvisorHandler))))
+	mux.HandleFunc(config.ProbeQuotaUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeQuotaHandler))))
+	mux.HandleFunc(config.ProbePolicyUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probePolicyHandler))))
+	mux.HandleFunc(config.ProbeActivityLogUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeActivityLogHandler))))
+	mux.HandleFunc(config.ProbeArcUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeArcHandler))))
+
+	mux.HandleFunc(config.ProbeKeyVaultUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeKeyVaultHandler))))
+
+	mux.HandleFunc(config.ProbeVmScheduledEventsUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeVmScheduledEventsHandler))))
+
+	mux.HandleFunc(config.ProbeCollectorUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeCollectorHandler))))
 
-	mux.HandleFunc(config.ProbeMetricsResourceUrl, probeMetricsResourceHandler)
+	mux.HandleFunc(config.ProbeLogsQueryUrl, prewarmScheduler.Handler(probeSingleFlight.Handler(probeLimiter.Handler(probeLogsQueryHandler))))
 
-	mux.HandleFunc(config.ProbeMetricsListUrl, probeMetricsListHandler)
+	mux.HandleFunc(config.ProbeValidateUrl, probeValidateHandler)
 
-	mux.HandleFunc(config.ProbeMetricsSubscriptionUrl, probeMetricsSubscriptionHandler)
+	mux.HandleFunc(config.DiscoveryTargetsUrl, discoveryTargetsHandler)
 
-	mux.HandleFunc(config.ProbeMetricsScrapeUrl, probeMetricsScrapeHandler)
+	mux.HandleFunc(config.GeneratePrometheusConfigUrl, generatePrometheusConfigHandler)
 
-	mux.HandleFunc(config.ProbeMetricsResourceGraphUrl, probeMetricsResourceGraphHandler)
+	if Opts.Webhook.EventGrid {
+		mux.HandleFunc(config.WebhookEventGridUrl, webhookEventGridHandler)
+	}
+
+	// Azure Monitor custom metrics relay
+	initRelay(mux)
+
+	// query webui
+	mux.HandleFunc(QueryApiSubscriptionsUrl, queryApiSubscriptionsHandler)
+	mux.HandleFunc(QueryApiResourceTypesUrl, queryApiResourceTypesHandler)
 
-	// report
 	tmpl := template.Must(template.ParseFS(templates, "templates/*.html"))
 	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
 		cspNonce := base64.StdEncoding.EncodeToString([]byte(uuid.New().String()))
-
-		w.Header().Add("Content-Type", "text/html")
-		w.Header().Add("Referrer-Policy", "same-origin")
-		w.Header().Add("X-Frame-Options", "DENY")
-		w.Header().Add("X-XSS-Protection", "1; mode=block")
-		w.Header().Add("X-Content-Type-Options", "nosniff")
-		w.Header().Add("Content-Security-Policy",
-			fmt.Sprintf(
-				"default-src 'self'; script-src 'nonce-%[1]s'; style-src 'nonce-%[1]s'; img-src 'self' data:",
-				cspNonce,
-			),
-		)
+		setHtmlHeaders(w, cspNonce)
 
 		templatePayload := struct {
 			Nonce string
@@ -168,13 +277,105 @@ func startHttpServer() {
 		}
 	})
 
+	// index page (exact root path, other unknown paths are not found)
+	mux.HandleFunc(IndexUrl, indexHandler(tmpl))
+
 	srv := &http.Server{
 		Addr:         Opts.Server.Bind,
-		Handler:      mux,
+		Handler:      probeRequestBodyHandler(probeStaticLabelsHandler(probeStatsHandler(mux))),
 		ReadTimeout:  Opts.Server.ReadTimeout,
 		WriteTimeout: Opts.Server.WriteTimeout,
 	}
-	logger.Fatal(srv.ListenAndServe())
+
+	httpAuth, err := buildHttpAuthenticator()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if httpAuth != nil {
+		logger.Infof("enabled authentication for http endpoints")
+		srv.Handler = httpAuth.Handler(srv.Handler)
+	}
+
+	// access log is the outermost handler to include rejected requests
+	accessLog, err := buildAccessLogger()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if accessLog != nil {
+		logger.Infof("enabled access log (format: %s)", accessLog.format)
+		srv.Handler = accessLog.Handler(srv.Handler)
+	}
+
+	// jobs and prewarm probes are executed through the server handler after all routes are registered
+	startJobs(srv.Handler)
+	startPrewarm(srv.Handler)
+
+	// gRPC api, calls are executed through the server handler
+	grpcServer := startGrpcServer(srv.Handler)
+
+	tlsConfig, err := buildServerTlsConfig()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			logger.Infof("serving https (client certificates: %s)", tlsConfig.ClientAuth.String())
+			srv.TLSConfig = tlsConfig
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(err)
+		}
+	}()
+
+	// graceful shutdown
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signalChannel
+
+	logger.Infof("received %s, shutting down (delay %s, timeout %s)", sig.String(), Opts.Server.Shutdown.Delay.String(), Opts.Server.Shutdown.Timeout.String())
+	serverShuttingDown.Store(true)
+	if jobScheduler != nil {
+		jobScheduler.Stop()
+	}
+	prewarmScheduler.Stop()
+	if customMetricsRelay != nil {
+		customMetricsRelay.Flush(context.Background())
+	}
+
+	// keep serving until loadbalancers (eg. Kubernetes) noticed the failing readiness
+	time.Sleep(Opts.Server.Shutdown.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), Opts.Server.Shutdown.Timeout)
+	defer cancel()
+	if grpcServer != nil {
+		stopGrpcServer(ctx, grpcServer)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Errorf("graceful shutdown failed, in-flight requests were aborted: %v", err)
+		return
+	}
+	logger.Info("shutdown finished")
+}
+
+// metricsHandler returns the /metrics handler, including the results of scheduled jobs
+func metricsHandler() http.Handler {
+	if jobScheduler == nil {
+		return promhttp.Handler()
+	}
+
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(
+			prometheus.Gatherers{prometheus.DefaultGatherer, jobScheduler},
+			promhttp.HandlerOpts{},
+		),
+	)
 }
 
 func initMetricCollector() {
@@ -204,4 +405,37 @@ func initMetricCollector() {
 		},
 	)
 	prometheus.MustRegister(prometheusMetricRequests)
+
+	prometheus.MustRegister(metrics.PrometheusCacheRequests)
+	prometheus.MustRegister(metrics.PrometheusCacheRefreshes)
+	prometheus.MustRegister(metrics.PrometheusInventorySyncs)
+	prometheus.MustRegister(metrics.PrometheusSubscriptionInfo)
+
+	prometheus.MustRegister(metrics.PrometheusWorkerQueueDepth)
+	prometheus.MustRegister(metrics.PrometheusWorkerActive)
+	prometheus.MustRegister(metrics.PrometheusRateLimitThrottled)
+	prometheus.MustRegister(metrics.PrometheusMetricsBatchThrottled)
+	prometheus.MustRegister(metrics.PrometheusApiThrottled)
+	prometheus.MustRegister(metrics.PrometheusApiRequests)
+	prometheus.MustRegister(metrics.PrometheusApiRequestDuration)
+	prometheus.MustRegister(metrics.PrometheusDimensionValuesCapped)
+	prometheus.MustRegister(metrics.PrometheusRateLimitRemaining)
+	prometheus.MustRegister(metrics.PrometheusRateLimitDelayed)
+	prometheus.MustRegister(metrics.PrometheusCacheErrors)
+	prometheus.MustRegister(metrics.PrometheusCacheEvictions)
+	prometheus.MustRegister(metrics.PrometheusResourceGraphTruncated)
+	prometheus.MustRegister(prometheusAuthTokenExpiry)
+	prometheus.MustRegister(prometheusAuthFailures)
+	prometheus.MustRegister(prometheusAuthSecretFileReloads)
+	prometheus.MustRegister(prometheusLogErrors)
+	prometheus.MustRegister(prometheusLogSuppressed)
+
+	initProbeMetrics()
+	initProbeSingleFlightMetrics()
+	initProbeLimiterMetrics()
+	initProbeSeriesLimitMetrics()
+	initProbeStatsMetrics()
+	initCacheMetrics()
+	initEventGridWebhookMetrics()
+	initReadinessMetrics()
 }
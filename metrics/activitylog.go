@@ -0,0 +1,291 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ActivityLogApiVersion = "2015-04-01"
+
+	ActivityLogTimespanDefault = "PT1H"
+
+	// selected fields of activity log events
+	activityLogSelect = "category,operationName,status,caller,level,resourceGroupName"
+)
+
+var (
+	// ActivityLogCategories are the categories of activity log events
+	ActivityLogCategories = []string{"Administrative", "ServiceHealth", "Alert", "Autoscale", "Recommendation", "Security", "Policy", "ResourceHealth"}
+
+	// ActivityLogCategoriesDefault are the exported categories without parameter category
+	ActivityLogCategoriesDefault = []string{"Administrative", "ServiceHealth", "Autoscale"}
+)
+
+type (
+	// ActivityLogClient fetches Azure Monitor activity log events
+	ActivityLogClient struct {
+		client *arm.Client
+	}
+
+	ActivityLogSettings struct {
+		Subscriptions  []string
+		ResourceGroups []string
+		Categories     []string
+		Timespan       string
+
+		// caller (user, service principal) as label
+		Caller bool
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	ActivityLogResult struct {
+		Events []ActivityLogEventCount `json:"events"`
+	}
+
+	// ActivityLogEventCount is the number of activity log events with the same labels in the timespan
+	ActivityLogEventCount struct {
+		ResourceGroup string  `json:"resourceGroup"`
+		Category      string  `json:"category"`
+		OperationName string  `json:"operationName"`
+		Status        string  `json:"status"`
+		Caller        string  `json:"caller"`
+		Level         string  `json:"level"`
+		Count         float64 `json:"count"`
+	}
+
+	activityLogResponse struct {
+		Value []struct {
+			Category struct {
+				Value string `json:"value"`
+			} `json:"category"`
+			OperationName struct {
+				Value string `json:"value"`
+			} `json:"operationName"`
+			Status struct {
+				Value string `json:"value"`
+			} `json:"status"`
+			Caller            string `json:"caller"`
+			Level             string `json:"level"`
+			ResourceGroupName string `json:"resourceGroupName"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewActivityLogSettings(r *http.Request) (ActivityLogSettings, error) {
+	ret := ActivityLogSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param resourceGroup
+	if val, err := paramsGetList(params, "resourceGroup"); err == nil {
+		ret.ResourceGroups = val
+	} else {
+		return ret, err
+	}
+
+	// param category
+	if val, err := paramsGetList(params, "category"); err == nil {
+		for _, category := range val {
+			if !stringListContainsFold(ActivityLogCategories, category) {
+				return ret, fmt.Errorf(`parameter "category" must be one of %s`, strings.Join(ActivityLogCategories, ", "))
+			}
+		}
+		ret.Categories = val
+	} else {
+		return ret, err
+	}
+	if len(ret.Categories) == 0 {
+		ret.Categories = ActivityLogCategoriesDefault
+	}
+
+	// param timespan
+	ret.Timespan = paramsGetWithDefault(params, "timespan", ActivityLogTimespanDefault)
+	if _, _, err := parseTimespanInterval(ret.Timespan); err != nil {
+		return ret, fmt.Errorf(`parameter "timespan" is invalid: %w`, err)
+	}
+
+	// param caller
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "caller", "true")); err == nil {
+		ret.Caller = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewActivityLogClient creates a client for the activity log api of the configured cloud
+func NewActivityLogClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*ActivityLogClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActivityLogClient{client: client}, nil
+}
+
+// CountEvents returns the number of activity log events of the subscription in the timespan by resource group,
+// category, operation, status, caller and level (filtered by category and resource groups)
+func (c *ActivityLogClient) CountEvents(ctx context.Context, subscriptionId string, settings ActivityLogSettings) ([]ActivityLogEventCount, error) {
+	startTime, endTime, err := parseTimespanInterval(settings.Timespan)
+	if err != nil {
+		return nil, err
+	}
+
+	filterList := []string{}
+	timeFilter := fmt.Sprintf("eventTimestamp ge '%s' and eventTimestamp le '%s'", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+	if len(settings.ResourceGroups) == 0 {
+		filterList = append(filterList, timeFilter)
+	}
+	for _, resourceGroup := range settings.ResourceGroups {
+		// the activity log api supports only one resource group per request
+		filterList = append(filterList, fmt.Sprintf("%s and resourceGroupName eq '%s'", timeFilter, strings.ReplaceAll(resourceGroup, "'", "''")))
+	}
+
+	counts := map[ActivityLogEventCount]float64{}
+	for _, filter := range filterList {
+		query := url.Values{}
+		query.Set("api-version", ActivityLogApiVersion)
+		query.Set("$filter", filter)
+		query.Set("$select", activityLogSelect)
+
+		endpoint := fmt.Sprintf(
+			"%s/subscriptions/%s/providers/Microsoft.Insights/eventtypes/management/values?%s",
+			strings.TrimRight(c.client.Endpoint(), "/"),
+			url.PathEscape(subscriptionId),
+			query.Encode(),
+		)
+
+		for endpoint != "" {
+			response := activityLogResponse{}
+			if err := c.get(ctx, endpoint, &response); err != nil {
+				return nil, fmt.Errorf(`unable to list activity log of subscription "%s": %w`, subscriptionId, err)
+			}
+
+			for _, row := range response.Value {
+				if !stringListContainsFold(settings.Categories, row.Category.Value) {
+					continue
+				}
+
+				event := ActivityLogEventCount{
+					ResourceGroup: strings.ToLower(row.ResourceGroupName),
+					Category:      row.Category.Value,
+					OperationName: row.OperationName.Value,
+					Status:        row.Status.Value,
+					Level:         row.Level,
+				}
+				if settings.Caller {
+					event.Caller = row.Caller
+				}
+				counts[event]++
+			}
+
+			endpoint = response.NextLink
+		}
+	}
+
+	ret := []ActivityLogEventCount{}
+	for event, count := range counts {
+		event.Count = count
+		ret = append(ret, event)
+	}
+	return ret, nil
+}
+
+func (c *ActivityLogClient) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// PublishActivityLogResult publishes the number of activity log events in the timespan (azurerm_activitylog_events)
+func PublishActivityLogResult(registry *prometheus.Registry, results map[string]*ActivityLogResult) {
+	activityLogEvents := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_activitylog_events",
+			Help: "Azure Monitor activity log number of events in the timespan",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceGroup",
+			"category",
+			"operationName",
+			"status",
+			"caller",
+			"level",
+		},
+	)
+	registry.MustRegister(activityLogEvents)
+
+	for subscriptionId, result := range results {
+		for _, event := range result.Events {
+			activityLogEvents.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"resourceGroup":  event.ResourceGroup,
+				"category":       event.Category,
+				"operationName":  event.OperationName,
+				"status":         event.Status,
+				"caller":         event.Caller,
+				"level":          event.Level,
+			}).Add(event.Count)
+		}
+	}
+}
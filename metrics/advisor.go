@@ -0,0 +1,329 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	AdvisorApiVersion = "2023-01-01"
+)
+
+var (
+	// AdvisorCategories are the categories of Azure Advisor recommendations
+	AdvisorCategories = []string{"Cost", "Security", "Performance", "HighAvailability", "OperationalExcellence"}
+
+	// AdvisorImpacts are the impacts of Azure Advisor recommendations
+	AdvisorImpacts = []string{"High", "Medium", "Low"}
+)
+
+type (
+	// AdvisorClient fetches Azure Advisor recommendations
+	AdvisorClient struct {
+		client *arm.Client
+	}
+
+	AdvisorSettings struct {
+		Subscriptions []string
+		Categories    []string
+		Impacts       []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	AdvisorResult struct {
+		Recommendations []AdvisorRecommendation `json:"recommendations"`
+	}
+
+	AdvisorRecommendation struct {
+		RecommendationTypeId string  `json:"recommendationTypeId"`
+		Category             string  `json:"category"`
+		Impact               string  `json:"impact"`
+		ResourceId           string  `json:"resourceId"`
+		ResourceType         string  `json:"resourceType"`
+		Problem              string  `json:"problem"`
+		Savings              float64 `json:"savings"`
+		SavingsCurrency      string  `json:"savingsCurrency"`
+	}
+
+	advisorRecommendationResponse struct {
+		Value []struct {
+			Properties struct {
+				Category             string `json:"category"`
+				Impact               string `json:"impact"`
+				ImpactedField        string `json:"impactedField"`
+				RecommendationTypeId string `json:"recommendationTypeId"`
+				ShortDescription     struct {
+					Problem string `json:"problem"`
+				} `json:"shortDescription"`
+				ResourceMetadata struct {
+					ResourceId string `json:"resourceId"`
+				} `json:"resourceMetadata"`
+				ExtendedProperties map[string]interface{} `json:"extendedProperties"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewAdvisorSettings(r *http.Request) (AdvisorSettings, error) {
+	ret := AdvisorSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param category
+	if val, err := paramsGetList(params, "category"); err == nil {
+		for _, category := range val {
+			if !stringListContainsFold(AdvisorCategories, category) {
+				return ret, fmt.Errorf(`parameter "category" must be one of %s`, strings.Join(AdvisorCategories, ", "))
+			}
+		}
+		ret.Categories = val
+	} else {
+		return ret, err
+	}
+
+	// param impact
+	if val, err := paramsGetList(params, "impact"); err == nil {
+		for _, impact := range val {
+			if !stringListContainsFold(AdvisorImpacts, impact) {
+				return ret, fmt.Errorf(`parameter "impact" must be one of %s`, strings.Join(AdvisorImpacts, ", "))
+			}
+		}
+		ret.Impacts = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewAdvisorClient creates a client for the Azure Advisor api of the configured cloud
+func NewAdvisorClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*AdvisorClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdvisorClient{client: client}, nil
+}
+
+// ListRecommendations returns the Advisor recommendations of the subscription (filtered by category and impact)
+func (c *AdvisorClient) ListRecommendations(ctx context.Context, subscriptionId string, settings AdvisorSettings) ([]AdvisorRecommendation, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.Advisor/recommendations?api-version=%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		AdvisorApiVersion,
+	)
+
+	ret := []AdvisorRecommendation{}
+	for endpoint != "" {
+		response := advisorRecommendationResponse{}
+		if err := c.get(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list advisor recommendations of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			properties := row.Properties
+			if len(settings.Categories) > 0 && !stringListContainsFold(settings.Categories, properties.Category) {
+				continue
+			}
+			if len(settings.Impacts) > 0 && !stringListContainsFold(settings.Impacts, properties.Impact) {
+				continue
+			}
+
+			recommendation := AdvisorRecommendation{
+				RecommendationTypeId: properties.RecommendationTypeId,
+				Category:             properties.Category,
+				Impact:               properties.Impact,
+				ResourceId:           properties.ResourceMetadata.ResourceId,
+				ResourceType:         properties.ImpactedField,
+				Problem:              properties.ShortDescription.Problem,
+			}
+
+			// cost recommendations: estimated annual savings
+			if val, ok := properties.ExtendedProperties["annualSavingsAmount"]; ok {
+				recommendation.Savings = advisorExtendedPropertyFloat(val)
+				if currency, ok := properties.ExtendedProperties["savingsCurrency"].(string); ok {
+					recommendation.SavingsCurrency = currency
+				}
+			}
+
+			ret = append(ret, recommendation)
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+func (c *AdvisorClient) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// advisorExtendedPropertyFloat parses numeric extended properties (returned as string or number)
+func advisorExtendedPropertyFloat(val interface{}) float64 {
+	switch v := val.(type) {
+	case float64:
+		return v
+	case string:
+		if ret, err := strconv.ParseFloat(v, 64); err == nil {
+			return ret
+		}
+	}
+	return 0
+}
+
+// PublishAdvisorResult publishes the Advisor recommendations (azurerm_advisor_recommendation),
+// the number of recommendations per category and impact and the estimated savings of cost recommendations
+func PublishAdvisorResult(registry *prometheus.Registry, results map[string]*AdvisorResult) {
+	recommendationInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_advisor_recommendation",
+			Help: "Azure Advisor recommendation of resource",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceID",
+			"resourceGroup",
+			"resourceType",
+			"recommendationTypeID",
+			"category",
+			"impact",
+			"problem",
+		},
+	)
+	registry.MustRegister(recommendationInfo)
+
+	recommendationCount := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_advisor_recommendation_count",
+			Help: "Azure Advisor number of recommendations per category and impact",
+		},
+		[]string{
+			"subscriptionID",
+			"category",
+			"impact",
+		},
+	)
+	registry.MustRegister(recommendationCount)
+
+	recommendationSavings := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_advisor_recommendation_savings",
+			Help: "Azure Advisor estimated annual savings of cost recommendation",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceID",
+			"recommendationTypeID",
+			"currency",
+		},
+	)
+	registry.MustRegister(recommendationSavings)
+
+	for subscriptionId, result := range results {
+		counts := map[string]map[string]float64{}
+		for _, recommendation := range result.Recommendations {
+			resourceGroup := ""
+			if azureResource, err := armclient.ParseResourceId(recommendation.ResourceId); err == nil {
+				resourceGroup = azureResource.ResourceGroup
+			}
+
+			recommendationInfo.With(prometheus.Labels{
+				"subscriptionID":       subscriptionId,
+				"resourceID":           strings.ToLower(recommendation.ResourceId),
+				"resourceGroup":        resourceGroup,
+				"resourceType":         strings.ToLower(recommendation.ResourceType),
+				"recommendationTypeID": recommendation.RecommendationTypeId,
+				"category":             recommendation.Category,
+				"impact":               recommendation.Impact,
+				"problem":              recommendation.Problem,
+			}).Set(1)
+
+			if _, exists := counts[recommendation.Category]; !exists {
+				counts[recommendation.Category] = map[string]float64{}
+			}
+			counts[recommendation.Category][recommendation.Impact]++
+
+			if recommendation.Savings > 0 {
+				recommendationSavings.With(prometheus.Labels{
+					"subscriptionID":       subscriptionId,
+					"resourceID":           strings.ToLower(recommendation.ResourceId),
+					"recommendationTypeID": recommendation.RecommendationTypeId,
+					"currency":             recommendation.SavingsCurrency,
+				}).Add(recommendation.Savings)
+			}
+		}
+
+		for category, impacts := range counts {
+			for impact, count := range impacts {
+				recommendationCount.With(prometheus.Labels{
+					"subscriptionID": subscriptionId,
+					"category":       category,
+					"impact":         impact,
+				}).Set(count)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+)
+
+const (
+	AksResourceType = "Microsoft.ContainerService/managedClusters"
+
+	AksNodePoolLabel = "nodePool"
+
+	// VM Scale Sets of the node pools in the node resource group of the clusters (tag aks-managed-poolName)
+	aksNodePoolQuery = `resources
+| where type =~ 'microsoft.containerservice/managedclusters' and tolower(id) in (%s)
+| project clusterId=id, subscriptionId, nodeResourceGroup=tolower(tostring(properties.nodeResourceGroup))
+| join kind=inner (
+	resources
+	| where type =~ 'microsoft.compute/virtualmachinescalesets'
+	| extend nodePool=tostring(tags['aks-managed-poolName'])
+	| where nodePool != ''
+	| project id, location, subscriptionId, nodeResourceGroup=tolower(resourceGroup), nodePool
+) on subscriptionId, nodeResourceGroup
+| project id, location, clusterId, nodePool`
+)
+
+// expandAksNodePools adds the VM Scale Sets of the node pools of AKS cluster targets as targets (aksNodePools=true),
+// node pool metrics are exported with the resource of the cluster and the nodePool label. The metrics are split
+// between cluster and node pools using the metric definitions (eg. apiserver_current_inflight_requests of the cluster,
+// Percentage CPU of the node pools).
+func (p *MetricProber) expandAksNodePools() {
+	clusterTargets := map[string]MetricProbeTarget{}
+	subscriptions := []string{}
+	for subscriptionId, targetList := range p.targets {
+		resourceTargets := []MetricProbeTarget{}
+		for _, target := range targetList {
+			if target.ParentResourceId != "" || !strings.EqualFold(ResourceTypeFromResourceId(target.ResourceId), AksResourceType) {
+				resourceTargets = append(resourceTargets, target)
+				continue
+			}
+
+			clusterTargets[strings.ToLower(target.ResourceId)] = target
+			if !stringListContainsFold(subscriptions, subscriptionId) {
+				subscriptions = append(subscriptions, subscriptionId)
+			}
+
+			// cluster metrics (empty nodePool label)
+			if target.Metrics = p.filterSupportedMetrics(target.ResourceId, p.settings.MetricNamespace, target.Metrics); len(target.Metrics) > 0 {
+				resourceTargets = append(resourceTargets, target)
+			}
+		}
+		p.targets[subscriptionId] = resourceTargets
+	}
+
+	if len(clusterTargets) == 0 {
+		return
+	}
+
+	client, err := armresourcegraph.NewClient(p.GetCred(), NewArmClientOptions(p.AzureClient))
+	if err != nil {
+		p.logger.Error(err)
+		p.reportError("", err)
+		return
+	}
+
+	// resource ids are listed resources (no quotes)
+	clusterIds := []string{}
+	for clusterId := range clusterTargets {
+		clusterIds = append(clusterIds, fmt.Sprintf(`'%s'`, clusterId))
+	}
+	query := fmt.Sprintf(aksNodePoolQuery, strings.Join(clusterIds, ", "))
+
+	nodePoolTargets := []MetricProbeTarget{}
+	truncated, err := queryResourceGraph(p.ctx, client, "aksnodepools", query, subscriptions, p.Conf.Azure.ResourceGraph.MaxRows, func(row map[string]interface{}) {
+		resourceId, _ := row["id"].(string)
+		clusterId, _ := row["clusterId"].(string)
+		cluster, exists := clusterTargets[strings.ToLower(clusterId)]
+		if resourceId == "" || !exists {
+			return
+		}
+
+		location, _ := row["location"].(string)
+		nodePool, _ := row["nodePool"].(string)
+		nodePoolTarget := MetricProbeTarget{
+			ResourceId:       resourceId,
+			Location:         location,
+			Aggregations:     cluster.Aggregations,
+			Tags:             cluster.Tags,
+			MetricNamespace:  VmssResourceType,
+			ParentResourceId: cluster.ResourceId,
+			Labels:           map[string]string{AksNodePoolLabel: nodePool},
+		}
+		if nodePoolTarget.Metrics = p.filterSupportedMetrics(resourceId, nodePoolTarget.MetricNamespace, cluster.Metrics); len(nodePoolTarget.Metrics) > 0 {
+			nodePoolTargets = append(nodePoolTargets, nodePoolTarget)
+		}
+	})
+	if err != nil {
+		err = fmt.Errorf("unable to list AKS node pools: %w", err)
+		p.logger.Warn(err)
+		p.reportError("", err)
+		return
+	}
+	if truncated {
+		p.logger.Warnf("ResourceGraph result of AKS node pools was truncated after %v node pools (--azure.resourcegraph.max-rows or ResourceGraph limit)", len(nodePoolTargets))
+	}
+
+	p.AddTarget(nodePoolTargets...)
+}
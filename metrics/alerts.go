@@ -0,0 +1,346 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	MetricAlertRulesApiVersion = "2018-03-01"
+	AlertsManagementApiVersion = "2019-05-05-preview"
+
+	MetricAlertStateFired    = "Fired"
+	MetricAlertStateResolved = "Resolved"
+)
+
+var (
+	// MetricAlertStates are the monitor conditions of metric alerts (enum-style metric)
+	MetricAlertStates = []string{MetricAlertStateFired, MetricAlertStateResolved}
+
+	// MetricAlertTimeRanges are the time ranges of the AlertsManagement api (lookback of fired alerts)
+	MetricAlertTimeRanges = []string{"1h", "1d", "7d", "30d"}
+)
+
+type (
+	// MetricAlertsClient fetches metric alert rules (Azure Monitor) and their fired alerts (AlertsManagement)
+	MetricAlertsClient struct {
+		client *arm.Client
+	}
+
+	MetricAlertsSettings struct {
+		Subscriptions []string
+		TimeRange     string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	MetricAlertsResult struct {
+		Rules  []MetricAlertRule `json:"rules"`
+		Alerts []MetricAlert     `json:"alerts"`
+	}
+
+	MetricAlertRule struct {
+		RuleId      string   `json:"ruleId"`
+		Name        string   `json:"name"`
+		Severity    string   `json:"severity"`
+		Enabled     bool     `json:"enabled"`
+		ResourceIds []string `json:"resourceIds"`
+	}
+
+	MetricAlert struct {
+		RuleId           string `json:"ruleId"`
+		ResourceId       string `json:"resourceId"`
+		Severity         string `json:"severity"`
+		MonitorCondition string `json:"monitorCondition"`
+		StartTime        string `json:"startTime"`
+	}
+
+	metricAlertRuleResponse struct {
+		Value []struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			Properties struct {
+				Severity int      `json:"severity"`
+				Enabled  bool     `json:"enabled"`
+				Scopes   []string `json:"scopes"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+
+	alertsManagementResponse struct {
+		Value []struct {
+			Properties struct {
+				Essentials struct {
+					AlertRule        string `json:"alertRule"`
+					Severity         string `json:"severity"`
+					TargetResource   string `json:"targetResource"`
+					MonitorCondition string `json:"monitorCondition"`
+					StartDateTime    string `json:"startDateTime"`
+				} `json:"essentials"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewMetricAlertsSettings(r *http.Request) (MetricAlertsSettings, error) {
+	ret := MetricAlertsSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param timeRange
+	ret.TimeRange = paramsGetWithDefault(params, "timeRange", "1d")
+	if !stringListContainsFold(MetricAlertTimeRanges, ret.TimeRange) {
+		return ret, fmt.Errorf(`parameter "timeRange" must be one of %s`, strings.Join(MetricAlertTimeRanges, ", "))
+	}
+	ret.TimeRange = strings.ToLower(ret.TimeRange)
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewMetricAlertsClient creates a client for the metric alert and AlertsManagement api of the configured cloud
+func NewMetricAlertsClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*MetricAlertsClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricAlertsClient{client: client}, nil
+}
+
+// ListRules returns the metric alert rules of the subscription
+func (c *MetricAlertsClient) ListRules(ctx context.Context, subscriptionId string) ([]MetricAlertRule, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.Insights/metricAlerts?api-version=%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		MetricAlertRulesApiVersion,
+	)
+
+	ret := []MetricAlertRule{}
+	for endpoint != "" {
+		response := metricAlertRuleResponse{}
+		if err := c.get(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list metric alert rules of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			ret = append(ret, MetricAlertRule{
+				RuleId:      row.ID,
+				Name:        row.Name,
+				Severity:    fmt.Sprintf("Sev%d", row.Properties.Severity),
+				Enabled:     row.Properties.Enabled,
+				ResourceIds: row.Properties.Scopes,
+			})
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+// ListFiredAlerts returns the fired metric alerts of the subscription (AlertsManagement) within the time range
+func (c *MetricAlertsClient) ListFiredAlerts(ctx context.Context, subscriptionId, timeRange string) ([]MetricAlert, error) {
+	query := url.Values{}
+	query.Set("api-version", AlertsManagementApiVersion)
+	query.Set("monitorService", "Platform")
+	query.Set("signalType", "Metric")
+	query.Set("monitorCondition", MetricAlertStateFired)
+	query.Set("timeRange", timeRange)
+
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.AlertsManagement/alerts?%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		query.Encode(),
+	)
+
+	ret := []MetricAlert{}
+	for endpoint != "" {
+		response := alertsManagementResponse{}
+		if err := c.get(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list alerts of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			essentials := row.Properties.Essentials
+			if !strings.EqualFold(essentials.MonitorCondition, MetricAlertStateFired) {
+				continue
+			}
+
+			ret = append(ret, MetricAlert{
+				RuleId:           essentials.AlertRule,
+				ResourceId:       essentials.TargetResource,
+				Severity:         essentials.Severity,
+				MonitorCondition: essentials.MonitorCondition,
+				StartTime:        essentials.StartDateTime,
+			})
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+func (c *MetricAlertsClient) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// PublishMetricAlertsResult publishes the state of the metric alert rules per target resource
+// (azurerm_metric_alert_state, 1 for the current state) and the start time of fired alerts
+func PublishMetricAlertsResult(registry *prometheus.Registry, results map[string]*MetricAlertsResult) {
+	alertState := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_metric_alert_state",
+			Help: "Azure Monitor metric alert state of the alert rule and target resource (1 for the current state)",
+		},
+		[]string{
+			"subscriptionID",
+			"alertRuleID",
+			"alertRule",
+			"severity",
+			"enabled",
+			"resourceID",
+			"resourceGroup",
+			"state",
+		},
+	)
+	registry.MustRegister(alertState)
+
+	alertFiredTime := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_metric_alert_fired_timestamp_seconds",
+			Help: "Azure Monitor metric alert start time of the fired alert of the alert rule and target resource",
+		},
+		[]string{
+			"subscriptionID",
+			"alertRuleID",
+			"alertRule",
+			"resourceID",
+		},
+	)
+	registry.MustRegister(alertFiredTime)
+
+	for subscriptionId, result := range results {
+		// fired alerts per rule and target resource
+		fired := map[string]map[string]MetricAlert{}
+		for _, alert := range result.Alerts {
+			ruleId := strings.ToLower(alert.RuleId)
+			if _, exists := fired[ruleId]; !exists {
+				fired[ruleId] = map[string]MetricAlert{}
+			}
+			fired[ruleId][strings.ToLower(alert.ResourceId)] = alert
+		}
+
+		for _, rule := range result.Rules {
+			ruleId := strings.ToLower(rule.RuleId)
+
+			// scopes of the rule, resources of fired alerts (rules scoped to resource groups or subscriptions)
+			resourceIds := map[string]bool{}
+			for _, resourceId := range rule.ResourceIds {
+				resourceIds[strings.ToLower(resourceId)] = true
+			}
+			for resourceId := range fired[ruleId] {
+				resourceIds[resourceId] = true
+			}
+
+			for resourceId := range resourceIds {
+				resourceGroup := ""
+				if azureResource, err := armclient.ParseResourceId(resourceId); err == nil {
+					resourceGroup = azureResource.ResourceGroup
+				}
+
+				alert, isFired := fired[ruleId][resourceId]
+				if isFired {
+					if startTime, err := time.Parse(time.RFC3339, alert.StartTime); err == nil {
+						alertFiredTime.With(prometheus.Labels{
+							"subscriptionID": subscriptionId,
+							"alertRuleID":    ruleId,
+							"alertRule":      rule.Name,
+							"resourceID":     resourceId,
+						}).Set(float64(startTime.Unix()))
+					}
+				}
+
+				for _, state := range MetricAlertStates {
+					value := float64(0)
+					if (state == MetricAlertStateFired) == isFired {
+						value = 1
+					}
+
+					alertState.With(prometheus.Labels{
+						"subscriptionID": subscriptionId,
+						"alertRuleID":    ruleId,
+						"alertRule":      rule.Name,
+						"severity":       rule.Severity,
+						"enabled":        strconv.FormatBool(rule.Enabled),
+						"resourceID":     resourceId,
+						"resourceGroup":  resourceGroup,
+						"state":          state,
+					}).Set(value)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+var (
+	// api versions of Azure (eg. 2023-10-01, 2024-02-01-preview)
+	apiVersionRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-[a-z]+)?$`)
+
+	// api-version overrides of metric requests (--azure.api-version.metrics) and of resource requests
+	// (--azure.api-version.resources) per resource type
+	metricsApiVersions  = map[string]string{}
+	resourceApiVersions = map[string]string{}
+)
+
+type (
+	// apiVersionKey is the context key of the api-version override of a request
+	apiVersionKey struct{}
+
+	// apiVersionPolicy replaces the api-version of the request with the override of the request context
+	apiVersionPolicy struct{}
+)
+
+// InitApiVersions parses the api-version overrides per resource type (resourceType=apiVersion)
+func InitApiVersions(opts config.Opts) (err error) {
+	if metricsApiVersions, err = parseApiVersions(opts.Azure.ApiVersion.Metrics); err != nil {
+		return err
+	}
+	if resourceApiVersions, err = parseApiVersions(opts.Azure.ApiVersion.Resources); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseApiVersions returns the api versions by lowercase resource type (type=apiVersion)
+func parseApiVersions(list []string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, entry := range list {
+		resourceType, apiVersion, found := strings.Cut(entry, "=")
+		resourceType, apiVersion = strings.TrimSpace(resourceType), strings.TrimSpace(apiVersion)
+		if !found || resourceType == "" || !apiVersionRegexp.MatchString(apiVersion) {
+			return nil, fmt.Errorf(`api version "%s" must be resourceType=apiVersion (eg. Microsoft.Compute/virtualMachines=2024-02-01)`, entry)
+		}
+		ret[strings.ToLower(resourceType)] = apiVersion
+	}
+	return ret, nil
+}
+
+// lookupApiVersion returns the api version of the longest matching resource type, resource types match with their
+// sub resources (eg. microsoft.storage/storageaccounts/blobservices)
+func lookupApiVersion(apiVersions map[string]string, resourceType string) string {
+	resourceType = strings.ToLower(resourceType)
+	ret, matchLength := "", 0
+	for apiVersionResourceType, apiVersion := range apiVersions {
+		if (resourceType == apiVersionResourceType || strings.HasPrefix(resourceType, apiVersionResourceType+"/")) && len(apiVersionResourceType) > matchLength {
+			ret, matchLength = apiVersion, len(apiVersionResourceType)
+		}
+	}
+	return ret
+}
+
+// resourceApiVersion returns the api version of resource requests of the resource type (--azure.api-version.resources),
+// the default api version of the exporter if not overridden
+func resourceApiVersion(resourceType, defaultApiVersion string) string {
+	if apiVersion := lookupApiVersion(resourceApiVersions, resourceType); apiVersion != "" {
+		return apiVersion
+	}
+	return defaultApiVersion
+}
+
+// MetricsApiVersionFor returns the api-version of metric requests of the resource type (parameter apiVersion,
+// --azure.api-version.metrics), empty for the default api version of the Azure SDK
+func (s *RequestMetricSettings) MetricsApiVersionFor(resourceType string) string {
+	if s.ApiVersion != "" {
+		return s.ApiVersion
+	}
+	return lookupApiVersion(metricsApiVersions, resourceType)
+}
+
+// withApiVersion returns the context with the api-version override of requests (empty = default api version)
+func withApiVersion(ctx context.Context, apiVersion string) context.Context {
+	if apiVersion == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, apiVersionKey{}, apiVersion)
+}
+
+func (p apiVersionPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if apiVersion, ok := req.Raw().Context().Value(apiVersionKey{}).(string); ok && apiVersion != "" {
+		query := req.Raw().URL.Query()
+		query.Set("api-version", apiVersion)
+		req.Raw().URL.RawQuery = query.Encode()
+	}
+
+	return req.Next()
+}
@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	AppInsightsMetricNameDefault = "azure_appinsights_metric"
+
+	// api version of microsoft.insights/components (AppId lookup)
+	AppInsightsComponentApiVersion = "2020-02-02"
+)
+
+var (
+	appInsightsNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+type (
+	// AppInsightsClient queries the Application Insights metrics api
+	AppInsightsClient struct {
+		pipeline runtime.Pipeline
+		endpoint string
+	}
+
+	AppInsightsMetricsSettings struct {
+		Apps         []string
+		Targets      []string
+		Metrics      []string
+		Aggregations []string
+		Segments     []string
+		Timespan     string
+		Interval     string
+		Filter       string
+		Top          *int
+		Name         string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	// AppInsightsMetricResult is the result of a metric query, value contains the aggregations
+	// and (time or dimension) segments
+	AppInsightsMetricResult struct {
+		Value map[string]interface{} `json:"value"`
+	}
+
+	// AppInsightsMetricBuilder maps metric results to a gauge with appID, metric, aggregation and segment labels
+	AppInsightsMetricBuilder struct {
+		settings *AppInsightsMetricsSettings
+		gauge    *prometheus.GaugeVec
+	}
+)
+
+func NewAppInsightsMetricsSettings(r *http.Request) (AppInsightsMetricsSettings, error) {
+	ret := AppInsightsMetricsSettings{}
+	params := r.URL.Query()
+
+	// param app (application id)
+	if val, err := paramsGetList(params, "app"); err == nil {
+		ret.Apps = val
+	} else {
+		return ret, err
+	}
+
+	// param target (component resource id)
+	if val, err := paramsGetList(params, "target"); err == nil {
+		ret.Targets = val
+	} else {
+		return ret, err
+	}
+
+	if len(ret.Apps) == 0 && len(ret.Targets) == 0 {
+		return ret, fmt.Errorf("parameter \"app\" or \"target\" is missing")
+	}
+
+	// param metric
+	if val, err := paramsGetList(params, "metric"); err == nil && len(val) > 0 {
+		ret.Metrics = val
+	} else {
+		return ret, fmt.Errorf("parameter \"metric\" is missing")
+	}
+
+	// param aggregation
+	if val, err := paramsGetList(params, "aggregation"); err == nil {
+		ret.Aggregations = val
+	} else {
+		return ret, err
+	}
+
+	// param segment (dimension support)
+	if val, err := paramsGetList(params, "segment"); err == nil {
+		ret.Segments = val
+	} else {
+		return ret, err
+	}
+
+	// param timespan
+	ret.Timespan = paramsGetWithDefault(params, "timespan", "PT5M")
+
+	// param interval
+	ret.Interval = paramsGetWithDefault(params, "interval", "")
+
+	// param filter
+	ret.Filter = paramsGetWithDefault(params, "filter", "")
+
+	// param top
+	if val := params.Get("top"); val != "" {
+		top, err := strconv.Atoi(val)
+		if err != nil {
+			return ret, err
+		}
+		ret.Top = &top
+	}
+
+	// param name
+	ret.Name = paramsGetWithDefault(params, "name", AppInsightsMetricNameDefault)
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewAppInsightsClient creates a client for the Application Insights api of the configured cloud
+func NewAppInsightsClient(cred azcore.TokenCredential, clientOpts policy.ClientOptions, opts config.Opts) *AppInsightsClient {
+	pipeline := runtime.NewPipeline(
+		"azure-metrics-exporter",
+		"",
+		runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{opts.Azure.AppInsights.Audience}, nil),
+			},
+		},
+		&clientOpts,
+	)
+
+	return &AppInsightsClient{
+		pipeline: pipeline,
+		endpoint: strings.TrimRight(opts.Azure.AppInsights.Endpoint, "/"),
+	}
+}
+
+// AppIdFromResourceId returns the application id of an Application Insights component (microsoft.insights/components)
+func AppIdFromResourceId(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, resourceId string) (string, error) {
+	resource, err := armclient.ParseResourceId(resourceId)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := armresources.NewClient(resource.Subscription, cred, clientOpts)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := client.GetByID(ctx, resourceId, AppInsightsComponentApiVersion, nil)
+	if err != nil {
+		return "", fmt.Errorf(`unable to fetch Application Insights component "%s": %w`, resourceId, err)
+	}
+
+	if properties, ok := result.Properties.(map[string]interface{}); ok {
+		if appId, ok := properties["AppId"].(string); ok && appId != "" {
+			return appId, nil
+		}
+	}
+
+	return "", fmt.Errorf(`Application Insights component "%s" has no AppId`, resourceId)
+}
+
+// QueryMetric fetches one metric of the application (application id, not resource id)
+func (c *AppInsightsClient) QueryMetric(ctx context.Context, appId, metric string, settings *AppInsightsMetricsSettings) (*AppInsightsMetricResult, error) {
+	endpoint := fmt.Sprintf("%s/v1/apps/%s/metrics/%s", c.endpoint, url.PathEscape(appId), url.PathEscape(metric))
+
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	query := url.Values{}
+	query.Set("timespan", settings.Timespan)
+	if settings.Interval != "" {
+		query.Set("interval", settings.Interval)
+	}
+	if len(settings.Aggregations) > 0 {
+		query.Set("aggregation", strings.Join(settings.Aggregations, ","))
+	}
+	if len(settings.Segments) > 0 {
+		query.Set("segment", strings.Join(settings.Segments, ","))
+	}
+	if settings.Filter != "" {
+		query.Set("filter", settings.Filter)
+	}
+	if settings.Top != nil {
+		query.Set("top", strconv.Itoa(*settings.Top))
+	}
+	req.Raw().URL.RawQuery = query.Encode()
+
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	result := AppInsightsMetricResult{}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func NewAppInsightsMetricBuilder(settings *AppInsightsMetricsSettings, registry *prometheus.Registry) (*AppInsightsMetricBuilder, error) {
+	builder := AppInsightsMetricBuilder{}
+	builder.settings = settings
+
+	labelNames := []string{"appID", "metric", "aggregation"}
+	for _, segment := range settings.Segments {
+		labelNames = append(labelNames, appInsightsLabelName(segment))
+	}
+
+	builder.gauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: settings.Name,
+			Help: "Azure Application Insights metric",
+		},
+		labelNames,
+	)
+	if err := registry.Register(builder.gauge); err != nil {
+		return nil, fmt.Errorf(`unable to register metric "%s": %w`, settings.Name, err)
+	}
+
+	return &builder, nil
+}
+
+// AddResult adds the aggregations of the metric result, time segments (interval) are overwritten by the latest one
+func (b *AppInsightsMetricBuilder) AddResult(appId, metric string, result *AppInsightsMetricResult) {
+	labels := prometheus.Labels{
+		"appID":  appId,
+		"metric": metric,
+	}
+	for _, segment := range b.settings.Segments {
+		labels[appInsightsLabelName(segment)] = ""
+	}
+
+	b.addSegment(metric, result.Value, labels)
+}
+
+func (b *AppInsightsMetricBuilder) addSegment(metric string, segment map[string]interface{}, parentLabels prometheus.Labels) {
+	labels := prometheus.Labels{}
+	for name, value := range parentLabels {
+		labels[name] = value
+	}
+
+	// dimension values of segment
+	for _, segmentName := range b.settings.Segments {
+		if value, ok := segment[segmentName]; ok {
+			labels[appInsightsLabelName(segmentName)] = fmt.Sprintf("%v", value)
+		}
+	}
+
+	if aggregations, ok := segment[metric].(map[string]interface{}); ok {
+		for aggregation, value := range aggregations {
+			if val, ok := value.(float64); ok {
+				labels["aggregation"] = aggregation
+				b.gauge.With(labels).Set(val)
+			}
+		}
+	}
+
+	if childSegments, ok := segment["segments"].([]interface{}); ok {
+		for _, childSegment := range childSegments {
+			if child, ok := childSegment.(map[string]interface{}); ok {
+				b.addSegment(metric, child, labels)
+			}
+		}
+	}
+}
+
+// appInsightsLabelName converts segment names (eg. request/name) to label names (request_name)
+func appInsightsLabelName(segment string) string {
+	return appInsightsNameRegexp.ReplaceAllString(segment, "_")
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ArcResourceTypeMachines          = "Microsoft.HybridCompute/machines"
+	ArcResourceTypeConnectedClusters = "Microsoft.Kubernetes/connectedClusters"
+
+	// connectivity status of Arc-enabled servers and Kubernetes clusters
+	ArcStatusConnected = "connected"
+
+	// Arc-enabled servers (status of the connected machine agent heartbeat) and Kubernetes clusters (connectivity
+	// of the cluster agents) with last status change and agent version
+	arcResourceQuery = `resources
+| where type in~ (%s)
+| extend isMachine = type =~ 'microsoft.hybridcompute/machines'
+| project id, subscriptionId, resourceGroup, location, type=tolower(type),
+	status=iff(isMachine, tostring(properties.status), tostring(properties.connectivityStatus)),
+	lastStatusChange=iff(isMachine, tostring(properties.lastStatusChange), tostring(properties.lastConnectivityTime)),
+	agentVersion=tostring(properties.agentVersion),
+	platform=iff(isMachine, tostring(properties.osName), tostring(properties.distribution))`
+)
+
+var (
+	// ArcResourceTypes are the Azure Arc resource types with connectivity status
+	ArcResourceTypes = []string{ArcResourceTypeMachines, ArcResourceTypeConnectedClusters}
+)
+
+type (
+	ArcSettings struct {
+		Subscriptions []string
+		ResourceTypes []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	ArcResult struct {
+		Resources []ArcResource `json:"resources"`
+	}
+
+	// ArcResource is an Arc-enabled server or Kubernetes cluster with its connectivity status
+	ArcResource struct {
+		ResourceId       string `json:"resourceId"`
+		SubscriptionId   string `json:"subscriptionId"`
+		ResourceGroup    string `json:"resourceGroup"`
+		Location         string `json:"location"`
+		ResourceType     string `json:"resourceType"`
+		Status           string `json:"status"`
+		LastStatusChange string `json:"lastStatusChange"`
+		AgentVersion     string `json:"agentVersion"`
+		Platform         string `json:"platform"`
+	}
+)
+
+func NewArcSettings(r *http.Request) (ArcSettings, error) {
+	ret := ArcSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param resourceType
+	if val, err := paramsGetList(params, "resourceType"); err == nil {
+		for _, resourceType := range val {
+			if !stringListContainsFold(ArcResourceTypes, resourceType) {
+				return ret, fmt.Errorf(`parameter "resourceType" must be one of %s`, strings.Join(ArcResourceTypes, ", "))
+			}
+		}
+		ret.ResourceTypes = val
+	} else {
+		return ret, err
+	}
+	if len(ret.ResourceTypes) == 0 {
+		ret.ResourceTypes = ArcResourceTypes
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// ListArcResources returns the Arc-enabled servers and Kubernetes clusters of the subscriptions (Resource Graph)
+func ListArcResources(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, settings ArcSettings, maxRows int) ([]ArcResource, error) {
+	client, err := armresourcegraph.NewClient(cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// resource types are validated (no quotes)
+	resourceTypes := []string{}
+	for _, resourceType := range settings.ResourceTypes {
+		resourceTypes = append(resourceTypes, fmt.Sprintf(`'%s'`, strings.ToLower(resourceType)))
+	}
+	query := fmt.Sprintf(arcResourceQuery, strings.Join(resourceTypes, ", "))
+
+	ret := []ArcResource{}
+	_, err = queryResourceGraph(ctx, client, "arc", query, settings.Subscriptions, maxRows, func(row map[string]interface{}) {
+		resource := ArcResource{}
+		resource.ResourceId, _ = row["id"].(string)
+		resource.SubscriptionId, _ = row["subscriptionId"].(string)
+		resource.ResourceGroup, _ = row["resourceGroup"].(string)
+		resource.Location, _ = row["location"].(string)
+		resource.ResourceType, _ = row["type"].(string)
+		resource.Status, _ = row["status"].(string)
+		resource.LastStatusChange, _ = row["lastStatusChange"].(string)
+		resource.AgentVersion, _ = row["agentVersion"].(string)
+		resource.Platform, _ = row["platform"].(string)
+		ret = append(ret, resource)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query Arc resources: %w", err)
+	}
+
+	return ret, nil
+}
+
+// PublishArcResult publishes the connectivity status (azurerm_arc_status, azurerm_arc_connected), the last status
+// change (azurerm_arc_last_status_change_timestamp_seconds) and agent info (azurerm_arc_info) of Arc resources
+func PublishArcResult(registry *prometheus.Registry, result *ArcResult) {
+	resourceLabels := []string{
+		"subscriptionID",
+		"resourceID",
+		"resourceGroup",
+		"resourceType",
+		"location",
+	}
+
+	arcStatus := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_arc_status",
+			Help: "Azure Arc connectivity status of Arc-enabled server or Kubernetes cluster (1 for the current status)",
+		},
+		append(append([]string{}, resourceLabels...), "status"),
+	)
+	registry.MustRegister(arcStatus)
+
+	arcConnected := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_arc_connected",
+			Help: "Azure Arc-enabled server or Kubernetes cluster is connected (1) or disconnected, expired, offline (0)",
+		},
+		resourceLabels,
+	)
+	registry.MustRegister(arcConnected)
+
+	arcLastStatusChange := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_arc_last_status_change_timestamp_seconds",
+			Help: "Azure Arc last status change (servers) or last connectivity time (Kubernetes clusters)",
+		},
+		resourceLabels,
+	)
+	registry.MustRegister(arcLastStatusChange)
+
+	arcInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_arc_info",
+			Help: "Azure Arc agent version and platform (operating system or Kubernetes distribution)",
+		},
+		append(append([]string{}, resourceLabels...), "agentVersion", "platform"),
+	)
+	registry.MustRegister(arcInfo)
+
+	withLabels := func(labels prometheus.Labels, extraLabels prometheus.Labels) prometheus.Labels {
+		ret := prometheus.Labels{}
+		for name, value := range labels {
+			ret[name] = value
+		}
+		for name, value := range extraLabels {
+			ret[name] = value
+		}
+		return ret
+	}
+
+	for _, resource := range result.Resources {
+		labels := prometheus.Labels{
+			"subscriptionID": resource.SubscriptionId,
+			"resourceID":     strings.ToLower(resource.ResourceId),
+			"resourceGroup":  strings.ToLower(resource.ResourceGroup),
+			"resourceType":   resource.ResourceType,
+			"location":       strings.ToLower(resource.Location),
+		}
+
+		status := strings.ToLower(resource.Status)
+		arcStatus.With(withLabels(labels, prometheus.Labels{"status": status})).Set(1)
+
+		connected := float64(0)
+		if status == ArcStatusConnected {
+			connected = 1
+		}
+		arcConnected.With(labels).Set(connected)
+
+		if lastStatusChange, err := time.Parse(time.RFC3339, resource.LastStatusChange); err == nil {
+			arcLastStatusChange.With(labels).Set(float64(lastStatusChange.Unix()))
+		}
+
+		arcInfo.With(withLabels(labels, prometheus.Labels{
+			"agentVersion": resource.AgentVersion,
+			"platform":     resource.Platform,
+		})).Set(1)
+	}
+}
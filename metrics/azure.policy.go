@@ -5,6 +5,19 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	PrometheusApiThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_api_throttled",
+			Help: "Azure metrics exporter Azure Monitor requests throttled by Azure (http 429)",
+		},
+		[]string{
+			"subscriptionID",
+		},
+	)
 )
 
 type noCachePolicy struct{}
@@ -19,3 +32,42 @@ func (p noCachePolicy) Do(req *policy.Request) (*http.Response, error) {
 	// Forward the request to the next policy in the pipeline.
 	return req.Next()
 }
+
+// throttlingPolicy counts throttled requests, runs per retry so every 429 response is counted
+type throttlingPolicy struct{}
+
+func (p throttlingPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		PrometheusApiThrottled.WithLabelValues(subscriptionIdFromUrlPath(req.Raw().URL.Path)).Inc()
+	}
+	return resp, err
+}
+
+// subscriptionIdFromUrlPath returns the subscription id of an ARM url path (/subscriptions/xxx/...)
+func subscriptionIdFromUrlPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.EqualFold(parts[i], "subscriptions") {
+			return strings.ToLower(parts[i+1])
+		}
+	}
+	return ""
+}
+
+// setMonitorRetryOptions sets the retry policy for Azure Monitor requests (--azure.retry.*),
+// throttled requests (429) are retried after Retry-After
+func (p *MetricProber) setMonitorRetryOptions(clientOpts *policy.ClientOptions) {
+	clientOpts.Retry.MaxRetries = p.Conf.Azure.Retry.MaxRetries
+	if clientOpts.Retry.MaxRetries == 0 {
+		// azcore: zero means default, negative disables retries
+		clientOpts.Retry.MaxRetries = -1
+	}
+	clientOpts.Retry.RetryDelay = p.Conf.Azure.Retry.Delay
+	clientOpts.Retry.MaxRetryDelay = p.Conf.Azure.Retry.MaxDelay
+
+	clientOpts.PerRetryPolicies = append(clientOpts.PerRetryPolicies, throttlingPolicy{})
+	if p.quotaCoordinator != nil {
+		clientOpts.PerRetryPolicies = append(clientOpts.PerRetryPolicies, p.quotaCoordinator.Policy())
+	}
+}
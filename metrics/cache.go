@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+
+	// interval expired items of the in-process cache are removed
+	MemoryCacheCleanupInterval = 1 * time.Minute
+)
+
+var (
+	PrometheusCacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_cache_evictions",
+			Help: "Azure metrics exporter items removed from the in-process cache before expiry (--cache.max-entries)",
+		},
+		[]string{
+			"cache",
+		},
+	)
+)
+
+type (
+	// Cache is the cache backend for metric results, resource lists and ResourceGraph results,
+	// values are serialized so they can be shared between exporter replicas (redis)
+	Cache interface {
+		Get(key string) ([]byte, bool)
+		Set(key string, value []byte, ttl time.Duration)
+	}
+
+	// MemoryCache is the in-process cache backend (default), limited to maxEntries items (0 = unlimited),
+	// items expiring first are evicted when the limit is reached
+	MemoryCache struct {
+		name       string
+		maxEntries int
+
+		lock  sync.Mutex
+		cache *cache.Cache
+	}
+)
+
+func NewMemoryCache(name string, maxEntries int) *MemoryCache {
+	c := MemoryCache{name: name, maxEntries: maxEntries}
+	c.cache = cache.New(cache.NoExpiration, MemoryCacheCleanupInterval)
+	return &c
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	if val, ok := c.cache.Get(key); ok {
+		if data, ok := val.([]byte); ok {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// ItemCount returns the number of cached items (including expired items not yet cleaned up)
+func (c *MemoryCache) ItemCount() int {
+	return c.cache.ItemCount()
+}
+
+// Set caches the value, values without ttl (eg. parameter cache=0) are not cached
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	if ttl < time.Millisecond {
+		return
+	}
+
+	if c.maxEntries > 0 {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		if _, exists := c.cache.Get(key); !exists && c.cache.ItemCount() >= c.maxEntries {
+			c.evict()
+		}
+	}
+
+	c.cache.Set(key, value, ttl)
+}
+
+// evict removes expired items and, if the cache is still full, the item expiring first
+func (c *MemoryCache) evict() {
+	c.cache.DeleteExpired()
+	if c.cache.ItemCount() < c.maxEntries {
+		return
+	}
+
+	evictKey, evictExpiration := "", int64(0)
+	for key, item := range c.cache.Items() {
+		if evictKey == "" || item.Expiration < evictExpiration {
+			evictKey, evictExpiration = key, item.Expiration
+		}
+	}
+	c.cache.Delete(evictKey)
+	PrometheusCacheEvictions.WithLabelValues(c.name).Inc()
+}
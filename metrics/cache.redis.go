@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	RedisCacheMaxIdleConnections = 10
+)
+
+var (
+	PrometheusCacheErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_cache_errors",
+			Help: "Azure metrics exporter failed requests to the cache backend (redis)",
+		},
+		[]string{
+			"backend",
+			"operation",
+		},
+	)
+)
+
+type (
+	// RedisCache is a shared cache backend using redis, errors are logged and treated as cache miss
+	RedisCache struct {
+		opts   RedisCacheOptions
+		logger *zap.SugaredLogger
+
+		client *redis.Client
+	}
+
+	RedisCacheOptions struct {
+		Address  string
+		Username string
+		Password string
+		DB       int
+		Tls      bool
+		Prefix   string
+		Timeout  time.Duration
+	}
+)
+
+func NewRedisCache(logger *zap.SugaredLogger, opts RedisCacheOptions) (*RedisCache, error) {
+	c := RedisCache{}
+	c.opts = opts
+	c.logger = logger
+
+	clientOpts := redis.Options{
+		Addr:         opts.Address,
+		Username:     opts.Username,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		DialTimeout:  opts.Timeout,
+		ReadTimeout:  opts.Timeout,
+		WriteTimeout: opts.Timeout,
+		MaxIdleConns: RedisCacheMaxIdleConnections,
+	}
+	if opts.Tls {
+		host, _, _ := net.SplitHostPort(opts.Address)
+		clientOpts.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	c.client = redis.NewClient(&clientOpts)
+
+	// check connection and credentials
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		_ = c.client.Close()
+		return nil, fmt.Errorf(`unable to connect to redis "%s": %w`, opts.Address, err)
+	}
+
+	return &c, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, c.opts.Prefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.logger.Warnf("redis cache get failed: %v", err)
+			PrometheusCacheErrors.WithLabelValues(CacheBackendRedis, "get").Inc()
+		}
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (c *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	if ttl < time.Millisecond {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+
+	if err := c.client.Set(ctx, c.opts.Prefix+key, value, ttl).Err(); err != nil {
+		c.logger.Warnf("redis cache set failed: %v", err)
+		PrometheusCacheErrors.WithLabelValues(CacheBackendRedis, "set").Inc()
+	}
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	CacheResultHit   = "hit"
+	CacheResultMiss  = "miss"
+	CacheResultStale = "stale"
+
+	ResourceGraphCacheRefreshTimeout = 2 * time.Minute
+)
+
+var (
+	PrometheusCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_cache_requests",
+			Help: "Azure metrics exporter cache requests by result (hit, miss, stale)",
+		},
+		[]string{
+			"cache",
+			"result",
+		},
+	)
+
+	PrometheusCacheRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_cache_refreshes",
+			Help: "Azure metrics exporter background cache refreshes (stale-while-revalidate) by result",
+		},
+		[]string{
+			"cache",
+			"result",
+		},
+	)
+)
+
+type (
+	// ResourceGraphCache caches ResourceGraph query results, expired entries are served (stale)
+	// while they are refreshed in background
+	ResourceGraphCache struct {
+		cache         Cache
+		staleDuration time.Duration
+		invalidations *ResourceInvalidations
+
+		lock       sync.Mutex
+		refreshing map[string]bool
+	}
+
+	resourceGraphCacheEntry struct {
+		ResourceList []AzureResource `json:"resourceList"`
+		Created      time.Time       `json:"created"`
+		Expiry       time.Time       `json:"expiry"`
+	}
+
+	resourceGraphFetchFunc func(ctx context.Context) ([]AzureResource, error)
+)
+
+func NewResourceGraphCache(backend Cache, staleDuration time.Duration) *ResourceGraphCache {
+	c := ResourceGraphCache{}
+	c.cache = backend
+	c.staleDuration = staleDuration
+	c.refreshing = map[string]bool{}
+	return &c
+}
+
+// SetInvalidations sets the resource changes (Event Grid webhook), results created before a change are fetched again
+func (c *ResourceGraphCache) SetInvalidations(invalidations *ResourceInvalidations) {
+	c.invalidations = invalidations
+}
+
+// Get returns the cached result or fetches it, stale results are returned and refreshed in background,
+// results of changed subscriptions are fetched again
+func (c *ResourceGraphCache) Get(ctx context.Context, logger *zap.SugaredLogger, cacheKey string, subscriptions []string, ttl time.Duration, fetch resourceGraphFetchFunc) ([]AzureResource, error) {
+	if cacheData, ok := c.cache.Get(cacheKey); ok {
+		entry := resourceGraphCacheEntry{}
+		switch err := json.Unmarshal(cacheData, &entry); {
+		case err != nil:
+			logger.Debug("unable to parse cached resourcegraph result")
+		case c.invalidations.Invalidated(entry.Created, subscriptions...):
+			logger.Debugf("resources changed since %s, fetching resourcegraph result", entry.Created.Format(time.RFC3339))
+		case time.Now().Before(entry.Expiry):
+			PrometheusCacheRequests.WithLabelValues("resourcegraph", CacheResultHit).Inc()
+			return entry.ResourceList, nil
+		default:
+			PrometheusCacheRequests.WithLabelValues("resourcegraph", CacheResultStale).Inc()
+			c.refreshInBackground(logger, cacheKey, ttl, fetch)
+			return entry.ResourceList, nil
+		}
+	}
+
+	PrometheusCacheRequests.WithLabelValues("resourcegraph", CacheResultMiss).Inc()
+	resourceList, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.set(cacheKey, resourceList, ttl)
+
+	return resourceList, nil
+}
+
+func (c *ResourceGraphCache) set(cacheKey string, resourceList []AzureResource, ttl time.Duration) {
+	entry := resourceGraphCacheEntry{
+		ResourceList: resourceList,
+		Created:      time.Now(),
+		Expiry:       time.Now().Add(ttl),
+	}
+	if cacheData, err := json.Marshal(entry); err == nil {
+		c.cache.Set(cacheKey, cacheData, ttl+c.staleDuration)
+	}
+}
+
+func (c *ResourceGraphCache) refreshInBackground(logger *zap.SugaredLogger, cacheKey string, ttl time.Duration, fetch resourceGraphFetchFunc) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// only one refresh per cache key
+	if c.refreshing[cacheKey] {
+		return
+	}
+	c.refreshing[cacheKey] = true
+
+	go func() {
+		defer func() {
+			c.lock.Lock()
+			delete(c.refreshing, cacheKey)
+			c.lock.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), ResourceGraphCacheRefreshTimeout)
+		defer cancel()
+
+		resourceList, err := fetch(ctx)
+		if err != nil {
+			logger.Warnf("background refresh of resourcegraph cache failed: %v", err)
+			PrometheusCacheRefreshes.WithLabelValues("resourcegraph", "error").Inc()
+			return
+		}
+
+		c.set(cacheKey, resourceList, ttl)
+		PrometheusCacheRefreshes.WithLabelValues("resourcegraph", "success").Inc()
+	}()
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"crypto/sha1" // #nosec G505
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type (
+	// ProbeCacheKeySettings are the parsed settings of a probe which build its cache key (ProbeCacheKey)
+	ProbeCacheKeySettings interface {
+		// cacheKeySettings returns a normalized copy of the settings with json representation, parameters which
+		// don't change the probe result (order of lists, casing of case-insensitive Azure values) are normalized
+		cacheKeySettings() interface{}
+	}
+
+	// metricSettingsCacheKey are the canonical metric settings with the regular expressions of the resource match
+	// as strings (shadows the embedded fields, *regexp.Regexp has no json representation)
+	metricSettingsCacheKey struct {
+		RequestMetricSettings
+		ResourceMatch resourceMatchCacheKey
+	}
+
+	resourceMatchCacheKey struct {
+		ResourceMatch
+		ResourceNameRegexp  string
+		ResourceGroupRegexp string
+		LocationRegexp      string
+	}
+)
+
+// ProbeCacheKey returns the cache key of a probe (prefix per endpoint) built from the parsed settings instead of the
+// request url: parameters are defaulted (eg. profile, timespan) and validated by the settings parser and the settings
+// are normalized (cacheKeySettings), so reordered parameters and explicit default values share the cache entry.
+// Request parameters which are not part of the settings (eg. target) have to be passed as keyParts ("name=value",
+// normalized by the caller, the order doesn't matter). Cache durations are part of the key, a probe never reads
+// results cached with a longer duration than its own.
+func ProbeCacheKey(prefix string, settings ProbeCacheKeySettings, keyParts ...string) (string, error) {
+	parts := append([]string{}, keyParts...)
+	sort.Strings(parts)
+
+	data, err := json.Marshal(struct {
+		Settings interface{}
+		Parts    []string
+	}{
+		Settings: settings.cacheKeySettings(),
+		Parts:    parts,
+	})
+	if err != nil {
+		return "", fmt.Errorf(`unable to build cache key of probe "%s": %w`, prefix, err)
+	}
+
+	return fmt.Sprintf("%s:%x", prefix, sha1.Sum(data)), nil // #nosec G401
+}
+
+func (s *RequestMetricSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.SubscriptionFilter = sortedCacheKeyList(s.SubscriptionFilter, false)
+	settings.SubscriptionTags = sortedCacheKeyList(s.SubscriptionTags, false)
+	settings.Regions = sortedCacheKeyList(s.Regions, true)
+	settings.Metrics = sortedCacheKeyList(s.Metrics, false)
+	settings.Aggregations = sortedCacheKeyList(s.Aggregations, true)
+	settings.Dimensions = sortedCacheKeyList(s.Dimensions, false)
+	settings.TagLabels = sortedCacheKeyList(s.TagLabels, false)
+	settings.StorageServices = sortedCacheKeyList(s.StorageServices, true)
+	settings.ResourceType = strings.ToLower(s.ResourceType)
+	settings.MetricNamespace = strings.ToLower(s.MetricNamespace)
+
+	// templates are part of the key by their source, priority only changes the order of requests
+	settings.MetricGoTemplate = nil
+	settings.HelpGoTemplate = nil
+	settings.Priority = ""
+
+	return metricSettingsCacheKey{
+		RequestMetricSettings: settings,
+		ResourceMatch: resourceMatchCacheKey{
+			ResourceMatch:       s.ResourceMatch,
+			ResourceNameRegexp:  regexpString(s.ResourceMatch.ResourceNameRegexp),
+			ResourceGroupRegexp: regexpString(s.ResourceMatch.ResourceGroupRegexp),
+			LocationRegexp:      regexpString(s.ResourceMatch.LocationRegexp),
+		},
+	}
+}
+
+func (s *MetricAlertsSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	return settings
+}
+
+func (s *KeyVaultSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.Vaults = sortedCacheKeyList(s.Vaults, true)
+	settings.ObjectTypes = sortedCacheKeyList(s.ObjectTypes, true)
+	return settings
+}
+
+func (s *ArcSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.ResourceTypes = sortedCacheKeyList(s.ResourceTypes, true)
+	return settings
+}
+
+func (s *ScheduledEventsSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	return settings
+}
+
+func (s *PolicySettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	return settings
+}
+
+func (s *AdvisorSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.Categories = sortedCacheKeyList(s.Categories, false)
+	settings.Impacts = sortedCacheKeyList(s.Impacts, false)
+	return settings
+}
+
+func (s *ActivityLogSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.ResourceGroups = sortedCacheKeyList(s.ResourceGroups, true)
+	settings.Categories = sortedCacheKeyList(s.Categories, false)
+	return settings
+}
+
+func (s *CostsSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.GroupBy = sortedCacheKeyList(s.GroupBy, false)
+	return settings
+}
+
+func (s *ResourceHealthSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.ResourceTypes = sortedCacheKeyList(s.ResourceTypes, true)
+	return settings
+}
+
+func (s *QuotaSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Subscriptions = sortedCacheKeyList(s.Subscriptions, true)
+	settings.Locations = sortedCacheKeyList(s.Locations, false)
+	settings.Providers = sortedCacheKeyList(s.Providers, false)
+	return settings
+}
+
+// segments are nested in the order of the parameter and are not sorted
+func (s *AppInsightsMetricsSettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Apps = sortedCacheKeyList(s.Apps, false)
+	settings.Targets = sortedCacheKeyList(s.Targets, false)
+	settings.Metrics = sortedCacheKeyList(s.Metrics, false)
+	settings.Aggregations = sortedCacheKeyList(s.Aggregations, false)
+	return settings
+}
+
+// value columns are exported in the order of the parameter and are not sorted
+func (s *LogAnalyticsQuerySettings) cacheKeySettings() interface{} {
+	settings := *s
+	settings.Workspaces = sortedCacheKeyList(s.Workspaces, false)
+	return settings
+}
+
+// sortedCacheKeyList returns a sorted copy of the list (optionally lowercase)
+func sortedCacheKeyList(list []string, lowercase bool) []string {
+	ret := make([]string, 0, len(list))
+	for _, value := range list {
+		if lowercase {
+			value = strings.ToLower(value)
+		}
+		ret = append(ret, value)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func regexpString(re *regexp.Regexp) string {
+	if re == nil {
+		return ""
+	}
+	return re.String()
+}
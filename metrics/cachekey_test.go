@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeCacheKeyNormalized(t *testing.T) {
+	cacheShort := 30 * time.Second
+	cacheLong := 1 * time.Hour
+
+	testCases := []struct {
+		name    string
+		a, b    ProbeCacheKeySettings
+		differs ProbeCacheKeySettings
+	}{
+		{
+			name: "metrics reordered",
+			a: &RequestMetricSettings{
+				Subscriptions: []string{"AAA", "bbb"},
+				Regions:       []string{"westeurope", "NorthEurope"},
+				ResourceType:  "Microsoft.Storage/storageAccounts",
+				Metrics:       []string{"UsedCapacity", "Transactions"},
+				Aggregations:  []string{"Average", "total"},
+				Dimensions:    []string{"ApiName", "GeoType"},
+				Cache:         &cacheShort,
+			},
+			b: &RequestMetricSettings{
+				Subscriptions: []string{"bbb", "aaa"},
+				Regions:       []string{"northeurope", "westeurope"},
+				ResourceType:  "microsoft.storage/storageaccounts",
+				Metrics:       []string{"Transactions", "UsedCapacity"},
+				Aggregations:  []string{"total", "average"},
+				Dimensions:    []string{"GeoType", "ApiName"},
+				Cache:         &cacheShort,
+			},
+			differs: &RequestMetricSettings{
+				Subscriptions: []string{"aaa", "bbb"},
+				Regions:       []string{"northeurope", "westeurope"},
+				ResourceType:  "microsoft.storage/storageaccounts",
+				Metrics:       []string{"Transactions"},
+				Aggregations:  []string{"average", "total"},
+				Dimensions:    []string{"ApiName", "GeoType"},
+				Cache:         &cacheShort,
+			},
+		},
+		{
+			name:    "metrics ttl",
+			a:       &RequestMetricSettings{Subscriptions: []string{"aaa"}, Metrics: []string{"m"}, Cache: &cacheShort},
+			b:       &RequestMetricSettings{Subscriptions: []string{"AAA"}, Metrics: []string{"m"}, Cache: &cacheShort},
+			differs: &RequestMetricSettings{Subscriptions: []string{"aaa"}, Metrics: []string{"m"}, Cache: &cacheLong},
+		},
+		{
+			name:    "advisor",
+			a:       &AdvisorSettings{Subscriptions: []string{"AAA", "bbb"}, Categories: []string{"Cost", "Security"}},
+			b:       &AdvisorSettings{Subscriptions: []string{"bbb", "aaa"}, Categories: []string{"Security", "Cost"}},
+			differs: &AdvisorSettings{Subscriptions: []string{"aaa", "bbb"}, Categories: []string{"Cost"}},
+		},
+		{
+			name:    "alerts",
+			a:       &MetricAlertsSettings{Subscriptions: []string{"aaa", "bbb"}, TimeRange: "PT1H"},
+			b:       &MetricAlertsSettings{Subscriptions: []string{"BBB", "aaa"}, TimeRange: "PT1H"},
+			differs: &MetricAlertsSettings{Subscriptions: []string{"aaa", "bbb"}, TimeRange: "PT2H"},
+		},
+		{
+			name:    "policy",
+			a:       &PolicySettings{Subscriptions: []string{"aaa", "bbb"}},
+			b:       &PolicySettings{Subscriptions: []string{"bbb", "aaa"}},
+			differs: &PolicySettings{Subscriptions: []string{"aaa", "bbb"}, ResourceTypes: true},
+		},
+		{
+			name:    "costs",
+			a:       &CostsSettings{Subscriptions: []string{"aaa"}, GroupBy: []string{"ServiceName", "ResourceGroupName"}},
+			b:       &CostsSettings{Subscriptions: []string{"AAA"}, GroupBy: []string{"ResourceGroupName", "ServiceName"}},
+			differs: &CostsSettings{Subscriptions: []string{"aaa"}, GroupBy: []string{"ServiceName"}},
+		},
+		{
+			name:    "health",
+			a:       &ResourceHealthSettings{Subscriptions: []string{"aaa"}, ResourceTypes: []string{"Microsoft.Compute/virtualMachines", "microsoft.web/sites"}},
+			b:       &ResourceHealthSettings{Subscriptions: []string{"aaa"}, ResourceTypes: []string{"Microsoft.Web/sites", "microsoft.compute/virtualmachines"}},
+			differs: &ResourceHealthSettings{Subscriptions: []string{"aaa"}, ResourceTypes: []string{"microsoft.web/sites"}},
+		},
+		{
+			name:    "logs",
+			a:       &LogAnalyticsQuerySettings{Workspaces: []string{"w1", "w2"}, Query: "Heartbeat", ValueColumns: []string{"a", "b"}},
+			b:       &LogAnalyticsQuerySettings{Workspaces: []string{"w2", "w1"}, Query: "Heartbeat", ValueColumns: []string{"a", "b"}},
+			differs: &LogAnalyticsQuerySettings{Workspaces: []string{"w1", "w2"}, Query: "Heartbeat", ValueColumns: []string{"b", "a"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			keyA, err := ProbeCacheKey(testCase.name, testCase.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyB, err := ProbeCacheKey(testCase.name, testCase.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyDiffers, err := ProbeCacheKey(testCase.name, testCase.differs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if keyA != keyB {
+				t.Errorf("expected same cache key for normalized settings, got %s and %s", keyA, keyB)
+			}
+			if keyA == keyDiffers {
+				t.Errorf("expected different cache key for different settings, got %s", keyA)
+			}
+		})
+	}
+}
+
+func TestProbeCacheKeyParts(t *testing.T) {
+	settings := &RequestMetricSettings{Subscriptions: []string{"aaa"}, Metrics: []string{"UsedCapacity"}}
+
+	testCases := []struct {
+		name    string
+		a, b    []string
+		sameKey bool
+	}{
+		{
+			name:    "target order",
+			a:       []string{"target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/foo", "target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/bar"},
+			b:       []string{"target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/bar", "target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/foo"},
+			sameKey: true,
+		},
+		{
+			name: "different target",
+			a:    []string{"target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/foo"},
+			b:    []string{"target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/bar"},
+		},
+		{
+			name: "no target",
+			a:    []string{"target=/subscriptions/aaa/resourcegroups/rg/providers/x/y/foo"},
+			b:    nil,
+		},
+		{
+			name: "different metricTagName",
+			a:    []string{"metricTagName=metric", "aggregationTagName=aggregation"},
+			b:    []string{"metricTagName=metrics", "aggregationTagName=aggregation"},
+		},
+		{
+			name: "different aggregationTagName",
+			a:    []string{"metricTagName=metric", "aggregationTagName=aggregation"},
+			b:    []string{"metricTagName=metric", "aggregationTagName=aggregations"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			keyA, err := ProbeCacheKey("resource", settings, testCase.a...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyB, err := ProbeCacheKey("resource", settings, testCase.b...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if testCase.sameKey && keyA != keyB {
+				t.Errorf("expected same cache key, got %s and %s", keyA, keyB)
+			}
+			if !testCase.sameKey && keyA == keyB {
+				t.Errorf("expected different cache key, got %s", keyA)
+			}
+		})
+	}
+}
+
+func TestProbeCacheKeySettingsTypes(t *testing.T) {
+	top := 5
+	settingsList := []ProbeCacheKeySettings{
+		&RequestMetricSettings{},
+		&MetricAlertsSettings{},
+		&KeyVaultSettings{},
+		&ArcSettings{},
+		&ScheduledEventsSettings{},
+		&PolicySettings{},
+		&AdvisorSettings{},
+		&ActivityLogSettings{},
+		&CostsSettings{},
+		&ResourceHealthSettings{},
+		&QuotaSettings{},
+		&AppInsightsMetricsSettings{Top: &top},
+		&LogAnalyticsQuerySettings{},
+	}
+
+	for _, settings := range settingsList {
+		if _, err := ProbeCacheKey("test", settings); err != nil {
+			t.Errorf("%T: %v", settings, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type (
+	// Collector is a custom collector compiled into the exporter (eg. for preview APIs), registered with
+	// RegisterCollector and served as /probe/collector/<name> with the credentials, caching, restrictions,
+	// timeouts and response formats of the built-in probes
+	Collector interface {
+		// Name of the collector (probe url), lowercase letters, digits, dashes and underscores
+		Name() string
+
+		// Collect collects the metrics of the probe request into request.Registry
+		Collect(ctx context.Context, request *CollectorRequest) error
+	}
+
+	// CollectorRequest is a probe request of a custom collector
+	CollectorRequest struct {
+		// subscriptions of the probe (parameter subscription, checked against --restrict.subscription)
+		Subscriptions []string
+
+		// all parameters of the probe request (eg. custom filters of the collector)
+		Params url.Values
+
+		// credential of the probe (parameters credential and tenant) and client options of the configured cloud
+		Credential    azcore.TokenCredential
+		ClientOptions *arm.ClientOptions
+
+		// cache of the exporter (--cache.backend), keys are prefixed with the collector name, CacheDuration
+		// is set by parameter cache (nil = results should not be cached)
+		Cache         Cache
+		CacheDuration *time.Duration
+
+		Registry *prometheus.Registry
+		Logger   *zap.SugaredLogger
+	}
+
+	CollectorSettings struct {
+		Subscriptions []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	// collectorCache prefixes the cache keys of a collector
+	collectorCache struct {
+		cache  Cache
+		prefix string
+	}
+)
+
+var (
+	collectorNameRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+	collectorLock sync.RWMutex
+	collectors    = map[string]Collector{}
+)
+
+// RegisterCollector registers the custom collector (usually in init() of the collector package, compiled in with a
+// blank import), panics if the name is invalid or already registered
+func RegisterCollector(collector Collector) {
+	name := collector.Name()
+	if !collectorNameRegexp.MatchString(name) {
+		panic(fmt.Sprintf(`collector name "%s" is invalid, only lowercase letters, digits, dashes and underscores are allowed`, name))
+	}
+
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+
+	if _, exists := collectors[name]; exists {
+		panic(fmt.Sprintf(`collector "%s" is already registered`, name))
+	}
+	collectors[name] = collector
+}
+
+// GetCollector returns the registered collector
+func GetCollector(name string) (Collector, bool) {
+	collectorLock.RLock()
+	defer collectorLock.RUnlock()
+
+	collector, exists := collectors[name]
+	return collector, exists
+}
+
+// CollectorNames returns the names of the registered collectors (sorted)
+func CollectorNames() []string {
+	collectorLock.RLock()
+	defer collectorLock.RUnlock()
+
+	names := []string{}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func NewCollectorSettings(r *http.Request) (CollectorSettings, error) {
+	ret := CollectorSettings{}
+	params := r.URL.Query()
+
+	// param subscription (optional, collectors without subscription scope)
+	if val, err := paramsGetList(params, "subscription"); err == nil {
+		ret.Subscriptions = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewCollectorCache returns the cache of the collector, keys are prefixed with the collector name
+func NewCollectorCache(cache Cache, name string) Cache {
+	return &collectorCache{cache: cache, prefix: "collector:" + name + ":"}
+}
+
+func (c *collectorCache) Get(key string) ([]byte, bool) {
+	return c.cache.Get(c.prefix + key)
+}
+
+func (c *collectorCache) Set(key string, value []byte, ttl time.Duration) {
+	c.cache.Set(c.prefix+key, value, ttl)
+}
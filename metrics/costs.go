@@ -0,0 +1,346 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	CostsMetricNameDefault = "azurerm_costs"
+
+	CostManagementApiVersion = "2023-03-01"
+
+	// max groupings of the Cost Management query api
+	CostsMaxGroupings = 2
+
+	CostsGranularityNone  = "None"
+	CostsGranularityDaily = "Daily"
+)
+
+type (
+	// CostManagementClient runs queries against the Cost Management query api
+	CostManagementClient struct {
+		client *arm.Client
+	}
+
+	CostsSettings struct {
+		Subscriptions []string
+		Type          string
+		Timeframe     string
+		Granularity   string
+		GroupBy       []string
+		Tag           string
+		Name          string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	CostsQueryResult struct {
+		Columns []CostsColumn   `json:"columns"`
+		Rows    [][]interface{} `json:"rows"`
+	}
+
+	CostsColumn struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	costsQueryRequest struct {
+		Type      string       `json:"type"`
+		Timeframe string       `json:"timeframe"`
+		Dataset   costsDataset `json:"dataset"`
+	}
+
+	costsDataset struct {
+		Granularity string                      `json:"granularity"`
+		Aggregation map[string]costsAggregation `json:"aggregation"`
+		Grouping    []costsGrouping             `json:"grouping,omitempty"`
+	}
+
+	costsAggregation struct {
+		Name     string `json:"name"`
+		Function string `json:"function"`
+	}
+
+	costsGrouping struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+
+	costsQueryResponse struct {
+		Properties CostsQueryResult `json:"properties"`
+	}
+
+	// CostsMetricBuilder maps query result rows to a gauge (cost per subscription, groupings and currency)
+	CostsMetricBuilder struct {
+		settings *CostsSettings
+		gauge    *prometheus.GaugeVec
+	}
+)
+
+func NewCostsSettings(r *http.Request, opts config.Opts) (CostsSettings, error) {
+	ret := CostsSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param type
+	ret.Type = paramsGetWithDefault(params, "type", "ActualCost")
+
+	// param timeframe
+	ret.Timeframe = paramsGetWithDefault(params, "timeframe", "MonthToDate")
+
+	// param granularity
+	ret.Granularity = paramsGetWithDefault(params, "granularity", CostsGranularityNone)
+	if !strings.EqualFold(ret.Granularity, CostsGranularityNone) && !strings.EqualFold(ret.Granularity, CostsGranularityDaily) {
+		return ret, fmt.Errorf(`parameter "granularity" must be "%s" or "%s"`, CostsGranularityNone, CostsGranularityDaily)
+	}
+
+	// param groupBy (dimensions, eg. ResourceGroupName, ServiceName)
+	if val, err := paramsGetList(params, "groupBy"); err == nil {
+		ret.GroupBy = val
+	} else {
+		return ret, err
+	}
+
+	// param tag
+	ret.Tag = paramsGetWithDefault(params, "tag", "")
+
+	groupings := len(ret.GroupBy)
+	if ret.Tag != "" {
+		groupings++
+	}
+	if groupings > CostsMaxGroupings {
+		return ret, fmt.Errorf(`only %d groupings ("groupBy" and "tag") are supported by the Cost Management api`, CostsMaxGroupings)
+	}
+
+	// param name
+	ret.Name = paramsGetWithDefault(params, "name", CostsMetricNameDefault)
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache (Cost Management api has strict rate limits)
+	cacheDuration := opts.Azure.Costs.CacheDuration
+	if val := params.Get("cache"); val != "" {
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			return ret, err
+		}
+		cacheDuration = duration
+	}
+	if cacheDuration.Seconds() > 0 {
+		ret.Cache = &cacheDuration
+	}
+
+	return ret, nil
+}
+
+// NewCostManagementClient creates a client for the Cost Management api of the configured cloud
+func NewCostManagementClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*CostManagementClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CostManagementClient{client: client}, nil
+}
+
+// Query runs the cost query for the subscription
+func (c *CostManagementClient) Query(ctx context.Context, subscriptionId string, settings *CostsSettings) (*CostsQueryResult, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.CostManagement/query",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+	)
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("api-version", CostManagementApiVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header.Set("Accept", "application/json")
+
+	body := costsQueryRequest{
+		Type:      settings.Type,
+		Timeframe: settings.Timeframe,
+		Dataset: costsDataset{
+			Granularity: settings.Granularity,
+			Aggregation: map[string]costsAggregation{
+				"totalCost": {Name: "Cost", Function: "Sum"},
+			},
+		},
+	}
+	for _, dimension := range settings.GroupBy {
+		body.Dataset.Grouping = append(body.Dataset.Grouping, costsGrouping{Type: "Dimension", Name: dimension})
+	}
+	if settings.Tag != "" {
+		body.Dataset.Grouping = append(body.Dataset.Grouping, costsGrouping{Type: "TagKey", Name: settings.Tag})
+	}
+
+	if err := runtime.MarshalAsJSON(req, body); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	result := costsQueryResponse{}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result.Properties, nil
+}
+
+func NewCostsMetricBuilder(settings *CostsSettings, registry *prometheus.Registry) (*CostsMetricBuilder, error) {
+	builder := CostsMetricBuilder{}
+	builder.settings = settings
+
+	labelNames := []string{"subscriptionID", "type", "timeframe", "currency"}
+	for _, dimension := range settings.GroupBy {
+		labelNames = append(labelNames, costsLabelName(dimension))
+	}
+	if settings.Tag != "" {
+		labelNames = append(labelNames, costsTagLabelName(settings.Tag))
+	}
+
+	builder.gauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: settings.Name,
+			Help: "Azure Cost Management costs",
+		},
+		labelNames,
+	)
+	if err := registry.Register(builder.gauge); err != nil {
+		return nil, fmt.Errorf(`unable to register metric "%s": %w`, settings.Name, err)
+	}
+
+	return &builder, nil
+}
+
+// AddResult adds the rows of the query result of the subscription, with daily granularity only the latest day is used
+func (b *CostsMetricBuilder) AddResult(subscriptionId string, result *CostsQueryResult) {
+	columns := map[string]int{}
+	for i, column := range result.Columns {
+		columns[strings.ToLower(column.Name)] = i
+	}
+
+	costColumn, exists := columns["cost"]
+	if !exists {
+		return
+	}
+
+	rows := result.Rows
+	if dateColumn, exists := columns["usagedate"]; exists {
+		rows = costsLatestRows(rows, dateColumn)
+	}
+
+	for _, row := range rows {
+		cost, ok := logAnalyticsValueToFloat(costsRowValue(row, costColumn))
+		if !ok {
+			continue
+		}
+
+		labels := prometheus.Labels{
+			"subscriptionID": subscriptionId,
+			"type":           b.settings.Type,
+			"timeframe":      b.settings.Timeframe,
+			"currency":       "",
+		}
+		if i, exists := columns["currency"]; exists {
+			labels["currency"] = logAnalyticsValueToString(costsRowValue(row, i))
+		}
+		for _, dimension := range b.settings.GroupBy {
+			labels[costsLabelName(dimension)] = ""
+			if i, exists := columns[strings.ToLower(dimension)]; exists {
+				labels[costsLabelName(dimension)] = logAnalyticsValueToString(costsRowValue(row, i))
+			}
+		}
+		if b.settings.Tag != "" {
+			labels[costsTagLabelName(b.settings.Tag)] = ""
+			if i, exists := columns["tagvalue"]; exists {
+				labels[costsTagLabelName(b.settings.Tag)] = logAnalyticsValueToString(costsRowValue(row, i))
+			}
+		}
+
+		b.gauge.With(labels).Add(cost)
+	}
+}
+
+// costsLatestRows returns the rows of the latest usage date
+func costsLatestRows(rows [][]interface{}, dateColumn int) [][]interface{} {
+	latestDate := ""
+	for _, row := range rows {
+		if date := logAnalyticsValueToString(costsRowValue(row, dateColumn)); date > latestDate {
+			latestDate = date
+		}
+	}
+
+	ret := [][]interface{}{}
+	for _, row := range rows {
+		if logAnalyticsValueToString(costsRowValue(row, dateColumn)) == latestDate {
+			ret = append(ret, row)
+		}
+	}
+	return ret
+}
+
+func costsRowValue(row []interface{}, i int) interface{} {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
+
+// costsLabelName converts dimension names (eg. ResourceGroupName) to label names (resourceGroupName)
+func costsLabelName(dimension string) string {
+	name := logAnalyticsNameRegexp.ReplaceAllString(dimension, "_")
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+func costsTagLabelName(tag string) string {
+	return "tag_" + logAnalyticsNameRegexp.ReplaceAllString(tag, "_")
+}
@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/remotewrite"
+)
+
+const (
+	// Azure Monitor custom metrics limits
+	CustomMetricsMaxDimensions = 10
+	CustomMetricsMaxAge        = 20 * time.Minute
+	CustomMetricsMaxSeries     = 100
+)
+
+type (
+	// CustomMetricsRelay publishes Prometheus series (remote-write, scraped targets) as Azure Monitor custom metrics
+	// of a resource, samples are aggregated per minute (min, max, sum, count) until they are flushed
+	CustomMetricsRelay struct {
+		pipeline  runtime.Pipeline
+		endpoint  string
+		namespace string
+		match     []string
+		logger    *zap.SugaredLogger
+
+		lock    sync.Mutex
+		buckets map[customMetricsBucketKey]*customMetricsSeries
+	}
+
+	customMetricsBucketKey struct {
+		metric string
+		time   time.Time
+		series string
+	}
+
+	customMetricsSeries struct {
+		DimValues []string `json:"dimValues,omitempty"`
+		Min       float64  `json:"min"`
+		Max       float64  `json:"max"`
+		Sum       float64  `json:"sum"`
+		Count     int      `json:"count"`
+
+		dimNames []string
+	}
+
+	customMetricsRequest struct {
+		Time string `json:"time"`
+		Data struct {
+			BaseData struct {
+				Metric    string                 `json:"metric"`
+				Namespace string                 `json:"namespace"`
+				DimNames  []string               `json:"dimNames,omitempty"`
+				Series    []*customMetricsSeries `json:"series"`
+			} `json:"baseData"`
+		} `json:"data"`
+	}
+)
+
+var (
+	PrometheusRelaySamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_relay_samples",
+			Help: "Azure metrics exporter samples received by the custom metrics relay by result (accepted, unmatched, dropped)",
+		},
+		[]string{
+			"result",
+		},
+	)
+
+	PrometheusRelayRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_relay_requests",
+			Help: "Azure metrics exporter custom metrics requests of the relay by result (success, error)",
+		},
+		[]string{
+			"result",
+		},
+	)
+)
+
+// NewCustomMetricsRelay creates the relay for the resource (custom metrics endpoint of the region)
+func NewCustomMetricsRelay(logger *zap.SugaredLogger, cred azcore.TokenCredential, clientOpts policy.ClientOptions, opts config.Opts) *CustomMetricsRelay {
+	pipeline := runtime.NewPipeline(
+		"azure-metrics-exporter",
+		"",
+		runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{opts.Relay.Audience}, nil),
+			},
+		},
+		&clientOpts,
+	)
+
+	match := []string{}
+	for _, pattern := range opts.Relay.Match {
+		match = append(match, strings.ToLower(pattern))
+	}
+
+	return &CustomMetricsRelay{
+		pipeline:  pipeline,
+		endpoint:  strings.TrimRight(fmt.Sprintf(opts.Relay.Endpoint, strings.ToLower(opts.Relay.Region)), "/") + "/" + strings.Trim(opts.Relay.ResourceId, "/") + "/metrics",
+		namespace: opts.Relay.Namespace,
+		match:     match,
+		logger:    logger,
+		buckets:   map[customMetricsBucketKey]*customMetricsSeries{},
+	}
+}
+
+// Add aggregates the samples of the matching series, all labels (except __name__) are used as dimensions
+func (r *CustomMetricsRelay) Add(series []remotewrite.TimeSeries) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	minTime := time.Now().Add(-CustomMetricsMaxAge)
+	for _, ts := range series {
+		metricName := ts.MetricName()
+		if !r.matches(metricName) {
+			PrometheusRelaySamples.WithLabelValues("unmatched").Add(float64(len(ts.Samples)))
+			continue
+		}
+
+		dimNames := []string{}
+		dimValues := []string{}
+		for _, label := range ts.Labels {
+			if label.Name != "__name__" {
+				dimNames = append(dimNames, label.Name)
+				dimValues = append(dimValues, label.Value)
+			}
+		}
+		if len(dimNames) > CustomMetricsMaxDimensions {
+			r.logger.Debugf(`dropping series of metric "%s": %d labels (max %d dimensions)`, metricName, len(dimNames), CustomMetricsMaxDimensions)
+			PrometheusRelaySamples.WithLabelValues("dropped").Add(float64(len(ts.Samples)))
+			continue
+		}
+
+		for _, sample := range ts.Samples {
+			sampleTime := time.UnixMilli(sample.Timestamp)
+			if sampleTime.Before(minTime) {
+				// not accepted by Azure Monitor anymore
+				PrometheusRelaySamples.WithLabelValues("dropped").Inc()
+				continue
+			}
+
+			key := customMetricsBucketKey{
+				metric: metricName,
+				time:   sampleTime.UTC().Truncate(time.Minute),
+				series: strings.Join(dimNames, "\xff") + "\xfe" + strings.Join(dimValues, "\xff"),
+			}
+			bucket, exists := r.buckets[key]
+			if !exists {
+				bucket = &customMetricsSeries{
+					DimValues: dimValues,
+					Min:       sample.Value,
+					Max:       sample.Value,
+					dimNames:  dimNames,
+				}
+				r.buckets[key] = bucket
+			}
+			bucket.Min = min(bucket.Min, sample.Value)
+			bucket.Max = max(bucket.Max, sample.Value)
+			bucket.Sum += sample.Value
+			bucket.Count++
+			PrometheusRelaySamples.WithLabelValues("accepted").Inc()
+		}
+	}
+}
+
+// Start flushes the aggregated samples every interval until the context is canceled
+func (r *CustomMetricsRelay) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.Flush(ctx)
+			}
+		}
+	}()
+}
+
+// Flush publishes the aggregated samples, one request per metric, minute and dimension set
+func (r *CustomMetricsRelay) Flush(ctx context.Context) {
+	r.lock.Lock()
+	buckets := r.buckets
+	r.buckets = map[customMetricsBucketKey]*customMetricsSeries{}
+	r.lock.Unlock()
+
+	requests := map[string]*customMetricsRequest{}
+	for key, bucket := range buckets {
+		dimNames := strings.Join(bucket.dimNames, "\xff")
+		requestKey := key.metric + "\xfe" + key.time.Format(time.RFC3339) + "\xfe" + dimNames
+
+		request, exists := requests[requestKey]
+		if !exists || len(request.Data.BaseData.Series) >= CustomMetricsMaxSeries {
+			if exists {
+				r.publish(ctx, request)
+			}
+
+			request = &customMetricsRequest{Time: key.time.Format(time.RFC3339)}
+			request.Data.BaseData.Metric = key.metric
+			request.Data.BaseData.Namespace = r.namespace
+			request.Data.BaseData.DimNames = bucket.dimNames
+			requests[requestKey] = request
+		}
+		request.Data.BaseData.Series = append(request.Data.BaseData.Series, bucket)
+	}
+
+	requestKeys := make([]string, 0, len(requests))
+	for requestKey := range requests {
+		requestKeys = append(requestKeys, requestKey)
+	}
+	sort.Strings(requestKeys)
+
+	for _, requestKey := range requestKeys {
+		r.publish(ctx, requests[requestKey])
+	}
+}
+
+func (r *CustomMetricsRelay) publish(ctx context.Context, request *customMetricsRequest) {
+	if err := r.send(ctx, request); err != nil {
+		r.logger.Warnf(`unable to publish custom metric "%s": %v`, request.Data.BaseData.Metric, err)
+		PrometheusRelayRequests.WithLabelValues("error").Inc()
+		return
+	}
+	PrometheusRelayRequests.WithLabelValues("success").Inc()
+}
+
+func (r *CustomMetricsRelay) send(ctx context.Context, request *customMetricsRequest) error {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, r.endpoint)
+	if err != nil {
+		return err
+	}
+
+	if err := runtime.MarshalAsJSON(req, request); err != nil {
+		return err
+	}
+
+	resp, err := r.pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
+
+func (r *CustomMetricsRelay) matches(metricName string) bool {
+	for _, pattern := range r.match {
+		if matchGlob(pattern, metricName) {
+			return true
+		}
+	}
+	return false
+}
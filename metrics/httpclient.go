@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+type (
+	// azureHttpTransport sends the requests of all Azure clients with the http settings (--azure.http.*)
+	azureHttpTransport struct {
+		client           *http.Client
+		endpointTimeouts map[string]time.Duration
+	}
+
+	// apiRequestCounterKey is the context key of the Azure API request counter of a probe request
+	apiRequestCounterKey struct{}
+
+	// cancelReadCloser cancels the request context of endpoint timeouts after the response body is closed
+	cancelReadCloser struct {
+		io.ReadCloser
+		cancel context.CancelFunc
+	}
+)
+
+var (
+	azureTransport policy.Transporter
+
+	PrometheusApiRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_api_requests_total",
+			Help: "Azure metrics exporter outbound Azure API requests (including retries) by endpoint, method, status code and subscription",
+		},
+		[]string{
+			"endpoint",
+			"method",
+			"statusCode",
+			"subscription",
+		},
+	)
+
+	PrometheusApiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_api_request_duration_seconds",
+			Help:    "Azure metrics exporter latency of outbound Azure API requests by endpoint and method",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{
+			"endpoint",
+			"method",
+		},
+	)
+)
+
+// InitAzureHttpTransport builds the http transport for Azure clients (proxy, ca bundle, timeouts, idle connections),
+// defaults match the Azure SDK transport
+func InitAzureHttpTransport(opts config.Opts) error {
+	tlsConfig := &tls.Config{
+		MinVersion:    tls.VersionTLS12,
+		Renegotiation: tls.RenegotiateFreelyAsClient,
+	}
+
+	if opts.Azure.Http.CaFile != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		caPem, err := os.ReadFile(opts.Azure.Http.CaFile)
+		if err != nil {
+			return fmt.Errorf(`unable to read ca file "%s": %w`, opts.Azure.Http.CaFile, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf(`no certificates found in ca file "%s"`, opts.Azure.Http.CaFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   opts.Azure.Http.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          opts.Azure.Http.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.Azure.Http.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.Azure.Http.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.Azure.Http.TlsHandshakeTimeout,
+		ResponseHeaderTimeout: opts.Azure.Http.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	if opts.Azure.Http.Proxy != "" {
+		proxyUrl, err := url.Parse(opts.Azure.Http.Proxy)
+		if err != nil || proxyUrl.Host == "" {
+			return fmt.Errorf(`invalid proxy url "%s"`, opts.Azure.Http.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	endpointTimeouts := map[string]time.Duration{}
+	for _, val := range opts.Azure.Http.EndpointTimeouts {
+		host, timeout, found := strings.Cut(val, "=")
+		if !found || strings.TrimSpace(host) == "" {
+			return fmt.Errorf(`invalid endpoint timeout "%s", expected host=duration`, val)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(timeout))
+		if err != nil || duration <= 0 {
+			return fmt.Errorf(`invalid endpoint timeout "%s": duration must be positive`, val)
+		}
+		endpointTimeouts[strings.ToLower(strings.TrimSpace(host))] = duration
+	}
+
+	azureTransport = &azureHttpTransport{
+		client:           &http.Client{Transport: transport},
+		endpointTimeouts: endpointTimeouts,
+	}
+	return nil
+}
+
+// WithApiRequestCounter returns a context counting the Azure API requests sent with it (eg. per probe request)
+func WithApiRequestCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	counter := &atomic.Int64{}
+	return context.WithValue(ctx, apiRequestCounterKey{}, counter), counter
+}
+
+// NewArmClientOptions returns the client options of the Azure client with the http transport (--azure.http.*)
+func NewArmClientOptions(client *armclient.ArmClient) *arm.ClientOptions {
+	clientOpts := client.NewArmClientOptions()
+	if azureTransport != nil {
+		clientOpts.Transport = azureTransport
+	}
+	return clientOpts
+}
+
+// Do sends the request and records it in the api usage metrics, requests to endpoints with configured timeout
+// are canceled after the timeout
+func (t *azureHttpTransport) Do(req *http.Request) (*http.Response, error) {
+	startTime := time.Now()
+	resp, err := t.send(req)
+
+	statusCode := "error"
+	if resp != nil {
+		statusCode = strconv.Itoa(resp.StatusCode)
+	}
+	endpoint := apiEndpoint(req.URL)
+	PrometheusApiRequests.WithLabelValues(endpoint, req.Method, statusCode, apiSubscription(req.URL)).Inc()
+	if counter, ok := req.Context().Value(apiRequestCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+	PrometheusApiRequestDuration.WithLabelValues(endpoint, req.Method).Observe(time.Since(startTime).Seconds())
+
+	return resp, err
+}
+
+func (t *azureHttpTransport) send(req *http.Request) (*http.Response, error) {
+	timeout, exists := t.endpointTimeout(req.URL.Hostname())
+	if !exists {
+		return t.client.Do(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// endpointTimeout returns the timeout of the host, also matches subdomains (eg. regional metrics endpoints)
+func (t *azureHttpTransport) endpointTimeout(host string) (time.Duration, bool) {
+	host = strings.ToLower(host)
+	for {
+		if timeout, exists := t.endpointTimeouts[host]; exists {
+			return timeout, true
+		}
+
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			return 0, false
+		}
+		host = parent
+	}
+}
+
+func (c *cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// apiEndpoint returns the host and the resource provider api (eg. management.azure.com/microsoft.insights/metrics)
+// of the request, resource names and ids are not included (cardinality)
+func apiEndpoint(u *url.URL) string {
+	endpoint := strings.ToLower(u.Hostname())
+
+	path := strings.ToLower(u.Path)
+	if idx := strings.LastIndex(path, "/providers/"); idx >= 0 {
+		parts := strings.SplitN(strings.Trim(path[idx+len("/providers/"):], "/"), "/", 3)
+		if len(parts) > 2 {
+			parts = parts[:2]
+		}
+		endpoint += "/" + strings.Join(parts, "/")
+	}
+
+	return endpoint
+}
+
+// apiSubscription returns the subscription of the request url (empty for tenant level requests)
+func apiSubscription(u *url.URL) string {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "subscriptions") {
+			return strings.ToLower(parts[i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ResourceAggregationSum = "sum"
+	ResourceAggregationAvg = "avg"
+
+	ResourceAggregationByResourceGroup = "resourcegroup"
+	ResourceAggregationBySubscription  = "subscription"
+)
+
+var (
+	ResourceAggregations   = []string{ResourceAggregationSum, ResourceAggregationAvg}
+	ResourceAggregationsBy = []string{ResourceAggregationByResourceGroup, ResourceAggregationBySubscription}
+)
+
+// aggregateResources collapses the series of all resources into one series per resource group or subscription
+// (parameter aggregateResources), resource specific labels (resource, tags, sub-resources) are dropped
+func (p *MetricProber) aggregateResources() {
+	if p.settings.AggregateResources == "" || p.metricList == nil {
+		return
+	}
+
+	dropLabels := map[string]bool{
+		"resourceID":   true,
+		"resourceName": true,
+	}
+	if p.settings.AggregateResourcesBy == ResourceAggregationBySubscription {
+		dropLabels["resourceGroup"] = true
+	}
+	for _, labelName := range p.targetLabelNames() {
+		dropLabels[labelName] = true
+	}
+	if p.AzureResourceTagManager != nil {
+		for _, tag := range p.AzureResourceTagManager.Tags {
+			dropLabels[tag.TargetName] = true
+		}
+	}
+
+	type aggregatedRow struct {
+		row   MetricRow
+		sum   float64
+		count int
+	}
+
+	for _, metricName := range p.metricList.GetMetricNames() {
+		keys := []string{}
+		rows := map[string]*aggregatedRow{}
+		for _, row := range p.metricList.GetMetricList(metricName) {
+			labels := map[string]string{}
+			for labelName, labelValue := range row.Labels {
+				if !dropLabels[labelName] {
+					labels[labelName] = labelValue
+				}
+			}
+
+			// series are aggregated per datapoint (datapoints=all)
+			key := metricRowTime(row).Format(time.RFC3339Nano) + "\xff" + labelsKey(labels)
+			aggregated, exists := rows[key]
+			if !exists {
+				aggregated = &aggregatedRow{
+					row: MetricRow{
+						Labels:    labels,
+						Timestamp: row.Timestamp,
+						Missing:   true,
+					},
+				}
+				rows[key] = aggregated
+				keys = append(keys, key)
+			}
+
+			// missing datapoints of single resources are ignored
+			if !row.Missing {
+				aggregated.row.Missing = false
+				aggregated.sum += row.Value
+				aggregated.count++
+			}
+		}
+
+		list := make([]MetricRow, 0, len(keys))
+		for _, key := range keys {
+			aggregated := rows[key]
+			aggregated.row.Value = aggregated.sum
+			if p.settings.AggregateResources == ResourceAggregationAvg && aggregated.count > 0 {
+				aggregated.row.Value = aggregated.sum / float64(aggregated.count)
+			}
+			list = append(list, aggregated.row)
+		}
+		p.metricList.List[metricName] = list
+	}
+}
+
+// labelsKey returns a stable key of the labels (sorted by label name)
+func labelsKey(labels map[string]string) string {
+	labelNames := make([]string, 0, len(labels))
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	parts := make([]string, 0, len(labelNames))
+	for _, labelName := range labelNames {
+		parts = append(parts, labelName+"="+labels[labelName])
+	}
+	return strings.Join(parts, "\xff")
+}
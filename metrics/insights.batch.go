@@ -0,0 +1,305 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+)
+
+const (
+	AzureMetricBatchApiVersion   = "2023-10-01"
+	AzureMetricBatchMaxResources = 50
+)
+
+type (
+	// MetricsBatchClient queries the regional endpoint of the Azure Monitor metrics data plane (metrics:getBatch)
+	MetricsBatchClient struct {
+		pipeline runtime.Pipeline
+		endpoint string
+	}
+
+	// MetricsBatchClients shares the metrics data plane clients per region and credential across probes, so tokens
+	// and connections of the regional endpoints are reused, and limits the requests per region (regional throttling)
+	MetricsBatchClients struct {
+		lock        sync.Mutex
+		clients     map[metricsBatchClientKey]*MetricsBatchClient
+		rateLimiter *RateLimiter
+	}
+
+	metricsBatchClientKey struct {
+		cred   azcore.TokenCredential
+		region string
+	}
+
+	metricsBatchRequest struct {
+		ResourceIds []string `json:"resourceids"`
+	}
+
+	metricsBatchResponse struct {
+		Values []metricsBatchResponseValue `json:"values"`
+	}
+
+	metricsBatchResponseValue struct {
+		ResourceId     string               `json:"resourceid"`
+		ResourceRegion string               `json:"resourceregion"`
+		Namespace      string               `json:"namespace"`
+		Value          []*armmonitor.Metric `json:"value"`
+	}
+)
+
+func NewMetricsBatchClients(rate float64, burst int) *MetricsBatchClients {
+	clients := MetricsBatchClients{}
+	clients.clients = map[metricsBatchClientKey]*MetricsBatchClient{}
+	if rate > 0 {
+		clients.rateLimiter = NewRegionRateLimiter(rate, burst)
+	}
+	return &clients
+}
+
+// Get returns the shared client of the region and credential, the client is created by build on first use
+func (c *MetricsBatchClients) Get(region string, cred azcore.TokenCredential, build func() *MetricsBatchClient) *MetricsBatchClient {
+	// credentials are compared by identity, credentials without identity get a client per probe
+	if cred == nil || !reflect.TypeOf(cred).Comparable() {
+		return build()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := metricsBatchClientKey{cred: cred, region: region}
+	if client, exists := c.clients[key]; exists {
+		return client
+	}
+
+	client := build()
+	c.clients[key] = client
+	return client
+}
+
+// Wait blocks until a request to the region is allowed (--azure.metrics-batch.ratelimit)
+func (c *MetricsBatchClients) Wait(ctx context.Context, region string) error {
+	if c.rateLimiter == nil {
+		return nil
+	}
+	return c.rateLimiter.Wait(ctx, strings.ToLower(region))
+}
+
+// MetricsBatchClient returns the client for the metrics data plane endpoint of the region (resource location),
+// clients are shared across probes if the prober has the shared clients
+func (p *MetricProber) MetricsBatchClient(location string) *MetricsBatchClient {
+	region := ""
+	if strings.Contains(p.Conf.Azure.MetricsBatch.Endpoint, "{region}") {
+		region = strings.ToLower(location)
+	}
+
+	build := func() *MetricsBatchClient {
+		clientOpts := NewArmClientOptions(p.AzureClient).ClientOptions
+		p.setMonitorRetryOptions(&clientOpts)
+
+		pipeline := runtime.NewPipeline(
+			"azure-metrics-exporter",
+			"",
+			runtime.PipelineOptions{
+				PerRetry: []policy.Policy{
+					runtime.NewBearerTokenPolicy(p.GetCred(), []string{p.Conf.Azure.MetricsBatch.Audience}, nil),
+				},
+			},
+			&clientOpts,
+		)
+
+		return &MetricsBatchClient{
+			pipeline: pipeline,
+			endpoint: strings.TrimRight(strings.ReplaceAll(p.Conf.Azure.MetricsBatch.Endpoint, "{region}", region), "/"),
+		}
+	}
+
+	if p.metricsBatchClients == nil {
+		return build()
+	}
+	return p.metricsBatchClients.Get(region, p.GetCred(), build)
+}
+
+// QueryBatch fetches metrics for up to 50 resources of the same type and region
+func (c *MetricsBatchClient) QueryBatch(ctx context.Context, subscriptionId, metricNamespace string, resourceIds []string, query url.Values) (*metricsBatchResponse, error) {
+	endpoint := c.endpoint + fmt.Sprintf("/subscriptions/%s/metrics:getBatch", url.PathEscape(subscriptionId))
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	query.Set("api-version", AzureMetricBatchApiVersion)
+	query.Set("metricnamespace", metricNamespace)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header.Set("Accept", "application/json")
+
+	if err := runtime.MarshalAsJSON(req, metricsBatchRequest{ResourceIds: resourceIds}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	result := metricsBatchResponse{}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// batchQueryParams builds the getBatch query parameters from the request settings
+func (p *MetricProber) batchQueryParams(metrics, aggregations []string, interval *string, timespan string) (url.Values, error) {
+	// getBatch doesn't support timespan, translate it into start and end time
+	startTime, endTime, err := parseTimespanInterval(timespan)
+	if err != nil {
+		return nil, fmt.Errorf(`timespan "%s" not supported by batch api: %w`, timespan, err)
+	}
+
+	query := url.Values{}
+	query.Set("starttime", startTime.Format(time.RFC3339))
+	query.Set("endtime", endTime.Format(time.RFC3339))
+	query.Set("metricnames", strings.Join(metrics, ","))
+
+	if interval != nil {
+		query.Set("interval", *interval)
+	}
+
+	if len(aggregations) >= 1 {
+		query.Set("aggregation", strings.Join(aggregations, ","))
+	}
+
+	if p.settings.MetricTop != nil {
+		query.Set("top", fmt.Sprintf("%d", *p.settings.MetricTop))
+	}
+
+	if len(p.settings.MetricFilter) >= 1 {
+		query.Set("filter", p.settings.MetricFilter)
+	}
+
+	if len(p.settings.MetricOrderBy) >= 1 {
+		query.Set("orderby", p.settings.MetricOrderBy)
+	}
+
+	return query, nil
+}
+
+// splitBatchTargets groups targets by region, resource type, metrics and aggregations,
+// targets which cannot be batched are returned as remaining targets
+func (p *MetricProber) splitBatchTargets(targetList []MetricProbeTarget) (batches [][]MetricProbeTarget, remaining []MetricProbeTarget) {
+	// storage sub-namespaces require different resource URIs
+	if strings.HasPrefix(strings.ToLower(p.settings.MetricNamespace), "microsoft.storage/storageaccounts/") {
+		return nil, targetList
+	}
+
+	groups := map[string][]MetricProbeTarget{}
+	groupOrder := []string{}
+	for _, target := range targetList {
+		resourceType := ResourceTypeFromResourceId(target.ResourceId)
+		// api-version overrides are only supported by the resource manager metrics api
+		if resourceType == "" || target.Location == "" || strings.HasPrefix(strings.ToLower(target.MetricNamespace), "microsoft.storage/storageaccounts/") || p.settings.MetricsApiVersionFor(resourceType) != "" {
+			remaining = append(remaining, target)
+			continue
+		}
+
+		groupKey := strings.ToLower(fmt.Sprintf(
+			"%s|%s|%s|%s",
+			target.Location,
+			resourceType,
+			strings.Join(target.Metrics, ","),
+			strings.Join(target.Aggregations, ","),
+		))
+		if _, exists := groups[groupKey]; !exists {
+			groupOrder = append(groupOrder, groupKey)
+		}
+		groups[groupKey] = append(groups[groupKey], target)
+	}
+
+	for _, groupKey := range groupOrder {
+		group := groups[groupKey]
+		if len(group) < 2 {
+			// no benefit for single resources
+			remaining = append(remaining, group...)
+			continue
+		}
+
+		for i := 0; i < len(group); i += AzureMetricBatchMaxResources {
+			end := i + AzureMetricBatchMaxResources
+			if end > len(group) {
+				end = len(group)
+			}
+			batches = append(batches, group[i:end])
+		}
+	}
+
+	return
+}
+
+// FetchMetricsFromBatch fetches the metrics of a batch of targets (same region and type) using metrics:getBatch
+// of the regional endpoint
+func (p *MetricProber) FetchMetricsFromBatch(subscriptionId string, targetList []MetricProbeTarget, metrics []string) ([]AzureInsightMetricsResult, error) {
+	firstTarget := targetList[0]
+
+	metricNamespace := p.targetMetricNamespace(firstTarget)
+	interval, timespan := p.resolveTimeGrain(firstTarget.ResourceId, metricNamespace, metrics)
+	if metricNamespace == "" {
+		metricNamespace = ResourceTypeFromResourceId(firstTarget.ResourceId)
+	}
+	query, err := p.batchQueryParams(metrics, firstTarget.Aggregations, interval, p.requestTimespan(ResourceTypeFromResourceId(firstTarget.ResourceId), interval, timespan))
+	if err != nil {
+		return nil, err
+	}
+
+	targetMap := map[string]MetricProbeTarget{}
+	resourceIds := []string{}
+	for _, target := range targetList {
+		targetMap[strings.ToLower(target.ResourceId)] = target
+		resourceIds = append(resourceIds, target.ResourceId)
+	}
+
+	client := p.MetricsBatchClient(firstTarget.Location)
+	response, err := client.QueryBatch(p.ctx, subscriptionId, metricNamespace, resourceIds, query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []AzureInsightMetricsResult{}
+	for _, value := range response.Values {
+		target, exists := targetMap[strings.ToLower(value.ResourceId)]
+		if !exists {
+			continue
+		}
+
+		results = append(results, AzureInsightMetricsResult{
+			AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
+				prober: p,
+			},
+			target:   &target,
+			interval: interval,
+			timespan: timespan,
+			Result: &armmonitor.MetricsClientListResponse{
+				Response: armmonitor.Response{
+					Value: value.Value,
+				},
+			},
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	// long tail of dimension values is aggregated into one timeseries with dimension values "other"
+	DimensionOverflowOther = "other"
+
+	// long tail of dimension values is dropped
+	DimensionOverflowTruncate = "truncate"
+
+	DimensionOtherValue = "other"
+)
+
+var (
+	DimensionOverflowModes = []string{DimensionOverflowOther, DimensionOverflowTruncate}
+
+	PrometheusDimensionValuesCapped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_dimension_values_capped",
+			Help: "Azure metrics exporter timeseries (dimension values) dropped or aggregated into other by maxDimensionValues",
+		},
+		[]string{
+			"metric",
+			"mode",
+		},
+	)
+)
+
+// capTimeseries limits the timeseries (dimension value combinations) of the metric to maxDimensionValues per group
+// (eg. resource of subscription scoped requests), the timeseries with the highest values are kept and the
+// long tail is dropped or aggregated into one timeseries with dimension values "other"
+func (p *MetricProber) capTimeseries(metricName string, metricTimeseries []*armmonitor.TimeSeriesElement, groupDimension string) []*armmonitor.TimeSeriesElement {
+	maxValues := p.settings.MaxDimensionValues
+	if maxValues <= 0 || len(metricTimeseries) <= maxValues {
+		return metricTimeseries
+	}
+
+	groupOrder := []string{}
+	groups := map[string][]*armmonitor.TimeSeriesElement{}
+	for _, timeseries := range metricTimeseries {
+		groupValue := ""
+		if groupDimension != "" {
+			groupValue = timeseriesDimensionValue(timeseries, groupDimension)
+		}
+		if _, exists := groups[groupValue]; !exists {
+			groupOrder = append(groupOrder, groupValue)
+		}
+		groups[groupValue] = append(groups[groupValue], timeseries)
+	}
+
+	ret := []*armmonitor.TimeSeriesElement{}
+	for _, groupValue := range groupOrder {
+		timeseriesList := groups[groupValue]
+		if len(timeseriesList) <= maxValues {
+			ret = append(ret, timeseriesList...)
+			continue
+		}
+
+		sort.SliceStable(timeseriesList, func(i, j int) bool {
+			weightI, weightJ := timeseriesWeight(timeseriesList[i]), timeseriesWeight(timeseriesList[j])
+			if weightI != weightJ {
+				return weightI > weightJ
+			}
+			return timeseriesDimensionKey(timeseriesList[i]) < timeseriesDimensionKey(timeseriesList[j])
+		})
+
+		ret = append(ret, timeseriesList[:maxValues]...)
+		tail := timeseriesList[maxValues:]
+		if p.settings.MaxDimensionValuesMode == DimensionOverflowOther {
+			ret = append(ret, aggregateTimeseries(tail, groupDimension))
+		}
+
+		PrometheusDimensionValuesCapped.WithLabelValues(metricName, p.settings.MaxDimensionValuesMode).Add(float64(len(tail)))
+	}
+
+	return ret
+}
+
+// aggregateTimeseries combines the timeseries into one timeseries with dimension values "other"
+// (group dimension is kept), datapoints with the same timestamp are aggregated per aggregation
+func aggregateTimeseries(timeseriesList []*armmonitor.TimeSeriesElement, groupDimension string) *armmonitor.TimeSeriesElement {
+	type datapoint struct {
+		value *armmonitor.MetricValue
+
+		// weighted average
+		averageSum   float64
+		averageCount float64
+		averageItems int
+	}
+
+	timestamps := []time.Time{}
+	datapoints := map[time.Time]*datapoint{}
+	for _, timeseries := range timeseriesList {
+		for _, data := range timeseries.Data {
+			if data == nil || data.TimeStamp == nil {
+				continue
+			}
+
+			timestamp := *data.TimeStamp
+			point, exists := datapoints[timestamp]
+			if !exists {
+				point = &datapoint{value: &armmonitor.MetricValue{TimeStamp: &timestamp}}
+				datapoints[timestamp] = point
+				timestamps = append(timestamps, timestamp)
+			}
+
+			point.value.Total = aggregateSum(point.value.Total, data.Total)
+			point.value.Count = aggregateSum(point.value.Count, data.Count)
+			if data.Minimum != nil && (point.value.Minimum == nil || *data.Minimum < *point.value.Minimum) {
+				point.value.Minimum = to.Ptr(*data.Minimum)
+			}
+			if data.Maximum != nil && (point.value.Maximum == nil || *data.Maximum > *point.value.Maximum) {
+				point.value.Maximum = to.Ptr(*data.Maximum)
+			}
+			if data.Average != nil {
+				weight := 1.0
+				if data.Count != nil && *data.Count > 0 {
+					weight = *data.Count
+				}
+				point.averageSum += *data.Average * weight
+				point.averageCount += weight
+				point.averageItems++
+			}
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	ret := &armmonitor.TimeSeriesElement{}
+	for _, timestamp := range timestamps {
+		point := datapoints[timestamp]
+		if point.averageItems > 0 {
+			point.value.Average = to.Ptr(point.averageSum / point.averageCount)
+		}
+		ret.Data = append(ret.Data, point.value)
+	}
+
+	if len(timeseriesList) > 0 {
+		for _, dimensionRow := range timeseriesList[0].Metadatavalues {
+			if dimensionRow == nil || dimensionRow.Name == nil {
+				continue
+			}
+
+			value := DimensionOtherValue
+			if groupDimension != "" && strings.EqualFold(to.String(dimensionRow.Name.Value), groupDimension) {
+				value = to.String(dimensionRow.Value)
+			}
+			ret.Metadatavalues = append(ret.Metadatavalues, &armmonitor.MetadataValue{
+				Name:  dimensionRow.Name,
+				Value: to.Ptr(value),
+			})
+		}
+	}
+
+	return ret
+}
+
+func aggregateSum(current, value *float64) *float64 {
+	if value == nil {
+		return current
+	}
+	if current == nil {
+		return to.Ptr(*value)
+	}
+	return to.Ptr(*current + *value)
+}
+
+// timeseriesWeight returns the sum of the datapoints (first available aggregation) to rank timeseries
+func timeseriesWeight(timeseries *armmonitor.TimeSeriesElement) (weight float64) {
+	for _, data := range timeseries.Data {
+		if data == nil {
+			continue
+		}
+
+		for _, value := range []*float64{data.Total, data.Average, data.Maximum, data.Count, data.Minimum} {
+			if value != nil {
+				weight += *value
+				break
+			}
+		}
+	}
+	return
+}
+
+func timeseriesDimensionValue(timeseries *armmonitor.TimeSeriesElement, dimensionName string) string {
+	for _, dimensionRow := range timeseries.Metadatavalues {
+		if dimensionRow != nil && dimensionRow.Name != nil && strings.EqualFold(to.String(dimensionRow.Name.Value), dimensionName) {
+			return strings.ToLower(to.String(dimensionRow.Value))
+		}
+	}
+	return ""
+}
+
+func timeseriesDimensionKey(timeseries *armmonitor.TimeSeriesElement) string {
+	values := []string{}
+	for _, dimensionRow := range timeseries.Metadatavalues {
+		if dimensionRow != nil {
+			values = append(values, to.String(dimensionRow.Value))
+		}
+	}
+	return strings.Join(values, "\x00")
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	// dimension value of dry run series
+	DryRunDimensionValue = "dryrun"
+
+	// value of all aggregations of dry run series
+	DryRunValue = 1
+)
+
+var (
+	ErrDryRunSimulated = errors.New("simulated failed metric request (dry run)")
+)
+
+// dryRunMetrics returns a metric response with one datapoint of dummy values per metric (and one series per
+// dimension) instead of requesting Azure Monitor, the request is delayed by dryRunLatency and fails with
+// the ratio dryRunErrorRate
+func (p *MetricProber) dryRunMetrics(interval *string, timespan string, metrics, aggregations []string) (armmonitor.MetricsClientListResponse, error) {
+	ret := armmonitor.MetricsClientListResponse{}
+
+	if p.settings.DryRunLatency > 0 {
+		select {
+		case <-time.After(p.settings.DryRunLatency):
+		case <-p.ctx.Done():
+			return ret, p.ctx.Err()
+		}
+	}
+
+	if p.settings.DryRunErrorRate > 0 && rand.Float64() < p.settings.DryRunErrorRate { // #nosec G404
+		return ret, ErrDryRunSimulated
+	}
+
+	// Azure Monitor default aggregation
+	if len(aggregations) == 0 {
+		aggregations = []string{"average"}
+	}
+
+	timestamp := time.Now().UTC().Truncate(time.Minute)
+	datapoint := &armmonitor.MetricValue{TimeStamp: &timestamp}
+	for _, aggregation := range aggregations {
+		switch strings.ToLower(aggregation) {
+		case "average":
+			datapoint.Average = to.Float64Ptr(DryRunValue)
+		case "count":
+			datapoint.Count = to.Float64Ptr(DryRunValue)
+		case "maximum":
+			datapoint.Maximum = to.Float64Ptr(DryRunValue)
+		case "minimum":
+			datapoint.Minimum = to.Float64Ptr(DryRunValue)
+		case "total":
+			datapoint.Total = to.Float64Ptr(DryRunValue)
+		}
+	}
+
+	timeseries := &armmonitor.TimeSeriesElement{
+		Data: []*armmonitor.MetricValue{datapoint},
+	}
+	for _, dimension := range p.settings.Dimensions {
+		timeseries.Metadatavalues = append(timeseries.Metadatavalues, &armmonitor.MetadataValue{
+			Name:  &armmonitor.LocalizableString{Value: to.StringPtr(dimension)},
+			Value: to.StringPtr(DryRunDimensionValue),
+		})
+	}
+
+	unit := armmonitor.UnitUnspecified
+	for _, metric := range metrics {
+		ret.Value = append(ret.Value, &armmonitor.Metric{
+			Name:       &armmonitor.LocalizableString{Value: to.StringPtr(metric)},
+			Unit:       &unit,
+			Timeseries: []*armmonitor.TimeSeriesElement{timeseries},
+		})
+	}
+	ret.Interval = interval
+	ret.Timespan = to.StringPtr(timespan)
+
+	return ret, nil
+}
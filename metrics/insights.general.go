@@ -1,9 +1,15 @@
 package metrics
 
 import (
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/prometheus/client_golang/prometheus"
+	stringsCommon "github.com/webdevops/go-common/strings"
 )
 
 type (
@@ -12,7 +18,122 @@ type (
 	}
 )
 
-func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, value float64) (metric PrometheusMetricResult) {
+// addDimensionLabels adds the dimensions of a timeseries as labels
+func (r *AzureInsightBaseMetricsResult) addDimensionLabels(labels prometheus.Labels, dimensions map[string]string) {
+	if len(r.prober.settings.Dimensions) > 0 {
+		// dimensions requested explicitly (parameter dimension)
+		// add each dimension as dimensionXzy="foobar" label, also when missing to keep the label set stable
+		// Azure doesn't keep the case of dimension names, so use the requested names for labels
+		requestedNames := map[string]string{}
+		for _, dimensionName := range r.prober.settings.Dimensions {
+			requestedNames[strings.ToLower(dimensionName)] = dimensionName
+			labels[dimensionLabelName(dimensionName)] = ""
+		}
+		for dimensionName, dimensionValue := range dimensions {
+			if requestedName, exists := requestedNames[strings.ToLower(dimensionName)]; exists {
+				dimensionName = requestedName
+			}
+			labels[dimensionLabelName(dimensionName)] = dimensionValue
+		}
+		return
+	}
+
+	if len(dimensions) == 1 {
+		// we have only one dimension
+		// add one dimension="foobar" label (backward compatibility)
+		for _, dimensionValue := range dimensions {
+			labels["dimension"] = dimensionValue
+		}
+	} else if len(dimensions) >= 2 {
+		// we have multiple dimensions
+		// add each dimension as dimensionXzy="foobar" label
+		for dimensionName, dimensionValue := range dimensions {
+			labels[dimensionLabelName(dimensionName)] = dimensionValue
+		}
+	}
+}
+
+// sendTimeseriesData sends the datapoints of a timeseries, requested aggregations without datapoints
+// are handled as missing (see parameter missingDatapoints)
+func (r *AzureInsightBaseMetricsResult) sendTimeseriesData(channel chan<- PrometheusMetricResult, labels prometheus.Labels, data []*armmonitor.MetricValue, aggregations []string) {
+	latest := map[string]*PrometheusMetricResult{}
+	values := map[string][]float64{}
+
+	for _, timeseriesData := range data {
+		for _, aggregation := range []struct {
+			name  string
+			value *float64
+		}{
+			{"total", timeseriesData.Total},
+			{"minimum", timeseriesData.Minimum},
+			{"maximum", timeseriesData.Maximum},
+			{"average", timeseriesData.Average},
+			{"count", timeseriesData.Count},
+		} {
+			if aggregation.value != nil {
+				labels["aggregation"] = aggregation.name
+				metric := r.buildMetric(
+					labels,
+					*aggregation.value,
+					timeseriesData.TimeStamp,
+				)
+				latest[aggregation.name] = &metric
+				values[aggregation.name] = append(values[aggregation.name], *aggregation.value)
+				channel <- metric
+			}
+		}
+	}
+
+	r.sendPercentiles(channel, labels, latest, values)
+
+	if r.prober.settings.MissingDatapoints == MissingDatapointsOmit {
+		return
+	}
+
+	for _, aggregation := range aggregations {
+		aggregation = strings.ToLower(aggregation)
+		if aggregation == AggregationDefault {
+			continue
+		}
+		labels["aggregation"] = aggregation
+		r.sendMissingDatapoints(channel, labels, latest[aggregation])
+	}
+}
+
+// sendPercentiles sends the percentiles (parameter percentile) of the datapoints of each aggregation as quantile series,
+// Azure Monitor doesn't provide percentiles so they are calculated from the datapoints of the timespan (eg. PT1M interval, PT1H timespan)
+func (r *AzureInsightBaseMetricsResult) sendPercentiles(channel chan<- PrometheusMetricResult, labels prometheus.Labels, latest map[string]*PrometheusMetricResult, values map[string][]float64) {
+	// percentiles are quantiles of the summary with datapoints=summary
+	if len(r.prober.settings.Percentiles) == 0 || r.prober.settings.Datapoints == DatapointsSummary {
+		return
+	}
+
+	for aggregation, aggregationValues := range values {
+		sort.Float64s(aggregationValues)
+
+		labels["aggregation"] = aggregation
+		for _, percentile := range r.prober.settings.Percentiles {
+			labels["quantile"] = strconv.FormatFloat(percentile/100, 'f', -1, 64)
+			channel <- r.buildMetric(labels, percentileOfSorted(aggregationValues, percentile), latest[aggregation].Timestamp)
+		}
+	}
+	delete(labels, "quantile")
+}
+
+// percentileOfSorted returns the percentile (0-100) of the sorted values, interpolated between the closest ranks
+func percentileOfSorted(values []float64, percentile float64) float64 {
+	rank := percentile / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+func dimensionLabelName(dimensionName string) string {
+	labelName := "dimension" + stringsCommon.UppercaseFirst(dimensionName)
+	return metricLabelNotAllowedChars.ReplaceAllString(labelName, "")
+}
+
+func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, value float64, timestamp *time.Time) (metric PrometheusMetricResult) {
 	// copy map to ensure we don't keep references
 	metricLabels := prometheus.Labels{}
 	for labelName, labelValue := range labels {
@@ -20,9 +141,10 @@ func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, va
 	}
 
 	metric = PrometheusMetricResult{
-		Name:   r.prober.settings.MetricTemplate,
-		Labels: metricLabels,
-		Value:  value,
+		Name:      r.prober.settings.MetricTemplate,
+		Labels:    metricLabels,
+		Value:     value,
+		Timestamp: timestamp,
 	}
 
 	// fallback if template is empty (should not be)
@@ -38,7 +160,13 @@ func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, va
 
 	// set help
 	metric.Help = r.prober.settings.HelpTemplate
-	if metricNamePlaceholders.MatchString(metric.Help) {
+	if tmpl := r.prober.settings.HelpGoTemplate; tmpl != nil {
+		if val, err := tmpl.Execute(r.prober.settings.Name, resourceType, metric.Labels); err == nil {
+			metric.Help = val
+		} else {
+			r.prober.logger.Warnf("unable to render help template: %v", err)
+		}
+	} else if metricNamePlaceholders.MatchString(metric.Help) {
 		metric.Help = metricNamePlaceholders.ReplaceAllStringFunc(
 			metric.Help,
 			func(fieldName string) string {
@@ -58,7 +186,16 @@ func (r *AzureInsightBaseMetricsResult) buildMetric(labels prometheus.Labels, va
 		)
 	}
 
-	if metricNamePlaceholders.MatchString(metric.Name) {
+	if tmpl := r.prober.settings.MetricGoTemplate; tmpl != nil {
+		if val, err := tmpl.Execute(r.prober.settings.Name, resourceType, metric.Labels); err == nil {
+			metric.Name = val
+		} else {
+			r.prober.logger.Warnf("unable to render metric template: %v", err)
+			metric.Name = r.prober.settings.Name
+		}
+		// remove labels, when we add them to metric name
+		tmpl.RemoveLabels(metric.Labels)
+	} else if metricNamePlaceholders.MatchString(metric.Name) {
 		metric.Name = metricNamePlaceholders.ReplaceAllStringFunc(
 			metric.Name,
 			func(fieldName string) string {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,20 +20,26 @@ var (
 
 type (
 	PrometheusMetricResult struct {
-		Name   string
-		Labels prometheus.Labels
-		Value  float64
-		Help   string
+		Name      string
+		Labels    prometheus.Labels
+		Value     float64
+		Timestamp *time.Time
+		Help      string
+
+		// timeseries without datapoints (exported as NaN)
+		Missing bool
 	}
 )
 
 func (p *MetricProber) MetricsClient(subscriptionId string) (*armmonitor.MetricsClient, error) {
-	clientOpts := p.AzureClient.NewArmClientOptions()
+	clientOpts := NewArmClientOptions(p.AzureClient)
 	clientOpts.PerCallPolicies = append(
 		clientOpts.PerCallPolicies,
+		apiVersionPolicy{},
 		noCachePolicy{},
 	)
-	return armmonitor.NewMetricsClient(subscriptionId, p.AzureClient.GetCred(), clientOpts)
+	p.setMonitorRetryOptions(&clientOpts.ClientOptions)
+	return armmonitor.NewMetricsClient(subscriptionId, p.GetCred(), clientOpts)
 }
 
 func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient, target MetricProbeTarget, metrics, aggregations []string) (AzureInsightMetricsResult, error) {
@@ -43,11 +50,24 @@ func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient,
 		target: &target,
 	}
 
+	metricNamespace := p.targetMetricNamespace(target)
+	interval, timespan := p.resolveTimeGrain(target.ResourceId, metricNamespace, metrics)
+	ret.interval = interval
+	ret.timespan = timespan
+
+	if p.settings.DryRun {
+		result, err := p.dryRunMetrics(interval, timespan, metrics, aggregations)
+		if err == nil {
+			ret.Result = &result
+		}
+		return ret, err
+	}
+
 	resultType := armmonitor.ResultTypeData
 	opts := armmonitor.MetricsClientListOptions{
-		Interval:            p.settings.Interval,
+		Interval:            interval,
 		ResultType:          &resultType,
-		Timespan:            to.StringPtr(p.settings.Timespan),
+		Timespan:            to.StringPtr(p.requestTimespan(ResourceTypeFromResourceId(target.ResourceId), interval, timespan)),
 		Metricnames:         to.StringPtr(strings.Join(metrics, ",")),
 		Top:                 p.settings.MetricTop,
 		AutoAdjustTimegrain: to.BoolPtr(true),
@@ -62,25 +82,17 @@ func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient,
 		opts.Filter = to.StringPtr(p.settings.MetricFilter)
 	}
 
-	if len(p.settings.MetricNamespace) >= 1 {
-		opts.Metricnamespace = to.StringPtr(p.settings.MetricNamespace)
+	if len(metricNamespace) >= 1 {
+		opts.Metricnamespace = to.StringPtr(metricNamespace)
 	}
 
 	if len(p.settings.MetricOrderBy) >= 1 {
 		opts.Orderby = to.StringPtr(p.settings.MetricOrderBy)
 	}
 
-	resourceURI := target.ResourceId
-	if strings.HasPrefix(strings.ToLower(p.settings.MetricNamespace), "microsoft.storage/storageaccounts/") {
-		splitNamespace := strings.Split(p.settings.MetricNamespace, "/")
-		// Storage accounts have an extra requirement that their ResourceURI include <type>/default
-		storageAccountType := splitNamespace[len(splitNamespace)-1]
-		resourceURI = resourceURI + fmt.Sprintf("/%s/default", storageAccountType)
-	}
-
 	result, err := client.List(
-		p.ctx,
-		resourceURI,
+		withApiVersion(p.ctx, p.settings.MetricsApiVersionFor(ResourceTypeFromResourceId(target.ResourceId))),
+		metricResourceUri(target.ResourceId, metricNamespace),
 		&opts,
 	)
 
@@ -90,3 +102,14 @@ func (p *MetricProber) FetchMetricsFromTarget(client *armmonitor.MetricsClient,
 
 	return ret, err
 }
+
+// metricResourceUri returns the resource uri for metric requests of the metric namespace
+func metricResourceUri(resourceId, metricNamespace string) string {
+	if strings.HasPrefix(strings.ToLower(metricNamespace), "microsoft.storage/storageaccounts/") {
+		splitNamespace := strings.Split(metricNamespace, "/")
+		// Storage accounts have an extra requirement that their ResourceURI include <type>/default
+		storageAccountType := splitNamespace[len(splitNamespace)-1]
+		return resourceId + fmt.Sprintf("/%s/default", storageAccountType)
+	}
+	return resourceId
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	// value of the resourceID label
+	ResourceIdLabelFull  = "full"
+	ResourceIdLabelShort = "short"
+	ResourceIdLabelName  = "name"
+
+	// labels of the resource id with splitResourceId=true
+	ResourceProviderLabel = "resourceProvider"
+	ResourceTypeLabel     = "resourceType"
+)
+
+var (
+	ResourceIdLabelModes = []string{ResourceIdLabelFull, ResourceIdLabelShort, ResourceIdLabelName}
+
+	// labels set by the exporter (metric, aggregation and timespan), not lowercased by lowercaseLabels
+	normalizeLabelsKeepCase = map[string]bool{
+		"metric":      true,
+		"unit":        true,
+		"interval":    true,
+		"timespan":    true,
+		"aggregation": true,
+		"quantile":    true,
+	}
+)
+
+// normalizeLabels applies the label normalization of the probe to the metric list: the resourceID label is
+// shortened (parameter resourceIdLabel), the resource id is split into resourceProvider and resourceType labels
+// (splitResourceId) and the values of resource, subscription, tag and dimension labels are lowercased (lowercaseLabels)
+func (p *MetricProber) normalizeLabels() {
+	if p.metricList == nil {
+		return
+	}
+
+	fullResourceId := p.settings.ResourceIdLabel == "" || p.settings.ResourceIdLabel == ResourceIdLabelFull
+	if fullResourceId && !p.settings.SplitResourceId && !p.settings.LowercaseLabels {
+		return
+	}
+
+	for _, metricName := range p.metricList.GetMetricNames() {
+		for _, row := range p.metricList.GetMetricList(metricName) {
+			if resourceId, exists := row.Labels["resourceID"]; exists {
+				azureResource, _ := armclient.ParseResourceId(resourceId)
+
+				if p.settings.SplitResourceId {
+					// consistent label set, also for series without (parsable) resource id
+					row.Labels[ResourceProviderLabel] = ""
+					row.Labels[ResourceTypeLabel] = ""
+					if azureResource != nil {
+						row.Labels[ResourceProviderLabel] = azureResource.ResourceProviderNamespace
+						row.Labels[ResourceTypeLabel] = azureResource.ResourceProviderName
+					}
+				}
+
+				row.Labels["resourceID"] = shortResourceId(resourceId, azureResource, p.settings.ResourceIdLabel)
+			}
+
+			if p.settings.LowercaseLabels {
+				for labelName, labelValue := range row.Labels {
+					if !normalizeLabelsKeepCase[labelName] {
+						row.Labels[labelName] = strings.ToLower(labelValue)
+					}
+				}
+			}
+		}
+	}
+}
+
+// shortResourceId returns the value of the resourceID label: the resource id without subscription and resource
+// group prefix (short) or the resource name (name), unparsable resource ids are kept
+func shortResourceId(resourceId string, azureResource *armclient.AzureResourceInfo, mode string) string {
+	if azureResource == nil || azureResource.ResourceName == "" {
+		return resourceId
+	}
+
+	switch mode {
+	case ResourceIdLabelShort:
+		ret := azureResource.ResourceType + "/" + azureResource.ResourceName
+		if azureResource.ResourceSubPath != "" {
+			ret += "/" + strings.ToLower(azureResource.ResourceSubPath)
+		}
+		return ret
+	case ResourceIdLabelName:
+		return azureResource.ResourceName
+	default:
+		return resourceId
+	}
+}
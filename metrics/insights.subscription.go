@@ -4,10 +4,8 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	stringsCommon "github.com/webdevops/go-common/strings"
 	"github.com/webdevops/go-common/utils/to"
 )
 
@@ -15,7 +13,7 @@ type (
 	AzureInsightSubscriptionMetricsResult struct {
 		AzureInsightBaseMetricsResult
 
-		subscription *armsubscriptions.Subscription
+		subscription DiscoveredSubscription
 		Result       *armmonitor.MetricsClientListAtSubscriptionScopeResponse
 	}
 )
@@ -28,7 +26,7 @@ func (r *AzureInsightSubscriptionMetricsResult) SendMetricToChannel(channel chan
 
 		for _, metric := range r.Result.Value {
 			if metric.Timeseries != nil {
-				for _, timeseries := range metric.Timeseries {
+				for _, timeseries := range r.prober.capTimeseries(to.String(metric.Name.Value), metric.Timeseries, "microsoft.resourceid") {
 					if timeseries.Data != nil {
 						// get dimension name (optional)
 						dimensions := map[string]string{}
@@ -60,7 +58,7 @@ func (r *AzureInsightSubscriptionMetricsResult) SendMetricToChannel(channel chan
 						metricLabels := prometheus.Labels{
 							"resourceID":       strings.ToLower(resourceId),
 							"subscriptionID":   azureResource.Subscription,
-							"subscriptionName": to.String(r.subscription.DisplayName),
+							"subscriptionName": r.subscription.Name,
 							"resourceGroup":    azureResource.ResourceGroup,
 							"resourceName":     azureResource.ResourceName,
 							"metric":           to.String(metric.Name.Value),
@@ -71,65 +69,11 @@ func (r *AzureInsightSubscriptionMetricsResult) SendMetricToChannel(channel chan
 						}
 
 						// add resource tags as labels
-						metricLabels = r.prober.AzureResourceTagManager.AddResourceTagsToPrometheusLabels(r.prober.ctx, metricLabels, resourceId)
+						metricLabels = r.prober.addResourceTagLabels(metricLabels, resourceId)
 
-						if len(dimensions) == 1 {
-							// we have only one dimension
-							// add one dimension="foobar" label (backward compatibility)
-							for _, dimensionValue := range dimensions {
-								metricLabels["dimension"] = dimensionValue
-							}
-						} else if len(dimensions) >= 2 {
-							// we have multiple dimensions
-							// add each dimension as dimensionXzy="foobar" label
-							for dimensionName, dimensionValue := range dimensions {
-								labelName := "dimension" + stringsCommon.UppercaseFirst(dimensionName)
-								labelName = metricLabelNotAllowedChars.ReplaceAllString(labelName, "")
-								metricLabels[labelName] = dimensionValue
-							}
-						}
-
-						for _, timeseriesData := range timeseries.Data {
-							if timeseriesData.Total != nil {
-								metricLabels["aggregation"] = "total"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Total,
-								)
-							}
-
-							if timeseriesData.Minimum != nil {
-								metricLabels["aggregation"] = "minimum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Minimum,
-								)
-							}
+						r.addDimensionLabels(metricLabels, dimensions)
 
-							if timeseriesData.Maximum != nil {
-								metricLabels["aggregation"] = "maximum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Maximum,
-								)
-							}
-
-							if timeseriesData.Average != nil {
-								metricLabels["aggregation"] = "average"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Average,
-								)
-							}
-
-							if timeseriesData.Count != nil {
-								metricLabels["aggregation"] = "count"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Count,
-								)
-							}
-						}
+						r.sendTimeseriesData(channel, metricLabels, timeseries.Data, r.prober.settings.Aggregations)
 					}
 				}
 			}
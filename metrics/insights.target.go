@@ -6,7 +6,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/azuresdk/armclient"
-	stringsCommon "github.com/webdevops/go-common/strings"
 	"github.com/webdevops/go-common/utils/to"
 )
 
@@ -14,8 +13,10 @@ type (
 	AzureInsightMetricsResult struct {
 		AzureInsightBaseMetricsResult
 
-		target *MetricProbeTarget
-		Result *armmonitor.MetricsClientListResponse
+		target   *MetricProbeTarget
+		interval *string
+		timespan string
+		Result   *armmonitor.MetricsClientListResponse
 	}
 )
 
@@ -27,7 +28,7 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 
 		for _, metric := range r.Result.Value {
 			if metric.Timeseries != nil {
-				for _, timeseries := range metric.Timeseries {
+				for _, timeseries := range r.prober.capTimeseries(to.String(metric.Name.Value), metric.Timeseries, "") {
 					if timeseries.Data != nil {
 						// get dimension name (optional)
 						dimensions := map[string]string{}
@@ -42,6 +43,10 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 						}
 
 						resourceId := r.target.ResourceId
+						if r.target.ParentResourceId != "" {
+							// sub-resource, exported with the parent resource and sub-resource labels
+							resourceId = r.target.ParentResourceId
+						}
 						azureResource, _ := armclient.ParseResourceId(resourceId)
 
 						metricUnit := ""
@@ -49,84 +54,29 @@ func (r *AzureInsightMetricsResult) SendMetricToChannel(channel chan<- Prometheu
 							metricUnit = string(*metric.Unit)
 						}
 
-						subscriptionName := ""
-						if subscription, err := r.prober.AzureClient.GetCachedSubscription(r.prober.ctx, azureResource.Subscription); err == nil && subscription != nil {
-							subscriptionName = to.String(subscription.DisplayName)
-						}
-
 						metricLabels := prometheus.Labels{
 							"resourceID":       strings.ToLower(resourceId),
 							"subscriptionID":   azureResource.Subscription,
-							"subscriptionName": subscriptionName,
+							"subscriptionName": r.prober.subscriptionName(azureResource.Subscription),
 							"resourceGroup":    azureResource.ResourceGroup,
 							"resourceName":     azureResource.ResourceName,
 							"metric":           to.String(metric.Name.Value),
 							"unit":             metricUnit,
-							"interval":         to.String(r.prober.settings.Interval),
-							"timespan":         r.prober.settings.Timespan,
+							"interval":         to.String(r.interval),
+							"timespan":         r.timespan,
 							"aggregation":      "",
 						}
 
-						// add resource tags as labels
-						metricLabels = r.prober.AzureResourceTagManager.AddResourceTagsToPrometheusLabels(r.prober.ctx, metricLabels, resourceId)
-
-						if len(dimensions) == 1 {
-							// we have only one dimension
-							// add one dimension="foobar" label (backward compatibility)
-							for _, dimensionValue := range dimensions {
-								metricLabels["dimension"] = dimensionValue
-							}
-						} else if len(dimensions) >= 2 {
-							// we have multiple dimensions
-							// add each dimension as dimensionXzy="foobar" label
-							for dimensionName, dimensionValue := range dimensions {
-								labelName := "dimension" + stringsCommon.UppercaseFirst(dimensionName)
-								labelName = metricLabelNotAllowedChars.ReplaceAllString(labelName, "")
-								metricLabels[labelName] = dimensionValue
-							}
+						for _, labelName := range r.prober.targetLabelNames() {
+							metricLabels[labelName] = r.target.Labels[labelName]
 						}
 
-						for _, timeseriesData := range timeseries.Data {
-							if timeseriesData.Total != nil {
-								metricLabels["aggregation"] = "total"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Total,
-								)
-							}
-
-							if timeseriesData.Minimum != nil {
-								metricLabels["aggregation"] = "minimum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Minimum,
-								)
-							}
-
-							if timeseriesData.Maximum != nil {
-								metricLabels["aggregation"] = "maximum"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Maximum,
-								)
-							}
+						// add resource tags as labels
+						metricLabels = r.prober.addResourceTagLabels(metricLabels, resourceId)
 
-							if timeseriesData.Average != nil {
-								metricLabels["aggregation"] = "average"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Average,
-								)
-							}
+						r.addDimensionLabels(metricLabels, dimensions)
 
-							if timeseriesData.Count != nil {
-								metricLabels["aggregation"] = "count"
-								channel <- r.buildMetric(
-									metricLabels,
-									*timeseriesData.Count,
-								)
-							}
-						}
+						r.sendTimeseriesData(channel, metricLabels, timeseries.Data, r.target.Aggregations)
 					}
 				}
 			}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// ARM changes appear in ResourceGraph with a delay, lists fetched shortly after the change are also invalidated
+	ResourceInvalidationDelay = 30 * time.Second
+
+	// invalidations are kept longer than any cached resource list
+	ResourceInvalidationTtl = 24 * time.Hour
+)
+
+type (
+	// ResourceInvalidations records resource changes per subscription (Event Grid webhook), cached resource lists
+	// and ResourceGraph results of the subscription synced before the change are fetched again
+	ResourceInvalidations struct {
+		cache Cache
+	}
+)
+
+func NewResourceInvalidations(backend Cache) *ResourceInvalidations {
+	i := ResourceInvalidations{}
+	i.cache = backend
+	return &i
+}
+
+// Invalidate marks the cached resource lists of the subscription as outdated
+func (i *ResourceInvalidations) Invalidate(subscriptionId string) {
+	if i == nil {
+		return
+	}
+
+	validFrom := time.Now().Add(ResourceInvalidationDelay)
+	i.cache.Set(i.cacheKey(subscriptionId), []byte(validFrom.Format(time.RFC3339Nano)), ResourceInvalidationTtl)
+}
+
+// Invalidated checks if any of the subscriptions was changed after the resource list was synced
+func (i *ResourceInvalidations) Invalidated(synced time.Time, subscriptionIds ...string) bool {
+	if i == nil {
+		return false
+	}
+
+	for _, subscriptionId := range subscriptionIds {
+		cacheData, ok := i.cache.Get(i.cacheKey(subscriptionId))
+		if !ok {
+			continue
+		}
+
+		validFrom, err := time.Parse(time.RFC3339Nano, string(cacheData))
+		if err == nil && synced.Before(validFrom) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *ResourceInvalidations) cacheKey(subscriptionId string) string {
+	return "subscription:" + strings.ToLower(subscriptionId)
+}
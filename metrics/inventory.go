@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// resource changes are recorded with a delay, the change detection overlaps the previous sync
+	ResourceInventoryChangeDelay = 5 * time.Minute
+
+	ResourceInventorySyncFull      = "full"
+	ResourceInventorySyncUnchanged = "unchanged"
+)
+
+var (
+	PrometheusInventorySyncs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_inventory_syncs",
+			Help: "Azure metrics exporter resource inventory syncs by type (full list or unchanged by change detection)",
+		},
+		[]string{
+			"type",
+		},
+	)
+)
+
+type (
+	// ResourceInventory caches the resource list per subscription and filter (list and scrape probes),
+	// expired lists are only fetched again if ResourceGraph resourcechanges reports changes (change detection)
+	ResourceInventory struct {
+		cache           Cache
+		fullRefresh     time.Duration
+		changeDetection bool
+		invalidations   *ResourceInvalidations
+	}
+
+	resourceInventoryEntry struct {
+		ResourceList []AzureResource `json:"resourceList"`
+
+		// last sync (full list or change detection)
+		Synced time.Time `json:"synced"`
+
+		// last full list
+		Listed time.Time `json:"listed"`
+	}
+
+	resourceInventoryListFunc    func(ctx context.Context) ([]AzureResource, error)
+	resourceInventoryChangesFunc func(ctx context.Context, since time.Time) (int64, error)
+)
+
+func NewResourceInventory(backend Cache, fullRefresh time.Duration, changeDetection bool) *ResourceInventory {
+	i := ResourceInventory{}
+	i.cache = backend
+	i.fullRefresh = fullRefresh
+	i.changeDetection = changeDetection
+	return &i
+}
+
+// SetInvalidations sets the resource changes (Event Grid webhook), lists synced before a change are listed again
+func (i *ResourceInventory) SetInvalidations(invalidations *ResourceInvalidations) {
+	i.invalidations = invalidations
+}
+
+// Get returns the cached resource list, expired lists are synced using change detection (if enabled) or listed again
+func (i *ResourceInventory) Get(ctx context.Context, logger *zap.SugaredLogger, cacheKey, subscriptionId string, refreshInterval time.Duration, list resourceInventoryListFunc, changes resourceInventoryChangesFunc) ([]AzureResource, error) {
+	entry, ok := i.load(cacheKey)
+	if ok && i.invalidations.Invalidated(entry.Synced, subscriptionId) {
+		logger.Debugf("resources of subscription changed since %s, listing resources", entry.Synced.Format(time.RFC3339))
+		ok = false
+	}
+	if ok {
+		if time.Since(entry.Synced) < refreshInterval {
+			PrometheusCacheRequests.WithLabelValues("servicediscovery", CacheResultHit).Inc()
+			logger.Debugf("using servicediscovery from cache")
+			return entry.ResourceList, nil
+		}
+
+		if i.changeDetection && time.Since(entry.Listed) < i.fullRefresh {
+			syncTime := time.Now()
+			changeCount, err := changes(ctx, entry.Synced.Add(-ResourceInventoryChangeDelay))
+			switch {
+			case err != nil:
+				logger.Warnf("resource change detection failed, listing resources: %v", err)
+			case changeCount == 0:
+				PrometheusCacheRequests.WithLabelValues("servicediscovery", CacheResultHit).Inc()
+				PrometheusInventorySyncs.WithLabelValues(ResourceInventorySyncUnchanged).Inc()
+				logger.Debugf("no resource changes since %s, using servicediscovery from cache", entry.Synced.Format(time.RFC3339))
+
+				entry.Synced = syncTime
+				i.save(cacheKey, entry, refreshInterval)
+				return entry.ResourceList, nil
+			default:
+				logger.Debugf("found %d resource changes since %s, listing resources", changeCount, entry.Synced.Format(time.RFC3339))
+			}
+		}
+	}
+
+	PrometheusCacheRequests.WithLabelValues("servicediscovery", CacheResultMiss).Inc()
+	syncTime := time.Now()
+	resourceList, err := list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	PrometheusInventorySyncs.WithLabelValues(ResourceInventorySyncFull).Inc()
+
+	i.save(
+		cacheKey,
+		resourceInventoryEntry{
+			ResourceList: resourceList,
+			Synced:       syncTime,
+			Listed:       syncTime,
+		},
+		refreshInterval,
+	)
+
+	return resourceList, nil
+}
+
+func (i *ResourceInventory) load(cacheKey string) (entry resourceInventoryEntry, status bool) {
+	if cacheData, ok := i.cache.Get(cacheKey); ok {
+		if err := json.Unmarshal(cacheData, &entry); err == nil {
+			status = true
+		}
+	}
+	return
+}
+
+func (i *ResourceInventory) save(cacheKey string, entry resourceInventoryEntry, refreshInterval time.Duration) {
+	// entries are kept until the next full refresh for change detection
+	ttl := refreshInterval
+	if i.changeDetection && i.fullRefresh > ttl {
+		ttl = i.fullRefresh
+	}
+
+	if cacheData, err := json.Marshal(entry); err == nil {
+		i.cache.Set(cacheKey, cacheData, ttl)
+	}
+}
@@ -0,0 +1,362 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	KeyVaultResourceType       = "Microsoft.KeyVault/vaults"
+	KeyVaultResourceApiVersion = "2023-07-01"
+	KeyVaultDataApiVersion     = "7.4"
+
+	KeyVaultObjectTypeSecret      = "secret"
+	KeyVaultObjectTypeKey         = "key"
+	KeyVaultObjectTypeCertificate = "certificate"
+)
+
+var (
+	KeyVaultObjectTypes = []string{KeyVaultObjectTypeSecret, KeyVaultObjectTypeKey, KeyVaultObjectTypeCertificate}
+)
+
+type (
+	// KeyVaultClient lists the vaults of subscriptions (resource manager) and the secrets, keys and certificates
+	// of the vaults (data plane, requires list permissions on the vault)
+	KeyVaultClient struct {
+		client   *arm.Client
+		pipeline runtime.Pipeline
+	}
+
+	KeyVaultSettings struct {
+		Subscriptions []string
+		Vaults        []string
+		ObjectTypes   []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	KeyVaultResult struct {
+		Vaults []KeyVaultVault `json:"vaults"`
+	}
+
+	KeyVaultVault struct {
+		ResourceId string           `json:"resourceId"`
+		Name       string           `json:"name"`
+		VaultUri   string           `json:"vaultUri"`
+		Objects    []KeyVaultObject `json:"objects"`
+		Error      string           `json:"error,omitempty"`
+	}
+
+	KeyVaultObject struct {
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Enabled   bool   `json:"enabled"`
+		Expires   *int64 `json:"expires,omitempty"`
+		NotBefore *int64 `json:"notBefore,omitempty"`
+	}
+
+	keyVaultResourceResponse struct {
+		Value []struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			Properties struct {
+				VaultUri string `json:"vaultUri"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+
+	keyVaultObjectResponse struct {
+		Value []struct {
+			ID         string `json:"id"`
+			Kid        string `json:"kid"`
+			Managed    bool   `json:"managed"`
+			Attributes struct {
+				Enabled   bool   `json:"enabled"`
+				Expires   *int64 `json:"exp"`
+				NotBefore *int64 `json:"nbf"`
+			} `json:"attributes"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewKeyVaultSettings(r *http.Request) (KeyVaultSettings, error) {
+	ret := KeyVaultSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param vault
+	if val, err := paramsGetList(params, "vault"); err == nil {
+		ret.Vaults = val
+	} else {
+		return ret, err
+	}
+
+	// param objectType
+	if val, err := paramsGetList(params, "objectType"); err == nil && len(val) > 0 {
+		for _, objectType := range val {
+			if !stringListContainsFold(KeyVaultObjectTypes, objectType) {
+				return ret, fmt.Errorf(`parameter "objectType" must be one of %s`, strings.Join(KeyVaultObjectTypes, ", "))
+			}
+			ret.ObjectTypes = append(ret.ObjectTypes, strings.ToLower(objectType))
+		}
+	} else if err != nil {
+		return ret, err
+	} else {
+		ret.ObjectTypes = KeyVaultObjectTypes
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewKeyVaultClient creates a client for the Key Vault resource manager api and data plane of the configured cloud
+func NewKeyVaultClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions, opts config.Opts) (*KeyVaultClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := runtime.NewPipeline(
+		"azure-metrics-exporter",
+		"",
+		runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{opts.Azure.KeyVault.Audience}, nil),
+			},
+		},
+		&clientOpts.ClientOptions,
+	)
+
+	return &KeyVaultClient{client: client, pipeline: pipeline}, nil
+}
+
+// ListVaults returns the key vaults of the subscription (filtered by vault name)
+func (c *KeyVaultClient) ListVaults(ctx context.Context, subscriptionId string, vaults []string) ([]KeyVaultVault, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.KeyVault/vaults?api-version=%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		resourceApiVersion(KeyVaultResourceType, KeyVaultResourceApiVersion),
+	)
+
+	ret := []KeyVaultVault{}
+	for endpoint != "" {
+		response := keyVaultResourceResponse{}
+		if err := c.get(ctx, c.client.Pipeline(), endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list key vaults of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			if len(vaults) > 0 && !stringListContainsFold(vaults, row.Name) {
+				continue
+			}
+
+			ret = append(ret, KeyVaultVault{
+				ResourceId: row.ID,
+				Name:       row.Name,
+				VaultUri:   row.Properties.VaultUri,
+			})
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+// ListObjects returns the secrets, keys and certificates (objectTypes) of the vault with their expiry,
+// secrets and keys backing certificates (managed) are skipped
+func (c *KeyVaultClient) ListObjects(ctx context.Context, vault KeyVaultVault, objectTypes []string) ([]KeyVaultObject, error) {
+	ret := []KeyVaultObject{}
+	for _, objectType := range objectTypes {
+		endpoint := fmt.Sprintf(
+			"%s/%ss?api-version=%s",
+			strings.TrimRight(vault.VaultUri, "/"),
+			objectType,
+			KeyVaultDataApiVersion,
+		)
+
+		for endpoint != "" {
+			response := keyVaultObjectResponse{}
+			if err := c.get(ctx, c.pipeline, endpoint, &response); err != nil {
+				return nil, fmt.Errorf(`unable to list %ss of key vault "%s": %w`, objectType, vault.Name, err)
+			}
+
+			for _, row := range response.Value {
+				if row.Managed {
+					continue
+				}
+
+				id := row.ID
+				if id == "" {
+					id = row.Kid
+				}
+
+				ret = append(ret, KeyVaultObject{
+					Type:      objectType,
+					Name:      id[strings.LastIndex(id, "/")+1:],
+					Enabled:   row.Attributes.Enabled,
+					Expires:   row.Attributes.Expires,
+					NotBefore: row.Attributes.NotBefore,
+				})
+			}
+
+			endpoint = response.NextLink
+		}
+	}
+
+	return ret, nil
+}
+
+func (c *KeyVaultClient) get(ctx context.Context, pipeline runtime.Pipeline, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := pipeline.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// PublishKeyVaultResult publishes the expiry of secrets, keys and certificates (azurerm_keyvault_*_expiry_timestamp_seconds)
+// and if the objects of the vault could be listed (azurerm_keyvault_up)
+func PublishKeyVaultResult(registry *prometheus.Registry, results map[string]*KeyVaultResult) {
+	vaultUp := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_keyvault_up",
+			Help: "Azure Key Vault objects could be listed (data plane access)",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceGroup",
+			"resourceID",
+			"vault",
+		},
+	)
+	registry.MustRegister(vaultUp)
+
+	objectLabels := []string{
+		"subscriptionID",
+		"resourceGroup",
+		"resourceID",
+		"vault",
+		"name",
+		"enabled",
+	}
+	objectExpiry := map[string]*prometheus.GaugeVec{}
+	objectNotBefore := map[string]*prometheus.GaugeVec{}
+	for _, objectType := range KeyVaultObjectTypes {
+		objectExpiry[objectType] = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("azurerm_keyvault_%s_expiry_timestamp_seconds", objectType),
+				Help: fmt.Sprintf("Azure Key Vault expiry time of %s", objectType),
+			},
+			objectLabels,
+		)
+		registry.MustRegister(objectExpiry[objectType])
+
+		objectNotBefore[objectType] = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: fmt.Sprintf("azurerm_keyvault_%s_notbefore_timestamp_seconds", objectType),
+				Help: fmt.Sprintf("Azure Key Vault activation time (not before) of %s", objectType),
+			},
+			objectLabels,
+		)
+		registry.MustRegister(objectNotBefore[objectType])
+	}
+
+	for subscriptionId, result := range results {
+		for _, vault := range result.Vaults {
+			resourceGroup := ""
+			if azureResource, err := armclient.ParseResourceId(vault.ResourceId); err == nil {
+				resourceGroup = azureResource.ResourceGroup
+			}
+
+			up := float64(1)
+			if vault.Error != "" {
+				up = 0
+			}
+			vaultUp.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"resourceGroup":  resourceGroup,
+				"resourceID":     strings.ToLower(vault.ResourceId),
+				"vault":          vault.Name,
+			}).Set(up)
+
+			for _, object := range vault.Objects {
+				labels := prometheus.Labels{
+					"subscriptionID": subscriptionId,
+					"resourceGroup":  resourceGroup,
+					"resourceID":     strings.ToLower(vault.ResourceId),
+					"vault":          vault.Name,
+					"name":           object.Name,
+					"enabled":        strconv.FormatBool(object.Enabled),
+				}
+
+				// objects without expiry (or activation) time are not exported
+				if object.Expires != nil {
+					objectExpiry[object.Type].With(labels).Set(float64(*object.Expires))
+				}
+				if object.NotBefore != nil {
+					objectNotBefore[object.Type].With(labels).Set(float64(*object.NotBefore))
+				}
+			}
+		}
+	}
+}
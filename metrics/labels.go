@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// probe parameter prefix for static labels (eg. label_environment=prod)
+	StaticLabelParamPrefix = "label_"
+)
+
+type (
+	// StaticLabels are added to every series of a probe response (--metrics.extra-label, label_<name> parameter)
+	StaticLabels map[string]string
+)
+
+// ParseStaticLabels parses static labels in format name=value
+func ParseStaticLabels(list []string) (StaticLabels, error) {
+	ret := StaticLabels{}
+	for _, val := range list {
+		name, value, found := strings.Cut(val, "=")
+		if !found {
+			return nil, fmt.Errorf(`invalid label "%s", expected name=value`, val)
+		}
+
+		if err := ret.Set(strings.TrimSpace(name), value); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Set sets the label, the label name is validated
+func (l StaticLabels) Set(name, value string) error {
+	if !model.LabelName(name).IsValid() {
+		return fmt.Errorf(`invalid label name "%s"`, name)
+	}
+	l[name] = value
+	return nil
+}
+
+// WithParams returns a copy of the labels extended (or overwritten) by the label_<name> parameters of the request
+func (l StaticLabels) WithParams(params url.Values) (StaticLabels, error) {
+	ret := StaticLabels{}
+	for name, value := range l {
+		ret[name] = value
+	}
+
+	for param := range params {
+		if !strings.HasPrefix(param, StaticLabelParamPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(param, StaticLabelParamPrefix)
+		if err := ret.Set(name, params.Get(param)); err != nil {
+			return nil, fmt.Errorf(`invalid parameter "%s": %w`, param, err)
+		}
+	}
+
+	return ret, nil
+}
+
+// Gatherer wraps the gatherer and adds the labels to every series, existing labels of series are kept
+func (l StaticLabels) Gatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	if len(l) == 0 {
+		return gatherer
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return families, err
+		}
+
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				existingLabels := map[string]bool{}
+				for _, label := range metric.Label {
+					existingLabels[label.GetName()] = true
+				}
+
+				for _, name := range names {
+					if existingLabels[name] {
+						continue
+					}
+
+					labelName, labelValue := name, l[name]
+					metric.Label = append(metric.Label, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+				}
+
+				sort.Slice(metric.Label, func(i, j int) bool {
+					return metric.Label[i].GetName() < metric.Label[j].GetName()
+				})
+			}
+		}
+
+		return families, nil
+	})
+}
@@ -0,0 +1,308 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	LogAnalyticsMetricNameDefault = "azure_loganalytics"
+)
+
+var (
+	logAnalyticsNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+type (
+	// LogAnalyticsClient runs KQL queries against Log Analytics workspaces (query api)
+	LogAnalyticsClient struct {
+		pipeline runtime.Pipeline
+		endpoint string
+	}
+
+	LogAnalyticsQueryResult struct {
+		Tables []LogAnalyticsTable `json:"tables"`
+	}
+
+	LogAnalyticsTable struct {
+		Name    string               `json:"name"`
+		Columns []LogAnalyticsColumn `json:"columns"`
+		Rows    [][]interface{}      `json:"rows"`
+	}
+
+	LogAnalyticsColumn struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	logAnalyticsQueryRequest struct {
+		Query    string `json:"query"`
+		Timespan string `json:"timespan,omitempty"`
+	}
+
+	LogAnalyticsQuerySettings struct {
+		Workspaces   []string
+		Query        string
+		Timespan     string
+		Name         string
+		ValueColumns []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	// LogAnalyticsMetricBuilder maps query result rows to gauges (numeric columns are values, other columns are labels)
+	LogAnalyticsMetricBuilder struct {
+		settings *LogAnalyticsQuerySettings
+		registry *prometheus.Registry
+		list     *MetricList
+	}
+)
+
+func NewLogAnalyticsQuerySettings(r *http.Request) (LogAnalyticsQuerySettings, error) {
+	ret := LogAnalyticsQuerySettings{}
+	params := r.URL.Query()
+
+	// param workspace
+	if val, err := paramsGetList(params, "workspace"); err == nil && len(val) > 0 {
+		ret.Workspaces = val
+	} else {
+		return ret, fmt.Errorf("parameter \"workspace\" is missing")
+	}
+
+	// param query
+	ret.Query = params.Get("query")
+	if ret.Query == "" {
+		return ret, fmt.Errorf("parameter \"query\" is missing")
+	}
+
+	// param timespan
+	ret.Timespan = paramsGetWithDefault(params, "timespan", "")
+
+	// param name
+	ret.Name = paramsGetWithDefault(params, "name", LogAnalyticsMetricNameDefault)
+
+	// param valueColumn
+	if val, err := paramsGetList(params, "valueColumn"); err == nil {
+		ret.ValueColumns = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewLogAnalyticsClient creates a client for the Log Analytics query api of the configured cloud
+func NewLogAnalyticsClient(cred azcore.TokenCredential, clientOpts policy.ClientOptions, opts config.Opts) *LogAnalyticsClient {
+	pipeline := runtime.NewPipeline(
+		"azure-metrics-exporter",
+		"",
+		runtime.PipelineOptions{
+			PerRetry: []policy.Policy{
+				runtime.NewBearerTokenPolicy(cred, []string{opts.Azure.LogAnalytics.Audience}, nil),
+			},
+		},
+		&clientOpts,
+	)
+
+	return &LogAnalyticsClient{
+		pipeline: pipeline,
+		endpoint: strings.TrimRight(opts.Azure.LogAnalytics.Endpoint, "/"),
+	}
+}
+
+// Query runs the KQL query against the workspace (workspace id, not resource id)
+func (c *LogAnalyticsClient) Query(ctx context.Context, workspaceId, query, timespan string) (*LogAnalyticsQueryResult, error) {
+	endpoint := fmt.Sprintf("%s/v1/workspaces/%s/query", c.endpoint, url.PathEscape(workspaceId))
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	if err := runtime.MarshalAsJSON(req, logAnalyticsQueryRequest{Query: query, Timespan: timespan}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	result := LogAnalyticsQueryResult{}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func NewLogAnalyticsMetricBuilder(settings *LogAnalyticsQuerySettings, registry *prometheus.Registry) *LogAnalyticsMetricBuilder {
+	builder := LogAnalyticsMetricBuilder{}
+	builder.settings = settings
+	builder.registry = registry
+	builder.list = NewMetricList()
+	return &builder
+}
+
+// AddResult adds the rows of the query result of the workspace as metrics, tables may have different label columns
+func (b *LogAnalyticsMetricBuilder) AddResult(workspaceId string, result *LogAnalyticsQueryResult) {
+	for _, table := range result.Tables {
+		valueColumns := map[int]string{}
+		labelColumns := map[int]string{}
+
+		// metric and label names which are already used (workspaceID label is added to all rows)
+		metricNames := map[string]bool{}
+		labelNames := map[string]bool{"workspaceID": true}
+		for i, column := range table.Columns {
+			columnName := logAnalyticsNameRegexp.ReplaceAllString(column.Name, "_")
+			if b.isValueColumn(column) {
+				valueColumns[i] = uniqueLogAnalyticsName(fmt.Sprintf("%s_%s", b.settings.Name, strings.ToLower(columnName)), metricNames)
+			} else {
+				labelColumns[i] = uniqueLogAnalyticsName(logAnalyticsLabelName(columnName), labelNames)
+			}
+		}
+
+		for _, row := range table.Rows {
+			labels := prometheus.Labels{"workspaceID": workspaceId}
+			for i, labelName := range labelColumns {
+				labels[labelName] = ""
+				if i < len(row) {
+					labels[labelName] = logAnalyticsValueToString(row[i])
+				}
+			}
+
+			for i, metricName := range valueColumns {
+				if i >= len(row) {
+					continue
+				}
+
+				value, ok := logAnalyticsValueToFloat(row[i])
+				if !ok {
+					continue
+				}
+
+				b.list.SetMetricHelp(metricName, "Azure Log Analytics query result")
+				b.list.Add(metricName, MetricRow{Labels: labels, Value: value})
+			}
+		}
+	}
+}
+
+// Publish registers the metrics of all added results in the registry
+func (b *LogAnalyticsMetricBuilder) Publish() error {
+	return b.list.Publish(b.registry)
+}
+
+func (b *LogAnalyticsMetricBuilder) isValueColumn(column LogAnalyticsColumn) bool {
+	if len(b.settings.ValueColumns) > 0 {
+		for _, valueColumn := range b.settings.ValueColumns {
+			if strings.EqualFold(valueColumn, column.Name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch strings.ToLower(column.Type) {
+	case "int", "long", "real", "decimal":
+		return true
+	}
+	return false
+}
+
+// logAnalyticsLabelName returns a valid label name of the sanitized column name, invalid names (eg. starting with
+// a digit) and reserved names (__ prefix) are prefixed with "column_"
+func logAnalyticsLabelName(columnName string) string {
+	if !model.LabelName(columnName).IsValid() || strings.HasPrefix(columnName, model.ReservedLabelPrefix) {
+		return "column_" + columnName
+	}
+	return columnName
+}
+
+// uniqueLogAnalyticsName returns the name with a numeric suffix if it's already used (eg. columns "a-b" and "a_b")
+// and marks it as used
+func uniqueLogAnalyticsName(name string, usedNames map[string]bool) string {
+	ret := name
+	for i := 2; usedNames[ret]; i++ {
+		ret = fmt.Sprintf("%s_%d", name, i)
+	}
+	usedNames[ret] = true
+	return ret
+}
+
+func logAnalyticsValueToFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		if val, err := strconv.ParseFloat(v, 64); err == nil {
+			return val, true
+		}
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func logAnalyticsValueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
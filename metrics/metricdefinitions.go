@@ -0,0 +1,474 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	iso8601 "github.com/channelmeter/iso8601duration"
+	"github.com/patrickmn/go-cache"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"github.com/webdevops/go-common/utils/to"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	IntervalAuto = "auto"
+
+	// aggregation=default requests the primary aggregation type of each metric
+	AggregationDefault = "default"
+)
+
+type (
+	// MetricDefinitionCache caches the metric definitions (metric names, aggregations, dimensions and time grains)
+	// per resource type and metric namespace
+	MetricDefinitionCache struct {
+		cache         *cache.Cache
+		cacheDuration time.Duration
+
+		// one lookup per resource type at a time, concurrent probes wait for its result (cache is not locked)
+		flight singleflight.Group
+	}
+
+	MetricDefinition struct {
+		Name               string   `json:"name"`
+		DisplayName        string   `json:"displayName"`
+		Namespace          string   `json:"namespace"`
+		Unit               string   `json:"unit"`
+		PrimaryAggregation string   `json:"primaryAggregation"`
+		Aggregations       []string `json:"aggregations"`
+		Dimensions         []string `json:"dimensions"`
+		TimeGrains         []string `json:"timeGrains"`
+	}
+
+	// metricAggregationGroup are metrics requested with the same aggregations
+	metricAggregationGroup struct {
+		Metrics      []string
+		Aggregations []string
+	}
+
+	MetricNamespace struct {
+		Name           string `json:"name"`
+		Classification string `json:"classification"`
+	}
+)
+
+func NewMetricDefinitionCache(cacheDuration time.Duration) *MetricDefinitionCache {
+	c := MetricDefinitionCache{}
+	c.cache = cache.New(1*time.Minute, 1*time.Minute)
+	c.cacheDuration = cacheDuration
+	return &c
+}
+
+// Get returns the metric definitions of the resource type of resourceId, the resource is used as sample for the resource type
+func (c *MetricDefinitionCache) Get(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, resourceId, metricNamespace string) ([]MetricDefinition, error) {
+	resource, err := armclient.ParseResourceId(resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := strings.ToLower(ResourceTypeFromResourceId(resourceId) + "|" + metricNamespace)
+
+	if val, ok := c.cache.Get(cacheKey); ok {
+		PrometheusCacheRequests.WithLabelValues("metricdefinitions", CacheResultHit).Inc()
+		return val.([]MetricDefinition), nil
+	}
+	PrometheusCacheRequests.WithLabelValues("metricdefinitions", CacheResultMiss).Inc()
+
+	result, err, _ := c.flight.Do(cacheKey, func() (interface{}, error) {
+		definitionList, err := listMetricDefinitions(ctx, cred, clientOpts, resource.Subscription, resourceId, metricNamespace)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.Set(cacheKey, definitionList, c.cacheDuration)
+		return definitionList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]MetricDefinition), nil
+}
+
+// listMetricDefinitions requests the metric definitions of the resource
+func listMetricDefinitions(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, subscriptionId, resourceId, metricNamespace string) ([]MetricDefinition, error) {
+	client, err := armmonitor.NewMetricDefinitionsClient(subscriptionId, cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := armmonitor.MetricDefinitionsClientListOptions{}
+	if metricNamespace != "" {
+		opts.Metricnamespace = to.StringPtr(metricNamespace)
+	}
+
+	definitionList := []MetricDefinition{}
+	pager := client.NewListPager(resourceId, &opts)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			// resource types without Azure Monitor metrics (eg. Arc-enabled servers) are cached without metrics,
+			// so probes don't request the definitions of every resource again
+			var responseErr *azcore.ResponseError
+			if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusBadRequest {
+				return []MetricDefinition{}, nil
+			}
+			return nil, fmt.Errorf(`unable to list metric definitions of "%s": %w`, resourceId, err)
+		}
+
+		for _, row := range result.Value {
+			definition := newMetricDefinition(row.Name, row.Namespace, row.Unit, row.PrimaryAggregationType, row.SupportedAggregationTypes, row.Dimensions, row.MetricAvailabilities)
+			definitionList = append(definitionList, definition)
+		}
+	}
+
+	return definitionList, nil
+}
+
+// GetAtSubscriptionScope returns the metric definitions of the metric namespace (resource type) in the region of the
+// subscription (subscription scoped metric requests)
+func (c *MetricDefinitionCache) GetAtSubscriptionScope(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, subscriptionId, region, metricNamespace string) ([]MetricDefinition, error) {
+	cacheKey := strings.ToLower("subscriptionscope|" + metricNamespace + "|" + region)
+
+	if val, ok := c.cache.Get(cacheKey); ok {
+		PrometheusCacheRequests.WithLabelValues("metricdefinitions", CacheResultHit).Inc()
+		return val.([]MetricDefinition), nil
+	}
+	PrometheusCacheRequests.WithLabelValues("metricdefinitions", CacheResultMiss).Inc()
+
+	result, err, _ := c.flight.Do(cacheKey, func() (interface{}, error) {
+		client, err := armmonitor.NewMetricDefinitionsClient(subscriptionId, cred, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		definitionList := []MetricDefinition{}
+		pager := client.NewListAtSubscriptionScopePager(region, &armmonitor.MetricDefinitionsClientListAtSubscriptionScopeOptions{
+			Metricnamespace: to.StringPtr(metricNamespace),
+		})
+		for pager.More() {
+			result, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf(`unable to list metric definitions of "%s" in region "%s": %w`, metricNamespace, region, err)
+			}
+
+			for _, row := range result.Value {
+				definition := newMetricDefinition(row.Name, row.Namespace, row.Unit, row.PrimaryAggregationType, row.SupportedAggregationTypes, row.Dimensions, row.MetricAvailabilities)
+				definitionList = append(definitionList, definition)
+			}
+		}
+
+		c.cache.Set(cacheKey, definitionList, c.cacheDuration)
+		return definitionList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]MetricDefinition), nil
+}
+
+// newMetricDefinition converts the metric definition of resource or subscription scoped definition responses
+// (aggregation types differ between both)
+func newMetricDefinition[T armmonitor.AggregationType | armmonitor.MetricAggregationType](name *armmonitor.LocalizableString, namespace *string, unit *armmonitor.MetricUnit, primaryAggregation *T, aggregations []*T, dimensions []*armmonitor.LocalizableString, availabilities []*armmonitor.MetricAvailability) MetricDefinition {
+	definition := MetricDefinition{
+		Namespace: to.String(namespace),
+	}
+	if name != nil {
+		definition.Name = to.String(name.Value)
+		definition.DisplayName = to.String(name.LocalizedValue)
+	}
+	if unit != nil {
+		definition.Unit = string(*unit)
+	}
+	if primaryAggregation != nil {
+		definition.PrimaryAggregation = strings.ToLower(string(*primaryAggregation))
+	}
+	for _, aggregation := range aggregations {
+		if aggregation != nil {
+			definition.Aggregations = append(definition.Aggregations, strings.ToLower(string(*aggregation)))
+		}
+	}
+	for _, dimension := range dimensions {
+		if dimension != nil {
+			definition.Dimensions = append(definition.Dimensions, to.String(dimension.Value))
+		}
+	}
+	for _, availability := range availabilities {
+		if availability != nil && availability.TimeGrain != nil {
+			definition.TimeGrains = append(definition.TimeGrains, *availability.TimeGrain)
+		}
+	}
+	return definition
+}
+
+// GetNamespaces returns the metric namespaces (eg. storage sub-services or custom metrics) of the resource type of resourceId
+func (c *MetricDefinitionCache) GetNamespaces(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, resourceId string) ([]MetricNamespace, error) {
+	cacheKey := strings.ToLower("namespaces|" + ResourceTypeFromResourceId(resourceId))
+
+	if val, ok := c.cache.Get(cacheKey); ok {
+		PrometheusCacheRequests.WithLabelValues("metricnamespaces", CacheResultHit).Inc()
+		return val.([]MetricNamespace), nil
+	}
+	PrometheusCacheRequests.WithLabelValues("metricnamespaces", CacheResultMiss).Inc()
+
+	result, err, _ := c.flight.Do(cacheKey, func() (interface{}, error) {
+		namespaceList, err := listMetricNamespaces(ctx, cred, clientOpts, resourceId)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.Set(cacheKey, namespaceList, c.cacheDuration)
+		return namespaceList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]MetricNamespace), nil
+}
+
+// listMetricNamespaces requests the metric namespaces of the resource (sorted by name)
+func listMetricNamespaces(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, resourceId string) ([]MetricNamespace, error) {
+	client, err := armmonitor.NewMetricNamespacesClient(cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceList := []MetricNamespace{}
+	pager := client.NewListPager(resourceId, nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(`unable to list metric namespaces of "%s": %w`, resourceId, err)
+		}
+
+		for _, row := range result.Value {
+			if row.Properties == nil || row.Properties.MetricNamespaceName == nil {
+				continue
+			}
+
+			namespace := MetricNamespace{
+				Name: to.String(row.Properties.MetricNamespaceName),
+			}
+			if row.Classification != nil {
+				namespace.Classification = strings.ToLower(string(*row.Classification))
+			}
+			namespaceList = append(namespaceList, namespace)
+		}
+	}
+
+	sort.Slice(namespaceList, func(i, j int) bool {
+		return namespaceList[i].Name < namespaceList[j].Name
+	})
+
+	return namespaceList, nil
+}
+
+// selectTimeGrain returns the smallest time grain supported by all metrics, the requested interval is kept if supported
+func selectTimeGrain(definitionList []MetricDefinition, metrics []string, interval *string) (string, bool) {
+	definitionMap := map[string]MetricDefinition{}
+	for _, definition := range definitionList {
+		definitionMap[strings.ToLower(definition.Name)] = definition
+	}
+
+	var timeGrains map[string]time.Duration
+	for _, metric := range metrics {
+		definition, exists := definitionMap[strings.ToLower(metric)]
+		if !exists {
+			// unknown metric, let Azure decide
+			return "", false
+		}
+
+		metricTimeGrains := map[string]time.Duration{}
+		for _, timeGrain := range definition.TimeGrains {
+			if duration, err := iso8601.FromString(timeGrain); err == nil {
+				metricTimeGrains[strings.ToUpper(timeGrain)] = duration.ToDuration()
+			}
+		}
+
+		if timeGrains == nil {
+			timeGrains = metricTimeGrains
+			continue
+		}
+
+		// intersection of supported time grains
+		for timeGrain := range timeGrains {
+			if _, exists := metricTimeGrains[timeGrain]; !exists {
+				delete(timeGrains, timeGrain)
+			}
+		}
+	}
+
+	if len(timeGrains) == 0 {
+		return "", false
+	}
+
+	if interval != nil {
+		if _, exists := timeGrains[strings.ToUpper(*interval)]; exists {
+			return *interval, true
+		}
+	}
+
+	timeGrainList := make([]string, 0, len(timeGrains))
+	for timeGrain := range timeGrains {
+		timeGrainList = append(timeGrainList, timeGrain)
+	}
+	sort.Slice(timeGrainList, func(i, j int) bool {
+		return timeGrains[timeGrainList[i]] < timeGrains[timeGrainList[j]]
+	})
+
+	return timeGrainList[0], true
+}
+
+// resolveTimeGrain returns interval and timespan for the metric request, for interval=auto or unsupported intervals
+// a supported time grain is selected using the metric definitions and the timespan is extended to the time grain
+func (p *MetricProber) resolveTimeGrain(resourceId, metricNamespace string, metrics []string) (*string, string) {
+	if p.metricDefinitionCache == nil || len(metrics) == 0 || (p.settings.Interval == nil && !p.settings.IntervalAuto) {
+		return p.settings.Interval, p.settings.Timespan
+	}
+
+	definitionList, err := p.metricDefinitionCache.Get(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), metricResourceUri(resourceId, metricNamespace), metricNamespace)
+	return p.timeGrainFromDefinitions(definitionList, err, metrics)
+}
+
+// resolveSubscriptionTimeGrain returns interval and timespan for subscription scoped metric requests of the region
+// (see resolveTimeGrain), using the metric definitions of the metric namespace in the region
+func (p *MetricProber) resolveSubscriptionTimeGrain(subscriptionId, region, metricNamespace string, metrics []string) (*string, string) {
+	if p.metricDefinitionCache == nil || len(metrics) == 0 || (p.settings.Interval == nil && !p.settings.IntervalAuto) {
+		return p.settings.Interval, p.settings.Timespan
+	}
+
+	definitionList, err := p.metricDefinitionCache.GetAtSubscriptionScope(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), subscriptionId, region, metricNamespace)
+	return p.timeGrainFromDefinitions(definitionList, err, metrics)
+}
+
+// timeGrainFromDefinitions selects the time grain supported by all metrics and extends the timespan to the time grain
+func (p *MetricProber) timeGrainFromDefinitions(definitionList []MetricDefinition, err error, metrics []string) (*string, string) {
+	interval, timespan := p.settings.Interval, p.settings.Timespan
+	if err != nil {
+		p.logger.Warnf("unable to select interval: %v", err)
+		return interval, timespan
+	}
+
+	timeGrain, ok := selectTimeGrain(definitionList, metrics, interval)
+	if !ok {
+		return interval, timespan
+	}
+
+	if interval != nil && *interval != timeGrain {
+		p.logger.Debugf(`interval "%s" not supported by metrics, using "%s"`, *interval, timeGrain)
+	}
+	interval = &timeGrain
+
+	// timespan must contain at least one time grain, otherwise Azure returns empty series
+	if timespanDuration, err := iso8601.FromString(timespan); err == nil {
+		if timeGrainDuration, err := iso8601.FromString(timeGrain); err == nil && timespanDuration.ToDuration() < timeGrainDuration.ToDuration() {
+			timespan = timeGrain
+		}
+	}
+
+	return interval, timespan
+}
+
+// resolveAggregations checks the aggregations against the metric definitions and returns the metrics grouped by their
+// aggregations (one request per group), aggregation=default is replaced by the primary aggregation type of the metric,
+// unsupported aggregations are dropped (primary aggregation type if none is left) instead of returning no datapoints
+func (p *MetricProber) resolveAggregations(resourceId, metricNamespace string, metrics, aggregations []string) []metricAggregationGroup {
+	if p.metricDefinitionCache == nil || len(aggregations) == 0 || len(metrics) == 0 {
+		return []metricAggregationGroup{{Metrics: metrics, Aggregations: withoutDefaultAggregation(aggregations)}}
+	}
+
+	definitionList, err := p.metricDefinitionCache.Get(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), metricResourceUri(resourceId, metricNamespace), metricNamespace)
+	if err != nil {
+		p.logger.Warnf("unable to validate aggregations: %v", err)
+		return []metricAggregationGroup{{Metrics: metrics, Aggregations: withoutDefaultAggregation(aggregations)}}
+	}
+
+	definitionMap := map[string]MetricDefinition{}
+	for _, definition := range definitionList {
+		definitionMap[strings.ToLower(definition.Name)] = definition
+	}
+
+	groups := []metricAggregationGroup{}
+	groupIndex := map[string]int{}
+	for _, metric := range metrics {
+		metricAggregations := withoutDefaultAggregation(aggregations)
+		if definition, exists := definitionMap[strings.ToLower(metric)]; exists {
+			var unsupported []string
+			metricAggregations, unsupported = resolveMetricAggregations(definition, aggregations)
+			if len(unsupported) > 0 {
+				p.logger.Warnf(
+					`aggregation "%s" not supported by metric "%s" (supported: %s), using "%s"`,
+					strings.Join(unsupported, ","),
+					definition.Name,
+					strings.Join(definition.Aggregations, ","),
+					strings.Join(metricAggregations, ","),
+				)
+			}
+		}
+
+		key := strings.Join(metricAggregations, ",")
+		if num, exists := groupIndex[key]; exists {
+			groups[num].Metrics = append(groups[num].Metrics, metric)
+		} else {
+			groupIndex[key] = len(groups)
+			groups = append(groups, metricAggregationGroup{Metrics: []string{metric}, Aggregations: metricAggregations})
+		}
+	}
+
+	return groups
+}
+
+// resolveMetricAggregations returns the aggregations supported by the metric (default is the primary aggregation type)
+// and the unsupported aggregations, the primary aggregation type is used if no requested aggregation is supported
+func resolveMetricAggregations(definition MetricDefinition, aggregations []string) (ret []string, unsupported []string) {
+	for _, aggregation := range aggregations {
+		aggregation = strings.ToLower(aggregation)
+		if aggregation == AggregationDefault {
+			aggregation = definition.PrimaryAggregation
+			if aggregation == "" {
+				continue
+			}
+		}
+
+		if len(definition.Aggregations) > 0 && !stringListContainsFold(definition.Aggregations, aggregation) {
+			unsupported = append(unsupported, aggregation)
+			continue
+		}
+
+		if !stringListContainsFold(ret, aggregation) {
+			ret = append(ret, aggregation)
+		}
+	}
+
+	if len(ret) == 0 && definition.PrimaryAggregation != "" {
+		ret = []string{definition.PrimaryAggregation}
+	}
+
+	return ret, unsupported
+}
+
+// withoutDefaultAggregation removes aggregation=default (Azure Monitor returns the primary aggregation type without aggregations)
+func withoutDefaultAggregation(aggregations []string) []string {
+	ret := []string{}
+	for _, aggregation := range aggregations {
+		if !strings.EqualFold(aggregation, AggregationDefault) {
+			ret = append(ret, aggregation)
+		}
+	}
+	return ret
+}
+
+// withTargetAggregations returns copies of the targets with the aggregations
+func withTargetAggregations(targetList []MetricProbeTarget, aggregations []string) []MetricProbeTarget {
+	ret := make([]MetricProbeTarget, 0, len(targetList))
+	for _, target := range targetList {
+		target.Aggregations = aggregations
+		ret = append(ret, target)
+	}
+	return ret
+}
@@ -1,7 +1,14 @@
 package metrics
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const (
@@ -15,8 +22,12 @@ type (
 	}
 
 	MetricRow struct {
-		Labels prometheus.Labels
-		Value  float64
+		Labels    prometheus.Labels
+		Value     float64
+		Timestamp *time.Time
+
+		// timeseries without datapoints, value is NaN (not possible in json)
+		Missing bool
 	}
 )
 
@@ -73,3 +84,190 @@ func (l *MetricList) GetMetricLabelNames(name string) []string {
 
 	return list
 }
+
+// Publish registers the metrics of the list as gauges in the registry (registry of the probe request),
+// labels missing in rows are set empty so rows with differing label sets (eg. dimensions) never panic
+// on inconsistent label cardinality
+func (l *MetricList) Publish(registry prometheus.Registerer) error {
+	metricNames := l.GetMetricNames()
+	sort.Strings(metricNames)
+
+	for _, metricName := range metricNames {
+		labelNames := l.GetMetricLabelNames(metricName)
+		sort.Strings(labelNames)
+
+		gauge := prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metricName,
+				Help: l.GetMetricHelp(metricName),
+			},
+			labelNames,
+		)
+		if err := registry.Register(gauge); err != nil {
+			return fmt.Errorf(`unable to register metric "%s": %w`, metricName, err)
+		}
+
+		for _, row := range l.List[metricName] {
+			labelValues := make([]string, len(labelNames))
+			for i, labelName := range labelNames {
+				labelValues[i] = row.Labels[labelName]
+			}
+			gauge.WithLabelValues(labelValues...).Set(row.MetricValue())
+		}
+	}
+
+	return nil
+}
+
+// GetMetricFamily builds the gauge family of the metric with the timestamps of the datapoints,
+// only the latest datapoint of each series is used unless allDatapoints is set
+func (l *MetricList) GetMetricFamily(name string, allDatapoints bool) *dto.MetricFamily {
+	labelNames := l.GetMetricLabelNames(name)
+	sort.Strings(labelNames)
+
+	series := map[string][]MetricRow{}
+	seriesKeys := []string{}
+	for _, row := range l.List[name] {
+		labelValues := make([]string, len(labelNames))
+		for i, labelName := range labelNames {
+			labelValues[i] = row.Labels[labelName]
+		}
+		key := strings.Join(labelValues, "\xff")
+		if _, exists := series[key]; !exists {
+			seriesKeys = append(seriesKeys, key)
+		}
+		series[key] = append(series[key], row)
+	}
+	sort.Strings(seriesKeys)
+
+	help := l.GetMetricHelp(name)
+	family := &dto.MetricFamily{
+		Name: &name,
+		Help: &help,
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, key := range seriesKeys {
+		rows := series[key]
+		// datapoints in chronological order
+		sort.SliceStable(rows, func(i, j int) bool {
+			return metricRowTime(rows[i]).Before(metricRowTime(rows[j]))
+		})
+		if !allDatapoints {
+			rows = rows[len(rows)-1:]
+		}
+
+		for _, row := range rows {
+			value := row.MetricValue()
+			metric := &dto.Metric{
+				Gauge: &dto.Gauge{Value: &value},
+			}
+			for _, labelName := range labelNames {
+				labelName, labelValue := labelName, row.Labels[labelName]
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+			}
+			if row.Timestamp != nil {
+				timestampMs := row.Timestamp.UnixMilli()
+				metric.TimestampMs = &timestampMs
+			}
+			family.Metric = append(family.Metric, metric)
+		}
+	}
+
+	return family
+}
+
+// GetSummaryFamily builds the summary family of the metric from all datapoints of each series (count, sum and
+// quantiles, quantile 0 is the minimum and quantile 1 the maximum of the datapoints), missing datapoints are not counted,
+// the timestamp of the latest datapoint is attached with withTimestamps
+func (l *MetricList) GetSummaryFamily(name string, quantiles []float64, withTimestamps bool) *dto.MetricFamily {
+	labelNames := l.GetMetricLabelNames(name)
+	sort.Strings(labelNames)
+
+	series := map[string][]MetricRow{}
+	seriesKeys := []string{}
+	for _, row := range l.List[name] {
+		labelValues := make([]string, len(labelNames))
+		for i, labelName := range labelNames {
+			labelValues[i] = row.Labels[labelName]
+		}
+		key := strings.Join(labelValues, "\xff")
+		if _, exists := series[key]; !exists {
+			seriesKeys = append(seriesKeys, key)
+		}
+		series[key] = append(series[key], row)
+	}
+	sort.Strings(seriesKeys)
+
+	quantiles = append([]float64{0}, quantiles...)
+	quantiles = append(quantiles, 1)
+	sort.Float64s(quantiles)
+
+	help := l.GetMetricHelp(name)
+	family := &dto.MetricFamily{
+		Name: &name,
+		Help: &help,
+		Type: dto.MetricType_SUMMARY.Enum(),
+	}
+	for _, key := range seriesKeys {
+		rows := series[key]
+
+		values := []float64{}
+		sum := float64(0)
+		var latest *time.Time
+		for _, row := range rows {
+			if row.Missing {
+				continue
+			}
+			values = append(values, row.Value)
+			sum += row.Value
+			if row.Timestamp != nil && (latest == nil || row.Timestamp.After(*latest)) {
+				latest = row.Timestamp
+			}
+		}
+		sort.Float64s(values)
+
+		count := uint64(len(values))
+		summary := &dto.Summary{
+			SampleCount: &count,
+			SampleSum:   &sum,
+		}
+		for i, quantile := range quantiles {
+			if i > 0 && quantile == quantiles[i-1] {
+				continue
+			}
+			quantile, value := quantile, math.NaN()
+			if len(values) > 0 {
+				value = percentileOfSorted(values, quantile*100)
+			}
+			summary.Quantile = append(summary.Quantile, &dto.Quantile{Quantile: &quantile, Value: &value})
+		}
+
+		metric := &dto.Metric{Summary: summary}
+		for _, labelName := range labelNames {
+			labelName, labelValue := labelName, rows[0].Labels[labelName]
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+		}
+		if withTimestamps && latest != nil {
+			timestampMs := latest.UnixMilli()
+			metric.TimestampMs = &timestampMs
+		}
+		family.Metric = append(family.Metric, metric)
+	}
+
+	return family
+}
+
+// MetricValue returns the value of the row, NaN for missing datapoints
+func (r MetricRow) MetricValue() float64 {
+	if r.Missing {
+		return math.NaN()
+	}
+	return r.Value
+}
+
+func metricRowTime(row MetricRow) time.Time {
+	if row.Timestamp != nil {
+		return *row.Timestamp
+	}
+	return time.Time{}
+}
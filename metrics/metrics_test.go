@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestMetricListPublishConcurrent publishes the metric lists of parallel probes, each probe uses its own registry
+// (as probe requests do), series of other probes must never show up and rows with differing label sets must not panic
+func TestMetricListPublishConcurrent(t *testing.T) {
+	const probes = 32
+
+	errs := make(chan error, probes)
+	wg := sync.WaitGroup{}
+	for probe := 0; probe < probes; probe++ {
+		wg.Add(1)
+		go func(probe int) {
+			defer wg.Done()
+			errs <- publishAndVerifyProbe(probe)
+		}(probe)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func publishAndVerifyProbe(probe int) error {
+	resourceId := fmt.Sprintf("/subscriptions/sub/resourcegroups/rg/providers/microsoft.storage/storageaccounts/probe%d", probe)
+
+	list := NewMetricList()
+	list.SetMetricHelp("azurerm_test_metric", "test metric")
+	list.Add(
+		"azurerm_test_metric",
+		MetricRow{
+			Labels: prometheus.Labels{"resourceID": resourceId, "aggregation": "average"},
+			Value:  float64(probe),
+		},
+		// dimension only set on one row, label set differs
+		MetricRow{
+			Labels: prometheus.Labels{"resourceID": resourceId, "aggregation": "total", "dimensionApiName": "GetBlob"},
+			Value:  float64(probe) * 10,
+		},
+	)
+
+	registry := prometheus.NewRegistry()
+	if err := list.Publish(registry); err != nil {
+		return fmt.Errorf("probe %d: publish failed: %w", probe, err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return fmt.Errorf("probe %d: gather failed: %w", probe, err)
+	}
+
+	if len(families) != 1 {
+		return fmt.Errorf("probe %d: expected 1 metric family, got %d", probe, len(families))
+	}
+
+	series := families[0].GetMetric()
+	if len(series) != 2 {
+		return fmt.Errorf("probe %d: expected 2 series, got %d", probe, len(series))
+	}
+
+	for _, metric := range series {
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+
+		if labels["resourceID"] != resourceId {
+			return fmt.Errorf("probe %d: found series of other probe: %v", probe, labels)
+		}
+
+		expectedValue := float64(probe)
+		if labels["aggregation"] == "total" {
+			expectedValue = float64(probe) * 10
+			if labels["dimensionApiName"] != "GetBlob" {
+				return fmt.Errorf("probe %d: dimension label missing: %v", probe, labels)
+			}
+		} else if labels["dimensionApiName"] != "" {
+			return fmt.Errorf("probe %d: unexpected dimension label: %v", probe, labels)
+		}
+
+		if value := metric.GetGauge().GetValue(); value != expectedValue {
+			return fmt.Errorf("probe %d: expected value %v, got %v (%v)", probe, expectedValue, value, labels)
+		}
+	}
+
+	return nil
+}
@@ -3,7 +3,23 @@ package metrics
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	// prefix of structured dimension filter parameters (dimFilter.<dimension>=<value>,<value>)
+	DimensionFilterParamPrefix = "dimFilter."
+)
+
+var (
+	// tenant id (guid) or verified domain of the tenant (eg. contoso.onmicrosoft.com)
+	tenantIdRegexp     = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	tenantDomainRegexp = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
 )
 
 func paramsGetWithDefault(params url.Values, name, defaultValue string) (value string) {
@@ -14,6 +30,35 @@ func paramsGetWithDefault(params url.Values, name, defaultValue string) (value s
 	return
 }
 
+// ParamReportErrors returns the reportErrors parameter (defaults to --metrics.report-errors)
+func ParamReportErrors(params url.Values, opts config.Opts) (bool, error) {
+	val, err := strconv.ParseBool(paramsGetWithDefault(params, "reportErrors", strconv.FormatBool(opts.Metrics.ReportErrors)))
+	if err != nil {
+		return false, fmt.Errorf(`parameter "reportErrors" is invalid: %w`, err)
+	}
+	return val, nil
+}
+
+// paramsGetTenant returns the tenant parameter, tenant and named credential can't be combined
+func paramsGetTenant(params url.Values, credential string) (string, error) {
+	tenant := strings.ToLower(strings.TrimSpace(params.Get("tenant")))
+	if tenant != "" && credential != "" {
+		return "", fmt.Errorf(`parameters "tenant" and "credential" can't be combined`)
+	}
+	if tenant != "" && !IsValidTenant(tenant) {
+		return "", fmt.Errorf(`parameter "tenant" is invalid, expected tenant id or domain`)
+	}
+	return tenant, nil
+}
+
+// IsValidTenant checks if the tenant is a tenant id (guid) or a domain name (lowercase)
+func IsValidTenant(tenant string) bool {
+	if len(tenant) > 253 {
+		return false
+	}
+	return tenantIdRegexp.MatchString(tenant) || tenantDomainRegexp.MatchString(tenant)
+}
+
 func paramsGetList(params url.Values, name string) (list []string, err error) {
 	for _, v := range params[name] {
 		list = append(list, stringToStringList(v, ",")...)
@@ -21,15 +66,45 @@ func paramsGetList(params url.Values, name string) (list []string, err error) {
 	return
 }
 
-func paramsGetListRequired(params url.Values, name string) (list []string, err error) {
-	list, err = paramsGetList(params, name)
+// paramsDimensionFilter builds the metric filter expression of the dimFilter.<dimension> parameters,
+// values of a dimension are combined with or, dimensions with and (eg. "ApiName eq 'GetBlob' or ApiName eq 'PutBlob' and GeoType eq 'Primary'"),
+// the value * splits by the dimension
+func paramsDimensionFilter(params url.Values) (string, error) {
+	dimensions := []string{}
+	for name := range params {
+		if strings.HasPrefix(name, DimensionFilterParamPrefix) {
+			dimensions = append(dimensions, name)
+		}
+	}
+	sort.Strings(dimensions)
+
+	filterList := []string{}
+	for _, name := range dimensions {
+		dimension := strings.TrimPrefix(name, DimensionFilterParamPrefix)
+		if dimension == "" || strings.ContainsAny(dimension, "' \t") {
+			return "", fmt.Errorf(`parameter "%s" has an invalid dimension name`, name)
+		}
+
+		values, _ := paramsGetList(params, name)
+		values = uniqueStringList(values)
+		if len(values) == 0 {
+			return "", fmt.Errorf(`parameter "%s" has no value`, name)
+		}
 
-	if len(list) == 0 {
-		err = fmt.Errorf("parameter \"%v\" is missing", name)
-		return
+		expressions := []string{}
+		for _, value := range values {
+			if value == "*" {
+				// split by dimension, other values are included
+				expressions = []string{fmt.Sprintf("%s eq '*'", dimension)}
+				break
+			}
+			// quotes are escaped by doubling (OData)
+			expressions = append(expressions, fmt.Sprintf("%s eq '%s'", dimension, strings.ReplaceAll(value, "'", "''")))
+		}
+		filterList = append(filterList, strings.Join(expressions, " or "))
 	}
 
-	return
+	return strings.Join(filterList, " and "), nil
 }
 
 func stringToStringList(v string, sep string) (list []string) {
@@ -38,3 +113,41 @@ func stringToStringList(v string, sep string) (list []string) {
 	}
 	return
 }
+
+// uniqueStringList removes empty and duplicate (case-insensitive) values, the order is kept
+func uniqueStringList(list []string) (ret []string) {
+	seen := map[string]bool{}
+	for _, val := range list {
+		key := strings.ToLower(val)
+		if val == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, val)
+	}
+	return
+}
+
+// ResourceTypeFromResourceId returns the resource type (eg. Microsoft.Storage/storageAccounts) of a resource id
+func ResourceTypeFromResourceId(resourceId string) string {
+	parts := strings.Split(strings.Trim(resourceId, "/"), "/")
+
+	providerIndex := -1
+	for i, part := range parts {
+		if strings.EqualFold(part, "providers") {
+			providerIndex = i
+		}
+	}
+
+	if providerIndex < 0 || providerIndex+2 >= len(parts) {
+		return ""
+	}
+
+	// provider namespace followed by type/name pairs
+	resourceType := []string{parts[providerIndex+1]}
+	for i := providerIndex + 2; i+1 < len(parts); i += 2 {
+		resourceType = append(resourceType, parts[i])
+	}
+
+	return strings.Join(resourceType, "/")
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// handling of timeseries without datapoints (parameter missingDatapoints)
+	MissingDatapointsOmit  = "omit"
+	MissingDatapointsNaN   = "nan"
+	MissingDatapointsGauge = "gauge"
+	MissingDatapointsKeep  = "keep"
+
+	MissingDatapointsMetricSuffix = "_datapoints_missing"
+
+	// entries of series which are not requested anymore are removed after
+	datapointStoreTTL = 24 * time.Hour
+)
+
+var (
+	MissingDatapointsModes = []string{MissingDatapointsOmit, MissingDatapointsNaN, MissingDatapointsGauge, MissingDatapointsKeep}
+
+	// last values of series for missingDatapoints=keep
+	missingDatapointStore = newDatapointStore()
+)
+
+type (
+	datapointStore struct {
+		lock        sync.Mutex
+		entries     map[string]*datapointStoreEntry
+		lastCleanup time.Time
+	}
+
+	datapointStoreEntry struct {
+		Value     float64
+		Timestamp *time.Time
+		Missed    int
+		Updated   time.Time
+	}
+)
+
+func newDatapointStore() *datapointStore {
+	return &datapointStore{
+		entries:     map[string]*datapointStoreEntry{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// set stores the latest value of the series
+func (s *datapointStore) set(metric PrometheusMetricResult) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries[datapointStoreKey(metric)] = &datapointStoreEntry{
+		Value:     metric.Value,
+		Timestamp: metric.Timestamp,
+		Updated:   time.Now(),
+	}
+
+	if time.Since(s.lastCleanup) > time.Hour {
+		for key, entry := range s.entries {
+			if time.Since(entry.Updated) > datapointStoreTTL {
+				delete(s.entries, key)
+			}
+		}
+		s.lastCleanup = time.Now()
+	}
+}
+
+// keep returns the last value of the series if it was missing less than maxMissed times
+func (s *datapointStore) keep(metric PrometheusMetricResult, maxMissed int) (*datapointStoreEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := datapointStoreKey(metric)
+	entry, exists := s.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	entry.Missed++
+	if entry.Missed > maxMissed {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func datapointStoreKey(metric PrometheusMetricResult) string {
+	labelNames := make([]string, 0, len(metric.Labels))
+	for labelName := range metric.Labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	parts := []string{metric.Name}
+	for _, labelName := range labelNames {
+		parts = append(parts, labelName+"="+metric.Labels[labelName])
+	}
+	return strings.Join(parts, "\xff")
+}
+
+// sendMissingDatapoints handles aggregations of a timeseries with (missing=true) or without missing datapoints
+// depending on parameter missingDatapoints, latest contains the latest value of the aggregation
+func (r *AzureInsightBaseMetricsResult) sendMissingDatapoints(channel chan<- PrometheusMetricResult, labels prometheus.Labels, latest *PrometheusMetricResult) {
+	settings := r.prober.settings
+
+	switch settings.MissingDatapoints {
+	case MissingDatapointsNaN:
+		if latest == nil {
+			metric := r.buildMetric(labels, 0, nil)
+			metric.Missing = true
+			channel <- metric
+		}
+	case MissingDatapointsGauge:
+		metric := r.buildMetric(labels, 0, nil)
+		metric.Name += MissingDatapointsMetricSuffix
+		metric.Help = "Azure Monitor returned no datapoints for the timespan (1 = missing)"
+		if latest == nil {
+			metric.Value = 1
+		}
+		channel <- metric
+	case MissingDatapointsKeep:
+		if latest != nil {
+			missingDatapointStore.set(*latest)
+			return
+		}
+
+		metric := r.buildMetric(labels, 0, nil)
+		if entry, ok := missingDatapointStore.keep(metric, settings.MissingDatapointsKeep); ok {
+			metric.Value = entry.Value
+			metric.Timestamp = entry.Timestamp
+			channel <- metric
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	PolicyInsightsApiVersion = "2019-10-01"
+)
+
+var (
+	// PolicyComplianceStatePriority is the compliance state of resources with multiple policy states,
+	// eg. a resource non-compliant to one policy and compliant to others is non-compliant
+	PolicyComplianceStatePriority = []string{"noncompliant", "conflict", "unknown", "compliant", "exempt"}
+)
+
+type (
+	// PolicyClient fetches the compliance summaries of Azure Policy (Policy Insights)
+	PolicyClient struct {
+		client *arm.Client
+	}
+
+	PolicySettings struct {
+		Subscriptions []string
+
+		// policy states filter (OData $filter, eg. policyDefinitionAction eq 'deny')
+		Filter string
+
+		// number of resources per resource type
+		ResourceTypes bool
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	PolicyResult struct {
+		// resources per compliance state
+		Resources map[string]float64 `json:"resources"`
+
+		Assignments   []PolicyAssignmentCompliance   `json:"assignments"`
+		ResourceTypes []PolicyResourceTypeCompliance `json:"resourceTypes"`
+	}
+
+	PolicyAssignmentCompliance struct {
+		AssignmentId          string             `json:"assignmentId"`
+		PolicySetDefinitionId string             `json:"policySetDefinitionId"`
+		NonCompliantPolicies  float64            `json:"nonCompliantPolicies"`
+		Resources             map[string]float64 `json:"resources"`
+	}
+
+	PolicyResourceTypeCompliance struct {
+		ResourceType string             `json:"resourceType"`
+		Resources    map[string]float64 `json:"resources"`
+	}
+
+	policyResourceDetails []struct {
+		ComplianceState string  `json:"complianceState"`
+		Count           float64 `json:"count"`
+	}
+
+	policySummarizeResponse struct {
+		Value []struct {
+			Results struct {
+				ResourceDetails policyResourceDetails `json:"resourceDetails"`
+			} `json:"results"`
+			PolicyAssignments []struct {
+				PolicyAssignmentId    string `json:"policyAssignmentId"`
+				PolicySetDefinitionId string `json:"policySetDefinitionId"`
+				Results               struct {
+					NonCompliantPolicies float64               `json:"nonCompliantPolicies"`
+					ResourceDetails      policyResourceDetails `json:"resourceDetails"`
+				} `json:"results"`
+			} `json:"policyAssignments"`
+		} `json:"value"`
+	}
+
+	policyStatesResponse struct {
+		Value []struct {
+			ResourceId      string `json:"resourceId"`
+			ResourceType    string `json:"resourceType"`
+			ComplianceState string `json:"complianceState"`
+		} `json:"value"`
+		NextLink string `json:"@odata.nextLink"`
+	}
+)
+
+func NewPolicySettings(r *http.Request) (PolicySettings, error) {
+	ret := PolicySettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param filter
+	ret.Filter = paramsGetWithDefault(params, "filter", "")
+
+	// param resourceTypes
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "resourceTypes", "true")); err == nil {
+		ret.ResourceTypes = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewPolicyClient creates a client for the Policy Insights api of the configured cloud
+func NewPolicyClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*PolicyClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PolicyClient{client: client}, nil
+}
+
+// Summarize returns the compliance summary of the subscription (resources per compliance state in total and per
+// policy assignment) and, with resourceTypes, the compliance of the resources per resource type
+func (c *PolicyClient) Summarize(ctx context.Context, subscriptionId string, settings PolicySettings) (*PolicyResult, error) {
+	query := url.Values{}
+	query.Set("api-version", PolicyInsightsApiVersion)
+	if settings.Filter != "" {
+		query.Set("$filter", settings.Filter)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.PolicyInsights/policyStates/latest/summarize?%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		query.Encode(),
+	)
+
+	response := policySummarizeResponse{}
+	if err := c.post(ctx, endpoint, &response); err != nil {
+		return nil, fmt.Errorf(`unable to summarize policy states of subscription "%s": %w`, subscriptionId, err)
+	}
+
+	ret := &PolicyResult{
+		Resources:     map[string]float64{},
+		Assignments:   []PolicyAssignmentCompliance{},
+		ResourceTypes: []PolicyResourceTypeCompliance{},
+	}
+	for _, summary := range response.Value {
+		for _, row := range summary.Results.ResourceDetails {
+			ret.Resources[row.ComplianceState] += row.Count
+		}
+
+		for _, assignment := range summary.PolicyAssignments {
+			assignmentCompliance := PolicyAssignmentCompliance{
+				AssignmentId:          assignment.PolicyAssignmentId,
+				PolicySetDefinitionId: assignment.PolicySetDefinitionId,
+				NonCompliantPolicies:  assignment.Results.NonCompliantPolicies,
+				Resources:             map[string]float64{},
+			}
+			for _, row := range assignment.Results.ResourceDetails {
+				assignmentCompliance.Resources[row.ComplianceState] += row.Count
+			}
+			ret.Assignments = append(ret.Assignments, assignmentCompliance)
+		}
+	}
+
+	if settings.ResourceTypes {
+		resourceTypes, err := c.resourceTypeCompliance(ctx, subscriptionId, settings)
+		if err != nil {
+			return nil, err
+		}
+		ret.ResourceTypes = resourceTypes
+	}
+
+	return ret, nil
+}
+
+// resourceTypeCompliance returns the number of resources per resource type and compliance state, the compliance
+// state of resources with multiple policy states is chosen by PolicyComplianceStatePriority
+func (c *PolicyClient) resourceTypeCompliance(ctx context.Context, subscriptionId string, settings PolicySettings) ([]PolicyResourceTypeCompliance, error) {
+	query := url.Values{}
+	query.Set("api-version", PolicyInsightsApiVersion)
+	query.Set("$apply", "groupby((resourceId, resourceType, complianceState))")
+	if settings.Filter != "" {
+		query.Set("$filter", settings.Filter)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.PolicyInsights/policyStates/latest/queryResults?%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		query.Encode(),
+	)
+
+	resourceTypes := map[string]string{}
+	resourceStates := map[string]string{}
+	for endpoint != "" {
+		response := policyStatesResponse{}
+		if err := c.post(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to query policy states of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			resourceId := strings.ToLower(row.ResourceId)
+			resourceTypes[resourceId] = strings.ToLower(row.ResourceType)
+			if state, exists := resourceStates[resourceId]; !exists || policyComplianceStateRank(row.ComplianceState) < policyComplianceStateRank(state) {
+				resourceStates[resourceId] = row.ComplianceState
+			}
+		}
+
+		endpoint = response.NextLink
+	}
+
+	counts := map[string]map[string]float64{}
+	for resourceId, state := range resourceStates {
+		resourceType := resourceTypes[resourceId]
+		if _, exists := counts[resourceType]; !exists {
+			counts[resourceType] = map[string]float64{}
+		}
+		counts[resourceType][state]++
+	}
+
+	ret := []PolicyResourceTypeCompliance{}
+	for resourceType, resources := range counts {
+		ret = append(ret, PolicyResourceTypeCompliance{ResourceType: resourceType, Resources: resources})
+	}
+	return ret, nil
+}
+
+// policyComplianceStateRank returns the rank of the compliance state in PolicyComplianceStatePriority (lower wins)
+func policyComplianceStateRank(state string) int {
+	for rank, val := range PolicyComplianceStatePriority {
+		if strings.EqualFold(val, state) {
+			return rank
+		}
+	}
+	return len(PolicyComplianceStatePriority)
+}
+
+func (c *PolicyClient) post(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// PublishPolicyResult publishes the number of resources per compliance state of the subscription
+// (azurerm_policy_compliance_resources), per policy assignment and per resource type
+func PublishPolicyResult(registry *prometheus.Registry, results map[string]*PolicyResult) {
+	complianceResources := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_policy_compliance_resources",
+			Help: "Azure Policy number of resources per compliance state",
+		},
+		[]string{
+			"subscriptionID",
+			"complianceState",
+		},
+	)
+	registry.MustRegister(complianceResources)
+
+	assignmentResources := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_policy_assignment_compliance_resources",
+			Help: "Azure Policy number of resources per policy assignment and compliance state",
+		},
+		[]string{
+			"subscriptionID",
+			"policyAssignmentID",
+			"policyAssignmentName",
+			"policySetDefinitionID",
+			"complianceState",
+		},
+	)
+	registry.MustRegister(assignmentResources)
+
+	assignmentNonCompliantPolicies := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_policy_assignment_noncompliant_policies",
+			Help: "Azure Policy number of non-compliant policies of policy assignment",
+		},
+		[]string{
+			"subscriptionID",
+			"policyAssignmentID",
+			"policyAssignmentName",
+			"policySetDefinitionID",
+		},
+	)
+	registry.MustRegister(assignmentNonCompliantPolicies)
+
+	resourceTypeResources := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_policy_resourcetype_compliance_resources",
+			Help: "Azure Policy number of resources per resource type and compliance state",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceType",
+			"complianceState",
+		},
+	)
+	registry.MustRegister(resourceTypeResources)
+
+	for subscriptionId, result := range results {
+		for state, count := range result.Resources {
+			complianceResources.With(prometheus.Labels{
+				"subscriptionID":  subscriptionId,
+				"complianceState": strings.ToLower(state),
+			}).Set(count)
+		}
+
+		for _, assignment := range result.Assignments {
+			assignmentId := strings.ToLower(assignment.AssignmentId)
+			assignmentName := assignmentId[strings.LastIndex(assignmentId, "/")+1:]
+
+			assignmentNonCompliantPolicies.With(prometheus.Labels{
+				"subscriptionID":        subscriptionId,
+				"policyAssignmentID":    assignmentId,
+				"policyAssignmentName":  assignmentName,
+				"policySetDefinitionID": strings.ToLower(assignment.PolicySetDefinitionId),
+			}).Set(assignment.NonCompliantPolicies)
+
+			for state, count := range assignment.Resources {
+				assignmentResources.With(prometheus.Labels{
+					"subscriptionID":        subscriptionId,
+					"policyAssignmentID":    assignmentId,
+					"policyAssignmentName":  assignmentName,
+					"policySetDefinitionID": strings.ToLower(assignment.PolicySetDefinitionId),
+					"complianceState":       strings.ToLower(state),
+				}).Set(count)
+			}
+		}
+
+		for _, resourceType := range result.ResourceTypes {
+			for state, count := range resourceType.Resources {
+				resourceTypeResources.With(prometheus.Labels{
+					"subscriptionID":  subscriptionId,
+					"resourceType":    resourceType.ResourceType,
+					"complianceState": strings.ToLower(state),
+				}).Add(count)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// portalUrls maps the resource manager endpoint of the well-known clouds to the Azure portal
+	portalUrls = map[string]string{
+		"management.azure.com":         "https://portal.azure.com",
+		"management.usgovcloudapi.net": "https://portal.azure.us",
+		"management.chinacloudapi.cn":  "https://portal.azure.cn",
+	}
+)
+
+// PortalUrlFromResourceManager returns the Azure portal url of a well-known cloud by its resource manager endpoint
+func PortalUrlFromResourceManager(endpoint string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(endpoint), "https://"), "http://")
+	host = strings.TrimRight(host, "/")
+	return portalUrls[host]
+}
+
+// PortalResourceUrl returns the Azure portal deep-link to the resource blade
+func PortalResourceUrl(portalUrl, resourceId string) string {
+	return fmt.Sprintf("%s/#@/resource%s", strings.TrimRight(portalUrl, "/"), resourceId)
+}
+
+// publishPortalLinks publishes azurerm_resource_portal_info for all resources of the metric list
+func (p *MetricProber) publishPortalLinks() {
+	if !p.settings.PortalLinks || p.Conf.Azure.PortalUrl == "" {
+		return
+	}
+
+	portalInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_resource_portal_info",
+			Help: "Azure metrics exporter Azure portal link of resource",
+		},
+		[]string{
+			"resourceID",
+			"subscriptionID",
+			"url",
+		},
+	)
+	p.prometheus.registry.MustRegister(portalInfo)
+
+	for _, metricName := range p.metricList.GetMetricNames() {
+		for _, row := range p.metricList.GetMetricList(metricName) {
+			resourceId := row.Labels["resourceID"]
+			if resourceId == "" {
+				continue
+			}
+
+			portalInfo.With(prometheus.Labels{
+				"resourceID":     resourceId,
+				"subscriptionID": row.Labels["subscriptionID"],
+				"url":            PortalResourceUrl(p.Conf.Azure.PortalUrl, resourceId),
+			}).Set(1)
+		}
+	}
+}
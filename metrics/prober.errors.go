@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ProbeErrorCodeTimeout = "timeout"
+	ProbeErrorCodeUnknown = "error"
+)
+
+type (
+	// ProbeErrorList collects the failed requests of a probe (partial failures)
+	ProbeErrorList struct {
+		lock   sync.Mutex
+		errors map[string]probeError
+	}
+
+	probeError struct {
+		ResourceId string
+		Code       string
+	}
+)
+
+// reportError records a failed request for the resource (or subscription scope) of the probe
+func (p *MetricProber) reportError(resourceId string, err error) {
+	p.probeErrors.Report(resourceId, err)
+}
+
+// ErrorCount returns the number of failed requests (per resource and error code)
+func (p *MetricProber) ErrorCount() int {
+	return p.probeErrors.Count()
+}
+
+// publishProbeErrors publishes azurerm_probe_success and azurerm_resource_scrape_error if error reporting is enabled
+func (p *MetricProber) publishProbeErrors() {
+	if !p.settings.ReportErrors {
+		return
+	}
+	p.probeErrors.Publish(p.prometheus.registry, p.response)
+}
+
+// Report records a failed request for the resource (or subscription scope)
+func (l *ProbeErrorList) Report(resourceId string, err error) {
+	if err == nil {
+		return
+	}
+
+	entry := probeError{
+		ResourceId: strings.ToLower(resourceId),
+		Code:       probeErrorCode(err),
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.errors == nil {
+		l.errors = map[string]probeError{}
+	}
+	l.errors[fmt.Sprintf("%s:%s", entry.ResourceId, entry.Code)] = entry
+}
+
+// Count returns the number of failed requests (per resource and error code)
+func (l *ProbeErrorList) Count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.errors)
+}
+
+// Publish publishes azurerm_probe_success and azurerm_resource_scrape_error, the number of failed requests is
+// set as X-probe-errors header
+func (l *ProbeErrorList) Publish(registry *prometheus.Registry, w http.ResponseWriter) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	probeSuccess := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_probe_success",
+			Help: "Azure metrics exporter probe success (0 if at least one request failed)",
+		},
+	)
+	registry.MustRegister(probeSuccess)
+
+	scrapeError := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_resource_scrape_error",
+			Help: "Azure metrics exporter failed requests of probe by resource and error code",
+		},
+		[]string{
+			"resourceID",
+			"code",
+		},
+	)
+	registry.MustRegister(scrapeError)
+
+	keys := make([]string, 0, len(l.errors))
+	for key := range l.errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := l.errors[key]
+		scrapeError.WithLabelValues(entry.ResourceId, entry.Code).Set(1)
+	}
+
+	if len(keys) == 0 {
+		probeSuccess.Set(1)
+	} else {
+		probeSuccess.Set(0)
+		w.Header().Add("X-probe-errors", strconv.Itoa(len(keys)))
+	}
+}
+
+// probeErrorCode returns the http status code of Azure errors (eg. 429, 403), "timeout" or "error"
+func probeErrorCode(err error) string {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return strconv.Itoa(responseErr.StatusCode)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ProbeErrorCodeTimeout
+	}
+
+	return ProbeErrorCodeUnknown
+}
+
+// AzureErrorStatusCode returns the http status code of probe responses for failed Azure requests: 503 for timeouts and
+// throttled requests (429), 502 for other errors of Azure
+func AzureErrorStatusCode(err error) int {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusTooManyRequests {
+		return http.StatusServiceUnavailable
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusBadGateway
+}
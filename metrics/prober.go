@@ -2,16 +2,19 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
-	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/patrickmn/go-cache"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/remeh/sizedwaitgroup"
 	"github.com/webdevops/go-common/azuresdk/armclient"
 	"github.com/webdevops/go-common/utils/to"
@@ -31,6 +34,8 @@ type (
 		AzureClient             *armclient.ArmClient
 		AzureResourceTagManager *armclient.ResourceTagManager
 
+		cred azcore.TokenCredential
+
 		userAgent string
 
 		settings *RequestMetricSettings
@@ -42,34 +47,81 @@ type (
 		logger *zap.SugaredLogger
 
 		metricsCache struct {
-			cache         *cache.Cache
+			cache         Cache
 			cacheKey      *string
 			cacheDuration *time.Duration
 		}
 
-		serviceDiscoveryCache struct {
-			cache         *cache.Cache
-			cacheDuration *time.Duration
+		resourceInventory struct {
+			inventory       *ResourceInventory
+			refreshInterval time.Duration
+		}
+
+		resourceGraphCache struct {
+			cache         *ResourceGraphCache
+			cacheDuration time.Duration
+		}
+
+		metricDefinitionCache *MetricDefinitionCache
+		resourceTagCache      *ResourceTagCache
+		subscriptionDiscovery *SubscriptionDiscovery
+
+		// subscriptions visible to the credential of the probe (subscription names), listed once per probe
+		subscriptionList struct {
+			once          sync.Once
+			subscriptions map[string]DiscoveredSubscription
+			err           error
 		}
 
-		targets map[string][]MetricProbeTarget
+		workerPool          *WorkerPool
+		rateLimiter         *RateLimiter
+		quotaCoordinator    *QuotaCoordinator
+		restrictions        *ProbeRestrictions
+		metricsBatchClients *MetricsBatchClients
+
+		targets     map[string][]MetricProbeTarget
+		targetsLock sync.Mutex
+
+		// per subscription start time and subscription level errors (isolated error domains)
+		subscriptions struct {
+			lock    sync.Mutex
+			started map[string]time.Time
+			failed  map[string]error
+		}
 
 		metricList *MetricList
 
+		probeErrors ProbeErrorList
+
 		prometheus struct {
 			registry *prometheus.Registry
+
+			// metrics with datapoint timestamps (not possible with registry, datapoints=all has duplicate series)
+			families []*dto.MetricFamily
 		}
 
-		callbackSubscriptionFishish func(subscriptionId string)
+		callbackSubscriptionFishish func(subscriptionId string, duration time.Duration, err error)
 
 		ServiceDiscovery AzureServiceDiscovery
 	}
 
 	MetricProbeTarget struct {
 		ResourceId   string
+		Location     string
 		Metrics      []string
 		Aggregations []string
 		Tags         map[string]string
+
+		// sku tier of discovered resources (metrics of profiles by sku tier)
+		SkuTier string
+
+		// overrides parameter metricNamespace (eg. VM Scale Set instances)
+		MetricNamespace string
+
+		// sub-resource (VM Scale Set instance, storage service), metrics are exported with the
+		// parent resource and the labels of the sub-resource
+		ParentResourceId string
+		Labels           map[string]string
 	}
 )
 
@@ -87,10 +139,15 @@ func NewMetricProber(ctx context.Context, logger *zap.SugaredLogger, w http.Resp
 
 func (p *MetricProber) Init() {
 	p.targets = map[string][]MetricProbeTarget{}
+	p.subscriptions.started = map[string]time.Time{}
+	p.subscriptions.failed = map[string]error{}
 
 	p.metricList = NewMetricList()
 }
-func (p *MetricProber) RegisterSubscriptionCollectFinishCallback(callback func(subscriptionId string)) {
+
+// RegisterSubscriptionCollectFinishCallback registers the callback called after the collection of each subscription
+// with the duration (discovery and collection) and the subscription level error
+func (p *MetricProber) RegisterSubscriptionCollectFinishCallback(callback func(subscriptionId string, duration time.Duration, err error)) {
 	p.callbackSubscriptionFishish = callback
 }
 
@@ -106,71 +163,233 @@ func (p *MetricProber) SetAzureClient(client *armclient.ArmClient) {
 	p.AzureClient = client
 }
 
+func (p *MetricProber) SetAzureCredential(cred azcore.TokenCredential) {
+	p.cred = cred
+}
+
+// GetCred returns the credential for Azure clients, falls back to the credential of the AzureClient
+func (p *MetricProber) GetCred() azcore.TokenCredential {
+	if p.cred != nil {
+		return p.cred
+	}
+	return p.AzureClient.GetCred()
+}
+
 func (p *MetricProber) SetAzureResourceTagManager(client *armclient.ResourceTagManager) {
 	p.AzureResourceTagManager = client
 }
 
-func (p *MetricProber) EnableMetricsCache(cache *cache.Cache, cacheKey string, cacheDuration *time.Duration) {
+// SetResourceTagCache sets the cache of resource tags (tag labels), tags are requested per probe without cache
+func (p *MetricProber) SetResourceTagCache(cache *ResourceTagCache) {
+	p.resourceTagCache = cache
+}
+
+// SetSubscriptionDiscovery sets the subscription discovery used for subscription names and subscription scoped
+// probes, subscriptions are listed per probe without discovery
+func (p *MetricProber) SetSubscriptionDiscovery(discovery *SubscriptionDiscovery) {
+	p.subscriptionDiscovery = discovery
+}
+
+func (p *MetricProber) EnableMetricsCache(cache Cache, cacheKey string, cacheDuration *time.Duration) {
 	p.metricsCache.cache = cache
 	p.metricsCache.cacheKey = &cacheKey
 	p.metricsCache.cacheDuration = cacheDuration
 }
 
-func (p *MetricProber) EnableServiceDiscoveryCache(cache *cache.Cache, cacheDuration *time.Duration) {
-	p.serviceDiscoveryCache.cache = cache
-	p.serviceDiscoveryCache.cacheDuration = cacheDuration
+func (p *MetricProber) EnableResourceInventory(inventory *ResourceInventory, refreshInterval time.Duration) {
+	p.resourceInventory.inventory = inventory
+	p.resourceInventory.refreshInterval = refreshInterval
+}
+
+func (p *MetricProber) EnableResourceGraphCache(cache *ResourceGraphCache, cacheDuration time.Duration) {
+	p.resourceGraphCache.cache = cache
+	p.resourceGraphCache.cacheDuration = cacheDuration
+}
+
+func (p *MetricProber) SetMetricDefinitionCache(cache *MetricDefinitionCache) {
+	// metric definitions are requested from Azure Monitor, not used in dry runs
+	if p.settings.DryRun {
+		return
+	}
+	p.metricDefinitionCache = cache
+}
+
+func (p *MetricProber) SetWorkerPool(pool *WorkerPool) {
+	p.workerPool = pool
+}
+
+func (p *MetricProber) SetRateLimiter(limiter *RateLimiter) {
+	p.rateLimiter = limiter
+}
+
+func (p *MetricProber) SetQuotaCoordinator(coordinator *QuotaCoordinator) {
+	p.quotaCoordinator = coordinator
+}
+
+func (p *MetricProber) SetRestrictions(restrictions *ProbeRestrictions) {
+	p.restrictions = restrictions
+}
+
+func (p *MetricProber) SetMetricsBatchClients(clients *MetricsBatchClients) {
+	p.metricsBatchClients = clients
+}
+
+// waitForRequest waits for the ARM quota (probe priority), the rate limiter and a free worker,
+// the returned release function must be called after the request
+func (p *MetricProber) waitForRequest(subscriptionId string) (release func(), err error) {
+	// dry runs don't send requests to Azure Monitor (no quota and rate limit)
+	if p.quotaCoordinator != nil && !p.settings.DryRun {
+		if err := p.quotaCoordinator.Wait(p.ctx, subscriptionId, p.settings.Priority); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.rateLimiter != nil && !p.settings.DryRun {
+		if err := p.rateLimiter.Wait(p.ctx, subscriptionId); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.workerPool != nil {
+		if err := p.workerPool.Acquire(p.ctx); err != nil {
+			return nil, err
+		}
+		return p.workerPool.Release, nil
+	}
+
+	return func() {}, nil
+}
+
+// waitForBatchRequest waits for the regional rate limiter of the metrics data plane, the rate limiter and a free
+// worker, metrics:getBatch requests don't use the ARM quota; the returned release function must be called after the request
+func (p *MetricProber) waitForBatchRequest(subscriptionId, region string) (release func(), err error) {
+	if p.metricsBatchClients != nil {
+		if err := p.metricsBatchClients.Wait(p.ctx, region); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.rateLimiter != nil {
+		if err := p.rateLimiter.Wait(p.ctx, subscriptionId); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.workerPool != nil {
+		if err := p.workerPool.Acquire(p.ctx); err != nil {
+			return nil, err
+		}
+		return p.workerPool.Release, nil
+	}
+
+	return func() {}, nil
 }
 
 func (p *MetricProber) AddTarget(targets ...MetricProbeTarget) {
 	for _, target := range targets {
-		resourceInfo, err := azure.ParseResourceID(target.ResourceId)
+		resourceInfo, err := armclient.ParseResourceId(target.ResourceId)
 		if err != nil {
 			p.logger.Warnf("unable to parse resource id: %s", err.Error())
 			continue
 		}
 
-		subscriptionId := resourceInfo.SubscriptionID
+		// skip discovered resources outside of --restrict.* (instances are checked by their scale set)
+		if p.restrictions != nil {
+			restrictedResourceId := target.ResourceId
+			if target.ParentResourceId != "" {
+				restrictedResourceId = target.ParentResourceId
+			}
+			if err := p.restrictions.CheckResources(restrictedResourceId); err != nil {
+				p.logger.Debugf("skipping target %s: %v", target.ResourceId, err)
+				continue
+			}
+		}
+
+		// metrics of the profile not available for the sku tier (eg. DTU metrics of vCore databases)
+		if metrics := p.profileTargetMetrics(target); len(metrics) > 0 || len(target.Metrics) == 0 {
+			target.Metrics = metrics
+		} else {
+			p.logger.Debugf("skipping target %s: no metrics available for sku tier %s", target.ResourceId, target.SkuTier)
+			continue
+		}
+
+		subscriptionId := resourceInfo.Subscription
+		p.targetsLock.Lock()
 		if _, exists := p.targets[subscriptionId]; !exists {
 			p.targets[subscriptionId] = []MetricProbeTarget{}
 		}
 
 		p.targets[subscriptionId] = append(p.targets[subscriptionId], target)
+		p.targetsLock.Unlock()
 	}
 }
 
 func (p *MetricProber) FetchFromCache() bool {
-	if p.metricsCache.cache == nil {
+	// dummy series of dry runs are not cached
+	if p.metricsCache.cache == nil || p.settings.DryRun {
 		return false
 	}
 
-	if val, ok := p.metricsCache.cache.Get(*p.metricsCache.cacheKey); ok {
-		p.metricList = val.(*MetricList)
+	if cacheData, ok := p.metricsCache.cache.Get(*p.metricsCache.cacheKey); ok {
+		metricList := NewMetricList()
+		if err := json.Unmarshal(cacheData, metricList); err != nil {
+			p.logger.Debugf("unable to parse cached metrics: %v", err)
+			return false
+		}
+		p.metricList = metricList
 		p.publishMetricList()
+		PrometheusCacheRequests.WithLabelValues("metrics", CacheResultHit).Inc()
 		return true
 	}
 
+	PrometheusCacheRequests.WithLabelValues("metrics", CacheResultMiss).Inc()
 	return false
 }
 
 func (p *MetricProber) SaveToCache() {
-	if p.metricsCache.cache == nil {
+	if p.metricsCache.cache == nil || p.settings.DryRun {
+		return
+	}
+
+	// don't cache partial results: errors are reported or series of failed subscriptions were dropped, the
+	// missing subscriptions would stay missing for the cache duration
+	if p.settings.ReportErrors && p.ErrorCount() > 0 {
+		return
+	}
+	if p.hasFailedSubscriptions() {
 		return
 	}
 
 	if p.metricsCache.cacheDuration != nil {
-		_ = p.metricsCache.cache.Add(*p.metricsCache.cacheKey, p.metricList, *p.metricsCache.cacheDuration)
+		cacheData, err := json.Marshal(p.metricList)
+		if err != nil {
+			p.logger.Debugf("unable to cache metrics: %v", err)
+			return
+		}
+		p.metricsCache.cache.Set(*p.metricsCache.cacheKey, cacheData, *p.metricsCache.cacheDuration)
 		p.response.Header().Add("X-metrics-cached-until", time.Now().Add(*p.metricsCache.cacheDuration).Format(time.RFC3339))
 	}
 }
 
 func (p *MetricProber) Run() {
+	if p.settings.AksNodePools {
+		p.expandAksNodePools()
+	}
+	if p.settings.VmssInstances {
+		p.expandVmssInstances()
+	}
+	if len(p.settings.StorageServices) > 0 {
+		p.expandStorageServices()
+	}
 	p.collectMetricsFromTargets()
+	p.aggregateResources()
 	p.SaveToCache()
 	p.publishMetricList()
 }
 
 func (p *MetricProber) RunOnSubscriptionScope() {
 	p.collectMetricsFromSubscriptions()
+	p.aggregateResources()
 	p.SaveToCache()
 	p.publishMetricList()
 }
@@ -178,111 +397,159 @@ func (p *MetricProber) RunOnSubscriptionScope() {
 func (p *MetricProber) collectMetricsFromSubscriptions() {
 	metricsChannel := make(chan PrometheusMetricResult)
 
-	subscriptionIterator := armclient.NewSubscriptionIterator(p.AzureClient, p.settings.Subscriptions...)
-	subscriptionIterator.SetConcurrency(p.Conf.Prober.ConcurrencySubscription)
-
 	go func() {
+		defer close(metricsChannel)
+
 		regions, err := p.discoverResourceRegions()
 		if err != nil {
 			p.logger.Error(fmt.Errorf("error getting subscription locations: %w", err))
+			p.reportError("", err)
 			return
 		}
 
-		err = subscriptionIterator.ForEachAsync(p.logger, func(subscription *armsubscriptions.Subscription, logger *zap.SugaredLogger) {
-			subscriptionRegions := regions[*subscription.SubscriptionID]
+		// subscriptions are resolved with the credential of the probe
+		subscriptionList, err := p.visibleSubscriptions()
+		if err != nil {
+			p.logger.Error(err)
+			p.reportError("", err)
+			return
+		}
 
-			for _, region := range subscriptionRegions {
-				client, err := p.MetricsClient(*subscription.SubscriptionID)
-				if err != nil {
-					// FIXME: find a better way to report errors
-					p.logger.Error(err)
-					return
-				}
+		wgSubscription := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscription)
+		for _, subscriptionId := range p.settings.Subscriptions {
+			subscription, exists := subscriptionList[subscriptionKey(subscriptionId)]
+			if !exists {
+				err := fmt.Errorf(`subscription "%s" not found`, subscriptionId)
+				p.logger.Error(err)
+				p.reportError("/subscriptions/"+subscriptionId, err)
+				continue
+			}
 
-				// request metrics in 20 metrics chunks (azure metric api limitation)
-				for i := 0; i < len(p.settings.Metrics); i += AzureMetricApiMaxMetricNumber {
-					end := i + AzureMetricApiMaxMetricNumber
-					if end > len(p.settings.Metrics) {
-						end = len(p.settings.Metrics)
-					}
-					metricList := p.settings.Metrics[i:end]
-
-					resultType := armmonitor.MetricResultTypeData
-					opts := armmonitor.MetricsClientListAtSubscriptionScopeOptions{
-						Interval:            p.settings.Interval,
-						Timespan:            to.StringPtr(p.settings.Timespan),
-						Metricnames:         to.StringPtr(strings.Join(metricList, ",")),
-						Metricnamespace:     to.StringPtr(p.settings.ResourceType),
-						Top:                 p.settings.MetricTop,
-						AutoAdjustTimegrain: to.BoolPtr(true),
-						ResultType:          &resultType,
-						ValidateDimensions:  to.BoolPtr(p.settings.ValidateDimensions),
-						Filter:              to.StringPtr(`Microsoft.ResourceId eq '*'`),
-					}
+			wgSubscription.Add()
+			go func(subscription DiscoveredSubscription) {
+				defer wgSubscription.Done()
+				p.collectSubscriptionScopeMetrics(metricsChannel, subscription, regions[subscriptionKey(subscription.ID)])
+			}(subscription)
+		}
+		wgSubscription.Wait()
+	}()
 
-					if len(p.settings.Aggregations) >= 1 {
-						opts.Aggregation = to.StringPtr(strings.Join(p.settings.Aggregations, ","))
-					}
+	for result := range metricsChannel {
+		metric := MetricRow{
+			Labels:    result.Labels,
+			Value:     result.Value,
+			Timestamp: result.Timestamp,
+			Missing:   result.Missing,
+		}
+		p.metricList.Add(result.Name, metric)
+		p.metricList.SetMetricHelp(result.Name, result.Help)
+	}
+}
 
-					if len(p.settings.MetricFilter) >= 1 {
-						opts.Filter = to.StringPtr(*opts.Filter + " and " + p.settings.MetricFilter)
-					}
+// collectSubscriptionScopeMetrics requests the metrics of the resource type in each region of the subscription
+// (subscription scoped metric requests)
+func (p *MetricProber) collectSubscriptionScopeMetrics(metricsChannel chan<- PrometheusMetricResult, subscription DiscoveredSubscription, subscriptionRegions []string) {
+	subscriptionStartTime := time.Now()
+
+	// the finish callback gets the error of the subscription (failed request or panic)
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			p.failSubscription(subscription.ID, err)
+		}
 
-					if len(p.settings.MetricOrderBy) >= 1 {
-						opts.Orderby = to.StringPtr(p.settings.MetricOrderBy)
-					}
+		if p.callbackSubscriptionFishish != nil {
+			p.callbackSubscriptionFishish(subscription.ID, time.Since(subscriptionStartTime), err)
+		}
+	}()
 
-					if len(p.settings.MetricNamespace) >= 1 {
-						opts.Metricnamespace = to.StringPtr(p.settings.MetricNamespace)
-					}
+	for _, region := range subscriptionRegions {
+		var client *armmonitor.MetricsClient
+		client, err = p.MetricsClient(subscription.ID)
+		if err != nil {
+			p.logger.Error(err)
+			p.reportError("/subscriptions/"+subscription.ID, err)
+			return
+		}
 
-					response, err := client.ListAtSubscriptionScope(p.ctx, region, &opts)
-					if err != nil {
-						// FIXME: find a better way to report errors
-						p.logger.Error(err)
-						return
-					}
+		metricNamespace := p.settings.ResourceType
+		if len(p.settings.MetricNamespace) >= 1 {
+			metricNamespace = p.settings.MetricNamespace
+		}
 
-					result := AzureInsightSubscriptionMetricsResult{
-						AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
-							prober: p,
-						},
-						subscription: subscription,
-						Result:       &response}
-					result.SendMetricToChannel(metricsChannel)
-				}
+		// request metrics in 20 metrics chunks (azure metric api limitation)
+		for _, metricList := range chunkMetricNames(p.settings.Metrics) {
+			// interval=auto and unsupported intervals are resolved using the metric definitions of the region
+			interval, timespan := p.resolveSubscriptionTimeGrain(subscription.ID, region, metricNamespace, metricList)
+
+			resultType := armmonitor.MetricResultTypeData
+			opts := armmonitor.MetricsClientListAtSubscriptionScopeOptions{
+				Interval:            interval,
+				Timespan:            to.StringPtr(p.requestTimespan(p.settings.ResourceType, interval, timespan)),
+				Metricnames:         to.StringPtr(strings.Join(metricList, ",")),
+				Metricnamespace:     to.StringPtr(p.settings.ResourceType),
+				Top:                 p.settings.MetricTop,
+				AutoAdjustTimegrain: to.BoolPtr(true),
+				ResultType:          &resultType,
+				ValidateDimensions:  to.BoolPtr(p.settings.ValidateDimensions),
+				Filter:              to.StringPtr(`Microsoft.ResourceId eq '*'`),
+			}
 
-				if p.callbackSubscriptionFishish != nil {
-					p.callbackSubscriptionFishish(*subscription.SubscriptionID)
-				}
+			// aggregation=default is the primary aggregation type (Azure Monitor default without aggregations)
+			if aggregations := withoutDefaultAggregation(p.settings.Aggregations); len(aggregations) >= 1 {
+				opts.Aggregation = to.StringPtr(strings.Join(aggregations, ","))
 			}
-		})
-		if err != nil {
-			// FIXME: find a better way to report errors
-			p.logger.Error(err)
-		}
 
-		close(metricsChannel)
-	}()
+			if len(p.settings.MetricFilter) >= 1 {
+				opts.Filter = to.StringPtr(*opts.Filter + " and " + p.settings.MetricFilter)
+			}
 
-	for result := range metricsChannel {
-		metric := MetricRow{
-			Labels: result.Labels,
-			Value:  result.Value,
+			if len(p.settings.MetricOrderBy) >= 1 {
+				opts.Orderby = to.StringPtr(p.settings.MetricOrderBy)
+			}
+
+			if len(p.settings.MetricNamespace) >= 1 {
+				opts.Metricnamespace = to.StringPtr(p.settings.MetricNamespace)
+			}
+
+			var release func()
+			release, err = p.waitForRequest(subscription.ID)
+			if err != nil {
+				p.logger.Error(err)
+				p.reportError("/subscriptions/"+subscription.ID, err)
+				return
+			}
+			var response armmonitor.MetricsClientListAtSubscriptionScopeResponse
+			response, err = client.ListAtSubscriptionScope(withApiVersion(p.ctx, p.settings.MetricsApiVersionFor(p.settings.ResourceType)), region, &opts)
+			release()
+			if err != nil {
+				p.logger.Error(err)
+				p.reportError("/subscriptions/"+subscription.ID, err)
+				return
+			}
+
+			result := AzureInsightSubscriptionMetricsResult{
+				AzureInsightBaseMetricsResult: AzureInsightBaseMetricsResult{
+					prober: p,
+				},
+				subscription: subscription,
+				Result:       &response}
+			result.SendMetricToChannel(metricsChannel)
 		}
-		p.metricList.Add(result.Name, metric)
-		p.metricList.SetMetricHelp(result.Name, result.Help)
 	}
 }
 
+// discoverResourceRegions returns the regions of each subscription (by lowercase id), regions with resources of the
+// resource type are discovered with ResourceGraph (credential of the probe) if the regions are not set
 func (p *MetricProber) discoverResourceRegions() (map[string][]string, error) {
 	regions := map[string][]string{}
 
 	for _, subscriptionId := range p.settings.Subscriptions {
 		if len(p.settings.Regions) == 0 {
-			regions[subscriptionId] = []string{}
+			regions[subscriptionKey(subscriptionId)] = []string{}
 		} else {
-			regions[subscriptionId] = p.settings.Regions
+			regions[subscriptionKey(subscriptionId)] = p.settings.Regions
 		}
 	}
 
@@ -290,26 +557,28 @@ func (p *MetricProber) discoverResourceRegions() (map[string][]string, error) {
 		return regions, nil
 	}
 
-	query := fmt.Sprintf(`Resources | where type == "%s" | summarize count() by subscriptionId, location`, strings.ToLower(p.settings.ResourceType))
-
-	opts := armclient.ResourceGraphOptions{
-		Subscriptions: p.settings.Subscriptions,
-	}
-	results, err := p.AzureClient.ExecuteResourceGraphQuery(p.ctx, query, opts)
+	client, err := armresourcegraph.NewClient(p.GetCred(), NewArmClientOptions(p.AzureClient))
 	if err != nil {
 		return nil, err
 	}
 
-	for _, row := range results {
-		subscriptionId := row["subscriptionId"].(string)
-		location := row["location"].(string)
-
-		regions[subscriptionId] = append(regions[subscriptionId], location)
+	query := fmt.Sprintf(`Resources | where type == "%s" | summarize count() by subscriptionId, location`, strings.ToLower(p.settings.ResourceType))
+	_, err = queryResourceGraph(p.ctx, client, "regions", query, p.settings.Subscriptions, p.Conf.Azure.ResourceGraph.MaxRows, func(row map[string]interface{}) {
+		subscriptionId, _ := row["subscriptionId"].(string)
+		location, _ := row["location"].(string)
+		if subscriptionId != "" && location != "" {
+			regions[subscriptionKey(subscriptionId)] = append(regions[subscriptionKey(subscriptionId)], location)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return regions, nil
 }
 
+// collectMetricsFromTargets collects the metrics of each subscription in parallel, subscriptions are isolated:
+// series of a subscription are only published if the subscription didn't fail (eg. expired access, panics)
 func (p *MetricProber) collectMetricsFromTargets() {
 	metricsChannel := make(chan PrometheusMetricResult)
 
@@ -321,74 +590,235 @@ func (p *MetricProber) collectMetricsFromTargets() {
 			go func(subscriptionId string, targetList []MetricProbeTarget) {
 				defer wgSubscription.Done()
 
-				wgSubscriptionResource := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscriptionResource)
-				client, err := p.MetricsClient(subscriptionId)
-				if err != nil {
-					// FIXME: find a better way to report errors
-					p.logger.Error(err)
-					return
-				}
-
-				for _, target := range targetList {
-					wgSubscriptionResource.Add()
-					go func(target MetricProbeTarget) {
-						defer wgSubscriptionResource.Done()
-
-						// request metrics in 20 metrics chunks (azure metric api limitation)
-						for i := 0; i < len(target.Metrics); i += AzureMetricApiMaxMetricNumber {
-							end := i + AzureMetricApiMaxMetricNumber
-							if end > len(target.Metrics) {
-								end = len(target.Metrics)
-							}
-							metricList := target.Metrics[i:end]
+				subscriptionStartTime := p.subscriptionStartTime(subscriptionId)
+				results := p.collectSubscriptionTargets(subscriptionId, targetList)
 
-							if result, err := p.FetchMetricsFromTarget(client, target, metricList, target.Aggregations); err == nil {
-								result.SendMetricToChannel(metricsChannel)
-							} else {
-								p.logger.With(zap.String("resourceID", target.ResourceId)).Warn(err)
-							}
-						}
-					}(target)
+				err := p.subscriptionError(subscriptionId)
+				if err == nil {
+					for _, result := range results {
+						metricsChannel <- result
+					}
+				} else {
+					p.logger.With(zap.String("subscriptionID", subscriptionId)).Warnf("dropping %d series of failed subscription: %v", len(results), err)
 				}
-				wgSubscriptionResource.Wait()
 
 				if p.callbackSubscriptionFishish != nil {
-					p.callbackSubscriptionFishish(subscriptionId)
+					p.callbackSubscriptionFishish(subscriptionId, time.Since(subscriptionStartTime), err)
 				}
 			}(subscriptionId, resourceList)
 		}
 		wgSubscription.Wait()
+
+		// subscriptions failed during discovery (no targets)
+		for subscriptionId, err := range p.failedSubscriptionsWithoutTargets() {
+			if p.callbackSubscriptionFishish != nil {
+				p.callbackSubscriptionFishish(subscriptionId, time.Since(p.subscriptionStartTime(subscriptionId)), err)
+			}
+		}
+
 		close(metricsChannel)
 	}()
 
 	for result := range metricsChannel {
 		metric := MetricRow{
-			Labels: result.Labels,
-			Value:  result.Value,
+			Labels:    result.Labels,
+			Value:     result.Value,
+			Timestamp: result.Timestamp,
+			Missing:   result.Missing,
 		}
 		p.metricList.Add(result.Name, metric)
 		p.metricList.SetMetricHelp(result.Name, result.Help)
 	}
 }
 
+// collectSubscriptionTargets collects the metrics of the targets of one subscription
+func (p *MetricProber) collectSubscriptionTargets(subscriptionId string, targetList []MetricProbeTarget) (results []PrometheusMetricResult) {
+	defer p.recoverSubscription(subscriptionId)
+
+	metricsChannel := make(chan PrometheusMetricResult)
+	collectorDone := make(chan struct{})
+	go func() {
+		defer close(collectorDone)
+		for result := range metricsChannel {
+			results = append(results, result)
+		}
+	}()
+	defer func() {
+		close(metricsChannel)
+		<-collectorDone
+	}()
+
+	wgSubscriptionResource := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscriptionResource)
+	defer wgSubscriptionResource.Wait()
+
+	client, err := p.MetricsClient(subscriptionId)
+	if err != nil {
+		p.logger.Error(err)
+		p.failSubscription(subscriptionId, err)
+		return
+	}
+
+	// fetch metrics of resources with same type and region via metrics:getBatch
+	if p.settings.MetricsBatch {
+		var batches [][]MetricProbeTarget
+		batches, targetList = p.splitBatchTargets(targetList)
+
+		for _, batch := range batches {
+			wgSubscriptionResource.Add()
+			go func(batch []MetricProbeTarget) {
+				defer wgSubscriptionResource.Done()
+				defer p.recoverSubscription(subscriptionId)
+
+				// request metrics in 20 metrics chunks (azure metric api limitation)
+				for _, metricList := range chunkMetricNames(batch[0].Metrics) {
+					for _, group := range p.resolveAggregations(batch[0].ResourceId, p.targetMetricNamespace(batch[0]), metricList, batch[0].Aggregations) {
+						release, err := p.waitForBatchRequest(subscriptionId, batch[0].Location)
+						if err != nil {
+							p.logger.Warn(err)
+							for _, target := range batch {
+								p.reportError(target.ResourceId, err)
+							}
+							return
+						}
+						batchResults, err := p.FetchMetricsFromBatch(subscriptionId, withTargetAggregations(batch, group.Aggregations), group.Metrics)
+						release()
+
+						if err == nil {
+							for _, result := range batchResults {
+								result.SendMetricToChannel(metricsChannel)
+							}
+						} else {
+							p.logger.With(zap.String("location", batch[0].Location)).Warn(err)
+							for _, target := range batch {
+								p.reportError(target.ResourceId, err)
+							}
+						}
+					}
+				}
+			}(batch)
+		}
+	}
+
+	for _, target := range targetList {
+		wgSubscriptionResource.Add()
+		go func(target MetricProbeTarget) {
+			defer wgSubscriptionResource.Done()
+			defer p.recoverSubscription(subscriptionId)
+
+			// request metrics in 20 metrics chunks (azure metric api limitation)
+			for _, metricList := range chunkMetricNames(target.Metrics) {
+				for _, group := range p.resolveAggregations(target.ResourceId, p.targetMetricNamespace(target), metricList, target.Aggregations) {
+					release, err := p.waitForRequest(subscriptionId)
+					if err != nil {
+						p.logger.With(zap.String("resourceID", target.ResourceId)).Warn(err)
+						p.reportError(target.ResourceId, err)
+						return
+					}
+					groupTarget := target
+					groupTarget.Aggregations = group.Aggregations
+					result, err := p.FetchMetricsFromTarget(client, groupTarget, group.Metrics, group.Aggregations)
+					release()
+
+					if err == nil {
+						result.SendMetricToChannel(metricsChannel)
+					} else {
+						p.logger.With(zap.String("resourceID", target.ResourceId)).Warn(err)
+						p.reportError(target.ResourceId, err)
+					}
+				}
+			}
+		}(target)
+	}
+
+	return
+}
+
 func (p *MetricProber) publishMetricList() {
+	// probe errors are published last (including failed publishing of the metric list)
+	defer p.publishProbeErrors()
+
 	if p.metricList == nil {
 		return
 	}
 
+	// portal links need the full resource id (before label normalization)
+	p.publishPortalLinks()
+	p.normalizeLabels()
+
 	// create prometheus metrics and set rows
-	for _, metricName := range p.metricList.GetMetricNames() {
-		gauge := prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: metricName,
-				Help: p.metricList.GetMetricHelp(metricName),
-			},
-			p.metricList.GetMetricLabelNames(metricName),
-		)
-		p.prometheus.registry.MustRegister(gauge)
+	if p.settings.Datapoints == DatapointsSummary {
+		for _, metricName := range p.metricList.GetMetricNames() {
+			if strings.HasSuffix(metricName, MissingDatapointsMetricSuffix) {
+				// missing datapoints gauge (missingDatapoints=gauge) is not a datapoint of the timespan
+				p.prometheus.families = append(p.prometheus.families, p.metricList.GetMetricFamily(metricName, false))
+				continue
+			}
+			p.prometheus.families = append(
+				p.prometheus.families,
+				p.metricList.GetSummaryFamily(metricName, p.settings.Percentiles, p.settings.ExportTimestamps),
+			)
+		}
+	} else if p.settings.ExportTimestamps {
+		for _, metricName := range p.metricList.GetMetricNames() {
+			p.prometheus.families = append(
+				p.prometheus.families,
+				p.metricList.GetMetricFamily(metricName, p.settings.Datapoints == DatapointsAll),
+			)
+		}
+	} else if err := p.metricList.Publish(p.prometheus.registry); err != nil {
+		p.logger.Error(err)
+		p.reportError("", err)
+	}
+}
+
+// targetMetricNamespace returns the metric namespace of the target, parameter metricNamespace as default
+func (p *MetricProber) targetMetricNamespace(target MetricProbeTarget) string {
+	if target.MetricNamespace != "" {
+		return target.MetricNamespace
+	}
+	return p.settings.MetricNamespace
+}
+
+// targetLabelNames returns the sub-resource labels added to all target metrics (consistent label set)
+func (p *MetricProber) targetLabelNames() (labelNames []string) {
+	if p.settings.VmssInstances {
+		labelNames = append(labelNames, VmssInstanceLabel)
+	}
+	if p.settings.AksNodePools {
+		labelNames = append(labelNames, AksNodePoolLabel)
+	}
+	if len(p.settings.StorageServices) > 0 {
+		labelNames = append(labelNames, StorageServiceLabel)
+	}
+	return
+}
+
+// Gatherer returns the gatherer for the probe response (registry and metrics with datapoint timestamps)
+func (p *MetricProber) Gatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := p.prometheus.registry.Gather()
+		if err != nil {
+			return nil, err
+		}
+
+		families = append(families, p.prometheus.families...)
+		sort.Slice(families, func(i, j int) bool {
+			return families[i].GetName() < families[j].GetName()
+		})
+		return families, nil
+	})
+}
 
-		for _, row := range p.metricList.GetMetricList(metricName) {
-			gauge.With(row.Labels).Set(row.Value)
+// chunkMetricNames splits the metric names (without duplicates) into chunks of max 20 metrics (azure metric api limitation),
+// all aggregations are requested with every chunk and split by the result
+func chunkMetricNames(metrics []string) (chunks [][]string) {
+	metrics = uniqueStringList(metrics)
+	for i := 0; i < len(metrics); i += AzureMetricApiMaxMetricNumber {
+		end := i + AzureMetricApiMaxMetricNumber
+		if end > len(metrics) {
+			end = len(metrics)
 		}
+		chunks = append(chunks, metrics[i:end])
 	}
+	return
 }
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/remeh/sizedwaitgroup"
+	"go.uber.org/zap"
+)
+
+// DiscoverSubscriptions runs the resource discovery of each subscription in parallel, a failed subscription
+// is reported and skipped without affecting the other subscriptions
+func (p *MetricProber) DiscoverSubscriptions(subscriptionIds []string, discover func(subscriptionId string) error) {
+	wg := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscription)
+	for _, subscriptionId := range subscriptionIds {
+		p.subscriptions.lock.Lock()
+		p.subscriptions.started[subscriptionKey(subscriptionId)] = time.Now()
+		p.subscriptions.lock.Unlock()
+
+		wg.Add()
+		go func(subscriptionId string) {
+			defer wg.Done()
+			defer p.recoverSubscription(subscriptionId)
+
+			if err := discover(subscriptionId); err != nil {
+				p.logger.With(zap.String("subscriptionID", subscriptionId)).Error(err)
+				p.failSubscription(subscriptionId, err)
+			}
+		}(subscriptionId)
+	}
+	wg.Wait()
+}
+
+// failSubscription marks the subscription as failed, the series of the subscription are dropped
+func (p *MetricProber) failSubscription(subscriptionId string, err error) {
+	p.reportError("/subscriptions/"+subscriptionId, err)
+
+	p.subscriptions.lock.Lock()
+	defer p.subscriptions.lock.Unlock()
+	if _, exists := p.subscriptions.failed[subscriptionKey(subscriptionId)]; !exists {
+		p.subscriptions.failed[subscriptionKey(subscriptionId)] = err
+	}
+}
+
+// recoverSubscription recovers panics of the subscription (deferred), only the subscription fails
+func (p *MetricProber) recoverSubscription(subscriptionId string) {
+	if r := recover(); r != nil {
+		p.failSubscription(subscriptionId, fmt.Errorf("panic: %v", r))
+	}
+}
+
+func (p *MetricProber) subscriptionError(subscriptionId string) error {
+	p.subscriptions.lock.Lock()
+	defer p.subscriptions.lock.Unlock()
+	return p.subscriptions.failed[subscriptionKey(subscriptionId)]
+}
+
+// subscriptionStartTime returns the start of the discovery of the subscription (now if not discovered by the prober)
+func (p *MetricProber) subscriptionStartTime(subscriptionId string) time.Time {
+	p.subscriptions.lock.Lock()
+	defer p.subscriptions.lock.Unlock()
+	if startTime, exists := p.subscriptions.started[subscriptionKey(subscriptionId)]; exists {
+		return startTime
+	}
+
+	startTime := time.Now()
+	p.subscriptions.started[subscriptionKey(subscriptionId)] = startTime
+	return startTime
+}
+
+// hasFailedSubscriptions returns if any subscription failed (during discovery or collection), the probe result is
+// partial
+func (p *MetricProber) hasFailedSubscriptions() bool {
+	p.subscriptions.lock.Lock()
+	defer p.subscriptions.lock.Unlock()
+	return len(p.subscriptions.failed) > 0
+}
+
+// failedSubscriptionsWithoutTargets returns the subscriptions which failed before targets were discovered
+func (p *MetricProber) failedSubscriptionsWithoutTargets() map[string]error {
+	targetSubscriptions := map[string]bool{}
+	p.targetsLock.Lock()
+	for subscriptionId := range p.targets {
+		targetSubscriptions[subscriptionKey(subscriptionId)] = true
+	}
+	p.targetsLock.Unlock()
+
+	p.subscriptions.lock.Lock()
+	defer p.subscriptions.lock.Unlock()
+
+	ret := map[string]error{}
+	for subscriptionId, err := range p.subscriptions.failed {
+		if !targetSubscriptions[subscriptionId] {
+			ret[subscriptionId] = err
+		}
+	}
+	return ret
+}
+
+// credentialKey returns the key of the probe credential (credential and tenant parameter) for shared caches
+func (p *MetricProber) credentialKey() string {
+	if p.settings == nil {
+		return ""
+	}
+	return p.settings.CredentialKey()
+}
+
+// visibleSubscriptions returns the subscriptions visible to the credential of the probe (by lowercase id),
+// subscriptions are listed once per probe (cached by subscription discovery)
+func (p *MetricProber) visibleSubscriptions() (map[string]DiscoveredSubscription, error) {
+	p.subscriptionList.once.Do(func() {
+		var subscriptionList []DiscoveredSubscription
+		if p.subscriptionDiscovery != nil {
+			subscriptionList, p.subscriptionList.err = p.subscriptionDiscovery.List(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), p.credentialKey())
+		} else {
+			subscriptionList, p.subscriptionList.err = listSubscriptions(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient))
+		}
+
+		p.subscriptionList.subscriptions = map[string]DiscoveredSubscription{}
+		for _, subscription := range subscriptionList {
+			p.subscriptionList.subscriptions[subscriptionKey(subscription.ID)] = subscription
+		}
+	})
+	return p.subscriptionList.subscriptions, p.subscriptionList.err
+}
+
+// subscriptionName returns the display name of the subscription, empty if the subscription is not visible
+func (p *MetricProber) subscriptionName(subscriptionId string) string {
+	subscriptionList, err := p.visibleSubscriptions()
+	if err != nil {
+		return ""
+	}
+	return subscriptionList[subscriptionKey(subscriptionId)].Name
+}
+
+func subscriptionKey(subscriptionId string) string {
+	return strings.ToLower(subscriptionId)
+}
@@ -0,0 +1,292 @@
+package metrics
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type (
+	// MetricProfile predefines metrics, aggregations and intervals for a resource type,
+	// explicit request parameters always take precedence
+	MetricProfile struct {
+		Description     string
+		ResourceType    string
+		MetricNamespace string
+		Metrics         []string
+		Aggregations    []string
+		Dimensions      []string
+		MetricFilter    string
+		MetricTop       string
+		Interval        string
+		Timespan        string
+		StorageServices []string
+		AksNodePools    bool
+
+		// sku tiers of metrics which are not available for all resources of the resource type (lowercase metric name),
+		// metrics are skipped for resources of other sku tiers (eg. DTU metrics of vCore databases)
+		SkuTierMetrics map[string][]string
+	}
+)
+
+var (
+	// sku tiers of the DTU and vCore purchase model of SQL databases and elastic pools
+	sqlDtuSkuTiers   = []string{"Basic", "Standard", "Premium"}
+	sqlVcoreSkuTiers = []string{"GeneralPurpose", "BusinessCritical", "Hyperscale"}
+
+	sqlSkuTierMetrics = map[string][]string{
+		"dtu_consumption_percent": sqlDtuSkuTiers,
+		"dtu_used":                sqlDtuSkuTiers,
+		"dtu_limit":               sqlDtuSkuTiers,
+		"edtu_used":               sqlDtuSkuTiers,
+		"edtu_limit":              sqlDtuSkuTiers,
+		"cpu_used":                sqlVcoreSkuTiers,
+		"cpu_limit":               sqlVcoreSkuTiers,
+		"app_cpu_billed":          sqlVcoreSkuTiers,
+	}
+
+	MetricProfiles = map[string]MetricProfile{
+		"cosmosdb-default": {
+			Description:  "Cosmos DB request units, normalized RU consumption, requests and latency per database, collection and region",
+			ResourceType: "Microsoft.DocumentDB/databaseAccounts",
+			Metrics:      []string{"TotalRequestUnits", "NormalizedRUConsumption", "TotalRequests", "ServerSideLatency"},
+			Aggregations: []string{AggregationDefault},
+			Dimensions:   []string{"DatabaseName", "CollectionName", "Region"},
+			MetricTop:    "500",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"cosmosdb-throttled": {
+			Description:  "Cosmos DB throttled requests (status code 429) per database, collection and region",
+			ResourceType: "Microsoft.DocumentDB/databaseAccounts",
+			Metrics:      []string{"TotalRequests"},
+			Aggregations: []string{"count"},
+			Dimensions:   []string{"DatabaseName", "CollectionName", "Region"},
+			MetricFilter: "StatusCode eq '429'",
+			MetricTop:    "500",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"storageaccount-default": {
+			Description:  "Storage account capacity, transactions and latency",
+			ResourceType: "Microsoft.Storage/storageAccounts",
+			Metrics:      []string{"UsedCapacity", "Transactions", "Ingress", "Egress", "SuccessServerLatency", "SuccessE2ELatency", "Availability"},
+			Aggregations: []string{"average", "total"},
+			Interval:     "PT1H",
+			Timespan:     "PT1H",
+		},
+		"storageaccount-services": {
+			Description:     "Storage account and blob, file, queue, table service capacity and transactions (service label)",
+			ResourceType:    "Microsoft.Storage/storageAccounts",
+			Metrics:         []string{"UsedCapacity", "Transactions", "Ingress", "Egress", "SuccessServerLatency", "Availability", "BlobCapacity", "BlobCount", "ContainerCount", "FileCapacity", "FileCount", "FileShareCount", "QueueCapacity", "QueueCount", "QueueMessageCount", "TableCapacity", "TableCount", "TableEntityCount"},
+			Aggregations:    []string{"average", "total"},
+			Interval:        "PT1H",
+			Timespan:        "PT1H",
+			StorageServices: []string{StorageServicesAll},
+		},
+		"frontdoor-endpoint": {
+			Description:  "Front Door (Standard/Premium) and CDN requests, latency and errors per endpoint",
+			ResourceType: "Microsoft.Cdn/profiles",
+			Metrics:      []string{"RequestCount", "TotalLatency", "ByteHitRatio", "Percentage4XX", "Percentage5XX"},
+			Aggregations: []string{"total", "average"},
+			Dimensions:   []string{"Endpoint"},
+			MetricTop:    "100",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"frontdoor-origin": {
+			Description:  "Front Door (Standard/Premium) and CDN origin health, requests and latency per origin",
+			ResourceType: "Microsoft.Cdn/profiles",
+			Metrics:      []string{"OriginHealthPercentage", "OriginRequestCount", "OriginLatency"},
+			Aggregations: []string{"average", "total"},
+			Dimensions:   []string{"Origin"},
+			MetricTop:    "100",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"frontdoor-country": {
+			Description:  "Front Door (Standard/Premium) and CDN requests, latency and errors per client country",
+			ResourceType: "Microsoft.Cdn/profiles",
+			Metrics:      []string{"RequestCount", "TotalLatency", "Percentage4XX", "Percentage5XX"},
+			Aggregations: []string{"total", "average"},
+			Dimensions:   []string{"ClientCountry"},
+			MetricTop:    "50",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"frontdoorclassic-backend": {
+			Description:  "Front Door (classic) backend health, requests and latency per backend",
+			ResourceType: "Microsoft.Network/frontdoors",
+			Metrics:      []string{"BackendHealthPercentage", "BackendRequestCount", "BackendRequestLatency"},
+			Aggregations: []string{"average", "total"},
+			Dimensions:   []string{"Backend"},
+			MetricTop:    "100",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"frontdoorclassic-country": {
+			Description:  "Front Door (classic) requests and latency per client country",
+			ResourceType: "Microsoft.Network/frontdoors",
+			Metrics:      []string{"RequestCount", "TotalLatency"},
+			Aggregations: []string{"total", "average"},
+			Dimensions:   []string{"ClientCountry"},
+			MetricTop:    "50",
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"vm-default": {
+			Description:  "Virtual machine cpu, memory, network and disk",
+			ResourceType: "Microsoft.Compute/virtualMachines",
+			Metrics:      []string{"Percentage CPU", "Available Memory Bytes", "Network In Total", "Network Out Total", "Disk Read Bytes", "Disk Write Bytes", "Disk Read Operations/Sec", "Disk Write Operations/Sec"},
+			Aggregations: []string{"average", "maximum"},
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"aks-default": {
+			Description:  "AKS control plane (apiserver, inflight requests), nodes and pods with cpu, memory and network of the node pools (nodePool label)",
+			ResourceType: AksResourceType,
+			Metrics:      []string{"apiserver_current_inflight_requests", "apiserver_cpu_usage_percentage", "apiserver_memory_usage_percentage", "kube_node_status_condition", "kube_node_status_allocatable_cpu_cores", "kube_node_status_allocatable_memory_bytes", "kube_pod_status_ready", "node_cpu_usage_percentage", "node_memory_working_set_percentage", "Percentage CPU", "Available Memory Bytes", "Network In Total", "Network Out Total"},
+			Aggregations: []string{"average", "maximum"},
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+			AksNodePools: true,
+		},
+		"appservice-http": {
+			Description:  "App Service requests, http status codes and response time",
+			ResourceType: "Microsoft.Web/sites",
+			Metrics:      []string{"Requests", "Http2xx", "Http3xx", "Http4xx", "Http5xx", "HttpResponseTime"},
+			Aggregations: []string{"total", "average"},
+			Interval:     "PT1M",
+			Timespan:     "PT1M",
+		},
+		"keyvault-default": {
+			Description:  "KeyVault availability, api hits and latency",
+			ResourceType: "Microsoft.KeyVault/vaults",
+			Metrics:      []string{"Availability", "ServiceApiHit", "ServiceApiLatency"},
+			Aggregations: []string{"average", "total"},
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+		"redis-default": {
+			Description:  "Redis clients, commands, cache hits/misses, memory and load",
+			ResourceType: "Microsoft.Cache/Redis",
+			Metrics:      []string{"connectedclients", "totalcommandsprocessed", "cachehits", "cachemisses", "usedmemory", "serverLoad", "percentProcessorTime"},
+			Aggregations: []string{"average", "maximum"},
+			Interval:     "PT1M",
+			Timespan:     "PT1M",
+		},
+		"sqldatabase-default": {
+			Description:    "SQL database cpu, dtu, storage and connections",
+			ResourceType:   "Microsoft.Sql/servers/databases",
+			Metrics:        []string{"cpu_percent", "dtu_consumption_percent", "storage_percent", "connection_successful", "connection_failed", "deadlock"},
+			Aggregations:   []string{"average", "maximum", "total"},
+			Interval:       "PT5M",
+			Timespan:       "PT5M",
+			SkuTierMetrics: sqlSkuTierMetrics,
+		},
+		"sqlelasticpool-default": {
+			Description:    "SQL elastic pool cpu, dtu/vCore, storage, workers and sessions",
+			ResourceType:   "Microsoft.Sql/servers/elasticPools",
+			Metrics:        []string{"cpu_percent", "dtu_consumption_percent", "eDTU_used", "eDTU_limit", "cpu_used", "cpu_limit", "storage_percent", "allocated_data_storage", "log_write_percent", "workers_percent", "sessions_percent"},
+			Aggregations:   []string{"average", "maximum"},
+			Interval:       "PT5M",
+			Timespan:       "PT5M",
+			SkuTierMetrics: sqlSkuTierMetrics,
+		},
+		"sqlelasticpool-database": {
+			Description:    "SQL elastic pool cpu, dtu, io and workers per database of the pool",
+			ResourceType:   "Microsoft.Sql/servers/elasticPools",
+			Metrics:        []string{"cpu_percent", "dtu_consumption_percent", "eDTU_used", "log_write_percent", "physical_data_read_percent", "workers_percent"},
+			Aggregations:   []string{"average", "maximum"},
+			Dimensions:     []string{"DatabaseResourceId"},
+			MetricTop:      "500",
+			Interval:       "PT5M",
+			Timespan:       "PT5M",
+			SkuTierMetrics: sqlSkuTierMetrics,
+		},
+		"sqlmanagedinstance-default": {
+			Description:  "SQL managed instance cpu, io, storage and vCores",
+			ResourceType: "Microsoft.Sql/managedInstances",
+			Metrics:      []string{"avg_cpu_percent", "io_requests", "io_bytes_read", "io_bytes_written", "storage_space_used_mb", "reserved_storage_mb", "virtual_core_count"},
+			Aggregations: []string{"average", "maximum"},
+			Interval:     "PT5M",
+			Timespan:     "PT5M",
+		},
+	}
+)
+
+// MetricProfileNames returns the sorted names of the built-in profiles
+func MetricProfileNames() []string {
+	names := make([]string, 0, len(MetricProfiles))
+	for name := range MetricProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyMetricProfile returns params with the values of the profile (param profile) for all parameters which are not set
+func applyMetricProfile(params url.Values) (url.Values, error) {
+	profileName := params.Get("profile")
+	if profileName == "" {
+		return params, nil
+	}
+
+	profile, exists := MetricProfiles[strings.ToLower(profileName)]
+	if !exists {
+		return params, fmt.Errorf(`profile "%s" not found, available profiles: %s`, profileName, strings.Join(MetricProfileNames(), ", "))
+	}
+
+	ret := url.Values{}
+	for name, values := range params {
+		ret[name] = values
+	}
+
+	setDefault := func(name string, values ...string) {
+		if len(ret[name]) == 0 && len(values) > 0 && values[0] != "" {
+			ret[name] = values
+		}
+	}
+
+	// resource type is only used as default if no filter is set
+	if ret.Get("filter") == "" {
+		setDefault("resourceType", profile.ResourceType)
+	}
+	setDefault("metricNamespace", profile.MetricNamespace)
+	setDefault("metric", profile.Metrics...)
+	setDefault("aggregation", profile.Aggregations...)
+	setDefault("dimension", profile.Dimensions...)
+	setDefault("metricFilter", profile.MetricFilter)
+	setDefault("metricTop", profile.MetricTop)
+	setDefault("interval", profile.Interval)
+	setDefault("timespan", profile.Timespan)
+	setDefault("storageServices", profile.StorageServices...)
+	if profile.AksNodePools {
+		setDefault("aksNodePools", "true")
+	}
+
+	return ret, nil
+}
+
+// profileTargetMetrics returns the metrics of the target without the metrics of the profile which are not available for
+// the sku tier of the target, targets without sku tier (eg. /probe/metrics/resource) request all metrics
+func (p *MetricProber) profileTargetMetrics(target MetricProbeTarget) []string {
+	if p.settings.Profile == "" || target.SkuTier == "" {
+		return target.Metrics
+	}
+
+	profile, exists := MetricProfiles[p.settings.Profile]
+	if !exists || len(profile.SkuTierMetrics) == 0 {
+		return target.Metrics
+	}
+
+	ret := []string{}
+	for _, metric := range target.Metrics {
+		if skuTiers, exists := profile.SkuTierMetrics[strings.ToLower(metric)]; exists && !stringListContainsFold(skuTiers, target.SkuTier) {
+			continue
+		}
+		ret = append(ret, metric)
+	}
+	return ret
+}
@@ -0,0 +1,271 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	QuotaProviderCompute = "compute"
+	QuotaProviderNetwork = "network"
+	QuotaProviderStorage = "storage"
+)
+
+var (
+	// QuotaProviders are the resource providers of the Usage APIs (provider => namespace, api version)
+	QuotaProviders = map[string]struct {
+		Namespace  string
+		ApiVersion string
+	}{
+		QuotaProviderCompute: {Namespace: "Microsoft.Compute", ApiVersion: "2023-07-01"},
+		QuotaProviderNetwork: {Namespace: "Microsoft.Network", ApiVersion: "2023-05-01"},
+		QuotaProviderStorage: {Namespace: "Microsoft.Storage", ApiVersion: "2023-01-01"},
+	}
+
+	// QuotaProviderNames are the providers of parameter provider (in order)
+	QuotaProviderNames = []string{QuotaProviderCompute, QuotaProviderNetwork, QuotaProviderStorage}
+)
+
+type (
+	// QuotaClient fetches the usages and limits of the subscription quotas (Usage APIs)
+	QuotaClient struct {
+		client *arm.Client
+	}
+
+	QuotaSettings struct {
+		Subscriptions []string
+		Locations     []string
+		Providers     []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	QuotaResult struct {
+		Usages []QuotaUsage `json:"usages"`
+	}
+
+	QuotaUsage struct {
+		Provider  string  `json:"provider"`
+		Location  string  `json:"location"`
+		Quota     string  `json:"quota"`
+		QuotaName string  `json:"quotaName"`
+		Unit      string  `json:"unit"`
+		Usage     float64 `json:"usage"`
+		Limit     float64 `json:"limit"`
+	}
+
+	quotaUsageResponse struct {
+		Value []struct {
+			Name struct {
+				Value          string `json:"value"`
+				LocalizedValue string `json:"localizedValue"`
+			} `json:"name"`
+			CurrentValue float64 `json:"currentValue"`
+			Limit        float64 `json:"limit"`
+			Unit         string  `json:"unit"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewQuotaSettings(r *http.Request) (QuotaSettings, error) {
+	ret := QuotaSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param location
+	if val, err := paramsGetList(params, "location"); err == nil && len(val) > 0 {
+		for _, location := range val {
+			ret.Locations = append(ret.Locations, strings.ToLower(strings.ReplaceAll(location, " ", "")))
+		}
+	} else {
+		return ret, fmt.Errorf("parameter \"location\" is missing")
+	}
+
+	// param provider
+	if val, err := paramsGetList(params, "provider"); err == nil {
+		for _, provider := range val {
+			provider = strings.ToLower(provider)
+			if _, exists := QuotaProviders[provider]; !exists {
+				return ret, fmt.Errorf(`parameter "provider" must be one of %s`, strings.Join(QuotaProviderNames, ", "))
+			}
+			ret.Providers = append(ret.Providers, provider)
+		}
+	} else {
+		return ret, err
+	}
+	if len(ret.Providers) == 0 {
+		ret.Providers = QuotaProviderNames
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewQuotaClient creates a client for the Usage APIs of the configured cloud
+func NewQuotaClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*QuotaClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuotaClient{client: client}, nil
+}
+
+// ListUsages returns the usages and limits of the quotas of the subscription per provider and location
+func (c *QuotaClient) ListUsages(ctx context.Context, subscriptionId string, settings QuotaSettings) ([]QuotaUsage, error) {
+	ret := []QuotaUsage{}
+	for _, provider := range settings.Providers {
+		for _, location := range settings.Locations {
+			endpoint := fmt.Sprintf(
+				"%s/subscriptions/%s/providers/%s/locations/%s/usages?api-version=%s",
+				strings.TrimRight(c.client.Endpoint(), "/"),
+				url.PathEscape(subscriptionId),
+				QuotaProviders[provider].Namespace,
+				url.PathEscape(location),
+				QuotaProviders[provider].ApiVersion,
+			)
+
+			for endpoint != "" {
+				response := quotaUsageResponse{}
+				if err := c.get(ctx, endpoint, &response); err != nil {
+					return nil, fmt.Errorf(`unable to list %s usages of subscription "%s" in location "%s": %w`, provider, subscriptionId, location, err)
+				}
+
+				for _, row := range response.Value {
+					ret = append(ret, QuotaUsage{
+						Provider:  provider,
+						Location:  location,
+						Quota:     row.Name.Value,
+						QuotaName: row.Name.LocalizedValue,
+						Unit:      row.Unit,
+						Usage:     row.CurrentValue,
+						Limit:     row.Limit,
+					})
+				}
+
+				endpoint = response.NextLink
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (c *QuotaClient) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+// PublishQuotaResult publishes the usage (azurerm_quota_usage) and limit (azurerm_quota_limit) of the quotas
+// and the utilization of quotas with limit (azurerm_quota_utilization, usage / limit)
+func PublishQuotaResult(registry *prometheus.Registry, results map[string]*QuotaResult) {
+	labels := []string{
+		"subscriptionID",
+		"provider",
+		"location",
+		"quota",
+		"quotaName",
+		"unit",
+	}
+
+	quotaUsage := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_usage",
+			Help: "Azure quota current usage",
+		},
+		labels,
+	)
+	registry.MustRegister(quotaUsage)
+
+	quotaLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_limit",
+			Help: "Azure quota limit",
+		},
+		labels,
+	)
+	registry.MustRegister(quotaLimit)
+
+	quotaUtilization := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_quota_utilization",
+			Help: "Azure quota utilization (usage / limit)",
+		},
+		labels,
+	)
+	registry.MustRegister(quotaUtilization)
+
+	for subscriptionId, result := range results {
+		for _, usage := range result.Usages {
+			usageLabels := prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"provider":       usage.Provider,
+				"location":       usage.Location,
+				"quota":          usage.Quota,
+				"quotaName":      usage.QuotaName,
+				"unit":           usage.Unit,
+			}
+
+			quotaUsage.With(usageLabels).Set(usage.Usage)
+			quotaLimit.With(usageLabels).Set(usage.Limit)
+			if usage.Limit > 0 {
+				quotaUtilization.With(usageLabels).Set(usage.Usage / usage.Limit)
+			}
+		}
+	}
+}
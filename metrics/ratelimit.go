@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	PrometheusWorkerQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_worker_queue_depth",
+			Help: "Azure metrics exporter metric requests waiting for a free worker",
+		},
+	)
+
+	PrometheusWorkerActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_worker_active",
+			Help: "Azure metrics exporter metric requests currently running",
+		},
+	)
+
+	PrometheusRateLimitThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_ratelimit_throttled",
+			Help: "Azure metrics exporter metric requests delayed by the internal rate limiter",
+		},
+		[]string{
+			"subscriptionID",
+		},
+	)
+
+	PrometheusMetricsBatchThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_metrics_batch_throttled",
+			Help: "Azure metrics exporter metrics:getBatch requests delayed by the regional rate limiter",
+		},
+		[]string{
+			"region",
+		},
+	)
+)
+
+type (
+	// WorkerPool limits the concurrent metric requests across all probes
+	WorkerPool struct {
+		slots chan struct{}
+	}
+
+	// RateLimiter is a token bucket rate limiter per subscription (or region of the metrics data plane)
+	RateLimiter struct {
+		rate  float64
+		burst float64
+
+		throttled *prometheus.CounterVec
+
+		lock    sync.Mutex
+		buckets map[string]*tokenBucket
+	}
+
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+func NewWorkerPool(size int) *WorkerPool {
+	pool := WorkerPool{}
+	pool.slots = make(chan struct{}, size)
+	return &pool
+}
+
+// Acquire waits for a free worker slot, Release must be called after the request is finished
+func (w *WorkerPool) Acquire(ctx context.Context) error {
+	PrometheusWorkerQueueDepth.Inc()
+	defer PrometheusWorkerQueueDepth.Dec()
+
+	select {
+	case w.slots <- struct{}{}:
+		PrometheusWorkerActive.Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *WorkerPool) Release() {
+	<-w.slots
+	PrometheusWorkerActive.Dec()
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	limiter := RateLimiter{}
+	limiter.rate = rate
+	limiter.burst = float64(burst)
+	if limiter.burst < 1 {
+		limiter.burst = 1
+	}
+	limiter.buckets = map[string]*tokenBucket{}
+	limiter.throttled = PrometheusRateLimitThrottled
+	return &limiter
+}
+
+// NewRegionRateLimiter creates a rate limiter per region for the metrics data plane (metrics:getBatch)
+func NewRegionRateLimiter(rate float64, burst int) *RateLimiter {
+	limiter := NewRateLimiter(rate, burst)
+	limiter.throttled = PrometheusMetricsBatchThrottled
+	return limiter
+}
+
+// Wait blocks until a request for the subscription (or region) is allowed
+func (l *RateLimiter) Wait(ctx context.Context, subscriptionId string) error {
+	delay := l.reserve(subscriptionId)
+	if delay <= 0 {
+		return nil
+	}
+
+	l.throttled.WithLabelValues(subscriptionId).Inc()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes one token from the bucket and returns the time until the token is available
+func (l *RateLimiter) reserve(subscriptionId string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	bucket, exists := l.buckets[subscriptionId]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[subscriptionId] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-bucket.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// probe priorities (parameter priority), low priority probes are delayed first when the ARM quota is low
+	ProbePriorityLow    = "low"
+	ProbePriorityNormal = "normal"
+	ProbePriorityHigh   = "high"
+
+	// observed quota is ignored after, so delayed requests refresh the quota
+	quotaObservationMaxAge = 1 * time.Minute
+	quotaWaitInterval      = 5 * time.Second
+
+	rateLimitRemainingHeaderPrefix = "x-ms-ratelimit-remaining-"
+)
+
+var (
+	ProbePriorities = []string{ProbePriorityLow, ProbePriorityNormal, ProbePriorityHigh}
+
+	PrometheusRateLimitRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_ratelimit_remaining",
+			Help: "Azure metrics exporter remaining ARM quota observed from x-ms-ratelimit-remaining-* response headers",
+		},
+		[]string{
+			"subscriptionID",
+			"type",
+		},
+	)
+
+	PrometheusRateLimitDelayed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_ratelimit_delayed",
+			Help: "Azure metrics exporter metric requests delayed because the remaining ARM quota is below the reserve",
+		},
+		[]string{
+			"subscriptionID",
+			"priority",
+		},
+	)
+)
+
+type (
+	// QuotaCoordinator tracks the remaining ARM quota per subscription (x-ms-ratelimit-remaining-* headers) across
+	// all probes and delays requests of lower priority probes while the quota is below the reserve
+	QuotaCoordinator struct {
+		reserve int64
+
+		lock  sync.RWMutex
+		quota map[string]map[string]quotaObservation
+	}
+
+	quotaObservation struct {
+		remaining int64
+		observed  time.Time
+	}
+
+	// quotaPolicy reports the remaining quota of every response to the coordinator
+	quotaPolicy struct {
+		coordinator *QuotaCoordinator
+	}
+)
+
+func NewQuotaCoordinator(reserve int64) *QuotaCoordinator {
+	c := QuotaCoordinator{}
+	c.reserve = reserve
+	c.quota = map[string]map[string]quotaObservation{}
+	return &c
+}
+
+// Observe records the remaining quota of the subscription for the quota type (eg. subscription-reads)
+func (c *QuotaCoordinator) Observe(subscriptionId, quotaType string, remaining int64) {
+	subscriptionId = strings.ToLower(subscriptionId)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, exists := c.quota[subscriptionId]; !exists {
+		c.quota[subscriptionId] = map[string]quotaObservation{}
+	}
+	c.quota[subscriptionId][quotaType] = quotaObservation{remaining: remaining, observed: time.Now()}
+
+	PrometheusRateLimitRemaining.WithLabelValues(subscriptionId, quotaType).Set(float64(remaining))
+}
+
+// Remaining returns the lowest remaining quota of the subscription observed within the last minute
+func (c *QuotaCoordinator) Remaining(subscriptionId string) (remaining int64, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, observation := range c.quota[strings.ToLower(subscriptionId)] {
+		if time.Since(observation.observed) > quotaObservationMaxAge {
+			continue
+		}
+		if !ok || observation.remaining < remaining {
+			remaining = observation.remaining
+			ok = true
+		}
+	}
+	return
+}
+
+// Wait blocks while the remaining quota of the subscription is below the threshold of the priority,
+// low priority probes wait below the reserve, normal priority probes below half of the reserve
+// and high priority probes are never delayed
+func (c *QuotaCoordinator) Wait(ctx context.Context, subscriptionId, priority string) error {
+	var threshold int64
+	switch priority {
+	case ProbePriorityLow:
+		threshold = c.reserve
+	case ProbePriorityNormal:
+		threshold = c.reserve / 2
+	default:
+		return nil
+	}
+
+	delayed := false
+	for {
+		remaining, ok := c.Remaining(subscriptionId)
+		if !ok || remaining >= threshold {
+			return nil
+		}
+
+		if !delayed {
+			PrometheusRateLimitDelayed.WithLabelValues(strings.ToLower(subscriptionId), priority).Inc()
+			delayed = true
+		}
+
+		timer := time.NewTimer(quotaWaitInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("remaining ARM quota of subscription %s below reserve (%d): %w", subscriptionId, remaining, ctx.Err())
+		}
+	}
+}
+
+// Policy returns the pipeline policy which reports the remaining quota of responses
+func (c *QuotaCoordinator) Policy() policy.Policy {
+	return quotaPolicy{coordinator: c}
+}
+
+func (p quotaPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if resp == nil {
+		return resp, err
+	}
+
+	subscriptionId := subscriptionIdFromUrlPath(req.Raw().URL.Path)
+	if subscriptionId == "" {
+		return resp, err
+	}
+
+	for name, values := range resp.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, rateLimitRemainingHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+
+		if remaining, parseErr := strconv.ParseInt(values[0], 10, 64); parseErr == nil {
+			p.coordinator.Observe(subscriptionId, strings.TrimPrefix(name, rateLimitRemainingHeaderPrefix), remaining)
+		}
+	}
+
+	return resp, err
+}
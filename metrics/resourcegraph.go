@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+var (
+	PrometheusResourceGraphTruncated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_resourcegraph_truncated",
+			Help: "Azure metrics exporter ResourceGraph query results cut off (--azure.resourcegraph.max-rows or truncated by ResourceGraph)",
+		},
+		[]string{
+			"query",
+		},
+	)
+)
+
+// queryResourceGraph runs the ResourceGraph query and passes all rows to the callback, pages of ResourceGraphQueryTop rows
+// are requested until ResourceGraph returns no continuation (skipToken) or maxRows (0 = unlimited) rows are fetched,
+// returns if the result was cut off (maxRows or truncated by ResourceGraph, eg. queries without id column), counted
+// as azurerm_stats_resourcegraph_truncated with the query name
+func queryResourceGraph(ctx context.Context, client *armresourcegraph.Client, name, query string, subscriptions []string, maxRows int, callback func(row map[string]interface{})) (truncated bool, err error) {
+	queryFormat := armresourcegraph.ResultFormatObjectArray
+	queryTop := int32(ResourceGraphQueryTop)
+	queryRequest := armresourcegraph.QueryRequest{
+		Query: to.StringPtr(query),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: &queryFormat,
+			Top:          &queryTop,
+		},
+		Subscriptions: to.SlicePtr(subscriptions),
+	}
+
+	rows := 0
+	for {
+		if maxRows > 0 && maxRows-rows < ResourceGraphQueryTop {
+			queryTop = int32(maxRows - rows)
+		}
+
+		result, err := client.Resources(ctx, queryRequest, nil)
+		if err != nil {
+			return false, err
+		}
+
+		resultList, _ := result.Data.([]interface{})
+		for _, v := range resultList {
+			if row, ok := v.(map[string]interface{}); ok {
+				callback(row)
+			}
+		}
+		rows += len(resultList)
+
+		if result.ResultTruncated != nil && *result.ResultTruncated == armresourcegraph.ResultTruncatedTrue {
+			truncated = true
+		}
+
+		// no continuation (or empty page)
+		if result.SkipToken == nil || *result.SkipToken == "" || len(resultList) == 0 {
+			break
+		}
+
+		// more rows available
+		if maxRows > 0 && rows >= maxRows {
+			truncated = true
+			break
+		}
+
+		queryRequest.Options.SkipToken = result.SkipToken
+	}
+
+	if truncated {
+		PrometheusResourceGraphTruncated.WithLabelValues(name).Inc()
+	}
+
+	return truncated, nil
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+const (
+	// prefix of query parameters (eg. param.env=prod)
+	ResourceGraphQueryParamPrefix = "param."
+)
+
+type (
+	// ResourceGraphQueries are the named Kusto filters of the config file (queries) for the resourcegraph probe
+	ResourceGraphQueries struct {
+		queries map[string]config.QueryConfig
+	}
+)
+
+func NewResourceGraphQueries(queryConfigs map[string]config.QueryConfig) *ResourceGraphQueries {
+	ret := ResourceGraphQueries{}
+	ret.queries = map[string]config.QueryConfig{}
+	for name, query := range queryConfigs {
+		ret.queries[strings.ToLower(name)] = query
+	}
+	return &ret
+}
+
+// Names returns the sorted names of the queries
+func (q *ResourceGraphQueries) Names() []string {
+	names := []string{}
+	if q == nil {
+		return names
+	}
+
+	for name := range q.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render returns the resource type and the Kusto filter of the query, parameters are inserted as string literals
+// (missing parameters use the default value of the query, unknown parameters are rejected)
+func (q *ResourceGraphQueries) Render(name string, params map[string]string) (resourceType, filter string, err error) {
+	var query config.QueryConfig
+	exists := false
+	if q != nil {
+		query, exists = q.queries[strings.ToLower(name)]
+	}
+	if !exists {
+		return "", "", fmt.Errorf(`query "%s" not found, available queries: %s`, name, strings.Join(q.Names(), ", "))
+	}
+
+	for paramName := range params {
+		if _, exists := query.Params[paramName]; !exists {
+			return "", "", fmt.Errorf(`parameter "%s%s" is not defined by query "%s"`, ResourceGraphQueryParamPrefix, paramName, name)
+		}
+	}
+
+	values := map[string]string{}
+	for paramName, defaultValue := range query.Params {
+		value := defaultValue
+		if val, exists := params[paramName]; exists {
+			value = val
+		}
+
+		if value == "" {
+			return "", "", fmt.Errorf(`parameter "%s%s" is required by query "%s"`, ResourceGraphQueryParamPrefix, paramName, name)
+		}
+		values[paramName] = value
+	}
+
+	filter = config.QueryParamRegexp.ReplaceAllStringFunc(query.Filter, func(match string) string {
+		paramName := config.QueryParamRegexp.FindStringSubmatch(match)[1]
+		return kustoStringLiteral(values[paramName])
+	})
+
+	return query.ResourceType, filter, nil
+}
+
+// kustoStringLiteral returns the value as double quoted Kusto string literal
+func kustoStringLiteral(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	val = strings.ReplaceAll(val, `"`, `\"`)
+	val = strings.ReplaceAll(val, "\n", `\n`)
+	val = strings.ReplaceAll(val, "\r", `\r`)
+	return `"` + val + `"`
+}
@@ -0,0 +1,360 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+const (
+	ResourceHealthApiVersion = "2022-10-01"
+)
+
+var (
+	// ResourceHealthStates are the availability states of Resource Health (enum-style metric)
+	ResourceHealthStates = []string{"Available", "Degraded", "Unavailable", "Unknown"}
+)
+
+type (
+	// ResourceHealthClient fetches availability states (Resource Health) and events (Service Health)
+	ResourceHealthClient struct {
+		client *arm.Client
+	}
+
+	ResourceHealthSettings struct {
+		Subscriptions []string
+		ResourceTypes []string
+		Events        bool
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	ResourceHealthResult struct {
+		Statuses []ResourceHealthStatus `json:"statuses"`
+		Events   []ServiceHealthEvent   `json:"events"`
+	}
+
+	ResourceHealthStatus struct {
+		ResourceId        string `json:"resourceId"`
+		AvailabilityState string `json:"availabilityState"`
+		ReasonType        string `json:"reasonType"`
+	}
+
+	ServiceHealthEvent struct {
+		TrackingId string   `json:"trackingId"`
+		EventType  string   `json:"eventType"`
+		Level      string   `json:"level"`
+		Title      string   `json:"title"`
+		Services   []string `json:"services"`
+		Regions    []string `json:"regions"`
+	}
+
+	resourceHealthStatusResponse struct {
+		Value []struct {
+			ID         string `json:"id"`
+			Properties struct {
+				AvailabilityState string `json:"availabilityState"`
+				ReasonType        string `json:"reasonType"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+
+	serviceHealthEventResponse struct {
+		Value []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				EventType  string `json:"eventType"`
+				Status     string `json:"status"`
+				Title      string `json:"title"`
+				EventLevel string `json:"eventLevel"`
+				Impact     []struct {
+					ImpactedService string `json:"impactedService"`
+					ImpactedRegions []struct {
+						ImpactedRegion string `json:"impactedRegion"`
+					} `json:"impactedRegions"`
+				} `json:"impact"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+func NewResourceHealthSettings(r *http.Request) (ResourceHealthSettings, error) {
+	ret := ResourceHealthSettings{}
+	params := r.URL.Query()
+
+	// param subscription
+	if val, err := paramsGetList(params, "subscription"); err == nil && len(val) > 0 {
+		ret.Subscriptions = val
+	} else {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
+	// param resourceType
+	if val, err := paramsGetList(params, "resourceType"); err == nil {
+		ret.ResourceTypes = val
+	} else {
+		return ret, err
+	}
+
+	// param events
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "events", "true")); err == nil {
+		ret.Events = val
+	} else {
+		return ret, err
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewResourceHealthClient creates a client for the Resource Health api of the configured cloud
+func NewResourceHealthClient(cred azcore.TokenCredential, clientOpts *arm.ClientOptions) (*ResourceHealthClient, error) {
+	client, err := arm.NewClient("azure-metrics-exporter", "", cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResourceHealthClient{client: client}, nil
+}
+
+// ListAvailabilityStatuses returns the current availability state of all resources of the subscription
+func (c *ResourceHealthClient) ListAvailabilityStatuses(ctx context.Context, subscriptionId string, resourceTypes []string) ([]ResourceHealthStatus, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.ResourceHealth/availabilityStatuses?api-version=%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		ResourceHealthApiVersion,
+	)
+
+	ret := []ResourceHealthStatus{}
+	for endpoint != "" {
+		response := resourceHealthStatusResponse{}
+		if err := c.get(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list availability statuses of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			// id is the status of the resource: {resourceId}/providers/Microsoft.ResourceHealth/availabilityStatuses/current
+			resourceId := row.ID
+			if i := strings.Index(strings.ToLower(resourceId), "/providers/microsoft.resourcehealth/"); i > 0 {
+				resourceId = resourceId[:i]
+			}
+
+			if !resourceHealthMatchesType(resourceId, resourceTypes) {
+				continue
+			}
+
+			ret = append(ret, ResourceHealthStatus{
+				ResourceId:        resourceId,
+				AvailabilityState: row.Properties.AvailabilityState,
+				ReasonType:        row.Properties.ReasonType,
+			})
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+// ListActiveEvents returns the active Service Health events (service issues, maintenance, advisories) of the subscription
+func (c *ResourceHealthClient) ListActiveEvents(ctx context.Context, subscriptionId string) ([]ServiceHealthEvent, error) {
+	endpoint := fmt.Sprintf(
+		"%s/subscriptions/%s/providers/Microsoft.ResourceHealth/events?api-version=%s&$filter=%s",
+		strings.TrimRight(c.client.Endpoint(), "/"),
+		url.PathEscape(subscriptionId),
+		ResourceHealthApiVersion,
+		url.QueryEscape("Properties/Status eq 'Active'"),
+	)
+
+	ret := []ServiceHealthEvent{}
+	for endpoint != "" {
+		response := serviceHealthEventResponse{}
+		if err := c.get(ctx, endpoint, &response); err != nil {
+			return nil, fmt.Errorf(`unable to list service health events of subscription "%s": %w`, subscriptionId, err)
+		}
+
+		for _, row := range response.Value {
+			if !strings.EqualFold(row.Properties.Status, "Active") {
+				continue
+			}
+
+			event := ServiceHealthEvent{
+				TrackingId: row.Name,
+				EventType:  row.Properties.EventType,
+				Level:      row.Properties.EventLevel,
+				Title:      row.Properties.Title,
+			}
+			for _, impact := range row.Properties.Impact {
+				event.Services = append(event.Services, impact.ImpactedService)
+				for _, region := range impact.ImpactedRegions {
+					event.Regions = append(event.Regions, region.ImpactedRegion)
+				}
+			}
+			ret = append(ret, event)
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
+
+func (c *ResourceHealthClient) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return err
+	}
+	req.Raw().Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Pipeline().Do(req)
+	if err != nil {
+		return err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return runtime.NewResponseError(resp)
+	}
+
+	return runtime.UnmarshalAsJSON(resp, result)
+}
+
+func resourceHealthMatchesType(resourceId string, resourceTypes []string) bool {
+	if len(resourceTypes) == 0 {
+		return true
+	}
+
+	resourceType := ResourceTypeFromResourceId(resourceId)
+	for _, val := range resourceTypes {
+		if strings.EqualFold(val, resourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishResourceHealthResult publishes the availability states (azurerm_resource_health_status)
+// and active events (azurerm_service_health_event) of the subscriptions
+func PublishResourceHealthResult(registry *prometheus.Registry, results map[string]*ResourceHealthResult) {
+	healthStatus := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_resource_health_status",
+			Help: "Azure Resource Health availability state of resource (1 for the current state)",
+		},
+		[]string{
+			"resourceID",
+			"subscriptionID",
+			"resourceGroup",
+			"resourceType",
+			"reasonType",
+			"state",
+		},
+	)
+	registry.MustRegister(healthStatus)
+
+	healthEvent := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_service_health_event",
+			Help: "Azure Service Health active events (service issues, planned maintenance, advisories)",
+		},
+		[]string{
+			"subscriptionID",
+			"trackingID",
+			"eventType",
+			"level",
+			"title",
+			"services",
+			"regions",
+		},
+	)
+	registry.MustRegister(healthEvent)
+
+	for subscriptionId, result := range results {
+		for _, status := range result.Statuses {
+			resourceGroup := ""
+			if azureResource, err := armclient.ParseResourceId(status.ResourceId); err == nil {
+				resourceGroup = azureResource.ResourceGroup
+			}
+
+			for _, state := range ResourceHealthStates {
+				value := float64(0)
+				if strings.EqualFold(status.AvailabilityState, state) {
+					value = 1
+				}
+
+				healthStatus.With(prometheus.Labels{
+					"resourceID":     strings.ToLower(status.ResourceId),
+					"subscriptionID": subscriptionId,
+					"resourceGroup":  resourceGroup,
+					"resourceType":   strings.ToLower(ResourceTypeFromResourceId(status.ResourceId)),
+					"reasonType":     status.ReasonType,
+					"state":          state,
+				}).Set(value)
+			}
+		}
+
+		for _, event := range result.Events {
+			healthEvent.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"trackingID":     event.TrackingId,
+				"eventType":      event.EventType,
+				"level":          event.Level,
+				"title":          event.Title,
+				"services":       strings.Join(uniqueSortedStrings(event.Services), ","),
+				"regions":        strings.Join(uniqueSortedStrings(event.Regions), ","),
+			}).Set(1)
+		}
+	}
+}
+
+func uniqueSortedStrings(list []string) []string {
+	values := map[string]bool{}
+	for _, val := range list {
+		values[val] = true
+	}
+
+	ret := []string{}
+	for val := range values {
+		ret = append(ret, val)
+	}
+	sort.Strings(ret)
+	return ret
+}
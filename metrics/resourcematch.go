@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+)
+
+var (
+	// valid resource group name (alphanumerics, underscores, hyphens, periods, parentheses and unicode letters,
+	// max 90 characters, not ending with a period)
+	resourceGroupNameRegexp = regexp.MustCompile(`^[-\w.()\p{L}]{0,89}[-\w()\p{L}]$`)
+)
+
+type (
+	// ResourceMatch selects discovered resources by name, resource group and location after enumeration
+	// (parameters resourceName, resourceGroup, location with wildcards and *Regex with regular expressions),
+	// all set fields must match
+	ResourceMatch struct {
+		// lowercase glob patterns (any must match)
+		ResourceNames  []string
+		ResourceGroups []string
+		Locations      []string
+
+		// regular expressions (case-insensitive, anchored)
+		ResourceNameRegexp  *regexp.Regexp
+		ResourceGroupRegexp *regexp.Regexp
+		LocationRegexp      *regexp.Regexp
+	}
+)
+
+// newResourceMatch parses the resource match parameters of the probe
+func newResourceMatch(params url.Values) (ResourceMatch, error) {
+	ret := ResourceMatch{}
+
+	globs := func(name string) ([]string, error) {
+		list, err := paramsGetList(params, name)
+		if err != nil {
+			return nil, err
+		}
+
+		patterns := []string{}
+		for _, pattern := range list {
+			pattern = strings.ToLower(pattern)
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf(`parameter "%s" has an invalid pattern "%s": %w`, name, pattern, err)
+			}
+			patterns = append(patterns, pattern)
+		}
+		return patterns, nil
+	}
+
+	regex := func(name string) (*regexp.Regexp, error) {
+		val := params.Get(name)
+		if val == "" {
+			return nil, nil
+		}
+
+		expr, err := regexp.Compile("^(?i:" + val + ")$")
+		if err != nil {
+			return nil, fmt.Errorf(`parameter "%s" is not a valid regular expression: %w`, name, err)
+		}
+		return expr, nil
+	}
+
+	var err error
+
+	// param resourceName, resourceGroup, location
+	if ret.ResourceNames, err = globs("resourceName"); err != nil {
+		return ret, err
+	}
+	if ret.ResourceGroups, err = globs("resourceGroup"); err != nil {
+		return ret, err
+	}
+	for _, resourceGroup := range ret.ResourceGroups {
+		if !isGlobPattern(resourceGroup) && !resourceGroupNameRegexp.MatchString(resourceGroup) {
+			return ret, fmt.Errorf(`parameter "resourceGroup" has an invalid resource group name "%s"`, resourceGroup)
+		}
+	}
+	if ret.Locations, err = globs("location"); err != nil {
+		return ret, err
+	}
+
+	// param resourceNameRegex, resourceGroupRegex, locationRegex
+	if ret.ResourceNameRegexp, err = regex("resourceNameRegex"); err != nil {
+		return ret, err
+	}
+	if ret.ResourceGroupRegexp, err = regex("resourceGroupRegex"); err != nil {
+		return ret, err
+	}
+	if ret.LocationRegexp, err = regex("locationRegex"); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+// Enabled returns true if any resource match parameter is set
+func (m *ResourceMatch) Enabled() bool {
+	return len(m.ResourceNames) > 0 || len(m.ResourceGroups) > 0 || len(m.Locations) > 0 ||
+		m.ResourceNameRegexp != nil || m.ResourceGroupRegexp != nil || m.LocationRegexp != nil
+}
+
+// ResourceGroupNames returns the resource groups (lowercase, sorted) the discovery is scoped to (ARM list by resource
+// group, ResourceGraph where clause), only if all resourceGroup patterns are names without wildcards
+func (m *ResourceMatch) ResourceGroupNames() []string {
+	if len(m.ResourceGroups) == 0 {
+		return nil
+	}
+
+	ret := []string{}
+	for _, resourceGroup := range m.ResourceGroups {
+		if isGlobPattern(resourceGroup) {
+			return nil
+		}
+		if !stringListContainsFold(ret, resourceGroup) {
+			ret = append(ret, resourceGroup)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Match returns true if the resource matches all set patterns and expressions
+func (m *ResourceMatch) Match(resourceId, location string) bool {
+	if !m.Enabled() {
+		return true
+	}
+
+	resourceInfo, err := armclient.ParseResourceId(resourceId)
+	if err != nil {
+		return false
+	}
+
+	return resourceMatchField(m.ResourceNames, m.ResourceNameRegexp, resourceInfo.ResourceName) &&
+		resourceMatchField(m.ResourceGroups, m.ResourceGroupRegexp, resourceInfo.ResourceGroup) &&
+		resourceMatchField(m.Locations, m.LocationRegexp, location)
+}
+
+// resourceMatchField matches the value against any glob pattern and the regular expression (if set)
+func resourceMatchField(patterns []string, expr *regexp.Regexp, value string) bool {
+	if len(patterns) > 0 {
+		matched := false
+		for _, pattern := range patterns {
+			if matchGlob(pattern, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return expr == nil || expr.MatchString(value)
+}
+
+// isGlobPattern returns true if the pattern contains wildcards
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[\\")
+}
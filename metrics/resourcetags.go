@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/azuresdk/armclient"
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// ResourceTagCache caches the tags of resources, resource groups and subscriptions per credential,
+	// tags are requested with the credential of the probe (credential and tenant parameter)
+	ResourceTagCache struct {
+		cache         *cache.Cache
+		cacheDuration time.Duration
+
+		// one lookup per scope and credential at a time, concurrent probes wait for its result
+		flight singleflight.Group
+	}
+)
+
+func NewResourceTagCache(cacheDuration time.Duration) *ResourceTagCache {
+	c := ResourceTagCache{}
+	c.cache = cache.New(1*time.Minute, 1*time.Minute)
+	c.cacheDuration = cacheDuration
+	return &c
+}
+
+// Get returns the tags of the scope (resource, resource group or subscription id), credentialKey separates the
+// results of credentials with different access
+func (c *ResourceTagCache) Get(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, credentialKey, scope string) (map[string]string, error) {
+	cacheKey := strings.ToLower(credentialKey + "|" + scope)
+
+	if val, ok := c.cache.Get(cacheKey); ok {
+		PrometheusCacheRequests.WithLabelValues("resourcetags", CacheResultHit).Inc()
+		return val.(map[string]string), nil
+	}
+	PrometheusCacheRequests.WithLabelValues("resourcetags", CacheResultMiss).Inc()
+
+	result, err, _ := c.flight.Do(cacheKey, func() (interface{}, error) {
+		tags, err := listResourceTags(ctx, cred, clientOpts, scope)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.Set(cacheKey, tags, c.cacheDuration)
+		return tags, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string]string), nil
+}
+
+// listResourceTags requests the tags of the scope
+func listResourceTags(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, scope string) (map[string]string, error) {
+	resource, err := armclient.ParseResourceId(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := armresources.NewTagsClient(resource.Subscription, cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.GetAtScope(ctx, scope, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`unable to get tags of "%s": %w`, scope, err)
+	}
+
+	tags := map[string]string{}
+	if response.Properties != nil {
+		for name, value := range response.Properties.Tags {
+			if value != nil {
+				tags[name] = strings.TrimSpace(*value)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// addResourceTagLabels adds the resource tags (--azure.resource-tag, tagLabels parameter) as labels, tags are
+// requested with the credential of the probe
+func (p *MetricProber) addResourceTagLabels(labels prometheus.Labels, resourceId string) prometheus.Labels {
+	if p.AzureResourceTagManager == nil {
+		return labels
+	}
+
+	// init label value, every series needs all labels
+	for _, tag := range p.AzureResourceTagManager.Tags {
+		labels[tag.TargetName] = ""
+	}
+
+	if resourceId == "" {
+		return labels
+	}
+
+	resource, err := armclient.ParseResourceId(resourceId)
+	if err != nil {
+		p.logger.Warnf(`unable to fetch resource tags for resource "%s": %v`, resourceId, err)
+		return labels
+	}
+
+	scopes := map[string]string{
+		armclient.AzureTagSourceSubscription: "/subscriptions/" + resource.Subscription,
+	}
+	if resource.ResourceGroup != "" {
+		scopes[armclient.AzureTagSourceResourceGroup] = scopes[armclient.AzureTagSourceSubscription] + "/resourceGroups/" + resource.ResourceGroup
+		if resource.ResourceName != "" {
+			scopes[armclient.AzureTagSourceResource] = resourceId
+		}
+	}
+
+	// tag value of the source, failed lookups are empty
+	fetchTagValue := func(tagName, source string) string {
+		scope, exists := scopes[source]
+		if !exists {
+			return ""
+		}
+
+		tags, err := p.resourceTags(scope)
+		if err != nil {
+			p.logger.Debugf(`unable to fetch tags of "%s": %v`, scope, err)
+			return ""
+		}
+		return tags[tagName]
+	}
+
+	for _, tag := range p.AzureResourceTagManager.Tags {
+		// automatic tag source based on the resource id
+		source := tag.Source
+		if source == "" {
+			source = armclient.AzureTagSourceResource
+			if resource.ResourceName == "" {
+				source = armclient.AzureTagSourceResourceGroup
+			}
+			if resource.ResourceGroup == "" {
+				source = armclient.AzureTagSourceSubscription
+			}
+		}
+
+		value := fetchTagValue(tag.Name, source)
+
+		// inherit from resource group and subscription if empty
+		if tag.Inherit {
+			if value == "" {
+				value = fetchTagValue(tag.Name, armclient.AzureTagSourceResourceGroup)
+			}
+			if value == "" {
+				value = fetchTagValue(tag.Name, armclient.AzureTagSourceSubscription)
+			}
+		}
+
+		if tag.Transform.ToLower {
+			value = strings.ToLower(value)
+		}
+		if tag.Transform.ToUpper {
+			value = strings.ToUpper(value)
+		}
+
+		labels[tag.TargetName] = value
+	}
+
+	return labels
+}
+
+// resourceTags returns the tags of the scope using the credential of the probe
+func (p *MetricProber) resourceTags(scope string) (map[string]string, error) {
+	if p.resourceTagCache == nil {
+		return listResourceTags(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), scope)
+	}
+	return p.resourceTagCache.Get(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), p.credentialKey(), scope)
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/webdevops/go-common/azuresdk/armclient"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+type (
+	// ProbeRestrictions are the allow and deny lists (--restrict.*) for subscriptions, resource groups and resource
+	// types of probes, deny lists take precedence and empty allow lists allow everything
+	ProbeRestrictions struct {
+		allowSubscriptions  []string
+		denySubscriptions   []string
+		allowResourceGroups []string
+		denyResourceGroups  []string
+		allowResourceTypes  []string
+		denyResourceTypes   []string
+	}
+
+	// ProbeRestrictedError is returned if a probe targets a restricted subscription, resource group or resource type (http 403)
+	ProbeRestrictedError struct {
+		message string
+	}
+)
+
+func NewProbeRestrictions(opts config.Opts) *ProbeRestrictions {
+	lower := func(list []string) (ret []string) {
+		for _, val := range list {
+			if val = strings.ToLower(strings.TrimSpace(val)); val != "" {
+				ret = append(ret, val)
+			}
+		}
+		return
+	}
+
+	r := ProbeRestrictions{}
+	r.allowSubscriptions = lower(opts.Restrict.Subscriptions)
+	r.denySubscriptions = lower(opts.Restrict.DenySubscriptions)
+	r.allowResourceGroups = lower(opts.Restrict.ResourceGroups)
+	r.denyResourceGroups = lower(opts.Restrict.DenyResourceGroups)
+	r.allowResourceTypes = lower(opts.Restrict.ResourceTypes)
+	r.denyResourceTypes = lower(opts.Restrict.DenyResourceTypes)
+	return &r
+}
+
+func (e *ProbeRestrictedError) Error() string {
+	return e.message
+}
+
+func newProbeRestrictedError(format string, args ...interface{}) error {
+	return &ProbeRestrictedError{message: fmt.Sprintf(format, args...)}
+}
+
+// RestrictsResourceGroups returns true if resource groups are restricted (not supported by subscription scoped probes)
+func (r *ProbeRestrictions) RestrictsResourceGroups() bool {
+	return r != nil && (len(r.allowResourceGroups) > 0 || len(r.denyResourceGroups) > 0)
+}
+
+func (r *ProbeRestrictions) SubscriptionAllowed(subscriptionId string) bool {
+	if r == nil {
+		return true
+	}
+	return restrictionAllows(r.allowSubscriptions, r.denySubscriptions, subscriptionId, strings.EqualFold)
+}
+
+func (r *ProbeRestrictions) ResourceGroupAllowed(resourceGroup string) bool {
+	if r == nil {
+		return true
+	}
+	return restrictionAllows(r.allowResourceGroups, r.denyResourceGroups, resourceGroup, matchGlob)
+}
+
+func (r *ProbeRestrictions) ResourceTypeAllowed(resourceType string) bool {
+	if r == nil {
+		return true
+	}
+	return restrictionAllows(r.allowResourceTypes, r.denyResourceTypes, resourceType, strings.EqualFold)
+}
+
+// FilterSubscriptions removes restricted subscriptions (eg. of subscription discovery)
+func (r *ProbeRestrictions) FilterSubscriptions(subscriptions []string) (ret []string) {
+	for _, subscriptionId := range subscriptions {
+		if r.SubscriptionAllowed(subscriptionId) {
+			ret = append(ret, subscriptionId)
+		}
+	}
+	return
+}
+
+// CheckSubscriptions returns a ProbeRestrictedError for the first restricted subscription
+func (r *ProbeRestrictions) CheckSubscriptions(subscriptions ...string) error {
+	for _, subscriptionId := range subscriptions {
+		if !r.SubscriptionAllowed(subscriptionId) {
+			return newProbeRestrictedError(`subscription "%s" is not allowed for probes of this exporter`, subscriptionId)
+		}
+	}
+	return nil
+}
+
+// CheckResourceTypes returns a ProbeRestrictedError for the first restricted resource type
+func (r *ProbeRestrictions) CheckResourceTypes(resourceTypes ...string) error {
+	for _, resourceType := range resourceTypes {
+		if resourceType != "" && !r.ResourceTypeAllowed(resourceType) {
+			return newProbeRestrictedError(`resource type "%s" is not allowed for probes of this exporter`, resourceType)
+		}
+	}
+	return nil
+}
+
+// CheckResources returns a ProbeRestrictedError for the first restricted resource (subscription, resource group, resource type)
+func (r *ProbeRestrictions) CheckResources(resourceIds ...string) error {
+	for _, resourceId := range resourceIds {
+		resourceInfo, err := armclient.ParseResourceId(resourceId)
+		if err != nil {
+			return err
+		}
+
+		if err := r.CheckSubscriptions(resourceInfo.Subscription); err != nil {
+			return err
+		}
+
+		if !r.ResourceGroupAllowed(resourceInfo.ResourceGroup) {
+			return newProbeRestrictedError(`resource group "%s" is not allowed for probes of this exporter`, resourceInfo.ResourceGroup)
+		}
+
+		if err := r.CheckResourceTypes(ResourceTypeFromResourceId(resourceId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSettings checks the subscriptions and the resource type of the probe
+func (r *ProbeRestrictions) CheckSettings(settings *RequestMetricSettings) error {
+	if err := r.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		return err
+	}
+	return r.CheckResourceTypes(settings.ResourceType)
+}
+
+func restrictionAllows(allow, deny []string, value string, match func(pattern, value string) bool) bool {
+	for _, pattern := range deny {
+		if match(pattern, value) {
+			return false
+		}
+	}
+
+	if len(allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range allow {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
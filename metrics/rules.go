@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+)
+
+type (
+	// MetricRules rewrites metric names and labels of probe responses (server side metric_relabel_configs)
+	MetricRules struct {
+		rules []metricRule
+	}
+
+	metricRule struct {
+		action      string
+		metric      *regexp.Regexp
+		regex       *regexp.Regexp
+		label       string
+		targetLabel string
+		replacement string
+	}
+)
+
+// NewMetricRules compiles the metric rules of the config file, regexes are anchored (full match)
+func NewMetricRules(ruleConfigs []config.MetricRuleConfig) (*MetricRules, error) {
+	rules := MetricRules{}
+	for i, ruleConfig := range ruleConfigs {
+		rule := metricRule{
+			action:      ruleConfig.Action,
+			label:       ruleConfig.Label,
+			targetLabel: ruleConfig.TargetLabel,
+			replacement: ruleConfig.Replacement,
+		}
+
+		var err error
+		if ruleConfig.Metric != "" {
+			if rule.metric, err = compileAnchoredRegex(ruleConfig.Metric); err != nil {
+				return nil, fmt.Errorf("metric rule #%d: %w", i+1, err)
+			}
+		}
+
+		if rule.regex, err = compileAnchoredRegex(ruleConfig.Regex); err != nil {
+			return nil, fmt.Errorf("metric rule #%d: %w", i+1, err)
+		}
+
+		rules.rules = append(rules.rules, rule)
+	}
+
+	return &rules, nil
+}
+
+func compileAnchoredRegex(regex string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + regex + ")$")
+}
+
+// Gatherer wraps the gatherer and applies the rules to the gathered metrics
+func (r *MetricRules) Gatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	if r == nil || len(r.rules) == 0 {
+		return gatherer
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return families, err
+		}
+		return r.Apply(families), nil
+	})
+}
+
+// Apply applies the rules to the metric families, families with same name (after renaming) are merged
+// and duplicate series (eg. after dropping labels) are removed
+func (r *MetricRules) Apply(families []*dto.MetricFamily) []*dto.MetricFamily {
+	familyMap := map[string]*dto.MetricFamily{}
+	seriesMap := map[string]bool{}
+
+	for _, family := range families {
+		name := family.GetName()
+		for _, rule := range r.rules {
+			if rule.metric != nil && !rule.metric.MatchString(name) {
+				continue
+			}
+			name = rule.apply(name, family)
+		}
+
+		if existing, exists := familyMap[name]; exists {
+			if existing.GetType() != family.GetType() {
+				// metrics with different types cannot be merged
+				continue
+			}
+		} else {
+			familyName := name
+			familyMap[name] = &dto.MetricFamily{
+				Name: &familyName,
+				Help: family.Help,
+				Type: family.Type,
+			}
+		}
+
+		for _, metric := range family.Metric {
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+
+			seriesKey := metricSeriesKey(name, metric)
+			if seriesMap[seriesKey] {
+				continue
+			}
+			seriesMap[seriesKey] = true
+			familyMap[name].Metric = append(familyMap[name].Metric, metric)
+		}
+	}
+
+	ret := make([]*dto.MetricFamily, 0, len(familyMap))
+	for _, family := range familyMap {
+		ret = append(ret, family)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].GetName() < ret[j].GetName()
+	})
+
+	return ret
+}
+
+// apply applies the rule to the metric family and returns the (new) metric name
+func (rule *metricRule) apply(name string, family *dto.MetricFamily) string {
+	switch rule.action {
+	case config.MetricRuleActionRename:
+		if match := rule.regex.FindStringSubmatchIndex(name); match != nil {
+			newName := string(rule.regex.ExpandString(nil, rule.replacement, name, match))
+			if model.IsValidMetricName(model.LabelValue(newName)) {
+				name = newName
+			}
+		}
+
+	case config.MetricRuleActionLabelDrop:
+		for _, metric := range family.Metric {
+			labels := metric.Label[:0]
+			for _, label := range metric.Label {
+				if !rule.regex.MatchString(label.GetName()) {
+					labels = append(labels, label)
+				}
+			}
+			metric.Label = labels
+		}
+
+	case config.MetricRuleActionReplace:
+		for _, metric := range family.Metric {
+			value := ""
+			for _, label := range metric.Label {
+				if label.GetName() == rule.label {
+					value = label.GetValue()
+					break
+				}
+			}
+
+			match := rule.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			metric.Label = setMetricLabel(metric.Label, rule.targetLabel, string(rule.regex.ExpandString(nil, rule.replacement, value, match)))
+		}
+	}
+
+	return name
+}
+
+// setMetricLabel sets the label value, empty values remove the label
+func setMetricLabel(labels []*dto.LabelPair, name, value string) []*dto.LabelPair {
+	ret := make([]*dto.LabelPair, 0, len(labels)+1)
+	for _, label := range labels {
+		if label.GetName() != name {
+			ret = append(ret, label)
+		}
+	}
+
+	if value != "" {
+		labelName, labelValue := name, value
+		ret = append(ret, &dto.LabelPair{Name: &labelName, Value: &labelValue})
+	}
+
+	return ret
+}
+
+func metricSeriesKey(name string, metric *dto.Metric) string {
+	key := strings.Builder{}
+	key.WriteString(name)
+	for _, label := range metric.Label {
+		key.WriteString("\xff")
+		key.WriteString(label.GetName())
+		key.WriteString("=")
+		key.WriteString(label.GetValue())
+	}
+	return key.String()
+}
@@ -0,0 +1,373 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ImdsScheduledEventsApiVersion = "2020-07-01"
+	ImdsComputeApiVersion         = "2021-02-01"
+
+	ScheduledEventTypePreempt = "Preempt"
+
+	// Spot virtual machines of the subscriptions with size and power state (deallocated/stopped after eviction)
+	scheduledEventsSpotVmQuery = `resources
+| where type =~ 'microsoft.compute/virtualmachines' and tostring(properties.priority) =~ 'Spot'
+| project id, location, vmSize=tostring(properties.hardwareProfile.vmSize), evictionPolicy=tostring(properties.evictionPolicy), powerState=tostring(properties.extended.instanceView.powerState.code)`
+
+	// eviction rates (eg. 0-5, 5-10 percent) of Spot virtual machine sizes per location
+	scheduledEventsSpotEvictionRateQuery = `spotresources
+| where type =~ 'microsoft.compute/skuspotevictionrate/location'
+| project vmSize=tostring(sku.name), location=tostring(location), evictionRate=tostring(properties.evictionRate)`
+)
+
+type (
+	// ScheduledEventsClient fetches the scheduled events of the virtual machine the exporter runs on (IMDS)
+	// and the Spot virtual machines of subscriptions (Resource Graph)
+	ScheduledEventsClient struct {
+		imdsEndpoint string
+		httpClient   *http.Client
+	}
+
+	ScheduledEventsSettings struct {
+		Fleet         bool
+		Subscriptions []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// cache
+		Cache *time.Duration
+	}
+
+	ScheduledEventsResult struct {
+		Instance      *ImdsInstance      `json:"instance,omitempty"`
+		Events        []ScheduledEvent   `json:"events"`
+		SpotVms       []SpotVm           `json:"spotVms"`
+		EvictionRates []SpotEvictionRate `json:"evictionRates"`
+	}
+
+	ImdsInstance struct {
+		ResourceId     string `json:"resourceId"`
+		Name           string `json:"name"`
+		Priority       string `json:"priority"`
+		EvictionPolicy string `json:"evictionPolicy"`
+	}
+
+	ScheduledEvent struct {
+		EventId     string   `json:"EventId"`
+		EventType   string   `json:"EventType"`
+		EventStatus string   `json:"EventStatus"`
+		EventSource string   `json:"EventSource"`
+		Resources   []string `json:"Resources"`
+		NotBefore   string   `json:"NotBefore"`
+		Duration    int      `json:"DurationInSeconds"`
+	}
+
+	SpotVm struct {
+		ResourceId     string `json:"resourceId"`
+		Location       string `json:"location"`
+		VmSize         string `json:"vmSize"`
+		EvictionPolicy string `json:"evictionPolicy"`
+		PowerState     string `json:"powerState"`
+	}
+
+	SpotEvictionRate struct {
+		VmSize       string `json:"vmSize"`
+		Location     string `json:"location"`
+		EvictionRate string `json:"evictionRate"`
+	}
+
+	imdsScheduledEventsResponse struct {
+		DocumentIncarnation int              `json:"DocumentIncarnation"`
+		Events              []ScheduledEvent `json:"Events"`
+	}
+)
+
+func NewScheduledEventsSettings(r *http.Request) (ScheduledEventsSettings, error) {
+	ret := ScheduledEventsSettings{}
+	params := r.URL.Query()
+
+	// param fleet
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "fleet", "false")); err == nil {
+		ret.Fleet = val
+	} else {
+		return ret, err
+	}
+
+	// param subscription (required for fleet)
+	if val, err := paramsGetList(params, "subscription"); err == nil {
+		ret.Subscriptions = val
+	} else {
+		return ret, err
+	}
+	if ret.Fleet && len(ret.Subscriptions) == 0 {
+		return ret, fmt.Errorf(`parameter "subscription" is missing (required for "fleet")`)
+	}
+
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param cache
+	if val := params.Get("cache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.Cache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// NewScheduledEventsClient creates a client for the instance metadata service (IMDS, only reachable from Azure VMs)
+func NewScheduledEventsClient(imdsEndpoint string) *ScheduledEventsClient {
+	return &ScheduledEventsClient{
+		imdsEndpoint: strings.TrimRight(imdsEndpoint, "/"),
+		// IMDS must not be requested via proxy
+		httpClient: &http.Client{Transport: &http.Transport{Proxy: nil}},
+	}
+}
+
+// Instance returns the virtual machine the exporter runs on
+func (c *ScheduledEventsClient) Instance(ctx context.Context) (*ImdsInstance, error) {
+	response := ImdsInstance{}
+	if err := c.imdsGet(ctx, "/metadata/instance/compute?api-version="+ImdsComputeApiVersion, &response); err != nil {
+		return nil, fmt.Errorf("unable to get instance metadata: %w", err)
+	}
+	return &response, nil
+}
+
+// ScheduledEvents returns the scheduled events (reboot, redeploy, freeze, Spot eviction) of the virtual machine,
+// the first request enables scheduled events for the virtual machine (may take some minutes)
+func (c *ScheduledEventsClient) ScheduledEvents(ctx context.Context) ([]ScheduledEvent, error) {
+	response := imdsScheduledEventsResponse{}
+	if err := c.imdsGet(ctx, "/metadata/scheduledevents?api-version="+ImdsScheduledEventsApiVersion, &response); err != nil {
+		return nil, fmt.Errorf("unable to get scheduled events: %w", err)
+	}
+	return response.Events, nil
+}
+
+func (c *ScheduledEventsClient) imdsGet(ctx context.Context, path string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.imdsEndpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// ListSpotVms returns the Spot virtual machines of the subscriptions and the eviction rates of their sizes (Resource Graph)
+func ListSpotVms(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, subscriptions []string, maxRows int) ([]SpotVm, []SpotEvictionRate, error) {
+	client, err := armresourcegraph.NewClient(cred, clientOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spotVms := []SpotVm{}
+	vmSizes := map[string]bool{}
+	_, err = queryResourceGraph(ctx, client, "spotvms", scheduledEventsSpotVmQuery, subscriptions, maxRows, func(row map[string]interface{}) {
+		vm := SpotVm{}
+		vm.ResourceId, _ = row["id"].(string)
+		vm.Location, _ = row["location"].(string)
+		vm.VmSize, _ = row["vmSize"].(string)
+		vm.EvictionPolicy, _ = row["evictionPolicy"].(string)
+		vm.PowerState, _ = row["powerState"].(string)
+		spotVms = append(spotVms, vm)
+		vmSizes[strings.ToLower(vm.VmSize+"\xff"+vm.Location)] = true
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to query Spot virtual machines: %w", err)
+	}
+
+	// eviction rates of the used sizes only
+	evictionRates := []SpotEvictionRate{}
+	if len(spotVms) > 0 {
+		_, err = queryResourceGraph(ctx, client, "spotevictionrates", scheduledEventsSpotEvictionRateQuery, subscriptions, maxRows, func(row map[string]interface{}) {
+			rate := SpotEvictionRate{}
+			rate.VmSize, _ = row["vmSize"].(string)
+			rate.Location, _ = row["location"].(string)
+			rate.EvictionRate, _ = row["evictionRate"].(string)
+			if vmSizes[strings.ToLower(rate.VmSize+"\xff"+rate.Location)] {
+				evictionRates = append(evictionRates, rate)
+			}
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to query Spot eviction rates: %w", err)
+		}
+	}
+
+	return spotVms, evictionRates, nil
+}
+
+// PublishScheduledEventsResult publishes the scheduled events of the virtual machine (azurerm_vm_scheduled_event),
+// pending Spot evictions (azurerm_vm_spot_eviction) and the Spot virtual machines of the subscriptions (fleet)
+func PublishScheduledEventsResult(registry *prometheus.Registry, result *ScheduledEventsResult) {
+	scheduledEvent := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_vm_scheduled_event",
+			Help: "Azure scheduled event of the virtual machine (IMDS)",
+		},
+		[]string{
+			"resourceID",
+			"resource",
+			"eventID",
+			"eventType",
+			"eventStatus",
+			"eventSource",
+		},
+	)
+	registry.MustRegister(scheduledEvent)
+
+	scheduledEventNotBefore := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_vm_scheduled_event_notbefore_timestamp_seconds",
+			Help: "Azure scheduled event earliest start time (not before) of the virtual machine (IMDS)",
+		},
+		[]string{
+			"resourceID",
+			"resource",
+			"eventID",
+			"eventType",
+		},
+	)
+	registry.MustRegister(scheduledEventNotBefore)
+
+	spotEviction := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_vm_spot_eviction",
+			Help: "Azure Spot eviction (Preempt event) of the virtual machine is pending (IMDS)",
+		},
+		[]string{
+			"resourceID",
+			"resource",
+			"priority",
+			"evictionPolicy",
+		},
+	)
+	registry.MustRegister(spotEviction)
+
+	spotVmInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_vm_spot_info",
+			Help: "Azure Spot virtual machine of the subscriptions with power state (deallocated or stopped after evictions)",
+		},
+		[]string{
+			"subscriptionID",
+			"resourceID",
+			"location",
+			"vmSize",
+			"evictionPolicy",
+			"powerState",
+		},
+	)
+	registry.MustRegister(spotVmInfo)
+
+	spotEvictionRate := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_vm_spot_eviction_rate",
+			Help: "Azure Spot eviction rate (percent range) of virtual machine size and location",
+		},
+		[]string{
+			"location",
+			"vmSize",
+			"evictionRate",
+		},
+	)
+	registry.MustRegister(spotEvictionRate)
+
+	if result.Instance != nil {
+		resourceId := strings.ToLower(result.Instance.ResourceId)
+
+		evictionPending := float64(0)
+		for _, event := range result.Events {
+			for _, resource := range event.Resources {
+				scheduledEvent.With(prometheus.Labels{
+					"resourceID":  resourceId,
+					"resource":    resource,
+					"eventID":     event.EventId,
+					"eventType":   event.EventType,
+					"eventStatus": event.EventStatus,
+					"eventSource": event.EventSource,
+				}).Set(1)
+
+				// NotBefore is empty for started events
+				if notBefore, err := time.Parse(time.RFC1123, event.NotBefore); err == nil {
+					scheduledEventNotBefore.With(prometheus.Labels{
+						"resourceID": resourceId,
+						"resource":   resource,
+						"eventID":    event.EventId,
+						"eventType":  event.EventType,
+					}).Set(float64(notBefore.Unix()))
+				}
+			}
+
+			if strings.EqualFold(event.EventType, ScheduledEventTypePreempt) {
+				evictionPending = 1
+			}
+		}
+
+		spotEviction.With(prometheus.Labels{
+			"resourceID":     resourceId,
+			"resource":       result.Instance.Name,
+			"priority":       result.Instance.Priority,
+			"evictionPolicy": result.Instance.EvictionPolicy,
+		}).Set(evictionPending)
+	}
+
+	for _, vm := range result.SpotVms {
+		subscriptionId := ""
+		if parts := strings.Split(strings.Trim(vm.ResourceId, "/"), "/"); len(parts) >= 2 {
+			subscriptionId = strings.ToLower(parts[1])
+		}
+
+		spotVmInfo.With(prometheus.Labels{
+			"subscriptionID": subscriptionId,
+			"resourceID":     strings.ToLower(vm.ResourceId),
+			"location":       vm.Location,
+			"vmSize":         vm.VmSize,
+			"evictionPolicy": vm.EvictionPolicy,
+			"powerState":     vm.PowerState,
+		}).Set(1)
+	}
+
+	for _, rate := range result.EvictionRates {
+		spotEvictionRate.With(prometheus.Labels{
+			"location":     rate.Location,
+			"vmSize":       rate.VmSize,
+			"evictionRate": rate.EvictionRate,
+		}).Set(1)
+	}
+}
@@ -3,10 +3,14 @@ package metrics
 import (
 	"context"
 	"crypto/sha1" // #nosec G505
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resourcegraph/armresourcegraph"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
 	"github.com/webdevops/go-common/utils/to"
@@ -26,106 +30,176 @@ type (
 		ID       string
 		Location string
 		Tags     map[string]string
+
+		// sku tier (eg. DTU and vCore purchase model of SQL databases)
+		SkuTier string
+	}
+
+	AzureResourceType struct {
+		Type  string `json:"type"`
+		Count int64  `json:"count"`
 	}
 )
 
 func (sd *AzureServiceDiscovery) ResourcesClient(subscriptionId string) (*armresources.Client, error) {
-	return armresources.NewClient(subscriptionId, sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
+	return armresources.NewClient(subscriptionId, sd.prober.GetCred(), NewArmClientOptions(sd.prober.AzureClient))
 }
 
+// publishTargetList adds the discovered resources matching the resource name, resource group and location patterns as targets
 func (sd *AzureServiceDiscovery) publishTargetList(targetList []MetricProbeTarget) {
+	if resourceMatch := sd.prober.settings.ResourceMatch; resourceMatch.Enabled() {
+		matchedTargetList := []MetricProbeTarget{}
+		for _, target := range targetList {
+			if resourceMatch.Match(target.ResourceId, target.Location) {
+				matchedTargetList = append(matchedTargetList, target)
+			}
+		}
+		sd.prober.logger.Debugf("%v of %v discovered resources matched resource name, resource group and location patterns", len(matchedTargetList), len(targetList))
+		targetList = matchedTargetList
+	}
+
 	sd.prober.AddTarget(targetList...)
 }
 
 func (sd *AzureServiceDiscovery) fetchResourceList(subscriptionId, filter string) (resourceList []AzureResource, err error) {
-	// nolint:gosec
-	cacheKey := fmt.Sprintf(
-		"%x",
-		string(sha1.New().Sum([]byte(fmt.Sprintf("%v:%v", subscriptionId, filter)))),
-	)
+	resourceGroups := sd.prober.settings.ResourceMatch.ResourceGroupNames()
 
-	// try to fetch info from cache
-	if cachedResourceList, ok := sd.fetchFromCache(cacheKey); !ok {
-		client, err := sd.ResourcesClient(subscriptionId)
-		if err != nil {
-			err = fmt.Errorf("servicediscovery failed: %w", err)
-			return resourceList, err
-		}
+	list := func(ctx context.Context) ([]AzureResource, error) {
+		return sd.listResources(ctx, subscriptionId, filter, resourceGroups)
+	}
+
+	changes := func(ctx context.Context, since time.Time) (int64, error) {
+		return sd.countResourceChanges(ctx, subscriptionId, since)
+	}
+
+	if resourceInventory := sd.prober.resourceInventory; resourceInventory.inventory != nil {
+		// nolint:gosec
+		cacheKey := fmt.Sprintf(
+			"%x",
+			string(sha1.New().Sum([]byte(fmt.Sprintf("%v:%v:%v:%v", sd.prober.settings.CredentialKey(), subscriptionId, filter, strings.Join(resourceGroups, ","))))),
+		)
+		return resourceInventory.inventory.Get(sd.prober.ctx, sd.prober.logger, cacheKey, subscriptionId, resourceInventory.refreshInterval, list, changes)
+	}
+
+	return list(sd.prober.ctx)
+}
 
+// listResources lists the resources of the subscription (or of the resource groups) using the ARM resources api ($filter)
+func (sd *AzureServiceDiscovery) listResources(ctx context.Context, subscriptionId, filter string, resourceGroups []string) (resourceList []AzureResource, err error) {
+	client, err := sd.ResourcesClient(subscriptionId)
+	if err != nil {
+		err = fmt.Errorf("servicediscovery failed: %w", err)
+		return resourceList, err
+	}
+
+	if len(resourceGroups) == 0 {
 		opts := armresources.ClientListOptions{
 			Filter: to.StringPtr(filter),
 		}
 		pager := client.NewListPager(&opts)
 
 		for pager.More() {
-			result, err := pager.NextPage(sd.prober.ctx)
+			result, err := pager.NextPage(ctx)
 			if err != nil {
 				err = fmt.Errorf("servicediscovery failed: %w", err)
 				return resourceList, err
 			}
 
-			if result.Value == nil {
-				continue
-			}
-
-			for _, row := range result.Value {
-				resource := row
-
-				resourceList = append(
-					resourceList,
-					AzureResource{
-						ID:   to.String(resource.ID),
-						Tags: to.StringMap(resource.Tags),
-					},
-				)
-			}
+			resourceList = appendResourceList(resourceList, result.Value)
 		}
 
-		// store to cache (if enabled)
-		sd.saveToCache(cacheKey, resourceList)
-	} else {
-		sd.prober.logger.Debugf("using servicediscovery from cache")
-		resourceList = cachedResourceList
+		return resourceList, nil
 	}
 
-	return
-}
+	for _, resourceGroup := range resourceGroups {
+		opts := armresources.ClientListByResourceGroupOptions{
+			Filter: to.StringPtr(filter),
+		}
+		pager := client.NewListByResourceGroupPager(resourceGroup, &opts)
 
-func (sd *AzureServiceDiscovery) fetchFromCache(cacheKey string) (resourceList []AzureResource, status bool) {
-	contextLogger := sd.prober.logger
-	cache := sd.prober.serviceDiscoveryCache.cache
-
-	if cache != nil {
-		if v, ok := cache.Get(cacheKey); ok {
-			if cacheData, ok := v.([]byte); ok {
-				if err := json.Unmarshal(cacheData, &resourceList); err == nil {
-					status = true
-				} else {
-					contextLogger.Debug("unable to parse cached servicediscovery")
+		for pager.More() {
+			result, err := pager.NextPage(ctx)
+			if err != nil {
+				var responseErr *azcore.ResponseError
+				if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+					// resource group doesn't exist (anymore)
+					sd.prober.logger.Debugf(`resource group "%s" not found in subscription "%s"`, resourceGroup, subscriptionId)
+					break
 				}
+
+				err = fmt.Errorf(`servicediscovery of resource group "%s" failed: %w`, resourceGroup, err)
+				return resourceList, err
 			}
+
+			resourceList = appendResourceList(resourceList, result.Value)
 		}
 	}
 
-	return
+	return resourceList, nil
 }
 
-func (sd *AzureServiceDiscovery) saveToCache(cacheKey string, resourceList []AzureResource) {
-	contextLogger := sd.prober.logger
-	cache := sd.prober.serviceDiscoveryCache.cache
-	cacheDuration := sd.prober.serviceDiscoveryCache.cacheDuration
-
-	// store to cache (if enabled)
-	if cache != nil {
-		contextLogger.Debug("saving servicedisccovery to cache")
-		if cacheData, err := json.Marshal(resourceList); err == nil {
-			cache.Set(cacheKey, cacheData, *cacheDuration)
-			contextLogger.Debugf("saved servicediscovery to cache for %s", cacheDuration.String())
+func appendResourceList(resourceList []AzureResource, resources []*armresources.GenericResourceExpanded) []AzureResource {
+	for _, resource := range resources {
+		skuTier := ""
+		if resource.SKU != nil {
+			skuTier = to.String(resource.SKU.Tier)
+		}
+
+		resourceList = append(
+			resourceList,
+			AzureResource{
+				ID:       to.String(resource.ID),
+				Location: to.String(resource.Location),
+				Tags:     to.StringMap(resource.Tags),
+				SkuTier:  skuTier,
+			},
+		)
+	}
+	return resourceList
+}
+
+// countResourceChanges returns the number of resource changes (create, update, delete) of the subscription
+// since the time using the ResourceGraph resourcechanges table
+func (sd *AzureServiceDiscovery) countResourceChanges(ctx context.Context, subscriptionId string, since time.Time) (int64, error) {
+	client, err := armresourcegraph.NewClient(sd.prober.GetCred(), NewArmClientOptions(sd.prober.AzureClient))
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(
+		`resourcechanges | extend changeTime = todatetime(properties.changeAttributes.timestamp) | where changeTime > datetime(%s) | summarize count=count()`,
+		since.UTC().Format(time.RFC3339),
+	)
+
+	queryFormat := armresourcegraph.ResultFormatObjectArray
+	queryRequest := armresourcegraph.QueryRequest{
+		Query: to.StringPtr(query),
+		Options: &armresourcegraph.QueryRequestOptions{
+			ResultFormat: &queryFormat,
+		},
+		Subscriptions: to.SlicePtr([]string{subscriptionId}),
+	}
+
+	result, err := client.Resources(ctx, queryRequest, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to query resource changes: %w", err)
+	}
+
+	if resultList, ok := result.Data.([]interface{}); ok {
+		for _, v := range resultList {
+			if resultRow, ok := v.(map[string]interface{}); ok {
+				if count, ok := resultRow["count"].(float64); ok {
+					return int64(count), nil
+				}
+			}
 		}
 	}
+
+	return 0, nil
 }
 
-func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filter string) {
+// FindSubscriptionResources adds the resources of the subscription as targets
+func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filter string) error {
 	var targetList []MetricProbeTarget
 
 	if resourceList, err := sd.fetchResourceList(subscriptionId, filter); err == nil {
@@ -134,21 +208,24 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResources(subscriptionId, filte
 				targetList,
 				MetricProbeTarget{
 					ResourceId:   resource.ID,
+					Location:     resource.Location,
 					Metrics:      sd.prober.settings.Metrics,
 					Aggregations: sd.prober.settings.Aggregations,
 					Tags:         resource.Tags,
+					SkuTier:      resource.SkuTier,
 				},
 			)
 		}
 	} else {
-		sd.prober.logger.Error(err)
-		return
+		return err
 	}
 
 	sd.publishTargetList(targetList)
+	return nil
 }
 
-func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx context.Context, subscriptionId, filter, metricTagName, aggregationTagName string) {
+// FindSubscriptionResourcesWithScrapeTags adds the resources of the subscription with metric and aggregation tags as targets
+func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx context.Context, subscriptionId, filter, metricTagName, aggregationTagName string) error {
 	var targetList []MetricProbeTarget
 
 	if resourceList, err := sd.fetchResourceList(subscriptionId, filter); err == nil {
@@ -159,8 +236,9 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx con
 						targetList,
 						MetricProbeTarget{
 							ResourceId:   resource.ID,
+							Location:     resource.Location,
 							Metrics:      stringToStringList(metrics, ","),
-							Aggregations: stringToStringList(aggregations, ","),
+							Aggregations: uniqueStringList(stringToStringList(aggregations, ",")),
 						},
 					)
 
@@ -168,95 +246,143 @@ func (sd *AzureServiceDiscovery) FindSubscriptionResourcesWithScrapeTags(ctx con
 			}
 		}
 	} else {
-		sd.prober.logger.Error(err)
-		return
+		return err
 	}
 
 	sd.publishTargetList(targetList)
+	return nil
 }
 
 func (sd *AzureServiceDiscovery) FindResourceGraph(ctx context.Context, subscriptions []string, resourceType, filter string) error {
 	var targetList []MetricProbeTarget
 
-	client, err := armresourcegraph.NewClient(sd.prober.AzureClient.GetCred(), sd.prober.AzureClient.NewArmClientOptions())
+	resourceList, err := sd.ListResourceGraph(ctx, subscriptions, resourceType, filter)
 	if err != nil {
 		return err
 	}
 
+	for _, resource := range resourceList {
+		targetList = append(
+			targetList,
+			MetricProbeTarget{
+				ResourceId:   resource.ID,
+				Location:     resource.Location,
+				Metrics:      sd.prober.settings.Metrics,
+				Aggregations: sd.prober.settings.Aggregations,
+				Tags:         resource.Tags,
+				SkuTier:      resource.SkuTier,
+			},
+		)
+	}
+
+	sd.publishTargetList(targetList)
+	return nil
+}
+
+// ListResourceGraph returns the resources of the resource type (with optional Kusto filter) using ResourceGraph (cached)
+func (sd *AzureServiceDiscovery) ListResourceGraph(ctx context.Context, subscriptions []string, resourceType, filter string) ([]AzureResource, error) {
 	if filter != "" {
 		filter = "| " + filter
 	}
 
-	queryTemplate := `Resources | where type =~ "%s" %s | project id, tags`
+	// resource groups are validated names (no quotes)
+	resourceGroupFilter := ""
+	if resourceGroups := sd.prober.settings.ResourceMatch.ResourceGroupNames(); len(resourceGroups) > 0 {
+		resourceGroupFilter = fmt.Sprintf(`| where resourceGroup in~ ("%s")`, strings.Join(resourceGroups, `", "`))
+	}
+
+	queryTemplate := `Resources | where type =~ "%s" %s %s | project id, location, tags, skuTier=tostring(sku.tier)`
 
 	query := strings.TrimSpace(fmt.Sprintf(
 		queryTemplate,
 		strings.ReplaceAll(resourceType, "'", "\\'"),
+		resourceGroupFilter,
 		filter,
 	))
 
 	sd.prober.logger.With(zap.String("query", query)).Debugf("using Kusto query")
 
-	queryFormat := armresourcegraph.ResultFormatObjectArray
-	queryTop := int32(ResourceGraphQueryTop)
-	queryRequest := armresourcegraph.QueryRequest{
-		Query: to.StringPtr(query),
-		Options: &armresourcegraph.QueryRequestOptions{
-			ResultFormat: &queryFormat,
-			Top:          &queryTop,
-		},
-		Subscriptions: to.SlicePtr(subscriptions),
+	fetch := func(ctx context.Context) ([]AzureResource, error) {
+		return sd.fetchResourceGraph(ctx, query, subscriptions)
 	}
 
-	result, err := client.Resources(ctx, queryRequest, nil)
+	var resourceList []AzureResource
+	var err error
+	if resourceGraphCache := sd.prober.resourceGraphCache; resourceGraphCache.cache != nil && resourceGraphCache.cacheDuration > 0 {
+		sortedSubscriptions := append([]string{}, subscriptions...)
+		sort.Strings(sortedSubscriptions)
+
+		// nolint:gosec
+		cacheKey := fmt.Sprintf(
+			"%x",
+			sha1.Sum([]byte(fmt.Sprintf("%v:%v:%v", sd.prober.settings.CredentialKey(), strings.Join(sortedSubscriptions, ","), query))),
+		)
+		resourceList, err = resourceGraphCache.cache.Get(ctx, sd.prober.logger, cacheKey, subscriptions, resourceGraphCache.cacheDuration, fetch)
+	} else {
+		resourceList, err = fetch(ctx)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for {
-		if resultList, ok := result.Data.([]interface{}); ok {
-			// check if we got data, otherwise break the for loop
-			if len(resultList) == 0 {
-				break
-			}
+	return resourceList, nil
+}
 
-			for _, v := range resultList {
-				if resultRow, ok := v.(map[string]interface{}); ok {
-					// check if we got data, otherwise break the for loop
-					if len(resultList) == 0 {
-						break
-					}
-
-					if val, ok := resultRow["id"]; ok && val != "" {
-						if resourceId, ok := val.(string); ok {
-							targetList = append(
-								targetList,
-								MetricProbeTarget{
-									ResourceId:   resourceId,
-									Metrics:      sd.prober.settings.Metrics,
-									Aggregations: sd.prober.settings.Aggregations,
-									Tags:         sd.resourceTagsToStringMap(resultRow["tags"]),
-								},
-							)
-						}
-					}
-				}
-			}
+// ListResourceTypes returns the resource types (with number of resources) of the subscriptions using ResourceGraph
+func (sd *AzureServiceDiscovery) ListResourceTypes(ctx context.Context, subscriptions []string) ([]AzureResourceType, error) {
+	client, err := armresourcegraph.NewClient(sd.prober.GetCred(), NewArmClientOptions(sd.prober.AzureClient))
+	if err != nil {
+		return nil, err
+	}
+
+	resourceTypeList := []AzureResourceType{}
+	query := `Resources | summarize count=count() by type=tolower(type) | order by type asc`
+	truncated, err := queryResourceGraph(ctx, client, "resourcetypes", query, subscriptions, sd.prober.Conf.Azure.ResourceGraph.MaxRows, func(row map[string]interface{}) {
+		resourceType, _ := row["type"].(string)
+		count, _ := row["count"].(float64)
+		if resourceType != "" {
+			resourceTypeList = append(resourceTypeList, AzureResourceType{Type: resourceType, Count: int64(count)})
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		sd.prober.logger.Warnf("ResourceGraph result of resource types was truncated after %v rows (--azure.resourcegraph.max-rows or ResourceGraph limit)", len(resourceTypeList))
+	}
 
-		if result.SkipToken != nil {
-			queryRequest.Options.SkipToken = result.SkipToken
-			result, err = client.Resources(ctx, queryRequest, nil)
-			if err != nil {
-				return err
-			}
-		} else {
-			break
+	return resourceTypeList, nil
+}
+
+func (sd *AzureServiceDiscovery) fetchResourceGraph(ctx context.Context, query string, subscriptions []string) (resourceList []AzureResource, err error) {
+	client, err := armresourcegraph.NewClient(sd.prober.GetCred(), NewArmClientOptions(sd.prober.AzureClient))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated, err := queryResourceGraph(ctx, client, "servicediscovery", query, subscriptions, sd.prober.Conf.Azure.ResourceGraph.MaxRows, func(row map[string]interface{}) {
+		if resourceId, ok := row["id"].(string); ok && resourceId != "" {
+			location, _ := row["location"].(string)
+			skuTier, _ := row["skuTier"].(string)
+			resourceList = append(
+				resourceList,
+				AzureResource{
+					ID:       resourceId,
+					Location: location,
+					Tags:     sd.resourceTagsToStringMap(row["tags"]),
+					SkuTier:  skuTier,
+				},
+			)
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		sd.prober.logger.With(zap.String("query", query)).Warnf("ResourceGraph result was truncated after %v resources (--azure.resourcegraph.max-rows or ResourceGraph limit)", len(resourceList))
 	}
 
-	sd.publishTargetList(targetList)
-	return nil
+	return resourceList, nil
 }
 
 func (sd *AzureServiceDiscovery) resourceTagsToStringMap(tags interface{}) (ret map[string]string) {
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,35 +15,134 @@ import (
 
 const (
 	PrometheusMetricNameDefault = "azurerm_resource_metric"
+
+	// datapoints of the timespan exported by probes
+	DatapointsLatest = "latest"
+	DatapointsAll    = "all"
+
+	// all datapoints of the timespan as summary (count, sum, min, max and percentiles)
+	DatapointsSummary = "summary"
 )
 
 type (
 	RequestMetricSettings struct {
 		Name            string
+		Profile         string
 		Subscriptions   []string
 		ResourceType    string
 		Filter          string
 		Timespan        string
 		Interval        *string
+		IntervalAuto    bool
 		Metrics         []string
 		MetricNamespace string
 		Aggregations    []string
 		Regions         []string
 
+		// resource name, resource group and location patterns of discovered resources
+		ResourceMatch ResourceMatch
+
+		// subscription discovery (if no subscription is set)
+		SubscriptionFilter []string
+		SubscriptionTags   []string
+
+		// named credential (credential registry)
+		Credential string
+
+		// tenant (credential of the tenant)
+		Tenant string
+
+		// named query of the resourcegraph probe (config file queries) with parameters (param.<name>)
+		Query       string
+		QueryParams map[string]string
+
+		// resource tags added as labels (overrides --azure.resource-tag)
+		TagLabels []string
+
 		// needed for dimension support
 		MetricTop     *int32
 		MetricFilter  string
 		MetricOrderBy string
+		Dimensions    []string
+
+		// max timeseries (dimension values) per metric, long tail is aggregated into "other" or truncated
+		MaxDimensionValues     int
+		MaxDimensionValuesMode string
+
+		// percentiles (0-100) of the datapoints of the timespan per aggregation (quantile label)
+		Percentiles []float64
+
+		// collapse the series of all resources per resource group or subscription (sum, avg)
+		AggregateResources   string
+		AggregateResourcesBy string
 
 		ValidateDimensions bool
 
+		// use metrics:getBatch for resources with same type and region
+		MetricsBatch bool
+
+		// report failed requests as metrics (partial failures)
+		ReportErrors bool
+
+		// add azurerm_resource_portal_info with Azure portal links
+		PortalLinks bool
+
+		// label normalization: value of the resourceID label (full, short, name), resourceProvider and resourceType
+		// labels split from the resource id and lowercased label values
+		ResourceIdLabel string
+		SplitResourceId bool
+		LowercaseLabels bool
+
 		MetricTemplate string
 		HelpTemplate   string
 
+		// compiled Go templates (nil for placeholder templates)
+		MetricGoTemplate *MetricTemplate
+		HelpGoTemplate   *MetricTemplate
+
 		DimensionLowercase bool
 
+		// api-version of metric requests (overrides --azure.api-version.metrics)
+		ApiVersion string
+
+		// priority of the probe for the ARM quota (low, normal, high)
+		Priority string
+
+		// collect metrics of VM Scale Set instances
+		VmssInstances bool
+
+		// collect metrics of the node pools (VM Scale Sets) of AKS clusters
+		AksNodePools bool
+
+		// collect metrics of storage account services (blob, file, queue, table)
+		StorageServices []string
+
+		// export timestamps of datapoints and which datapoints of the timespan are exported (latest, all, summary)
+		ExportTimestamps bool
+		Datapoints       string
+
+		// align the timespan to completed time grains (delayed for ingestion latency), covering at least the scrape interval
+		AlignTimespan  bool
+		AlignDelay     time.Duration
+		ScrapeInterval time.Duration
+
+		// shift of the timespan into the past (Azure ingestion latency), per resource type with metricOffset=auto
+		MetricOffset              time.Duration
+		MetricOffsetAuto          bool
+		MetricOffsetResourceTypes map[string]time.Duration
+
+		// handling of timeseries without datapoints (omit, nan, gauge, keep)
+		MissingDatapoints     string
+		MissingDatapointsKeep int
+
+		// dry run: series with dummy values without metric requests, with simulated latency and failed requests
+		DryRun          bool
+		DryRunLatency   time.Duration
+		DryRunErrorRate float64
+
 		// cache
-		Cache *time.Duration
+		Cache              *time.Duration
+		ResourceGraphCache *time.Duration
 	}
 )
 
@@ -74,13 +174,32 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		DimensionLowercase: opts.Metrics.Dimensions.Lowercase,
 	}
 
-	params := r.URL.Query()
+	// param profile (built-in metric profile as defaults for other params)
+	params, err := applyMetricProfile(r.URL.Query())
+	if err != nil {
+		return ret, err
+	}
+	ret.Profile = strings.ToLower(params.Get("profile"))
 
 	// param name
 	ret.Name = paramsGetWithDefault(params, "name", PrometheusMetricNameDefault)
 
-	// param subscription
-	if subscriptionList, err := paramsGetListRequired(params, "subscription"); err == nil {
+	// param subscriptionFilter
+	if val, err := paramsGetList(params, "subscriptionFilter"); err == nil {
+		ret.SubscriptionFilter = val
+	} else {
+		return ret, err
+	}
+
+	// param subscriptionTag
+	if val, err := paramsGetList(params, "subscriptionTag"); err == nil {
+		ret.SubscriptionTags = val
+	} else {
+		return ret, err
+	}
+
+	// param subscription (optional if subscriptions are discovered)
+	if subscriptionList, err := paramsGetList(params, "subscription"); err == nil {
 		for _, subscription := range subscriptionList {
 			subscription = strings.TrimSpace(subscription)
 			ret.Subscriptions = append(ret.Subscriptions, subscription)
@@ -89,6 +208,10 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		return ret, err
 	}
 
+	if len(ret.Subscriptions) == 0 && !ret.SubscriptionDiscoveryEnabled() {
+		return ret, fmt.Errorf("parameter \"subscription\" is missing")
+	}
+
 	// param region
 	if val, err := paramsGetList(params, "region"); err == nil {
 		ret.Regions = val
@@ -99,6 +222,28 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 	// param filter
 	ret.ResourceType = paramsGetWithDefault(params, "resourceType", "")
 	ret.Filter = paramsGetWithDefault(params, "filter", "")
+
+	// param resourceName, resourceGroup, location (wildcards) and resourceNameRegex, resourceGroupRegex, locationRegex
+	if val, err := newResourceMatch(params); err == nil {
+		ret.ResourceMatch = val
+	} else {
+		return ret, err
+	}
+
+	// param query (named query) and param.<name>
+	ret.Query = paramsGetWithDefault(params, "query", "")
+	ret.QueryParams = map[string]string{}
+	for name := range params {
+		if paramName, found := strings.CutPrefix(name, ResourceGraphQueryParamPrefix); found && paramName != "" {
+			ret.QueryParams[paramName] = params.Get(name)
+		}
+	}
+	if ret.Query != "" && ret.Filter != "" {
+		return ret, fmt.Errorf(`parameters "query" and "filter" can't be combined`)
+	}
+	if ret.Query == "" && len(ret.QueryParams) > 0 {
+		return ret, fmt.Errorf(`parameters "%s<name>" are only supported with parameter "query"`, ResourceGraphQueryParamPrefix)
+	}
 	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "validateDimensions", "true")); err == nil {
 		ret.ValidateDimensions = val
 	} else {
@@ -108,8 +253,10 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 	// param timespan
 	ret.Timespan = paramsGetWithDefault(params, "timespan", "PT1M")
 
-	// param interval
-	if val := params.Get("interval"); val != "" {
+	// param interval (auto selects a supported time grain)
+	if val := params.Get("interval"); strings.EqualFold(val, IntervalAuto) {
+		ret.IntervalAuto = true
+	} else if val != "" {
 		ret.Interval = &val
 	}
 
@@ -120,18 +267,223 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		return ret, err
 	}
 
+	// param credential
+	ret.Credential = paramsGetWithDefault(params, "credential", "")
+
+	// param tenant
+	if val, err := paramsGetTenant(params, ret.Credential); err == nil {
+		ret.Tenant = val
+	} else {
+		return ret, err
+	}
+
+	// param tagLabels
+	if val, err := paramsGetList(params, "tagLabels"); err == nil {
+		for _, tagLabel := range val {
+			if tagLabel = strings.TrimSpace(tagLabel); tagLabel != "" {
+				ret.TagLabels = append(ret.TagLabels, tagLabel)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param reportErrors
+	if val, err := ParamReportErrors(params, opts); err == nil {
+		ret.ReportErrors = val
+	} else {
+		return ret, err
+	}
+
+	// param portalLinks
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "portalLinks", strconv.FormatBool(opts.Metrics.PortalLinks))); err == nil {
+		ret.PortalLinks = val
+	} else {
+		return ret, err
+	}
+
+	// param resourceIdLabel
+	ret.ResourceIdLabel = strings.ToLower(paramsGetWithDefault(params, "resourceIdLabel", opts.Metrics.ResourceIdLabel))
+	if ret.ResourceIdLabel == "" {
+		ret.ResourceIdLabel = ResourceIdLabelFull
+	}
+	if !stringListContainsFold(ResourceIdLabelModes, ret.ResourceIdLabel) {
+		return ret, fmt.Errorf(`parameter "resourceIdLabel" must be one of "%s"`, strings.Join(ResourceIdLabelModes, `", "`))
+	}
+
+	// param splitResourceId
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "splitResourceId", strconv.FormatBool(opts.Metrics.SplitResourceId))); err == nil {
+		ret.SplitResourceId = val
+	} else {
+		return ret, err
+	}
+
+	// param lowercaseLabels
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "lowercaseLabels", strconv.FormatBool(opts.Metrics.LowercaseLabels))); err == nil {
+		ret.LowercaseLabels = val
+	} else {
+		return ret, err
+	}
+
+	// param batch
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "batch", strconv.FormatBool(opts.Azure.MetricsBatch.Enabled))); err == nil {
+		ret.MetricsBatch = val
+	} else {
+		return ret, err
+	}
+
+	// param dryRun (always enabled with --development.dry-run)
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "dryRun", "false")); err == nil {
+		ret.DryRun = val || opts.Development.DryRun
+	} else {
+		return ret, err
+	}
+
+	// param dryRunLatency
+	if val, err := time.ParseDuration(paramsGetWithDefault(params, "dryRunLatency", opts.Development.DryRunLatency.String())); err == nil && val >= 0 {
+		ret.DryRunLatency = val
+	} else {
+		return ret, fmt.Errorf(`parameter "dryRunLatency" must be a duration >= 0 (eg. 500ms)`)
+	}
+
+	// param dryRunErrorRate
+	if val, err := strconv.ParseFloat(paramsGetWithDefault(params, "dryRunErrorRate", strconv.FormatFloat(opts.Development.DryRunErrorRate, 'f', -1, 64)), 64); err == nil && val >= 0 && val <= 1 {
+		ret.DryRunErrorRate = val
+	} else {
+		return ret, fmt.Errorf(`parameter "dryRunErrorRate" must be a number between 0 and 1`)
+	}
+
+	// metrics:getBatch is not simulated, dry runs use the requests per resource
+	if ret.DryRun {
+		ret.MetricsBatch = false
+	}
+
+	// param apiVersion
+	if val := params.Get("apiVersion"); val == "" || apiVersionRegexp.MatchString(val) {
+		ret.ApiVersion = val
+	} else {
+		return ret, fmt.Errorf(`parameter "apiVersion" must be an api version (eg. 2024-02-01)`)
+	}
+
+	// param priority
+	ret.Priority = strings.ToLower(paramsGetWithDefault(params, "priority", ProbePriorityNormal))
+	if !stringListContainsFold(ProbePriorities, ret.Priority) {
+		return ret, fmt.Errorf(`parameter "priority" must be one of "%s"`, strings.Join(ProbePriorities, `", "`))
+	}
+
+	// param vmssInstances
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "vmssInstances", "false")); err == nil {
+		ret.VmssInstances = val
+	} else {
+		return ret, err
+	}
+
+	// param aksNodePools
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "aksNodePools", "false")); err == nil {
+		ret.AksNodePools = val
+	} else {
+		return ret, err
+	}
+
+	// param storageServices
+	if val, err := paramsGetList(params, "storageServices"); err == nil {
+		if ret.StorageServices, err = parseStorageServices(val); err != nil {
+			return ret, err
+		}
+	} else {
+		return ret, err
+	}
+
+	// param exportTimestamps
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "exportTimestamps", strconv.FormatBool(opts.Metrics.ExportTimestamps))); err == nil {
+		ret.ExportTimestamps = val
+	} else {
+		return ret, err
+	}
+
+	// param datapoints (all datapoints can only be exported with timestamps)
+	ret.Datapoints = strings.ToLower(paramsGetWithDefault(params, "datapoints", DatapointsLatest))
+	switch ret.Datapoints {
+	case DatapointsLatest:
+	case DatapointsAll:
+		ret.ExportTimestamps = true
+	case DatapointsSummary:
+	default:
+		return ret, fmt.Errorf(`parameter "datapoints" must be "%s", "%s" or "%s"`, DatapointsLatest, DatapointsAll, DatapointsSummary)
+	}
+
+	// param metricOffset (duration or auto)
+	if val := paramsGetWithDefault(params, "metricOffset", opts.Metrics.Offset); strings.EqualFold(val, MetricOffsetAuto) {
+		ret.MetricOffsetAuto = true
+		if ret.MetricOffsetResourceTypes, err = parseMetricOffsetResourceTypes(opts.Metrics.OffsetResourceType); err != nil {
+			return ret, err
+		}
+	} else if duration, err := time.ParseDuration(val); err == nil && duration >= 0 {
+		ret.MetricOffset = duration
+	} else {
+		return ret, fmt.Errorf(`parameter "metricOffset" must be a duration >= 0 (eg. 5m) or "%s"`, MetricOffsetAuto)
+	}
+
+	// param alignTimespan
+	if val, err := strconv.ParseBool(paramsGetWithDefault(params, "alignTimespan", strconv.FormatBool(opts.Metrics.AlignTimespan))); err == nil {
+		ret.AlignTimespan = val
+	} else {
+		return ret, err
+	}
+
+	// param alignDelay
+	if val, err := time.ParseDuration(paramsGetWithDefault(params, "alignDelay", opts.Metrics.AlignDelay.String())); err == nil && val >= 0 {
+		ret.AlignDelay = val
+	} else {
+		return ret, fmt.Errorf(`parameter "alignDelay" must be a duration >= 0 (eg. 2m)`)
+	}
+
+	// param scrapeInterval (scrape timeout as lower bound if not set, the scrape interval is not sent by Prometheus)
+	if val := params.Get("scrapeInterval"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil && duration >= 0 {
+			ret.ScrapeInterval = duration
+		} else {
+			return ret, fmt.Errorf(`parameter "scrapeInterval" must be a duration >= 0 (eg. 1m)`)
+		}
+	} else if val := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); val != "" {
+		if seconds, err := strconv.ParseFloat(val, 64); err == nil && seconds > 0 && !math.IsInf(seconds, 0) {
+			ret.ScrapeInterval = time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	// param missingDatapoints
+	ret.MissingDatapoints = strings.ToLower(paramsGetWithDefault(params, "missingDatapoints", opts.Metrics.MissingDatapoints))
+	if !stringListContainsFold(MissingDatapointsModes, ret.MissingDatapoints) {
+		return ret, fmt.Errorf(`parameter "missingDatapoints" must be one of "%s"`, strings.Join(MissingDatapointsModes, `", "`))
+	}
+
+	// param missingDatapointsKeep
+	if val, err := strconv.Atoi(paramsGetWithDefault(params, "missingDatapointsKeep", strconv.Itoa(opts.Metrics.MissingDatapointsKeep))); err == nil && val >= 0 {
+		ret.MissingDatapointsKeep = val
+	} else {
+		return ret, fmt.Errorf(`parameter "missingDatapointsKeep" must be a number >= 0`)
+	}
+
 	// param metricNamespace
 	ret.MetricNamespace = paramsGetWithDefault(params, "metricNamespace", "")
+	if len(ret.StorageServices) > 0 && strings.HasPrefix(strings.ToLower(ret.MetricNamespace), "microsoft.storage/storageaccounts/") {
+		return ret, fmt.Errorf(`parameter "storageServices" can't be used with storage service metric namespace "%s"`, ret.MetricNamespace)
+	}
 
 	// param aggregation
 	if val, err := paramsGetList(params, "aggregation"); err == nil {
-		ret.Aggregations = val
+		for _, aggregation := range val {
+			if !stringListContainsFold(MetricAggregations, aggregation) && !strings.EqualFold(aggregation, AggregationDefault) {
+				return ret, fmt.Errorf(`parameter "aggregation" must be one of %s or %s`, strings.Join(MetricAggregations, ", "), AggregationDefault)
+			}
+		}
+		ret.Aggregations = uniqueStringList(val)
 	} else {
 		return ret, err
 	}
 
-	// param metricTop
-	if val := params.Get("metricTop"); val != "" {
+	// param metricTop (top as alias)
+	if val := paramsGetWithDefault(params, "top", params.Get("metricTop")); val != "" {
 		valInt64, err := strconv.ParseInt(val, 10, 32)
 		if err != nil {
 			return ret, err
@@ -143,20 +495,101 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 	// param metricFilter
 	ret.MetricFilter = paramsGetWithDefault(params, "metricFilter", "")
 
-	// param metricOrderBy
-	ret.MetricOrderBy = paramsGetWithDefault(params, "metricOrderBy", "")
+	// param dimFilter.<dimension> (filter expression of dimension values, extends metricFilter)
+	if val, err := paramsDimensionFilter(params); err == nil {
+		if val != "" && ret.MetricFilter != "" {
+			ret.MetricFilter = ret.MetricFilter + " and " + val
+		} else if val != "" {
+			ret.MetricFilter = val
+		}
+	} else {
+		return ret, err
+	}
+
+	// param dimension (split by dimension, extends metricFilter)
+	if val, err := paramsGetList(params, "dimension"); err == nil {
+		ret.Dimensions = val
+	} else {
+		return ret, err
+	}
+
+	if len(ret.Dimensions) > 0 {
+		filterList := []string{}
+		if ret.MetricFilter != "" {
+			filterList = append(filterList, ret.MetricFilter)
+		}
+		for _, dimension := range ret.Dimensions {
+			filterList = append(filterList, fmt.Sprintf("%s eq '*'", dimension))
+		}
+		ret.MetricFilter = strings.Join(filterList, " and ")
+	}
+
+	// param metricOrderBy (orderby as alias)
+	ret.MetricOrderBy = paramsGetWithDefault(params, "orderby", paramsGetWithDefault(params, "metricOrderBy", ""))
+
+	// param maxDimensionValues (limited by --metrics.max-dimension-values)
+	if val, err := strconv.Atoi(paramsGetWithDefault(params, "maxDimensionValues", strconv.Itoa(opts.Metrics.MaxDimensionValues))); err == nil && val >= 0 {
+		ret.MaxDimensionValues = val
+	} else {
+		return ret, fmt.Errorf(`parameter "maxDimensionValues" must be a number >= 0`)
+	}
+	if opts.Metrics.MaxDimensionValues > 0 && (ret.MaxDimensionValues == 0 || ret.MaxDimensionValues > opts.Metrics.MaxDimensionValues) {
+		ret.MaxDimensionValues = opts.Metrics.MaxDimensionValues
+	}
 
-	// param template
-	ret.MetricTemplate = paramsGetWithDefault(params, "template", opts.Metrics.Template)
+	// param maxDimensionValuesMode
+	ret.MaxDimensionValuesMode = strings.ToLower(paramsGetWithDefault(params, "maxDimensionValuesMode", DimensionOverflowOther))
+	if !stringListContainsFold(DimensionOverflowModes, ret.MaxDimensionValuesMode) {
+		return ret, fmt.Errorf(`parameter "maxDimensionValuesMode" must be one of "%s"`, strings.Join(DimensionOverflowModes, `", "`))
+	}
+
+	// param percentile
+	if val, err := paramsGetList(params, "percentile"); err == nil {
+		for _, percentile := range val {
+			if value, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToLower(percentile), "p"), 64); err == nil && value > 0 && value <= 100 {
+				ret.Percentiles = append(ret.Percentiles, value)
+			} else {
+				return ret, fmt.Errorf(`parameter "percentile" must be a number between 0 and 100 (eg. 50, 90, 99): %s`, percentile)
+			}
+		}
+	} else {
+		return ret, err
+	}
+
+	// param aggregateResources
+	ret.AggregateResources = strings.ToLower(paramsGetWithDefault(params, "aggregateResources", ""))
+	if ret.AggregateResources != "" && !stringListContainsFold(ResourceAggregations, ret.AggregateResources) {
+		return ret, fmt.Errorf(`parameter "aggregateResources" must be one of "%s"`, strings.Join(ResourceAggregations, `", "`))
+	}
+
+	// param aggregateResourcesBy
+	ret.AggregateResourcesBy = strings.ToLower(paramsGetWithDefault(params, "aggregateResourcesBy", ResourceAggregationByResourceGroup))
+	if !stringListContainsFold(ResourceAggregationsBy, ret.AggregateResourcesBy) {
+		return ret, fmt.Errorf(`parameter "aggregateResourcesBy" must be one of "resourceGroup", "subscription"`)
+	}
+
+	// param template (metricTemplate as alias)
+	ret.MetricTemplate = paramsGetWithDefault(params, "template", paramsGetWithDefault(params, "metricTemplate", opts.Metrics.Template))
+	if val, err := NewMetricTemplate("template", ret.MetricTemplate); err == nil {
+		ret.MetricGoTemplate = val
+	} else {
+		return ret, err
+	}
 
 	// param help
 	ret.HelpTemplate = paramsGetWithDefault(params, "help", opts.Metrics.Help)
+	if val, err := NewMetricTemplate("help", ret.HelpTemplate); err == nil {
+		ret.HelpGoTemplate = val
+	} else {
+		return ret, err
+	}
 
-	// param cache (timespan as default)
+	// param cache (--cache.metrics.ttl or timespan as default, cache=0 bypasses the cache)
 	if opts.Prober.Cache {
-		cacheDefaultDuration, err := iso8601.FromString(ret.Timespan)
 		cacheDefaultDurationString := ""
-		if err == nil {
+		if opts.Cache.MetricsTtl > 0 {
+			cacheDefaultDurationString = opts.Cache.MetricsTtl.String()
+		} else if cacheDefaultDuration, err := iso8601.FromString(ret.Timespan); err == nil {
 			cacheDefaultDurationString = cacheDefaultDuration.ToDuration().String()
 		}
 
@@ -165,13 +598,24 @@ func NewRequestMetricSettings(r *http.Request, opts config.Opts) (RequestMetricS
 		// only enable caching if value is set
 		if cacheDurationString != "" {
 			if val, err := time.ParseDuration(cacheDurationString); err == nil {
-				ret.Cache = &val
+				if val > 0 {
+					ret.Cache = &val
+				}
 			} else {
 				return ret, err
 			}
 		}
 	}
 
+	// param resourceGraphCache
+	if val := params.Get("resourceGraphCache"); val != "" {
+		if duration, err := time.ParseDuration(val); err == nil {
+			ret.ResourceGraphCache = &duration
+		} else {
+			return ret, err
+		}
+	}
+
 	return ret, nil
 }
 
@@ -192,5 +636,18 @@ func (s *RequestMetricSettings) SetMetrics(val string) {
 }
 
 func (s *RequestMetricSettings) SetAggregations(val string) {
-	s.Aggregations = stringToStringList(val, ",")
+	s.Aggregations = uniqueStringList(stringToStringList(val, ","))
+}
+
+// SubscriptionDiscoveryEnabled returns true if subscriptions should be discovered using subscriptionFilter or subscriptionTag
+func (s *RequestMetricSettings) SubscriptionDiscoveryEnabled() bool {
+	return len(s.Subscriptions) == 0 && (len(s.SubscriptionFilter) > 0 || len(s.SubscriptionTags) > 0)
+}
+
+// CredentialKey identifies the credential (named credential or tenant) of the probe for cache keys
+func (s *RequestMetricSettings) CredentialKey() string {
+	if s.Tenant != "" {
+		return "tenant:" + s.Tenant
+	}
+	return strings.ToLower(s.Credential)
 }
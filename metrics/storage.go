@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	StorageAccountResourceType = "Microsoft.Storage/storageAccounts"
+
+	StorageServiceLabel = "service"
+	StorageServicesAll  = "all"
+)
+
+var (
+	// storage services (parameter storageServices) and their metric namespaces
+	StorageServices = map[string]string{
+		"blob":  "Microsoft.Storage/storageAccounts/blobServices",
+		"file":  "Microsoft.Storage/storageAccounts/fileServices",
+		"queue": "Microsoft.Storage/storageAccounts/queueServices",
+		"table": "Microsoft.Storage/storageAccounts/tableServices",
+	}
+)
+
+// parseStorageServices parses the storageServices parameter (blob, file, queue, table or all)
+func parseStorageServices(list []string) ([]string, error) {
+	ret := []string{}
+	for _, service := range list {
+		service = strings.ToLower(strings.TrimSpace(service))
+		switch {
+		case service == StorageServicesAll:
+			return []string{"blob", "file", "queue", "table"}, nil
+		case StorageServices[service] != "":
+			ret = append(ret, service)
+		default:
+			return nil, fmt.Errorf(`parameter "storageServices" contains invalid service "%s", expected blob, file, queue, table or all`, service)
+		}
+	}
+	return uniqueStringList(ret), nil
+}
+
+// expandStorageServices adds the services (blob, file, queue, table) of storage account targets as targets
+// (storageServices parameter), service metrics are exported with the storage account and the service label.
+// Metrics not supported by a service (or the storage account) are removed using the metric definitions.
+func (p *MetricProber) expandStorageServices() {
+	serviceTargets := []MetricProbeTarget{}
+	for subscriptionId, targetList := range p.targets {
+		accountTargets := []MetricProbeTarget{}
+		for _, target := range targetList {
+			if target.ParentResourceId != "" || !strings.EqualFold(ResourceTypeFromResourceId(target.ResourceId), StorageAccountResourceType) {
+				accountTargets = append(accountTargets, target)
+				continue
+			}
+
+			// storage account metrics (empty service label)
+			if target.Metrics = p.filterSupportedMetrics(target.ResourceId, p.settings.MetricNamespace, target.Metrics); len(target.Metrics) > 0 {
+				accountTargets = append(accountTargets, target)
+			}
+
+			for _, service := range p.settings.StorageServices {
+				serviceTarget := MetricProbeTarget{
+					ResourceId:       target.ResourceId,
+					Location:         target.Location,
+					Aggregations:     target.Aggregations,
+					Tags:             target.Tags,
+					MetricNamespace:  StorageServices[service],
+					ParentResourceId: target.ResourceId,
+					Labels:           map[string]string{StorageServiceLabel: service},
+				}
+				if serviceTarget.Metrics = p.filterSupportedMetrics(target.ResourceId, serviceTarget.MetricNamespace, target.Metrics); len(serviceTarget.Metrics) > 0 {
+					serviceTargets = append(serviceTargets, serviceTarget)
+				}
+			}
+		}
+		p.targets[subscriptionId] = accountTargets
+	}
+
+	p.AddTarget(serviceTargets...)
+}
+
+// filterSupportedMetrics returns the metrics supported by the resource (metric definitions),
+// all metrics are returned if the definitions are not available
+func (p *MetricProber) filterSupportedMetrics(resourceId, metricNamespace string, metrics []string) []string {
+	if p.metricDefinitionCache == nil {
+		return metrics
+	}
+
+	definitionList, err := p.metricDefinitionCache.Get(p.ctx, p.GetCred(), NewArmClientOptions(p.AzureClient), metricResourceUri(resourceId, metricNamespace), metricNamespace)
+	if err != nil {
+		p.logger.Warnf("unable to filter metrics: %v", err)
+		return metrics
+	}
+
+	ret := []string{}
+	for _, metric := range metrics {
+		for _, definition := range definitionList {
+			if strings.EqualFold(definition.Name, metric) {
+				ret = append(ret, metric)
+				break
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	SubscriptionStateEnabled = "enabled"
+)
+
+var (
+	PrometheusSubscriptionInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_subscription_info",
+			Help: "Azure subscriptions found by subscription discovery",
+		},
+		[]string{
+			"subscriptionID",
+			"subscriptionName",
+			"state",
+		},
+	)
+)
+
+type (
+	// SubscriptionDiscovery lists the subscriptions visible to a credential and filters them by name and tags,
+	// results are kept per credential and refreshed in background
+	SubscriptionDiscovery struct {
+		refreshInterval time.Duration
+
+		lock    sync.Mutex
+		entries map[string]*subscriptionDiscoveryEntry
+
+		// one discovery per credential at a time, concurrent probes wait for its result
+		flight singleflight.Group
+	}
+
+	subscriptionDiscoveryEntry struct {
+		cred       azcore.TokenCredential
+		clientOpts *arm.ClientOptions
+
+		subscriptions []DiscoveredSubscription
+		lastAccess    time.Time
+	}
+
+	DiscoveredSubscription struct {
+		ID    string            `json:"id"`
+		Name  string            `json:"name"`
+		State string            `json:"state"`
+		Tags  map[string]string `json:"tags"`
+	}
+)
+
+func NewSubscriptionDiscovery(refreshInterval time.Duration) *SubscriptionDiscovery {
+	d := SubscriptionDiscovery{}
+	d.refreshInterval = refreshInterval
+	d.entries = map[string]*subscriptionDiscoveryEntry{}
+	return &d
+}
+
+// Start refreshes the subscriptions of all credentials every refresh interval until the context is done,
+// credentials which were not requested since the previous refresh are removed
+func (d *SubscriptionDiscovery) Start(ctx context.Context, logger *zap.SugaredLogger) {
+	if d.refreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.refreshInterval)
+		defer ticker.Stop()
+
+		lastRefresh := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			for cacheKey, entry := range d.expire(lastRefresh) {
+				refreshCtx, cancel := context.WithTimeout(ctx, d.refreshInterval)
+				if _, err := d.refresh(refreshCtx, cacheKey, entry.cred, entry.clientOpts); err != nil {
+					// previous subscriptions are kept
+					logger.Warnf("background refresh of subscription discovery failed: %v", err)
+				}
+				cancel()
+			}
+			lastRefresh = time.Now()
+		}
+	}()
+}
+
+// expire removes the entries which were not requested since the time and returns the remaining entries
+func (d *SubscriptionDiscovery) expire(since time.Time) map[string]subscriptionDiscoveryEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ret := map[string]subscriptionDiscoveryEntry{}
+	for cacheKey, entry := range d.entries {
+		if entry.lastAccess.Before(since) {
+			for _, subscription := range entry.subscriptions {
+				PrometheusSubscriptionInfo.DeleteLabelValues(subscription.ID, subscription.Name, subscription.State)
+			}
+			delete(d.entries, cacheKey)
+			continue
+		}
+		ret[cacheKey] = *entry
+	}
+	return ret
+}
+
+// Find returns the IDs of the enabled subscriptions matching one of the name filters (glob, eg. "prod-*")
+// and all tag filters ("name=value" with glob support or "name" if tag only needs to exist)
+func (d *SubscriptionDiscovery) Find(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, cacheKey string, nameFilter, tagFilter []string) ([]string, error) {
+	subscriptionList, err := d.List(ctx, cred, clientOpts, cacheKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, subscription := range subscriptionList {
+		if !strings.EqualFold(subscription.State, SubscriptionStateEnabled) {
+			continue
+		}
+
+		if subscription.matchesName(nameFilter) && subscription.matchesTags(tagFilter) {
+			ret = append(ret, subscription.ID)
+		}
+	}
+
+	return ret, nil
+}
+
+// List returns all subscriptions visible to the credential, the first request of a credential lists the subscriptions
+func (d *SubscriptionDiscovery) List(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions, cacheKey string) ([]DiscoveredSubscription, error) {
+	d.lock.Lock()
+	entry, exists := d.entries[cacheKey]
+	var subscriptionList []DiscoveredSubscription
+	if exists {
+		entry.lastAccess = time.Now()
+		subscriptionList = entry.subscriptions
+	}
+	d.lock.Unlock()
+
+	if exists {
+		PrometheusCacheRequests.WithLabelValues("subscriptiondiscovery", CacheResultHit).Inc()
+		return subscriptionList, nil
+	}
+	PrometheusCacheRequests.WithLabelValues("subscriptiondiscovery", CacheResultMiss).Inc()
+
+	return d.refresh(ctx, cacheKey, cred, clientOpts)
+}
+
+// refresh lists the subscriptions of the credential (without holding the lock) and stores the result
+func (d *SubscriptionDiscovery) refresh(ctx context.Context, cacheKey string, cred azcore.TokenCredential, clientOpts *arm.ClientOptions) ([]DiscoveredSubscription, error) {
+	result, err, _ := d.flight.Do(cacheKey, func() (interface{}, error) {
+		subscriptionList, err := listSubscriptions(ctx, cred, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		// update info metric, remove subscriptions of previous discovery
+		entry, exists := d.entries[cacheKey]
+		if exists {
+			for _, subscription := range entry.subscriptions {
+				PrometheusSubscriptionInfo.DeleteLabelValues(subscription.ID, subscription.Name, subscription.State)
+			}
+		} else {
+			entry = &subscriptionDiscoveryEntry{lastAccess: time.Now()}
+			d.entries[cacheKey] = entry
+		}
+		for _, subscription := range subscriptionList {
+			PrometheusSubscriptionInfo.WithLabelValues(subscription.ID, subscription.Name, subscription.State).Set(1)
+		}
+
+		entry.cred = cred
+		entry.clientOpts = clientOpts
+		entry.subscriptions = subscriptionList
+		return subscriptionList, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]DiscoveredSubscription), nil
+}
+
+func listSubscriptions(ctx context.Context, cred azcore.TokenCredential, clientOpts *arm.ClientOptions) ([]DiscoveredSubscription, error) {
+	client, err := armsubscriptions.NewClient(cred, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptionList := []DiscoveredSubscription{}
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		result, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list subscriptions: %w", err)
+		}
+
+		for _, subscription := range result.Value {
+			discoveredSubscription := DiscoveredSubscription{
+				Tags: map[string]string{},
+			}
+			if subscription.SubscriptionID != nil {
+				discoveredSubscription.ID = *subscription.SubscriptionID
+			}
+			if subscription.DisplayName != nil {
+				discoveredSubscription.Name = *subscription.DisplayName
+			}
+			if subscription.State != nil {
+				discoveredSubscription.State = string(*subscription.State)
+			}
+			for tagName, tagValue := range subscription.Tags {
+				if tagValue != nil {
+					discoveredSubscription.Tags[tagName] = *tagValue
+				}
+			}
+			subscriptionList = append(subscriptionList, discoveredSubscription)
+		}
+	}
+
+	return subscriptionList, nil
+}
+
+func (s *DiscoveredSubscription) matchesName(nameFilter []string) bool {
+	if len(nameFilter) == 0 {
+		return true
+	}
+
+	for _, filter := range nameFilter {
+		filter = strings.ToLower(strings.TrimSpace(filter))
+		if matchGlob(filter, s.Name) || matchGlob(filter, s.ID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *DiscoveredSubscription) matchesTags(tagFilter []string) bool {
+	for _, filter := range tagFilter {
+		tagName, tagValueFilter, hasValue := strings.Cut(strings.TrimSpace(filter), "=")
+
+		found := false
+		for name, value := range s.Tags {
+			if strings.EqualFold(name, tagName) {
+				found = !hasValue || matchGlob(strings.ToLower(tagValueFilter), value)
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchGlob matches value against lowercase glob pattern (case-insensitive)
+func matchGlob(pattern, value string) bool {
+	matched, err := path.Match(pattern, strings.ToLower(value))
+	return err == nil && matched
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// template fields backed by labels, labels used in the metric name are removed
+	metricTemplateFieldLabels = map[string]string{
+		"Metric":         "metric",
+		"Aggregation":    "aggregation",
+		"Unit":           "unit",
+		"Interval":       "interval",
+		"Timespan":       "timespan",
+		"ResourceID":     "resourceID",
+		"SubscriptionID": "subscriptionID",
+		"ResourceGroup":  "resourceGroup",
+		"ResourceName":   "resourceName",
+	}
+
+	metricTemplateFuncs = template.FuncMap{
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"replace":    func(old, new, val string) string { return strings.ReplaceAll(val, old, new) },
+		"trimPrefix": func(prefix, val string) string { return strings.TrimPrefix(val, prefix) },
+		"trimSuffix": func(suffix, val string) string { return strings.TrimSuffix(val, suffix) },
+		"sanitize":   func(val string) string { return metricNameReplacer.Replace(val) },
+
+		// resourceIdPart returns the segment after the key of the resource id (eg. resourceGroups, providers, vaults)
+		"resourceIdPart": func(key, resourceId string) string {
+			parts := strings.Split(strings.Trim(resourceId, "/"), "/")
+			for i := 0; i < len(parts)-1; i++ {
+				if strings.EqualFold(parts[i], key) {
+					return parts[i+1]
+				}
+			}
+			return ""
+		},
+
+		// resourceTypeName returns the last part of the resource type (eg. vaults for Microsoft.KeyVault/vaults)
+		"resourceTypeName": func(resourceType string) string {
+			parts := strings.Split(resourceType, "/")
+			return parts[len(parts)-1]
+		},
+	}
+)
+
+type (
+	// MetricTemplate is a Go template for metric names and help (eg. azurerm_{{ .ResourceType | resourceTypeName }}_{{ .Metric }}),
+	// templates without "{{" use the placeholder syntax ({name}_{metric})
+	MetricTemplate struct {
+		template *template.Template
+
+		// labels used in the template
+		labels []string
+	}
+
+	MetricTemplateData struct {
+		// name parameter
+		Name string
+
+		// resource type or metric namespace of the request
+		Type string
+
+		// resource type of the resource id
+		ResourceType string
+
+		Metric         string
+		Aggregation    string
+		Unit           string
+		Interval       string
+		Timespan       string
+		ResourceID     string
+		SubscriptionID string
+		ResourceGroup  string
+		ResourceName   string
+
+		Labels map[string]string
+	}
+)
+
+// IsGoTemplate returns true if the template uses the Go template syntax
+func IsGoTemplate(val string) bool {
+	return strings.Contains(val, "{{")
+}
+
+// NewMetricTemplate parses the Go template, returns nil if the template uses the placeholder syntax
+func NewMetricTemplate(name, val string) (*MetricTemplate, error) {
+	if !IsGoTemplate(val) {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=zero").Funcs(metricTemplateFuncs).Parse(val)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid template "%s": %w`, val, err)
+	}
+
+	ret := MetricTemplate{template: tmpl}
+	walkTemplateFields(tmpl.Tree.Root, func(field []string) {
+		switch {
+		case len(field) == 2 && field[0] == "Labels":
+			ret.labels = append(ret.labels, field[1])
+		case len(field) == 1:
+			if label, exists := metricTemplateFieldLabels[field[0]]; exists {
+				ret.labels = append(ret.labels, label)
+			}
+		}
+	})
+
+	return &ret, nil
+}
+
+// Execute renders the template with the labels of the metric
+func (t *MetricTemplate) Execute(name, resourceType string, labels prometheus.Labels) (string, error) {
+	data := MetricTemplateData{
+		Name:           name,
+		Type:           resourceType,
+		Metric:         labels["metric"],
+		Aggregation:    labels["aggregation"],
+		Unit:           labels["unit"],
+		Interval:       labels["interval"],
+		Timespan:       labels["timespan"],
+		ResourceID:     labels["resourceID"],
+		SubscriptionID: labels["subscriptionID"],
+		ResourceGroup:  labels["resourceGroup"],
+		ResourceName:   labels["resourceName"],
+		Labels:         labels,
+	}
+
+	data.ResourceType = ResourceTypeFromResourceId(data.ResourceID)
+
+	buf := bytes.Buffer{}
+	if err := t.template.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RemoveLabels removes the labels used in the template (metric name)
+func (t *MetricTemplate) RemoveLabels(labels prometheus.Labels) {
+	for _, label := range t.labels {
+		delete(labels, label)
+	}
+}
+
+// walkTemplateFields calls fn for every field (eg. .Metric, .Labels.dimension) used in the template
+func walkTemplateFields(node parse.Node, fn func(field []string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplateFields(child, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplateFields(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplateFields(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkTemplateFields(arg, fn)
+		}
+	case *parse.FieldNode:
+		fn(n.Ident)
+	case *parse.IfNode:
+		walkTemplateFields(n.Pipe, fn)
+		walkTemplateFields(n.List, fn)
+		walkTemplateFields(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplateFields(n.Pipe, fn)
+		walkTemplateFields(n.List, fn)
+		walkTemplateFields(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplateFields(n.Pipe, fn)
+		walkTemplateFields(n.List, fn)
+		walkTemplateFields(n.ElseList, fn)
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	iso8601 "github.com/channelmeter/iso8601duration"
+)
+
+const (
+	// time grain used for timespan alignment without interval
+	TimespanAlignDefaultTimeGrain = time.Minute
+
+	// metric offset of resource types without default (metricOffset=auto)
+	MetricOffsetAuto        = "auto"
+	MetricOffsetAutoDefault = 3 * time.Minute
+)
+
+var (
+	// MetricOffsetResourceTypeDefaults are the ingestion latencies of resource types (metricOffset=auto), resource types
+	// match with their sub resources (eg. microsoft.storage/storageaccounts/blobservices)
+	MetricOffsetResourceTypeDefaults = map[string]time.Duration{
+		"microsoft.storage/storageaccounts":         15 * time.Minute,
+		"microsoft.compute/virtualmachines":         3 * time.Minute,
+		"microsoft.compute/virtualmachinescalesets": 3 * time.Minute,
+		"microsoft.sql/servers":                     5 * time.Minute,
+		"microsoft.web/sites":                       5 * time.Minute,
+		"microsoft.network/loadbalancers":           3 * time.Minute,
+		"microsoft.network/applicationgateways":     3 * time.Minute,
+	}
+)
+
+// MetricOffsetFor returns the metric offset of the resource type, with metricOffset=auto the offset of the
+// resource type (longest matching resource type of --metrics.offset.resource-type or defaults)
+func (s *RequestMetricSettings) MetricOffsetFor(resourceType string) time.Duration {
+	if !s.MetricOffsetAuto {
+		return s.MetricOffset
+	}
+
+	resourceType = strings.ToLower(resourceType)
+	offset, matchLength := MetricOffsetAutoDefault, 0
+	for offsetResourceType, resourceTypeOffset := range s.MetricOffsetResourceTypes {
+		if (resourceType == offsetResourceType || strings.HasPrefix(resourceType, offsetResourceType+"/")) && len(offsetResourceType) > matchLength {
+			offset, matchLength = resourceTypeOffset, len(offsetResourceType)
+		}
+	}
+	return offset
+}
+
+// parseMetricOffsetResourceTypes returns the resource type defaults with the configured offsets (type=duration)
+func parseMetricOffsetResourceTypes(list []string) (map[string]time.Duration, error) {
+	ret := map[string]time.Duration{}
+	for resourceType, offset := range MetricOffsetResourceTypeDefaults {
+		ret[resourceType] = offset
+	}
+
+	for _, entry := range list {
+		resourceType, value, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(resourceType) == "" {
+			return nil, fmt.Errorf(`metric offset "%s" must be resourceType=duration`, entry)
+		}
+		offset, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf(`metric offset "%s" must be resourceType=duration (eg. Microsoft.Storage/storageAccounts=15m)`, entry)
+		}
+		ret[strings.ToLower(strings.TrimSpace(resourceType))] = offset
+	}
+
+	return ret, nil
+}
+
+// requestTimespan returns the timespan of the metric request for the resource type, the relative timespan (eg. PT5M)
+// is shifted into the past by the metric offset (parameter metricOffset, Azure ingestion latency of the resource type)
+// and translated into an absolute time interval (start/end)
+func (p *MetricProber) requestTimespan(resourceType string, interval *string, timespan string) string {
+	offset := p.settings.MetricOffsetFor(resourceType)
+	if (!p.settings.AlignTimespan && offset == 0) || strings.Contains(timespan, "/") {
+		return timespan
+	}
+
+	timespanDuration, err := iso8601.FromString(timespan)
+	if err != nil {
+		return timespan
+	}
+
+	if !p.settings.AlignTimespan {
+		endTime := time.Now().UTC().Add(-offset)
+		return endTime.Add(-timespanDuration.ToDuration()).Format(time.RFC3339) + "/" + endTime.Format(time.RFC3339)
+	}
+
+	return p.alignTimespan(interval, timespanDuration.ToDuration(), offset)
+}
+
+// alignTimespan returns the absolute time interval (start/end) of the timespan ending at the last completed time
+// grain before now minus the offset and delay (parameter alignDelay), so each scrape picks the newly finalized
+// datapoints instead of the incomplete current time grain. The timespan covers at least the scrape interval
+// (parameter scrapeInterval), so no datapoint falls between two scrapes.
+func (p *MetricProber) alignTimespan(interval *string, timespan, offset time.Duration) string {
+	timeGrain := TimespanAlignDefaultTimeGrain
+	if interval != nil {
+		if timeGrainDuration, err := iso8601.FromString(*interval); err == nil && timeGrainDuration.ToDuration() > 0 {
+			timeGrain = timeGrainDuration.ToDuration()
+		}
+	}
+
+	startTime, endTime := alignTimeInterval(time.Now(), timespan, timeGrain, offset+p.settings.AlignDelay, p.settings.ScrapeInterval)
+	return startTime.Format(time.RFC3339) + "/" + endTime.Format(time.RFC3339)
+}
+
+// alignTimeInterval returns the time interval of complete time grains ending before now minus delay,
+// the duration is extended to the scrape interval and rounded up to full time grains
+func alignTimeInterval(now time.Time, duration, timeGrain, delay, scrapeInterval time.Duration) (time.Time, time.Time) {
+	if scrapeInterval > duration {
+		duration = scrapeInterval
+	}
+	if remainder := duration % timeGrain; remainder != 0 {
+		duration += timeGrain - remainder
+	}
+
+	endTime := now.UTC().Add(-delay).Truncate(timeGrain)
+	return endTime.Add(-duration), endTime
+}
+
+// parseTimespanInterval returns the start and end time of the timespan, relative timespans (ISO8601 duration) end now
+func parseTimespanInterval(timespan string) (time.Time, time.Time, error) {
+	if startValue, endValue, found := strings.Cut(timespan, "/"); found {
+		startTime, err := time.Parse(time.RFC3339, startValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf(`invalid start time "%s": %w`, startValue, err)
+		}
+		endTime, err := time.Parse(time.RFC3339, endValue)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf(`invalid end time "%s": %w`, endValue, err)
+		}
+		return startTime.UTC(), endTime.UTC(), nil
+	}
+
+	timespanDuration, err := iso8601.FromString(timespan)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	endTime := time.Now().UTC()
+	return endTime.Add(-timespanDuration.ToDuration()), endTime, nil
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	iso8601 "github.com/channelmeter/iso8601duration"
+)
+
+var (
+	// Azure Monitor aggregation types
+	MetricAggregations = []string{"average", "count", "maximum", "minimum", "total"}
+
+	// dimension names of metricFilter (eg. "ApiName eq '*' and GeoType eq 'Primary'")
+	metricFilterDimensionRegexp = regexp.MustCompile(`(?i)([a-z0-9_.\-/]+)\s+(eq|ne|sw)\s+'`)
+)
+
+type (
+	// ValidationProblem is a misconfigured probe parameter found by /probe/validate
+	ValidationProblem struct {
+		Parameter string `json:"parameter"`
+		Value     string `json:"value,omitempty"`
+		Message   string `json:"message"`
+	}
+)
+
+func newValidationProblem(parameter, value, message string, args ...interface{}) ValidationProblem {
+	return ValidationProblem{
+		Parameter: parameter,
+		Value:     value,
+		Message:   fmt.Sprintf(message, args...),
+	}
+}
+
+// ValidateMetricSettings checks timespan, interval and aggregations without calling the Azure API
+func ValidateMetricSettings(settings *RequestMetricSettings) (problems []ValidationProblem) {
+	if !isValidTimespan(settings.Timespan) {
+		problems = append(problems, newValidationProblem("timespan", settings.Timespan, "invalid timespan, expected ISO8601 duration (eg. PT5M) or time interval (start/end)"))
+	}
+
+	if settings.Interval != nil {
+		if _, err := iso8601.FromString(*settings.Interval); err != nil {
+			problems = append(problems, newValidationProblem("interval", *settings.Interval, "invalid interval, expected ISO8601 duration (eg. PT1M) or auto"))
+		}
+	}
+
+	for _, aggregation := range settings.Aggregations {
+		if !stringListContainsFold(MetricAggregations, aggregation) && !strings.EqualFold(aggregation, AggregationDefault) {
+			problems = append(problems, newValidationProblem("aggregation", aggregation, "unknown aggregation, expected one of %s or %s", strings.Join(MetricAggregations, ", "), AggregationDefault))
+		}
+	}
+
+	return
+}
+
+// ValidateMetricDefinitions checks metrics, aggregations, interval and dimensions against the metric definitions of the resource type
+func ValidateMetricDefinitions(settings *RequestMetricSettings, definitionList []MetricDefinition) (problems []ValidationProblem) {
+	definitionMap := map[string]MetricDefinition{}
+	for _, definition := range definitionList {
+		definitionMap[strings.ToLower(definition.Name)] = definition
+	}
+
+	knownMetrics := []MetricDefinition{}
+	for _, metric := range settings.Metrics {
+		definition, exists := definitionMap[strings.ToLower(metric)]
+		if !exists {
+			problems = append(problems, newValidationProblem("metric", metric, "unknown metric for resource type (see /probe/metrics/definitions)"))
+			continue
+		}
+		knownMetrics = append(knownMetrics, definition)
+
+		for _, aggregation := range settings.Aggregations {
+			if len(definition.Aggregations) > 0 && stringListContainsFold(MetricAggregations, aggregation) && !stringListContainsFold(definition.Aggregations, aggregation) {
+				problems = append(problems, newValidationProblem("aggregation", aggregation, `aggregation not supported by metric "%s" (%s)`, definition.Name, strings.Join(definition.Aggregations, ", ")))
+			}
+		}
+
+		if settings.Interval != nil && len(definition.TimeGrains) > 0 && !stringListContainsFold(definition.TimeGrains, *settings.Interval) {
+			problems = append(problems, newValidationProblem("interval", *settings.Interval, `interval not supported by metric "%s" (%s), use interval=auto`, definition.Name, strings.Join(definition.TimeGrains, ", ")))
+		}
+	}
+
+	// dimensions of metricFilter and dimension must exist for at least one metric
+	for _, match := range metricFilterDimensionRegexp.FindAllStringSubmatch(settings.MetricFilter, -1) {
+		dimension := match[1]
+		if strings.EqualFold(dimension, "Microsoft.ResourceId") {
+			continue
+		}
+
+		found := false
+		for _, definition := range knownMetrics {
+			if stringListContainsFold(definition.Dimensions, dimension) {
+				found = true
+			}
+		}
+		if !found && len(knownMetrics) > 0 {
+			problems = append(problems, newValidationProblem("metricFilter", dimension, "unknown dimension for requested metrics"))
+		}
+	}
+
+	return
+}
+
+// isValidTimespan checks if the timespan is an ISO8601 duration or time interval (start/end or start/duration)
+func isValidTimespan(timespan string) bool {
+	parts := strings.Split(timespan, "/")
+	switch len(parts) {
+	case 1:
+		_, err := iso8601.FromString(timespan)
+		return err == nil
+	case 2:
+		for _, part := range parts {
+			if _, err := time.Parse(time.RFC3339, part); err != nil {
+				if _, err := iso8601.FromString(part); err != nil {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func stringListContainsFold(list []string, value string) bool {
+	for _, val := range list {
+		if strings.EqualFold(val, value) {
+			return true
+		}
+	}
+	return false
+}
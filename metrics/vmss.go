@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/remeh/sizedwaitgroup"
+)
+
+const (
+	VmssResourceType            = "Microsoft.Compute/virtualMachineScaleSets"
+	VmssInstanceMetricNamespace = "Microsoft.Compute/virtualMachineScaleSets/virtualMachines"
+
+	VmssApiVersion = "2023-09-01"
+
+	VmssInstanceLabel = "instance"
+)
+
+type (
+	vmssInstanceListResponse struct {
+		Value []struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			InstanceId string `json:"instanceId"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+)
+
+// expandVmssInstances adds the instances of VM Scale Set targets as targets (vmssInstances=true),
+// instance metrics are exported with the resource of the scale set and the instance label
+func (p *MetricProber) expandVmssInstances() {
+	client, err := arm.NewClient("azure-metrics-exporter", "", p.GetCred(), NewArmClientOptions(p.AzureClient))
+	if err != nil {
+		p.logger.Error(err)
+		p.reportError("", err)
+		return
+	}
+
+	scaleSets := []MetricProbeTarget{}
+	for _, targetList := range p.targets {
+		for _, target := range targetList {
+			if target.ParentResourceId == "" && strings.EqualFold(ResourceTypeFromResourceId(target.ResourceId), VmssResourceType) {
+				scaleSets = append(scaleSets, target)
+			}
+		}
+	}
+
+	lock := sync.Mutex{}
+	instanceTargets := []MetricProbeTarget{}
+	wg := sizedwaitgroup.New(p.Conf.Prober.ConcurrencySubscriptionResource)
+	for _, target := range scaleSets {
+		wg.Add()
+		go func(target MetricProbeTarget) {
+			defer wg.Done()
+
+			targetList, err := p.listVmssInstanceTargets(p.ctx, client, target)
+			if err != nil {
+				p.logger.Warn(err)
+				p.reportError(target.ResourceId, err)
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			instanceTargets = append(instanceTargets, targetList...)
+		}(target)
+	}
+	wg.Wait()
+
+	p.AddTarget(instanceTargets...)
+}
+
+// listVmssInstanceTargets returns a target for each instance of the scale set
+func (p *MetricProber) listVmssInstanceTargets(ctx context.Context, client *arm.Client, target MetricProbeTarget) ([]MetricProbeTarget, error) {
+	endpoint := fmt.Sprintf(
+		"%s%s/virtualMachines?api-version=%s",
+		strings.TrimRight(client.Endpoint(), "/"),
+		target.ResourceId,
+		resourceApiVersion(VmssResourceType+"/virtualMachines", VmssApiVersion),
+	)
+
+	ret := []MetricProbeTarget{}
+	for endpoint != "" {
+		req, err := runtime.NewRequest(ctx, http.MethodGet, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		req.Raw().Header.Set("Accept", "application/json")
+
+		resp, err := client.Pipeline().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, fmt.Errorf(`unable to list instances of VM Scale Set "%s": %w`, target.ResourceId, runtime.NewResponseError(resp))
+		}
+
+		response := vmssInstanceListResponse{}
+		if err := runtime.UnmarshalAsJSON(resp, &response); err != nil {
+			return nil, err
+		}
+
+		for _, instance := range response.Value {
+			ret = append(ret, MetricProbeTarget{
+				ResourceId:       instance.ID,
+				Location:         target.Location,
+				Metrics:          target.Metrics,
+				Aggregations:     target.Aggregations,
+				Tags:             target.Tags,
+				MetricNamespace:  VmssInstanceMetricNamespace,
+				ParentResourceId: target.ResourceId,
+				Labels:           map[string]string{VmssInstanceLabel: instance.Name},
+			})
+		}
+
+		endpoint = response.NextLink
+	}
+
+	return ret, nil
+}
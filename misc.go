@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	stringsCommon "github.com/webdevops/go-common/strings"
 	"go.uber.org/zap"
@@ -24,6 +26,8 @@ func buildContextLoggerFromRequest(r *http.Request) *zap.SugaredLogger {
 	return contextLogger
 }
 
+// getPrometheusTimeout returns the probe timeout (seconds): parameter timeout, limited by the Prometheus scrape timeout
+// (X-Prometheus-Scrape-Timeout-Seconds header minus --probe.timeout-offset), defaultTimeout if neither is set
 func getPrometheusTimeout(r *http.Request, defaultTimeout float64) (timeout float64, err error) {
 	// If a timeout is configured via the Prometheus header, add it to the request.
 	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
@@ -31,7 +35,27 @@ func getPrometheusTimeout(r *http.Request, defaultTimeout float64) (timeout floa
 		if err != nil {
 			return
 		}
+		if err = checkTimeoutSeconds(timeout); err != nil {
+			return 0, err
+		}
+
+		// respond before Prometheus gives up
+		if offset := Opts.Prober.TimeoutOffset.Seconds(); timeout > offset {
+			timeout -= offset
+		}
+	}
+
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		paramTimeout, parseErr := parseTimeoutSeconds(v)
+		if parseErr != nil {
+			return 0, fmt.Errorf(`parameter "timeout" is invalid: %w`, parseErr)
+		}
+
+		if timeout == 0 || paramTimeout < timeout {
+			timeout = paramTimeout
+		}
 	}
+
 	if timeout == 0 {
 		timeout = defaultTimeout
 	}
@@ -39,6 +63,34 @@ func getPrometheusTimeout(r *http.Request, defaultTimeout float64) (timeout floa
 	return
 }
 
+// parseTimeoutSeconds parses a duration (eg. 30s) or seconds (eg. 30, 2.5)
+func parseTimeoutSeconds(val string) (float64, error) {
+	timeout, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		duration, durationErr := time.ParseDuration(val)
+		if durationErr != nil {
+			return 0, durationErr
+		}
+		timeout = duration.Seconds()
+	}
+
+	if err := checkTimeoutSeconds(timeout); err != nil {
+		return 0, err
+	}
+	return timeout, nil
+}
+
+// checkTimeoutSeconds rejects timeouts which don't result in a valid deadline (NaN, infinite, zero or negative)
+func checkTimeoutSeconds(timeout float64) error {
+	if math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return fmt.Errorf("timeout must be a finite number")
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
 func paramsGetRequired(params url.Values, name string) (value string, err error) {
 	value = params.Get(name)
 	if value == "" {
@@ -48,6 +100,14 @@ func paramsGetRequired(params url.Values, name string) (value string, err error)
 	return
 }
 
+func paramsGetWithDefault(params url.Values, name, defaultValue string) (value string) {
+	value = params.Get(name)
+	if value == "" {
+		value = defaultValue
+	}
+	return
+}
+
 func paramsGetList(params url.Values, name string) (list []string, err error) {
 	for _, v := range params[name] {
 		list = append(list, strings.Split(v, ",")...)
@@ -65,3 +125,11 @@ func paramsGetListRequired(params url.Values, name string) (list []string, err e
 
 	return
 }
+
+// collectResult returns the result label (success, error) of a subscription collection
+func collectResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,52 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/webdevops/azure-metrics-exporter/push"
+)
+
+type (
+	// Client exports metrics to an OpenTelemetry collector using OTLP/HTTP (json encoding)
+	Client struct {
+		*push.Client
+	}
+)
+
+var (
+	otlpHeaders = http.Header{
+		"Content-Type": {"application/json"},
+	}
+)
+
+func NewClient(url string, timeout time.Duration, retries int) *Client {
+	return &Client{
+		Client: push.NewClient("otlp export", url, timeout, retries, recoverableStatus),
+	}
+}
+
+// recoverableStatus returns if the export request is retried (status codes defined by the OTLP specification)
+func recoverableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Export sends the metrics to the OTLP receiver, recoverable errors (429, 502, 503, 504) are retried with exponential backoff
+func (c *Client) Export(ctx context.Context, request *ExportMetricsServiceRequest) error {
+	if request == nil || len(request.ResourceMetrics) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return c.Post(ctx, payload, otlpHeaders)
+}
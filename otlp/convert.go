@@ -0,0 +1,143 @@
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+// metric labels which are moved to resource attributes
+var resourceLabels = map[string]string{
+	"resourceID":     "cloud.resource_id",
+	"subscriptionID": "cloud.account.id",
+	"resourceGroup":  "azure.resource_group",
+	"resourceName":   "azure.resource.name",
+}
+
+// FromMetricFamilies converts gauge, counter and untyped metrics to an OTLP export request (NaN and infinite values
+// are skipped),
+// metrics are grouped by Azure resource (resourceID label) with resource attributes derived from the resource ID,
+// resourceAttributes are added to every resource
+func FromMetricFamilies(families []*dto.MetricFamily, scope InstrumentationScope, resourceAttributes map[string]string, timestamp time.Time) *ExportMetricsServiceRequest {
+	resourceOrder := []string{}
+	resources := map[string]*ResourceMetrics{}
+	resourceMetricIndex := map[string]map[string]*Metric{}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var value float64
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				value = metric.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				value = metric.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = metric.GetUntyped().GetValue()
+			default:
+				continue
+			}
+
+			// OTLP/JSON has no representation of NaN and infinity (json.Marshal fails for the whole request),
+			// points without recorded value are skipped
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			resourceValues := map[string]string{}
+			dataPoint := NumberDataPoint{
+				TimeUnixNano: strconv.FormatInt(timestamp.UnixNano(), 10),
+				AsDouble:     value,
+			}
+			if metric.TimestampMs != nil {
+				dataPoint.TimeUnixNano = strconv.FormatInt(time.UnixMilli(metric.GetTimestampMs()).UnixNano(), 10)
+			}
+			for _, label := range metric.GetLabel() {
+				if attributeName, exists := resourceLabels[label.GetName()]; exists {
+					resourceValues[attributeName] = label.GetValue()
+				} else {
+					dataPoint.Attributes = append(dataPoint.Attributes, stringAttribute(label.GetName(), label.GetValue()))
+				}
+			}
+
+			// find or create resource
+			resourceKey := strings.ToLower(resourceValues["cloud.resource_id"])
+			resource, exists := resources[resourceKey]
+			if !exists {
+				resource = &ResourceMetrics{
+					Resource:     Resource{Attributes: buildResourceAttributes(resourceValues, resourceAttributes)},
+					ScopeMetrics: []ScopeMetrics{{Scope: scope}},
+				}
+				resources[resourceKey] = resource
+				resourceMetricIndex[resourceKey] = map[string]*Metric{}
+				resourceOrder = append(resourceOrder, resourceKey)
+			}
+
+			// find or create metric inside resource
+			otlpMetric, exists := resourceMetricIndex[resourceKey][family.GetName()]
+			if !exists {
+				otlpMetric = &Metric{
+					Name:        family.GetName(),
+					Description: family.GetHelp(),
+				}
+				if family.GetType() == dto.MetricType_COUNTER {
+					otlpMetric.Sum = &Sum{
+						AggregationTemporality: AggregationTemporalityCumulative,
+						IsMonotonic:            true,
+					}
+				} else {
+					otlpMetric.Gauge = &Gauge{}
+				}
+				resourceMetricIndex[resourceKey][family.GetName()] = otlpMetric
+				resource.ScopeMetrics[0].Metrics = append(resource.ScopeMetrics[0].Metrics, otlpMetric)
+			}
+
+			if otlpMetric.Sum != nil {
+				otlpMetric.Sum.DataPoints = append(otlpMetric.Sum.DataPoints, dataPoint)
+			} else {
+				otlpMetric.Gauge.DataPoints = append(otlpMetric.Gauge.DataPoints, dataPoint)
+			}
+		}
+	}
+
+	request := ExportMetricsServiceRequest{}
+	for _, resourceKey := range resourceOrder {
+		request.ResourceMetrics = append(request.ResourceMetrics, *resources[resourceKey])
+	}
+	return &request
+}
+
+func buildResourceAttributes(resourceValues map[string]string, resourceAttributes map[string]string) []KeyValue {
+	attributes := map[string]string{}
+	for name, value := range resourceAttributes {
+		attributes[name] = value
+	}
+
+	if resourceId := resourceValues["cloud.resource_id"]; resourceId != "" {
+		attributes["cloud.provider"] = "azure"
+		for name, value := range resourceValues {
+			attributes[name] = value
+		}
+
+		if resourceType := metrics.ResourceTypeFromResourceId(resourceId); resourceType != "" {
+			attributes["azure.resource.type"] = resourceType
+		}
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]KeyValue, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, stringAttribute(name, attributes[name]))
+	}
+	return ret
+}
@@ -0,0 +1,86 @@
+package otlp
+
+// OTLP/HTTP JSON encoding of opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest
+// (only the parts needed for gauges and sums)
+
+const (
+	AggregationTemporalityCumulative = 2
+)
+
+type (
+	ExportMetricsServiceRequest struct {
+		ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+	}
+
+	ResourceMetrics struct {
+		Resource     Resource       `json:"resource"`
+		ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+	}
+
+	Resource struct {
+		Attributes []KeyValue `json:"attributes"`
+	}
+
+	ScopeMetrics struct {
+		Scope   InstrumentationScope `json:"scope"`
+		Metrics []*Metric            `json:"metrics"`
+	}
+
+	InstrumentationScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	Metric struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		Gauge       *Gauge `json:"gauge,omitempty"`
+		Sum         *Sum   `json:"sum,omitempty"`
+	}
+
+	Gauge struct {
+		DataPoints []NumberDataPoint `json:"dataPoints"`
+	}
+
+	Sum struct {
+		DataPoints             []NumberDataPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+
+	NumberDataPoint struct {
+		Attributes   []KeyValue `json:"attributes,omitempty"`
+		TimeUnixNano string     `json:"timeUnixNano"`
+		AsDouble     float64    `json:"asDouble"`
+	}
+
+	KeyValue struct {
+		Key   string   `json:"key"`
+		Value AnyValue `json:"value"`
+	}
+
+	AnyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+func stringAttribute(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: value}}
+}
+
+// DataPointCount returns the number of data points of the request
+func (r *ExportMetricsServiceRequest) DataPointCount() (count int) {
+	for _, resourceMetrics := range r.ResourceMetrics {
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				if metric.Gauge != nil {
+					count += len(metric.Gauge.DataPoints)
+				}
+				if metric.Sum != nil {
+					count += len(metric.Sum.DataPoints)
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	PrewarmIntervalDefault = 5 * time.Minute
+	PrewarmIntervalMinimum = 1 * time.Minute
+)
+
+type (
+	// PrewarmScheduler collects probes with prewarm=true in background, probe requests are served from the latest result
+	PrewarmScheduler struct {
+		handler       http.Handler
+		allowedProbes map[string]bool
+
+		lock sync.Mutex
+		jobs map[string]*prewarmJob
+	}
+
+	prewarmJob struct {
+		url      string
+		interval time.Duration
+		cancel   context.CancelFunc
+		ready    chan struct{}
+
+		lock       sync.RWMutex
+		lastAccess time.Time
+		result     *jobResponseWriter
+		updated    time.Time
+	}
+
+	// prewarmStatus is the latest result of a prewarm probe (index page)
+	prewarmStatus struct {
+		Url        string
+		Interval   time.Duration
+		Updated    time.Time
+		StatusCode int
+	}
+)
+
+var (
+	prewarmScheduler *PrewarmScheduler
+
+	prometheusPrewarmRuns *prometheus.CounterVec
+	prometheusPrewarmJobs prometheus.Gauge
+)
+
+// initPrewarm creates the scheduler for prewarm probes, probes are executed after startPrewarm
+func initPrewarm() {
+	prometheusPrewarmRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_prewarm_runs",
+			Help: "Azure metrics exporter background runs of prewarm probes",
+		},
+		[]string{
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusPrewarmRuns)
+
+	prometheusPrewarmJobs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_prewarm_jobs",
+			Help: "Azure metrics exporter active prewarm probes",
+		},
+	)
+	prometheus.MustRegister(prometheusPrewarmJobs)
+
+	allowedProbes := map[string]bool{}
+	for _, path := range Opts.Prewarm.AllowedProbes {
+		allowedProbes[path] = true
+	}
+
+	prewarmScheduler = &PrewarmScheduler{
+		allowedProbes: allowedProbes,
+		jobs:          map[string]*prewarmJob{},
+	}
+}
+
+// startPrewarm sets the handler which executes the prewarm probes, probes are executed through the server handler
+// (authentication, access log, probe stats) as internal requests
+func startPrewarm(handler http.Handler) {
+	prewarmScheduler.lock.Lock()
+	defer prewarmScheduler.lock.Unlock()
+	prewarmScheduler.handler = handler
+}
+
+// Handler serves probe requests with prewarm=true from the background collection, other requests are passed to next
+func (s *PrewarmScheduler) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("prewarm") == "" {
+			next(w, r)
+			return
+		}
+
+		contextLogger := buildContextLoggerFromRequest(r)
+
+		prewarm, err := strconv.ParseBool(query.Get("prewarm"))
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, fmt.Sprintf(`parameter "prewarm" is invalid: %s`, err), http.StatusBadRequest)
+			return
+		}
+
+		interval := PrewarmIntervalDefault
+		if val := query.Get("prewarmInterval"); val != "" {
+			if interval, err = time.ParseDuration(val); err != nil {
+				contextLogger.Warnln(err)
+				http.Error(w, fmt.Sprintf(`parameter "prewarmInterval" is invalid: %s`, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if interval < PrewarmIntervalMinimum {
+			interval = PrewarmIntervalMinimum
+		}
+
+		if !prewarm {
+			next(w, r)
+			return
+		}
+
+		// background probes are executed without the client, only authenticated clients or allowed probes can start them
+		if !s.isAllowed(r) {
+			contextLogger.Warnf(`prewarm probe "%s" rejected, authentication is disabled and probe is not allowed by --prewarm.allowed-probes`, r.URL.Path)
+			http.Error(w, "prewarm is not allowed for this probe", http.StatusForbidden)
+			return
+		}
+
+		job, err := s.getJob(prewarmUrl(r.URL), interval)
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		// wait for first collection
+		timeoutSeconds, err := getPrometheusTimeout(r, 10)
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+			return
+		}
+		select {
+		case <-job.ready:
+		case <-time.After(time.Duration(timeoutSeconds * float64(time.Second))):
+			http.Error(w, "prewarm probe is not collected yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		job.serve(w)
+	}
+}
+
+// isAllowed checks if the request can start prewarm probes: authenticated requests (--server.auth.*), requests of the
+// exporter itself and probes allowed by --prewarm.allowed-probes
+func (s *PrewarmScheduler) isAllowed(r *http.Request) bool {
+	return isAuthenticatedRequest(r) || isInternalRequest(r) || s.allowedProbes[r.URL.Path]
+}
+
+// getJob returns the job of the probe url, new jobs are started
+func (s *PrewarmScheduler) getJob(probeUrl string, interval time.Duration) (*prewarmJob, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if job, exists := s.jobs[probeUrl]; exists {
+		job.touch()
+		return job, nil
+	}
+
+	if s.handler == nil {
+		return nil, fmt.Errorf("prewarm probes are not started yet")
+	}
+
+	if len(s.jobs) >= Opts.Prewarm.MaxJobs {
+		return nil, fmt.Errorf("max prewarm probes (%d) reached", Opts.Prewarm.MaxJobs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &prewarmJob{
+		url:      probeUrl,
+		interval: interval,
+		cancel:   cancel,
+		ready:    make(chan struct{}),
+	}
+	job.touch()
+	s.jobs[probeUrl] = job
+	prometheusPrewarmJobs.Set(float64(len(s.jobs)))
+
+	logger.Infof(`starting prewarm probe "%s" (every %s)`, probeUrl, interval.String())
+	go s.runJobLoop(ctx, job)
+
+	return job, nil
+}
+
+func (s *PrewarmScheduler) removeJob(job *prewarmJob) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	job.cancel()
+	delete(s.jobs, job.url)
+	prometheusPrewarmJobs.Set(float64(len(s.jobs)))
+}
+
+// JobCount returns the number of active prewarm probes
+func (s *PrewarmScheduler) JobCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.jobs)
+}
+
+// Status returns the active prewarm probes with the time and status code of their latest result
+func (s *PrewarmScheduler) Status() []prewarmStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := []prewarmStatus{}
+	for _, job := range s.jobs {
+		status := prewarmStatus{
+			Url:      job.url,
+			Interval: job.interval,
+		}
+
+		job.lock.RLock()
+		status.Updated = job.updated
+		if job.result != nil {
+			status.StatusCode = job.result.statusCode
+		}
+		job.lock.RUnlock()
+
+		ret = append(ret, status)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Url < ret[j].Url
+	})
+	return ret
+}
+
+// Stop stops all prewarm probes
+func (s *PrewarmScheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, job := range s.jobs {
+		job.cancel()
+	}
+	s.jobs = map[string]*prewarmJob{}
+	prometheusPrewarmJobs.Set(0)
+}
+
+func (s *PrewarmScheduler) runJobLoop(ctx context.Context, job *prewarmJob) {
+	contextLogger := logger.With(zap.String("prewarm", job.url))
+
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		s.runJob(ctx, job, contextLogger)
+
+		select {
+		case <-ctx.Done():
+			contextLogger.Debug("stopped prewarm probe")
+			return
+		case <-ticker.C:
+		}
+
+		// stop probes which are not requested anymore
+		if job.idleSince() > Opts.Prewarm.IdleTimeout {
+			contextLogger.Infof("stopping prewarm probe, not requested since %s", Opts.Prewarm.IdleTimeout.String())
+			s.removeJob(job)
+			return
+		}
+	}
+}
+
+func (s *PrewarmScheduler) runJob(ctx context.Context, job *prewarmJob, contextLogger *zap.SugaredLogger) {
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, job.interval)
+	defer cancel()
+
+	req, err := newInternalRequest(ctx, job.url)
+	if err != nil {
+		contextLogger.Error(err)
+		prometheusPrewarmRuns.WithLabelValues("error").Inc()
+		return
+	}
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(job.interval.Seconds(), 'f', -1, 64))
+
+	s.lock.Lock()
+	handler := s.handler
+	s.lock.Unlock()
+
+	w := newJobResponseWriter()
+	handler.ServeHTTP(w, req)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// probe was stopped
+		return
+	}
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	if w.statusCode != http.StatusOK {
+		contextLogger.Errorf("prewarm probe failed with status %d: %s", w.statusCode, strings.TrimSpace(w.body.String()))
+		prometheusPrewarmRuns.WithLabelValues("error").Inc()
+		// keep previous result
+		if job.hasResult() {
+			return
+		}
+	} else {
+		prometheusPrewarmRuns.WithLabelValues("success").Inc()
+	}
+
+	job.setResult(w, startTime)
+	contextLogger.Debugf("finished prewarm probe in %s", time.Since(startTime).String())
+}
+
+func (j *prewarmJob) touch() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.lastAccess = time.Now()
+}
+
+func (j *prewarmJob) idleSince() time.Duration {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return time.Since(j.lastAccess)
+}
+
+func (j *prewarmJob) hasResult() bool {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.result != nil
+}
+
+func (j *prewarmJob) setResult(result *jobResponseWriter, updated time.Time) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	firstResult := j.result == nil
+	j.result = result
+	j.updated = updated
+	if firstResult {
+		close(j.ready)
+	}
+}
+
+// serve writes the latest result of the probe
+func (j *prewarmJob) serve(w http.ResponseWriter) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	for name, values := range j.result.header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("X-prewarm-updated", j.updated.Format(time.RFC3339))
+	w.WriteHeader(j.result.statusCode)
+	if _, err := w.Write(j.result.body.Bytes()); err != nil {
+		logger.Error(err)
+	}
+}
+
+// prewarmUrl returns the probe url without prewarm parameters
+func prewarmUrl(u *url.URL) string {
+	query := u.Query()
+	query.Del("prewarm")
+	query.Del("prewarmInterval")
+	return u.Path + "?" + query.Encode()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeAlertsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.MetricAlertsSettings
+	if settings, err = metrics.NewMetricAlertsSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheKey, err := metrics.ProbeCacheKey("alerts", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	probe, err := newSubscriptionProbe(w, r, contextLogger, config.ProbeAlertsUrl, startTime, cacheKey, settings.Cache)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cached results per subscription
+	results := map[string]*metrics.MetricAlertsResult{}
+	if !probe.fromCache(&results) {
+		client, err := metrics.NewMetricAlertsClient(azureCredential, metrics.NewArmClientOptions(AzureClient))
+		if err != nil {
+			probe.failInternal(err)
+			return
+		}
+
+		results, err = collectSubscriptionResults(probe, settings.Subscriptions, func(ctx context.Context, subscriptionId string) (*metrics.MetricAlertsResult, error) {
+			result := metrics.MetricAlertsResult{}
+
+			var err error
+			if result.Rules, err = client.ListRules(ctx, subscriptionId); err != nil {
+				return nil, err
+			}
+
+			if result.Alerts, err = client.ListFiredAlerts(ctx, subscriptionId, settings.TimeRange); err != nil {
+				return nil, err
+			}
+
+			return &result, nil
+		})
+		if err != nil {
+			probe.fail(err)
+			return
+		}
+
+		probe.finish(results)
+	}
+
+	metrics.PublishMetricAlertsResult(registry, results)
+	probe.publishErrors(registry)
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeArcHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeArcTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.ArcSettings
+	if settings, err = metrics.NewArcSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheKey, err := metrics.ProbeCacheKey("arc", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	probe, err := newSubscriptionProbe(w, r, contextLogger, config.ProbeArcUrl, startTime, cacheKey, settings.Cache)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := metrics.ArcResult{}
+	if !probe.fromCache(&result) {
+		// one ResourceGraph query for all subscriptions
+		result.Resources, err = metrics.ListArcResources(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), settings, Opts.Azure.ResourceGraph.MaxRows)
+		if err != nil {
+			probe.fail(err)
+			return
+		}
+
+		probe.finish(result)
+	}
+
+	metrics.PublishArcResult(registry, &result)
+	probe.publishErrors(registry)
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
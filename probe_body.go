@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// max size of the JSON body of POST probe requests
+	ProbeRequestBodyMax = 1024 * 1024
+
+	probeUrlPrefix = "/probe/"
+)
+
+// probeRequestBodyHandler accepts POST probe requests with a JSON object of probe parameters as body (alternative to
+// long query strings), the parameters are merged into the query string (body parameters override query parameters)
+// and the probe is handled like a GET request, so caching, coalescing and prewarm use the same probe url
+func probeRequestBodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, probeUrlPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		contextLogger := buildContextLoggerFromRequest(r)
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+				http.Error(w, `probe requests with body must be "application/json"`, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		params, err := parseProbeRequestBody(http.MaxBytesReader(w, r.Body, ProbeRequestBodyMax))
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+
+		probeRequest := r.Clone(r.Context())
+		probeRequest.Method = http.MethodGet
+		probeRequest.Body = http.NoBody
+		probeRequest.ContentLength = 0
+		probeRequest.Header.Del("Content-Type")
+		probeRequest.Header.Del("Content-Length")
+		probeRequest.URL.RawQuery = query.Encode()
+		probeRequest.RequestURI = probeRequest.URL.RequestURI()
+
+		next.ServeHTTP(w, probeRequest)
+	})
+}
+
+// parseProbeRequestBody parses the JSON object of probe parameters, values are strings, numbers, booleans or lists
+// (multiple values), objects (eg. metricSet entries) are encoded as query string
+func parseProbeRequestBody(body io.Reader) (url.Values, error) {
+	payload := map[string]interface{}{}
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("unable to parse probe request body (JSON object of probe parameters expected): %w", err)
+	}
+
+	ret := url.Values{}
+	for name, value := range payload {
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+
+		for _, val := range values {
+			paramValue, err := probeRequestBodyValue(val)
+			if err != nil {
+				return nil, fmt.Errorf(`probe request body parameter "%s": %w`, name, err)
+			}
+			ret.Add(name, paramValue)
+		}
+	}
+
+	return ret, nil
+}
+
+// probeRequestBodyValue returns the parameter value of a JSON value
+func probeRequestBodyValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case map[string]interface{}:
+		params := url.Values{}
+		for name, nestedValue := range v {
+			values := []interface{}{nestedValue}
+			if list, ok := nestedValue.([]interface{}); ok {
+				values = list
+			}
+
+			for _, val := range values {
+				if _, isObject := val.(map[string]interface{}); isObject {
+					return "", fmt.Errorf(`nested object "%s" is not supported`, name)
+				}
+				paramValue, err := probeRequestBodyValue(val)
+				if err != nil {
+					return "", err
+				}
+				params.Add(name, paramValue)
+			}
+		}
+		return params.Encode(), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+// probeCollectorHandler runs the custom collector of the probe url (/probe/collector/<name>, see metrics.RegisterCollector)
+func probeCollectorHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	name := strings.TrimPrefix(r.URL.Path, config.ProbeCollectorUrl)
+	collector, exists := metrics.GetCollector(name)
+	if !exists {
+		http.Error(w, fmt.Sprintf(`collector "%s" not found, available collectors: %s`, name, strings.Join(metrics.CollectorNames(), ", ")), http.StatusNotFound)
+		return
+	}
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeCollectorTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.CollectorSettings
+	if settings, err = metrics.NewCollectorSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var azureCredential azcore.TokenCredential
+	azureCredential, err = getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request := &metrics.CollectorRequest{
+		Subscriptions: settings.Subscriptions,
+		Params:        r.URL.Query(),
+		Credential:    azureCredential,
+		ClientOptions: metrics.NewArmClientOptions(AzureClient),
+		Cache:         metrics.NewCollectorCache(metricsCache, name),
+		CacheDuration: settings.Cache,
+		Registry:      registry,
+		Logger:        contextLogger,
+	}
+	// collectors cache their results themselves (request.Cache)
+	probe, err := newSubscriptionProbe(w, r, contextLogger, r.URL.Path, startTime, "", nil)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = collector.Collect(ctx, request); err != nil {
+		probe.fail(err)
+		return
+	}
+	probe.finish(nil)
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeHealthResourceHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeHealthResourceTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.ResourceHealthSettings
+	if settings, err = metrics.NewResourceHealthSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err = probeRestrictions.CheckResourceTypes(settings.ResourceTypes...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheKey, err := metrics.ProbeCacheKey("health", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	probe, err := newSubscriptionProbe(w, r, contextLogger, config.ProbeHealthResourceUrl, startTime, cacheKey, settings.Cache)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cached results per subscription
+	results := map[string]*metrics.ResourceHealthResult{}
+	if !probe.fromCache(&results) {
+		client, err := metrics.NewResourceHealthClient(azureCredential, metrics.NewArmClientOptions(AzureClient))
+		if err != nil {
+			probe.failInternal(err)
+			return
+		}
+
+		results, err = collectSubscriptionResults(probe, settings.Subscriptions, func(ctx context.Context, subscriptionId string) (*metrics.ResourceHealthResult, error) {
+			result := metrics.ResourceHealthResult{}
+
+			var err error
+			if result.Statuses, err = client.ListAvailabilityStatuses(ctx, subscriptionId, settings.ResourceTypes); err != nil {
+				return nil, err
+			}
+
+			if settings.Events {
+				if result.Events, err = client.ListActiveEvents(ctx, subscriptionId); err != nil {
+					return nil, err
+				}
+			}
+
+			return &result, nil
+		})
+		if err != nil {
+			probe.fail(err)
+			return
+		}
+
+		probe.finish(results)
+	}
+
+	metrics.PublishResourceHealthResult(registry, results)
+	probe.publishErrors(registry)
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// ProbeLimiter limits the concurrent probe requests per handler (--probe.max-concurrent), further requests
+	// wait in a bounded queue (--probe.max-queue) and are rejected with 429 when the queue is full or the wait
+	// exceeds the queue timeout, so thundering-herd scrapes (eg. Prometheus restarts) don't pile up goroutines
+	ProbeLimiter struct {
+		maxConcurrent int
+		maxQueue      int
+		queueTimeout  time.Duration
+		retryAfter    time.Duration
+
+		lock     sync.Mutex
+		handlers map[string]*probeLimit
+	}
+
+	probeLimit struct {
+		slots  chan struct{}
+		queued int
+	}
+)
+
+var (
+	probeLimiter *ProbeLimiter
+
+	prometheusProbeQueued   *prometheus.GaugeVec
+	prometheusProbeRejected *prometheus.CounterVec
+)
+
+func NewProbeLimiter(maxConcurrent, maxQueue int, queueTimeout, retryAfter time.Duration) *ProbeLimiter {
+	return &ProbeLimiter{
+		maxConcurrent: maxConcurrent,
+		maxQueue:      maxQueue,
+		queueTimeout:  queueTimeout,
+		retryAfter:    retryAfter,
+		handlers:      map[string]*probeLimit{},
+	}
+}
+
+func initProbeLimiterMetrics() {
+	prometheusProbeQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_probe_queued",
+			Help: "Azure metrics exporter probe requests waiting for a free slot (--probe.max-concurrent)",
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeQueued)
+
+	prometheusProbeRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_probe_rejected",
+			Help: "Azure metrics exporter probe requests rejected with 429 (queue full or queue timeout)",
+		},
+		[]string{
+			"handler",
+			"reason",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeRejected)
+}
+
+// Handler runs the probe request if a slot of the handler is free, otherwise it's queued or rejected
+func (l *ProbeLimiter) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l == nil || l.maxConcurrent <= 0 {
+			next(w, r)
+			return
+		}
+
+		handler := r.URL.Path
+		limit := l.limit(handler)
+
+		// free slot
+		select {
+		case limit.slots <- struct{}{}:
+			defer func() { <-limit.slots }()
+			next(w, r)
+			return
+		default:
+		}
+
+		if !l.enqueue(limit) {
+			l.reject(w, handler, "queue_full")
+			return
+		}
+		prometheusProbeQueued.WithLabelValues(handler).Inc()
+
+		// queued requests wait max until the scrape timeout (Prometheus gives up anyway)
+		queueTimeout := l.queueTimeout
+		if timeout, err := getPrometheusTimeout(r, queueTimeout.Seconds()); err == nil && timeout < queueTimeout.Seconds() {
+			queueTimeout = time.Duration(timeout * float64(time.Second))
+		}
+		timer := time.NewTimer(queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case limit.slots <- struct{}{}:
+			l.dequeue(limit, handler)
+			defer func() { <-limit.slots }()
+			next(w, r)
+		case <-timer.C:
+			l.dequeue(limit, handler)
+			l.reject(w, handler, "queue_timeout")
+		case <-r.Context().Done():
+			l.dequeue(limit, handler)
+		}
+	}
+}
+
+func (l *ProbeLimiter) limit(handler string) *probeLimit {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	limit, exists := l.handlers[handler]
+	if !exists {
+		limit = &probeLimit{slots: make(chan struct{}, l.maxConcurrent)}
+		l.handlers[handler] = limit
+	}
+	return limit
+}
+
+// enqueue reserves a place in the queue of the handler, false if the queue is full
+func (l *ProbeLimiter) enqueue(limit *probeLimit) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if limit.queued >= l.maxQueue {
+		return false
+	}
+	limit.queued++
+	return true
+}
+
+func (l *ProbeLimiter) dequeue(limit *probeLimit, handler string) {
+	l.lock.Lock()
+	limit.queued--
+	l.lock.Unlock()
+
+	prometheusProbeQueued.WithLabelValues(handler).Dec()
+}
+
+func (l *ProbeLimiter) reject(w http.ResponseWriter, handler, reason string) {
+	prometheusProbeRejected.WithLabelValues(handler, reason).Inc()
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.retryAfter.Seconds()))))
+	http.Error(w, "too many concurrent probe requests, retry later", http.StatusTooManyRequests)
+}
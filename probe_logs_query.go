@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeLogsQueryHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeLogsQueryTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.LogAnalyticsQuerySettings
+	if settings, err = metrics.NewLogAnalyticsQuerySettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cached query results per workspace
+	cacheKey, err := metrics.ProbeCacheKey("logs", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results := map[string]*metrics.LogAnalyticsQueryResult{}
+	cacheData, cached := metricsCache.Get(cacheKey)
+	if cached && settings.Cache != nil && json.Unmarshal(cacheData, &results) == nil {
+		w.Header().Add("X-metrics-cached", "true")
+	} else {
+		client := metrics.NewLogAnalyticsClient(azureCredential, metrics.NewArmClientOptions(AzureClient).ClientOptions, Opts)
+		for _, workspaceId := range settings.Workspaces {
+			result, err := client.Query(ctx, workspaceId, settings.Query, settings.Timespan)
+			if err != nil {
+				contextLogger.Errorln(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			results[workspaceId] = result
+		}
+
+		if settings.Cache != nil {
+			if cacheData, err := json.Marshal(results); err == nil {
+				metricsCache.Set(cacheKey, cacheData, *settings.Cache)
+			}
+		}
+
+		prometheusCollectTime.With(prometheus.Labels{
+			"subscriptionID": "",
+			"handler":        config.ProbeLogsQueryUrl,
+			"filter":         "",
+		}).Observe(time.Since(startTime).Seconds())
+	}
+
+	builder := metrics.NewLogAnalyticsMetricBuilder(&settings, registry)
+	for workspaceId, result := range results {
+		builder.AddResult(workspaceId, result)
+	}
+	if err := builder.Publish(); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
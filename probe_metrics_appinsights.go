@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeMetricsAppInsightsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeMetricsAppInsightsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.AppInsightsMetricsSettings
+	if settings, err = metrics.NewAppInsightsMetricsSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cached metric results per app and metric
+	cacheKey, err := metrics.ProbeCacheKey("appinsights", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	results := map[string]map[string]*metrics.AppInsightsMetricResult{}
+	cacheData, cached := metricsCache.Get(cacheKey)
+	if cached && settings.Cache != nil && json.Unmarshal(cacheData, &results) == nil {
+		w.Header().Add("X-metrics-cached", "true")
+	} else {
+		appIds := settings.Apps
+		for _, resourceId := range settings.Targets {
+			appId, err := metrics.AppIdFromResourceId(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), resourceId)
+			if err != nil {
+				contextLogger.Errorln(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			appIds = append(appIds, appId)
+		}
+
+		client := metrics.NewAppInsightsClient(azureCredential, metrics.NewArmClientOptions(AzureClient).ClientOptions, Opts)
+		for _, appId := range appIds {
+			results[appId] = map[string]*metrics.AppInsightsMetricResult{}
+			for _, metric := range settings.Metrics {
+				result, err := client.QueryMetric(ctx, appId, metric, &settings)
+				if err != nil {
+					contextLogger.Errorln(err)
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				results[appId][metric] = result
+			}
+		}
+
+		if settings.Cache != nil {
+			if cacheData, err := json.Marshal(results); err == nil {
+				metricsCache.Set(cacheKey, cacheData, *settings.Cache)
+			}
+		}
+
+		prometheusCollectTime.With(prometheus.Labels{
+			"subscriptionID": "",
+			"handler":        config.ProbeMetricsAppInsightsUrl,
+			"filter":         "",
+		}).Observe(time.Since(startTime).Seconds())
+	}
+
+	builder, err := metrics.NewAppInsightsMetricBuilder(&settings, registry)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for appId, metricResults := range results {
+		for metric, result := range metricResults {
+			builder.AddResult(appId, metric, result)
+		}
+	}
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
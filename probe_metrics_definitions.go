@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	errSampleResourceMissing  = errors.New(`parameter "target" or "resourceType" is missing`)
+	errSampleResourceNotFound = errors.New("no resources found")
+)
+
+type (
+	// MetricDefinitionsResponse is the json response of /probe/metrics/definitions
+	MetricDefinitionsResponse struct {
+		ResourceId      string                     `json:"resourceId"`
+		ResourceType    string                     `json:"resourceType"`
+		MetricNamespace string                     `json:"metricNamespace,omitempty"`
+		Metrics         []metrics.MetricDefinition `json:"metrics"`
+	}
+)
+
+// probeMetricsDefinitionsHandler lists the available metrics (aggregations, dimensions, time grains) of a resource
+// or resource type (first resource found is used as sample)
+func probeMetricsDefinitionsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeMetricsDefinitionsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.RequestMetricSettings
+	if settings, err = metrics.NewRequestMetricSettings(r, Opts); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	resourceId, ok := resolveSampleResourceId(ctx, w, r, contextLogger, azureCredential, &settings)
+	if !ok {
+		return
+	}
+
+	definitionList, err := metricDefinitionCache.Get(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), resourceId, settings.MetricNamespace)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := MetricDefinitionsResponse{
+		ResourceId:      resourceId,
+		ResourceType:    metrics.ResourceTypeFromResourceId(resourceId),
+		MetricNamespace: settings.MetricNamespace,
+		Metrics:         definitionList,
+	}
+
+	if format == ProbeFormatJson {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			contextLogger.Error(err)
+		}
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	definitionInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_metric_definition_info",
+			Help: "Azure monitor metric definitions (supported aggregations, dimensions and time grains)",
+		},
+		[]string{
+			"resourceType",
+			"metricNamespace",
+			"metric",
+			"unit",
+			"primaryAggregation",
+			"aggregations",
+			"dimensions",
+			"timeGrains",
+		},
+	)
+	registry.MustRegister(definitionInfo)
+
+	for _, definition := range response.Metrics {
+		definitionInfo.With(prometheus.Labels{
+			"resourceType":       strings.ToLower(response.ResourceType),
+			"metricNamespace":    definition.Namespace,
+			"metric":             definition.Name,
+			"unit":               definition.Unit,
+			"primaryAggregation": definition.PrimaryAggregation,
+			"aggregations":       strings.Join(definition.Aggregations, ","),
+			"dimensions":         strings.Join(definition.Dimensions, ","),
+			"timeGrains":         strings.Join(definition.TimeGrains, ","),
+		}).Set(1)
+	}
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
+
+// resolveSampleResourceId returns the target resource or the first resource of resourceType (sample for the resource type),
+// errors are written to the response
+func resolveSampleResourceId(ctx context.Context, w http.ResponseWriter, r *http.Request, contextLogger *zap.SugaredLogger, azureCredential azcore.TokenCredential, settings *metrics.RequestMetricSettings) (string, bool) {
+	resourceId, err := findSampleResourceId(ctx, w, r, contextLogger, azureCredential, settings)
+	var restrictedErr *metrics.ProbeRestrictedError
+	switch {
+	case errors.As(err, &restrictedErr):
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", false
+	case errors.Is(err, errSampleResourceNotFound):
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return "", false
+	case errors.Is(err, errSampleResourceMissing):
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	case err != nil:
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+
+	return resourceId, true
+}
+
+// findSampleResourceId returns the target resource or the first resource of resourceType
+func findSampleResourceId(ctx context.Context, w http.ResponseWriter, r *http.Request, contextLogger *zap.SugaredLogger, azureCredential azcore.TokenCredential, settings *metrics.RequestMetricSettings) (string, error) {
+	resourceId := r.URL.Query().Get("target")
+	if resourceId != "" {
+		if err := probeRestrictions.CheckResources(resourceId); err != nil {
+			return "", err
+		}
+		return resourceId, nil
+	}
+
+	if settings.ResourceType == "" {
+		return "", errSampleResourceMissing
+	}
+
+	prober := metrics.NewMetricProber(ctx, contextLogger, w, settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureCredential(azureCredential)
+	if resourceGraphCacheDuration := *Opts.Azure.ServiceDiscovery.CacheDuration; resourceGraphCacheDuration.Seconds() > 0 {
+		prober.EnableResourceGraphCache(resourceGraphCache, resourceGraphCacheDuration)
+	}
+
+	resourceList, err := prober.ServiceDiscovery.ListResourceGraph(ctx, settings.Subscriptions, settings.ResourceType, settings.Filter)
+	if err != nil {
+		return "", err
+	}
+
+	// use same sample resource for every request (stable results), resources outside of --restrict.* are skipped
+	sort.Slice(resourceList, func(i, j int) bool {
+		return resourceList[i].ID < resourceList[j].ID
+	})
+	for _, resource := range resourceList {
+		if probeRestrictions.CheckResources(resource.ID) == nil {
+			return resource.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf(`%w of type "%s"`, errSampleResourceNotFound, settings.ResourceType)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// MetricNamespacesResponse is the json response of /probe/metrics/namespaces
+	MetricNamespacesResponse struct {
+		ResourceId   string                    `json:"resourceId"`
+		ResourceType string                    `json:"resourceType"`
+		Namespaces   []metrics.MetricNamespace `json:"namespaces"`
+	}
+)
+
+// probeMetricsNamespacesHandler lists the metric namespaces (metricNamespace parameter) of a resource
+// or resource type (first resource found is used as sample)
+func probeMetricsNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeMetricsNamespacesTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.RequestMetricSettings
+	if settings, err = metrics.NewRequestMetricSettings(r, Opts); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	resourceId, ok := resolveSampleResourceId(ctx, w, r, contextLogger, azureCredential, &settings)
+	if !ok {
+		return
+	}
+
+	namespaceList, err := metricDefinitionCache.GetNamespaces(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), resourceId)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := MetricNamespacesResponse{
+		ResourceId:   resourceId,
+		ResourceType: metrics.ResourceTypeFromResourceId(resourceId),
+		Namespaces:   namespaceList,
+	}
+
+	if format == ProbeFormatJson {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			contextLogger.Error(err)
+		}
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	namespaceInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_metric_namespace_info",
+			Help: "Azure monitor metric namespaces (classification platform, custom or qos)",
+		},
+		[]string{
+			"resourceType",
+			"metricNamespace",
+			"classification",
+		},
+	)
+	registry.MustRegister(namespaceInfo)
+
+	for _, namespace := range response.Namespaces {
+		namespaceInfo.With(prometheus.Labels{
+			"resourceType":    strings.ToLower(response.ResourceType),
+			"metricNamespace": namespace.Name,
+			"classification":  namespace.Classification,
+		}).Set(1)
+	}
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
-	"crypto/sha1" // #nosec G505
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -19,6 +18,8 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 	var timeoutSeconds float64
 
 	startTime := time.Now()
+	memoryTracker := startProbeMemoryTracker(config.ProbeMetricsResourceUrl)
+	defer memoryTracker.Stop()
 	contextLogger := buildContextLoggerFromRequest(r)
 	registry := prometheus.NewRegistry()
 
@@ -30,7 +31,7 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
 	defer cancel()
 	r = r.WithContext(ctx)
 
@@ -41,25 +42,66 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resourceTagManager, err := getResourceTagManager(settings.TagLabels)
+	if err != nil {
 		contextLogger.Warnln(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	prober := metrics.NewMetricProber(ctx, contextLogger, w, &settings, Opts)
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
-	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	prober.SetAzureCredential(azureCredential)
+	prober.SetMetricDefinitionCache(metricDefinitionCache)
+	prober.SetResourceTagCache(resourceTagCache)
+	prober.SetSubscriptionDiscovery(subscriptionDiscovery)
+	prober.SetWorkerPool(metricsWorkerPool)
+	prober.SetRateLimiter(metricsRateLimiter)
+	prober.SetMetricsBatchClients(metricsBatchClients)
+	prober.SetQuotaCoordinator(metricsQuota)
+	prober.SetRestrictions(probeRestrictions)
+	prober.SetAzureResourceTagManager(resourceTagManager)
 	prober.SetPrometheusRegistry(registry)
-	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("resource:%x", sha1.Sum([]byte(r.URL.String()))) // #nosec G401
-		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
-	}
 
+	// targets are not part of the settings, they are added to the cache key
+	targetKeyParts := []string{}
 	if resourceList, err := paramsGetListRequired(r.URL.Query(), "target"); err == nil {
+		if err := probeRestrictions.CheckResources(resourceList...); err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		targetList := []metrics.MetricProbeTarget{}
 		for _, resourceId := range resourceList {
+			targetKeyParts = append(targetKeyParts, "target="+strings.ToLower(resourceId))
 			targetList = append(
 				targetList,
 				metrics.MetricProbeTarget{
@@ -76,14 +118,30 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if settings.Cache != nil {
+		cacheKey, err := metrics.ProbeCacheKey("resource", &settings, targetKeyParts...)
+		if err != nil {
+			contextLogger.Errorln(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
+	}
+
 	if !prober.FetchFromCache() {
-		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
+		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string, duration time.Duration, err error) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
 				"handler":        config.ProbeMetricsListUrl,
 				"filter":         settings.Filter,
-			}).Observe(time.Since(startTime).Seconds())
+			}).Observe(duration.Seconds())
+			prometheusMetricRequests.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"handler":        config.ProbeMetricsListUrl,
+				"filter":         settings.Filter,
+				"result":         collectResult(err),
+			}).Inc()
 		})
 
 		prober.Run()
@@ -99,6 +157,5 @@ func probeMetricsResourceHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	writeProbeResponse(w, r, format, probeGatherer(r, prober.Gatherer()))
 }
@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"crypto/sha1" // #nosec G505
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -19,6 +17,8 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 	var timeoutSeconds float64
 
 	startTime := time.Now()
+	memoryTracker := startProbeMemoryTracker(config.ProbeMetricsResourceGraphUrl)
+	defer memoryTracker.Stop()
 	contextLogger := buildContextLoggerFromRequest(r)
 	registry := prometheus.NewRegistry()
 
@@ -30,7 +30,7 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
 	defer cancel()
 	r = r.WithContext(ctx)
 
@@ -41,31 +41,103 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	format, err := getProbeFormat(r)
+	if err != nil {
 		contextLogger.Warnln(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	resourceType, err := paramsGetRequired(r.URL.Query(), "resourceType")
+	// resourcegraph probes can query many resources, don't let them starve other probes of the ARM quota
+	if r.URL.Query().Get("priority") == "" {
+		settings.Priority = metrics.ProbePriorityLow
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
 	if err != nil {
 		contextLogger.Warnln(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	resourceTagManager, err := getResourceTagManager(settings.TagLabels)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	// named query (config file queries), raw Kusto filters can be restricted
+	if settings.Query != "" {
+		queryResourceType, filter, err := resourceGraphQueries.Load().Render(settings.Query, settings.QueryParams)
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		settings.Filter = filter
+		if settings.ResourceType == "" {
+			settings.ResourceType = queryResourceType
+		}
+	} else if settings.Filter != "" && Opts.Azure.ResourceGraph.NamedQueriesOnly {
+		err := fmt.Errorf(`parameter "filter" is not allowed, only named queries (parameter "query") can be used`)
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// resource type can also be set by profile or named query
+	resourceType := settings.ResourceType
+	if resourceType == "" {
+		err := fmt.Errorf("parameter \"resourceType\" is missing")
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	prober := metrics.NewMetricProber(ctx, contextLogger, w, &settings, Opts)
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
-	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	prober.SetAzureCredential(azureCredential)
+	prober.SetMetricDefinitionCache(metricDefinitionCache)
+	prober.SetResourceTagCache(resourceTagCache)
+	prober.SetSubscriptionDiscovery(subscriptionDiscovery)
+	prober.SetWorkerPool(metricsWorkerPool)
+	prober.SetRateLimiter(metricsRateLimiter)
+	prober.SetMetricsBatchClients(metricsBatchClients)
+	prober.SetQuotaCoordinator(metricsQuota)
+	prober.SetRestrictions(probeRestrictions)
+	prober.SetAzureResourceTagManager(resourceTagManager)
 	prober.SetPrometheusRegistry(registry)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("scrape:%x", sha1.Sum([]byte(r.URL.String()))) // #nosec G401
+		cacheKey, err := metrics.ProbeCacheKey("resourcegraph", &settings)
+		if err != nil {
+			contextLogger.Errorln(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
 
-	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
-		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
+	resourceGraphCacheDuration := *Opts.Azure.ServiceDiscovery.CacheDuration
+	if settings.ResourceGraphCache != nil {
+		resourceGraphCacheDuration = *settings.ResourceGraphCache
+	}
+	if resourceGraphCacheDuration.Seconds() > 0 {
+		prober.EnableResourceGraphCache(resourceGraphCache, resourceGraphCacheDuration)
 	}
 
 	if !prober.FetchFromCache() {
@@ -76,13 +148,19 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
+		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string, duration time.Duration, err error) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
 				"handler":        config.ProbeMetricsListUrl,
 				"filter":         settings.Filter,
-			}).Observe(time.Since(startTime).Seconds())
+			}).Observe(duration.Seconds())
+			prometheusMetricRequests.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"handler":        config.ProbeMetricsListUrl,
+				"filter":         settings.Filter,
+				"result":         collectResult(err),
+			}).Inc()
 		})
 
 		prober.Run()
@@ -98,6 +176,5 @@ func probeMetricsResourceGraphHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	writeProbeResponse(w, r, format, probeGatherer(r, prober.Gatherer()))
 }
@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"crypto/sha1" // #nosec G505
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/azure-metrics-exporter/config"
 	"github.com/webdevops/azure-metrics-exporter/metrics"
@@ -20,6 +18,8 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 	var metricTagName, aggregationTagName string
 
 	startTime := time.Now()
+	memoryTracker := startProbeMemoryTracker(config.ProbeMetricsScrapeUrl)
+	defer memoryTracker.Stop()
 	contextLogger := buildContextLoggerFromRequest(r)
 	registry := prometheus.NewRegistry()
 
@@ -31,7 +31,7 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
 	defer cancel()
 	r = r.WithContext(ctx)
 
@@ -42,12 +42,39 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = paramsGetListRequired(r.URL.Query(), "subscription"); err != nil {
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
 		contextLogger.Warnln(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	resourceTagManager, err := getResourceTagManager(settings.TagLabels)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if metricTagName, err = paramsGetRequired(r.URL.Query(), "metricTagName"); err != nil {
 		contextLogger.Warnln(err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -62,29 +89,49 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 	prober := metrics.NewMetricProber(ctx, contextLogger, w, &settings, Opts)
 	prober.SetUserAgent(UserAgent + gitTag)
 	prober.SetAzureClient(AzureClient)
-	prober.SetAzureResourceTagManager(AzureResourceTagManager)
+	prober.SetAzureCredential(azureCredential)
+	prober.SetMetricDefinitionCache(metricDefinitionCache)
+	prober.SetResourceTagCache(resourceTagCache)
+	prober.SetSubscriptionDiscovery(subscriptionDiscovery)
+	prober.SetWorkerPool(metricsWorkerPool)
+	prober.SetRateLimiter(metricsRateLimiter)
+	prober.SetMetricsBatchClients(metricsBatchClients)
+	prober.SetQuotaCoordinator(metricsQuota)
+	prober.SetRestrictions(probeRestrictions)
+	prober.SetAzureResourceTagManager(resourceTagManager)
 	prober.SetPrometheusRegistry(registry)
 	if settings.Cache != nil {
-		cacheKey := fmt.Sprintf("scrape:%x", sha1.Sum([]byte(r.URL.String()))) // #nosec G401
+		cacheKey, err := metrics.ProbeCacheKey("scrape", &settings, "metricTagName="+metricTagName, "aggregationTagName="+aggregationTagName)
+		if err != nil {
+			contextLogger.Errorln(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		prober.EnableMetricsCache(metricsCache, cacheKey, settings.CacheDuration(startTime))
 	}
 
 	if Opts.Azure.ServiceDiscovery.CacheDuration.Seconds() > 0 {
-		prober.EnableServiceDiscoveryCache(azureCache, Opts.Azure.ServiceDiscovery.CacheDuration)
+		prober.EnableResourceInventory(resourceInventory, *Opts.Azure.ServiceDiscovery.CacheDuration)
 	}
 
 	if !prober.FetchFromCache() {
-		for _, subscription := range settings.Subscriptions {
-			prober.ServiceDiscovery.FindSubscriptionResourcesWithScrapeTags(ctx, subscription, settings.Filter, metricTagName, aggregationTagName)
-		}
+		prober.DiscoverSubscriptions(settings.Subscriptions, func(subscriptionId string) error {
+			return prober.ServiceDiscovery.FindSubscriptionResourcesWithScrapeTags(ctx, subscriptionId, settings.Filter, metricTagName, aggregationTagName)
+		})
 
-		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string) {
+		prober.RegisterSubscriptionCollectFinishCallback(func(subscriptionId string, duration time.Duration, err error) {
 			// global stats counter
 			prometheusCollectTime.With(prometheus.Labels{
 				"subscriptionID": subscriptionId,
 				"handler":        config.ProbeMetricsListUrl,
 				"filter":         settings.Filter,
-			}).Observe(time.Since(startTime).Seconds())
+			}).Observe(duration.Seconds())
+			prometheusMetricRequests.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"handler":        config.ProbeMetricsListUrl,
+				"filter":         settings.Filter,
+				"result":         collectResult(err),
+			}).Inc()
 		})
 
 		prober.Run()
@@ -100,6 +147,5 @@ func probeMetricsScrapeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	writeProbeResponse(w, r, format, probeGatherer(r, prober.Gatherer()))
 }
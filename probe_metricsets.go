@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// max metric sets (parameter metricSet) per probe request
+	ProbeMetricSetsMax = 10
+)
+
+var (
+	// parameters which can't be overridden by metric sets
+	probeMetricSetReservedParams = []string{"metricSet", "format", "prewarm", "prewarmInterval"}
+)
+
+type (
+	probeMetricSetResult struct {
+		families map[string]*dto.MetricFamily
+		err      error
+
+		// status code and Retry-After of the failed probe
+		statusCode int
+		retryAfter string
+	}
+)
+
+// metricSetsHandler collects multiple metric sets with one probe request (parameter metricSet, multiple times),
+// each metric set is a query string overriding the parameters of the probe (eg. metric=Percentage CPU&interval=PT1M),
+// the sets are collected concurrently and their results are merged into one response
+func metricSetsHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		metricSets := params["metricSet"]
+		if len(metricSets) == 0 {
+			next(w, r)
+			return
+		}
+
+		contextLogger := buildContextLoggerFromRequest(r)
+
+		format, err := getProbeFormat(r)
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		setRequests, err := buildMetricSetRequests(r, params, metricSets)
+		if err != nil {
+			contextLogger.Warnln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]probeMetricSetResult, len(setRequests))
+		wg := sync.WaitGroup{}
+		for num, setRequest := range setRequests {
+			wg.Add(1)
+			go func(num int, setRequest *http.Request) {
+				defer wg.Done()
+				results[num] = collectMetricSet(next, setRequest)
+			}(num, setRequest)
+		}
+		wg.Wait()
+
+		familyMap := map[string]*dto.MetricFamily{}
+		seriesKeys := map[string]bool{}
+		for num, result := range results {
+			if result.err != nil {
+				// status of the failed probe is passed through (eg. 400 invalid parameters, 502/503 Azure errors, 429)
+				err := fmt.Errorf(`metricSet %d ("%s"): %w`, num+1, metricSets[num], result.err)
+				contextLogger.Errorln(err)
+				if result.retryAfter != "" {
+					w.Header().Set("Retry-After", result.retryAfter)
+				}
+				http.Error(w, err.Error(), result.statusCode)
+				return
+			}
+			mergeMetricSetFamilies(familyMap, seriesKeys, result.families)
+		}
+
+		families := sortMetricFamilies(familyMap)
+		writeProbeResponse(w, r, format, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}))
+	}
+}
+
+// buildMetricSetRequests returns the probe requests of the metric sets (probe parameters overridden by the metric set)
+func buildMetricSetRequests(r *http.Request, params url.Values, metricSets []string) ([]*http.Request, error) {
+	if len(metricSets) > ProbeMetricSetsMax {
+		return nil, fmt.Errorf(`parameter "metricSet" is limited to %d metric sets per probe`, ProbeMetricSetsMax)
+	}
+
+	ret := []*http.Request{}
+	for num, metricSet := range metricSets {
+		setParams, err := url.ParseQuery(metricSet)
+		if err != nil {
+			return nil, fmt.Errorf(`metricSet %d ("%s") is not a valid query string: %w`, num+1, metricSet, err)
+		}
+
+		requestParams := url.Values{}
+		for name, values := range params {
+			requestParams[name] = values
+		}
+		for _, name := range probeMetricSetReservedParams {
+			if _, exists := setParams[name]; exists {
+				return nil, fmt.Errorf(`metricSet %d ("%s") can't set parameter "%s"`, num+1, metricSet, name)
+			}
+			requestParams.Del(name)
+		}
+		for name, values := range setParams {
+			requestParams[name] = values
+		}
+
+		setRequest := r.Clone(r.Context())
+		setRequest.URL.RawQuery = requestParams.Encode()
+		setRequest.RequestURI = setRequest.URL.RequestURI()
+		// results of metric sets are parsed as text exposition
+		setRequest.Header.Del("Accept")
+		setRequest.Header.Del("Accept-Encoding")
+		ret = append(ret, setRequest)
+	}
+
+	return ret, nil
+}
+
+// collectMetricSet executes the probe of the metric set and returns the parsed metrics, failed probes keep their
+// status code
+func collectMetricSet(handler http.HandlerFunc, r *http.Request) (ret probeMetricSetResult) {
+	w := newJobResponseWriter()
+	handler(w, r)
+	if w.statusCode != 0 && w.statusCode != http.StatusOK {
+		ret.err = fmt.Errorf("probe failed with status %d: %s", w.statusCode, strings.TrimSpace(w.body.String()))
+		ret.statusCode = w.statusCode
+		ret.retryAfter = w.Header().Get("Retry-After")
+		return
+	}
+
+	parser := expfmt.TextParser{}
+	familyMap, err := parser.TextToMetricFamilies(&w.body)
+	if err != nil {
+		ret.err = fmt.Errorf("unable to parse probe result: %w", err)
+		ret.statusCode = http.StatusInternalServerError
+		return
+	}
+	ret.families = familyMap
+	return
+}
+
+// mergeMetricSetFamilies adds the metrics of a metric set, series already collected by a previous metric set
+// (eg. the same metric in multiple sets) are skipped
+func mergeMetricSetFamilies(familyMap map[string]*dto.MetricFamily, seriesKeys map[string]bool, families map[string]*dto.MetricFamily) {
+	for name, family := range families {
+		existing, exists := familyMap[name]
+		if !exists {
+			existing = &dto.MetricFamily{
+				Name: family.Name,
+				Help: family.Help,
+				Type: family.Type,
+			}
+			familyMap[name] = existing
+		} else if existing.GetType() != family.GetType() {
+			continue
+		}
+
+		for _, metric := range family.Metric {
+			labels := []string{}
+			for _, label := range metric.Label {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			sort.Strings(labels)
+
+			seriesKey := name + "\xff" + strings.Join(labels, "\xff")
+			if seriesKeys[seriesKey] {
+				continue
+			}
+			seriesKeys[seriesKey] = true
+			existing.Metric = append(existing.Metric, metric)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/utils/to"
+)
+
+const (
+	// probes exceeding --metrics.max-series-per-probe fail
+	ProbeSeriesLimitAbort = "abort"
+
+	// probes exceeding --metrics.max-series-per-probe are truncated (azurerm_probe_series_dropped)
+	ProbeSeriesLimitTruncate = "truncate"
+)
+
+var (
+	prometheusProbeSeriesLimited *prometheus.CounterVec
+)
+
+func initProbeSeriesLimitMetrics() {
+	prometheusProbeSeriesLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_probe_series_limited",
+			Help: "Azure metrics exporter probes exceeding --metrics.max-series-per-probe (aborted or truncated)",
+		},
+		[]string{
+			"handler",
+			"mode",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeSeriesLimited)
+}
+
+// seriesLimitGatherer limits the series of the probe response (--metrics.max-series-per-probe), probes with more
+// series fail (abort) or the series beyond the limit are dropped and counted in azurerm_probe_series_dropped (truncate),
+// protecting Prometheus from dimension explosions
+func seriesLimitGatherer(handler string, gatherer prometheus.Gatherer) prometheus.Gatherer {
+	maxSeries := Opts.Metrics.MaxSeriesPerProbe
+	if maxSeries <= 0 {
+		return gatherer
+	}
+
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return families, err
+		}
+
+		seriesCount := 0
+		for _, family := range families {
+			seriesCount += len(family.Metric)
+		}
+		if seriesCount <= maxSeries {
+			return families, nil
+		}
+
+		prometheusProbeSeriesLimited.WithLabelValues(handler, Opts.Metrics.MaxSeriesPerProbeMode).Inc()
+		if Opts.Metrics.MaxSeriesPerProbeMode != ProbeSeriesLimitTruncate {
+			return nil, fmt.Errorf("probe exceeded the series limit (%d series, --metrics.max-series-per-probe=%d)", seriesCount, maxSeries)
+		}
+
+		ret := []*dto.MetricFamily{}
+		remaining := maxSeries
+		for _, family := range families {
+			if remaining <= 0 {
+				break
+			}
+			if len(family.Metric) > remaining {
+				family.Metric = family.Metric[:remaining]
+			}
+			remaining -= len(family.Metric)
+			ret = append(ret, family)
+		}
+
+		ret = append(ret, &dto.MetricFamily{
+			Name: to.StringPtr("azurerm_probe_series_dropped"),
+			Help: to.StringPtr("Azure metrics exporter series of the probe dropped by --metrics.max-series-per-probe"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{Gauge: &dto.Gauge{Value: to.Float64Ptr(float64(seriesCount - maxSeries))}},
+			},
+		})
+		return ret, nil
+	})
+}
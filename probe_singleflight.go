@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// ProbeSingleFlight coalesces concurrent identical probe requests (eg. multiple Prometheus replicas),
+	// only the first request collects the metrics and all waiting requests get the same response
+	// (no golang.org/x/sync/singleflight: the response of the leading request is streamed to its client while being
+	// recorded and failed flights are retried by the waiting requests)
+	ProbeSingleFlight struct {
+		lock    sync.Mutex
+		flights map[string]*probeFlight
+	}
+
+	probeFlight struct {
+		done chan struct{}
+
+		// leading probe failed without response, waiting requests run the probe themselves
+		failed bool
+
+		header     http.Header
+		body       bytes.Buffer
+		statusCode int
+	}
+
+	// probeFlightResponseWriter passes the response of the leading request to the client and records it for waiting requests
+	probeFlightResponseWriter struct {
+		http.ResponseWriter
+		flight *probeFlight
+	}
+)
+
+var (
+	probeSingleFlight = &ProbeSingleFlight{flights: map[string]*probeFlight{}}
+
+	prometheusProbeCoalesced *prometheus.CounterVec
+)
+
+func initProbeSingleFlightMetrics() {
+	prometheusProbeCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_probe_coalesced",
+			Help: "Azure metrics exporter probe requests served from a concurrent identical probe",
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeCoalesced)
+}
+
+// Handler runs concurrent probe requests with the same parameters only once, if the leading probe fails without
+// response (panic, canceled by its client) the waiting requests run the probe themselves
+func (s *ProbeSingleFlight) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := probeFlightKey(r)
+
+		for {
+			s.lock.Lock()
+			flight, exists := s.flights[key]
+			if !exists {
+				flight = &probeFlight{
+					done: make(chan struct{}),
+				}
+				s.flights[key] = flight
+				s.lock.Unlock()
+
+				s.lead(key, flight, next, w, r)
+				return
+			}
+			s.lock.Unlock()
+
+			select {
+			case <-flight.done:
+			case <-r.Context().Done():
+				return
+			}
+
+			if flight.failed {
+				// retry as leading request (or wait for the next leading request)
+				continue
+			}
+
+			prometheusProbeCoalesced.WithLabelValues(r.URL.Path).Inc()
+			flight.serve(w)
+			return
+		}
+	}
+}
+
+// lead runs the probe of the flight and records the response for the waiting requests
+func (s *ProbeSingleFlight) lead(key string, flight *probeFlight, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	finished := false
+	defer func() {
+		s.lock.Lock()
+		delete(s.flights, key)
+		s.lock.Unlock()
+
+		// panics, canceled probes and probes without response are not shared
+		if !finished || flight.statusCode == 0 || r.Context().Err() != nil {
+			flight.failed = true
+		}
+		close(flight.done)
+	}()
+
+	next(&probeFlightResponseWriter{ResponseWriter: w, flight: flight}, r)
+	finished = true
+}
+
+// serve writes the recorded response of the leading request
+func (f *probeFlight) serve(w http.ResponseWriter) {
+	for name, values := range f.header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set("X-probe-coalesced", "true")
+	w.WriteHeader(f.statusCode)
+	if _, err := w.Write(f.body.Bytes()); err != nil {
+		logger.Error(err)
+	}
+}
+
+func (w *probeFlightResponseWriter) WriteHeader(statusCode int) {
+	if w.flight.statusCode == 0 {
+		w.flight.statusCode = statusCode
+		w.flight.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *probeFlightResponseWriter) Write(b []byte) (int, error) {
+	if w.flight.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.flight.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original response writer (http.ResponseController, eg. flushing of probe responses)
+func (w *probeFlightResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// probeFlightKey returns the normalized probe parameters (sorted query), responses differ with the negotiated
+// format (Accept) and gzip encoding. The scrape timeout is part of the key, so requests with a shorter timeout don't
+// wait for a flight running longer than their own deadline
+func probeFlightKey(r *http.Request) string {
+	key := r.URL.Path + "?" + r.URL.Query().Encode() + "|" + r.Header.Get("Accept")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		key += "|gzip"
+	}
+
+	timeout := strings.TrimSpace(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"))
+	if val, err := strconv.ParseFloat(timeout, 64); err == nil {
+		timeout = strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	key += "|timeout=" + timeout
+
+	return key
+}
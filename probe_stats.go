@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+var (
+	prometheusProbeDuration    *prometheus.HistogramVec
+	prometheusProbeInFlight    *prometheus.GaugeVec
+	prometheusProbeApiRequests *prometheus.HistogramVec
+)
+
+func initProbeStatsMetrics() {
+	prometheusProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_stats_probe_duration_seconds",
+			Help:    "Azure metrics exporter duration of probe requests per handler with result (success, error)",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{
+			"handler",
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeDuration)
+
+	prometheusProbeInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_probe_inflight",
+			Help: "Azure metrics exporter probe requests currently handled (including queued requests) per handler",
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeInFlight)
+
+	prometheusProbeApiRequests = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "azurerm_stats_probe_api_requests",
+			Help:    "Azure metrics exporter outbound Azure API requests (including retries) per probe request and handler",
+			Buckets: []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeApiRequests)
+}
+
+// probeStatsHandler records duration, in-flight requests and Azure API requests of probe requests per handler,
+// only registered probe handlers are recorded (unknown urls would create a series per url)
+func probeStatsHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, probeUrlPrefix) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		_, handler := mux.Handler(r)
+		if handler == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		startTime := time.Now()
+		prometheusProbeInFlight.WithLabelValues(handler).Inc()
+		defer prometheusProbeInFlight.WithLabelValues(handler).Dec()
+
+		// Azure API requests of the probe are counted by the http transport of the Azure clients
+		ctx, apiRequests := metrics.WithApiRequestCounter(r.Context())
+		statusWriter := &probeStatusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		mux.ServeHTTP(statusWriter, r.WithContext(ctx))
+
+		result := "success"
+		if statusWriter.statusCode >= http.StatusBadRequest {
+			result = "error"
+		}
+		prometheusProbeDuration.WithLabelValues(handler, result).Observe(time.Since(startTime).Seconds())
+		prometheusProbeApiRequests.WithLabelValues(handler).Observe(float64(apiRequests.Load()))
+	})
+}
+
+type (
+	// probeStatusWriter records the status code of the probe response
+	probeStatusWriter struct {
+		http.ResponseWriter
+		statusCode int
+	}
+)
+
+func (w *probeStatusWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush supports streamed probe responses
+func (w *probeStatusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the original response writer (http.ResponseController)
+func (w *probeStatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	runtimemetrics "runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+const (
+	// output formats of probes (parameter format)
+	ProbeFormatPrometheus = "prometheus"
+	ProbeFormatJson       = "json"
+
+	// response is flushed to the client after every chunk, so the exposition isn't buffered completely
+	ProbeResponseFlushSize = 64 * 1024
+
+	probeMemorySampleInterval = 100 * time.Millisecond
+	probeMemoryRuntimeMetric  = "/memory/classes/heap/objects:bytes"
+)
+
+type (
+	// probeMemoryTracker samples the heap during a probe, the peak above the heap at probe start
+	// is an estimate of the probe memory (concurrent probes are included)
+	probeMemoryTracker struct {
+		handler string
+		start   uint64
+		peak    uint64
+
+		stop chan struct{}
+		wg   sync.WaitGroup
+	}
+
+	// flushWriter flushes the response after ProbeResponseFlushSize bytes
+	flushWriter struct {
+		writer     io.Writer
+		controller *http.ResponseController
+		flush      func() error
+		unflushed  int
+	}
+
+	// ProbeJsonMetric is a metric family of the json probe output (format=json)
+	ProbeJsonMetric struct {
+		Name   string            `json:"name"`
+		Type   string            `json:"type"`
+		Help   string            `json:"help"`
+		Series []ProbeJsonSeries `json:"series"`
+	}
+
+	// ProbeJsonSeries is one series of a metric family, resourceID, metric and aggregation are copied from the labels,
+	// value is null for NaN values (eg. missing datapoints)
+	ProbeJsonSeries struct {
+		ResourceId  string            `json:"resourceID,omitempty"`
+		Metric      string            `json:"metric,omitempty"`
+		Aggregation string            `json:"aggregation,omitempty"`
+		Labels      map[string]string `json:"labels"`
+		Value       *float64          `json:"value"`
+		Count       *uint64           `json:"count,omitempty"`
+		Timestamp   *time.Time        `json:"timestamp,omitempty"`
+	}
+)
+
+var (
+	prometheusProbeMemoryPeak *prometheus.GaugeVec
+)
+
+func initProbeMetrics() {
+	prometheusProbeMemoryPeak = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azurerm_stats_probe_memory_peak",
+			Help: "Azure metrics exporter estimated peak heap memory (bytes) of the last probe per handler",
+		},
+		[]string{
+			"handler",
+		},
+	)
+	prometheus.MustRegister(prometheusProbeMemoryPeak)
+}
+
+// startProbeMemoryTracker starts sampling the heap for the probe, Stop must be called after the response was written
+func startProbeMemoryTracker(handler string) *probeMemoryTracker {
+	t := &probeMemoryTracker{
+		handler: handler,
+		stop:    make(chan struct{}),
+	}
+	t.start = readHeapObjectBytes()
+	t.peak = t.start
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(probeMemorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.sample()
+			}
+		}
+	}()
+
+	return t
+}
+
+func (t *probeMemoryTracker) sample() {
+	if val := readHeapObjectBytes(); val > t.peak {
+		t.peak = val
+	}
+}
+
+// Stop stops sampling and publishes the peak (azurerm_stats_probe_memory_peak)
+func (t *probeMemoryTracker) Stop() {
+	close(t.stop)
+	t.wg.Wait()
+	t.sample()
+
+	peak := float64(0)
+	if t.peak > t.start {
+		peak = float64(t.peak - t.start)
+	}
+	prometheusProbeMemoryPeak.WithLabelValues(t.handler).Set(peak)
+}
+
+func readHeapObjectBytes() uint64 {
+	sample := []runtimemetrics.Sample{{Name: probeMemoryRuntimeMetric}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() != runtimemetrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// getProbeFormat returns the output format of the probe (parameter format)
+func getProbeFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(paramsGetWithDefault(r.URL.Query(), "format", ProbeFormatPrometheus))
+	if format != ProbeFormatPrometheus && format != ProbeFormatJson {
+		return "", fmt.Errorf(`parameter "format" must be "%s" or "%s"`, ProbeFormatPrometheus, ProbeFormatJson)
+	}
+	return format, nil
+}
+
+// writeProbeResponse writes the metric families of the gatherer incrementally, families are released after they
+// are encoded and the response is flushed in chunks, so the complete exposition isn't buffered in memory
+func writeProbeResponse(w http.ResponseWriter, r *http.Request, format string, gatherer prometheus.Gatherer) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		contextLogger.Error(err)
+		http.Error(w, "error gathering metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := expfmt.Negotiate(r.Header)
+	if format == ProbeFormatJson {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", string(contentType))
+	}
+
+	var output io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if err := gz.Close(); err != nil {
+				contextLogger.Debug(err)
+			}
+		}()
+		output = gz
+	}
+
+	writer := &flushWriter{writer: output, controller: http.NewResponseController(w)}
+	if gz, ok := output.(*gzip.Writer); ok {
+		writer.flush = gz.Flush
+	}
+
+	if format == ProbeFormatJson {
+		writeProbeJson(writer, families, contextLogger)
+		return
+	}
+
+	encoder := expfmt.NewEncoder(writer, contentType)
+	for i, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			// client is gone or response is broken, headers are already sent
+			contextLogger.Debugf("error encoding metric family %s: %v", family.GetName(), err)
+			return
+		}
+		families[i] = nil
+	}
+
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			contextLogger.Debug(err)
+		}
+	}
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	size, err := w.writer.Write(b)
+	w.unflushed += size
+	if err == nil && w.unflushed >= ProbeResponseFlushSize {
+		w.unflushed = 0
+		if w.flush != nil {
+			if err := w.flush(); err != nil {
+				return size, err
+			}
+		}
+		// response writers without flush support are buffered by net/http
+		_ = w.controller.Flush()
+	}
+	return size, err
+}
+
+// writeProbeJson writes the metric families as json array, families are encoded one after another
+func writeProbeJson(w io.Writer, families []*dto.MetricFamily, contextLogger *zap.SugaredLogger) {
+	encoder := json.NewEncoder(w)
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		contextLogger.Debug(err)
+		return
+	}
+	for i, family := range families {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				contextLogger.Debug(err)
+				return
+			}
+		}
+
+		if err := encoder.Encode(probeJsonMetric(family)); err != nil {
+			contextLogger.Debugf("error encoding metric family %s: %v", family.GetName(), err)
+			return
+		}
+		families[i] = nil
+	}
+	if _, err := io.WriteString(w, "]\n"); err != nil {
+		contextLogger.Debug(err)
+	}
+}
+
+func probeJsonMetric(family *dto.MetricFamily) ProbeJsonMetric {
+	ret := ProbeJsonMetric{
+		Name:   family.GetName(),
+		Type:   strings.ToLower(family.GetType().String()),
+		Help:   family.GetHelp(),
+		Series: make([]ProbeJsonSeries, 0, len(family.GetMetric())),
+	}
+
+	for _, metric := range family.GetMetric() {
+		series := ProbeJsonSeries{
+			Labels: map[string]string{},
+		}
+		for _, label := range metric.GetLabel() {
+			series.Labels[label.GetName()] = label.GetValue()
+		}
+		series.ResourceId = series.Labels["resourceID"]
+		series.Metric = series.Labels["metric"]
+		series.Aggregation = series.Labels["aggregation"]
+
+		var value float64
+		switch {
+		case metric.Gauge != nil:
+			value = metric.GetGauge().GetValue()
+		case metric.Counter != nil:
+			value = metric.GetCounter().GetValue()
+		case metric.Untyped != nil:
+			value = metric.GetUntyped().GetValue()
+		case metric.Summary != nil:
+			value = metric.GetSummary().GetSampleSum()
+			count := metric.GetSummary().GetSampleCount()
+			series.Count = &count
+		case metric.Histogram != nil:
+			value = metric.GetHistogram().GetSampleSum()
+			count := metric.GetHistogram().GetSampleCount()
+			series.Count = &count
+		}
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			series.Value = &value
+		}
+
+		if metric.TimestampMs != nil {
+			timestamp := time.UnixMilli(metric.GetTimestampMs()).UTC()
+			series.Timestamp = &timestamp
+		}
+
+		ret.Series = append(ret.Series, series)
+	}
+
+	return ret
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/remeh/sizedwaitgroup"
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// subscriptionProbe is the common flow of probes collecting Azure results per subscription (advisor, quota,
+	// alerts, policy, ...): cached results, failed subscriptions are recorded as probe errors and skipped,
+	// failed Azure requests are answered with 502/503 instead of failing with 400
+	subscriptionProbe struct {
+		w             http.ResponseWriter
+		r             *http.Request
+		logger        *zap.SugaredLogger
+		handler       string
+		startTime     time.Time
+		cacheKey      string
+		cacheDuration *time.Duration
+		reportErrors  bool
+
+		errors metrics.ProbeErrorList
+	}
+)
+
+// newSubscriptionProbe creates the probe flow of the handler (url), the cache key is built from the settings
+func newSubscriptionProbe(w http.ResponseWriter, r *http.Request, contextLogger *zap.SugaredLogger, handler string, startTime time.Time, cacheKey string, cacheDuration *time.Duration) (*subscriptionProbe, error) {
+	reportErrors, err := metrics.ParamReportErrors(r.URL.Query(), Opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriptionProbe{
+		w:             w,
+		r:             r,
+		logger:        contextLogger,
+		handler:       handler,
+		startTime:     startTime,
+		cacheKey:      cacheKey,
+		cacheDuration: cacheDuration,
+		reportErrors:  reportErrors,
+	}, nil
+}
+
+// fromCache loads the cached results of the probe
+func (p *subscriptionProbe) fromCache(results interface{}) bool {
+	if p.cacheDuration == nil {
+		return false
+	}
+
+	cacheData, cached := metricsCache.Get(p.cacheKey)
+	if !cached || json.Unmarshal(cacheData, results) != nil {
+		return false
+	}
+
+	p.w.Header().Add("X-metrics-cached", "true")
+	return true
+}
+
+// finish caches the results (partial results are not cached) and records the collect time of the probe
+func (p *subscriptionProbe) finish(results interface{}) {
+	if p.cacheDuration != nil && p.errors.Count() == 0 {
+		if cacheData, err := json.Marshal(results); err == nil {
+			metricsCache.Set(p.cacheKey, cacheData, *p.cacheDuration)
+		}
+	}
+
+	prometheusCollectTime.With(prometheus.Labels{
+		"subscriptionID": "",
+		"handler":        p.handler,
+		"filter":         "",
+	}).Observe(time.Since(p.startTime).Seconds())
+}
+
+// reportError records a failed request for the resource (or subscription scope) of the probe
+func (p *subscriptionProbe) reportError(resourceId string, err error) {
+	p.logger.With(zap.String("resourceID", resourceId)).Errorln(err)
+	p.errors.Report(resourceId, err)
+}
+
+// fail answers the probe with the error, failed Azure requests are 502 (503 for timeouts and throttling)
+func (p *subscriptionProbe) fail(err error) {
+	p.logger.Errorln(err)
+	http.Error(p.w, err.Error(), metrics.AzureErrorStatusCode(err))
+}
+
+// failInternal answers the probe with the error of the exporter (eg. Azure client can't be created)
+func (p *subscriptionProbe) failInternal(err error) {
+	p.logger.Errorln(err)
+	http.Error(p.w, err.Error(), http.StatusInternalServerError)
+}
+
+// publishErrors publishes the failed requests (azurerm_probe_success, azurerm_resource_scrape_error) if error
+// reporting is enabled (reportErrors parameter, --metrics.report-errors)
+func (p *subscriptionProbe) publishErrors(registry *prometheus.Registry) {
+	if p.reportErrors {
+		p.errors.Publish(registry, p.w)
+	}
+}
+
+// collectSubscriptionResults collects the result of each subscription in parallel (--concurrency.subscription),
+// failed subscriptions are reported and skipped, an error is only returned if all subscriptions failed
+func collectSubscriptionResults[T any](p *subscriptionProbe, subscriptions []string, collect func(ctx context.Context, subscriptionId string) (*T, error)) (map[string]*T, error) {
+	lock := sync.Mutex{}
+	results := map[string]*T{}
+	errs := []error{}
+
+	wg := sizedwaitgroup.New(Opts.Prober.ConcurrencySubscription)
+	for _, subscriptionId := range subscriptions {
+		wg.Add()
+		go func(subscriptionId string) {
+			defer wg.Done()
+
+			result, err := func() (result *T, err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = fmt.Errorf("panic: %v", r)
+					}
+				}()
+				return collect(p.r.Context(), subscriptionId)
+			}()
+
+			prometheusMetricRequests.With(prometheus.Labels{
+				"subscriptionID": subscriptionId,
+				"handler":        p.handler,
+				"filter":         "",
+				"result":         collectResult(err),
+			}).Inc()
+
+			if err != nil {
+				p.reportError("/subscriptions/"+subscriptionId, err)
+
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+				return
+			}
+
+			lock.Lock()
+			results[subscriptionId] = result
+			lock.Unlock()
+		}(subscriptionId)
+	}
+	wg.Wait()
+
+	if len(subscriptions) > 0 && len(errs) == len(subscriptions) {
+		return nil, errors.Join(errs...)
+	}
+	return results, nil
+}
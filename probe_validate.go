@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+type (
+	// ValidateResponse is the json report of /probe/validate
+	ValidateResponse struct {
+		Valid        bool                        `json:"valid"`
+		ResourceId   string                      `json:"resourceId,omitempty"`
+		ResourceType string                      `json:"resourceType,omitempty"`
+		Problems     []metrics.ValidationProblem `json:"problems"`
+	}
+)
+
+// probeValidateHandler validates the probe parameters (metrics, aggregations, timespan, interval and filters)
+// against the metric definitions of the resource type without fetching metric data
+func probeValidateHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeValidateTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	response := ValidateResponse{
+		Problems: []metrics.ValidationProblem{},
+	}
+	defer func() {
+		response.Valid = len(response.Problems) == 0
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			contextLogger.Error(err)
+		}
+	}()
+
+	addProblem := func(parameter, value string, err error) {
+		response.Problems = append(response.Problems, metrics.ValidationProblem{
+			Parameter: parameter,
+			Value:     value,
+			Message:   err.Error(),
+		})
+	}
+
+	settings, err := metrics.NewRequestMetricSettings(r, Opts)
+	if err != nil {
+		addProblem("", "", err)
+		return
+	}
+	response.Problems = append(response.Problems, metrics.ValidateMetricSettings(&settings)...)
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		addProblem("credential", settings.Credential, err)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		addProblem("subscription", "", err)
+		return
+	}
+
+	if err = probeRestrictions.CheckSettings(&settings); err != nil {
+		addProblem("subscription", "", err)
+		return
+	}
+
+	resourceId, err := findSampleResourceId(ctx, w, r, contextLogger, azureCredential, &settings)
+	var restrictedErr *metrics.ProbeRestrictedError
+	switch {
+	case errors.As(err, &restrictedErr):
+		addProblem("target", r.URL.Query().Get("target"), err)
+		return
+	case errors.Is(err, errSampleResourceMissing):
+		addProblem("resourceType", "", err)
+		return
+	case errors.Is(err, errSampleResourceNotFound):
+		addProblem("resourceType", settings.ResourceType, err)
+		return
+	case err != nil:
+		// ResourceGraph query with filter failed
+		addProblem("filter", settings.Filter, err)
+		return
+	}
+	response.ResourceId = resourceId
+	response.ResourceType = metrics.ResourceTypeFromResourceId(resourceId)
+
+	definitionList, err := metricDefinitionCache.Get(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), resourceId, settings.MetricNamespace)
+	if err != nil {
+		if settings.MetricNamespace != "" {
+			addProblem("metricNamespace", settings.MetricNamespace, err)
+		} else {
+			addProblem("target", resourceId, err)
+		}
+		return
+	}
+	response.Problems = append(response.Problems, metrics.ValidateMetricDefinitions(&settings, definitionList)...)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+func probeVmScheduledEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var timeoutSeconds float64
+
+	startTime := time.Now()
+	contextLogger := buildContextLoggerFromRequest(r)
+	registry := prometheus.NewRegistry()
+
+	// If a timeout is configured via the Prometheus header, add it to the request.
+	timeoutSeconds, err = getPrometheusTimeout(r, config.ProbeVmScheduledEventsTimeoutDefault)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, fmt.Sprintf("failed to parse timeout from Prometheus header: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds*float64(time.Second)))
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.ScheduledEventsSettings
+	if settings, err = metrics.NewScheduledEventsSettings(r); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := getProbeFormat(r)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = probeRestrictions.CheckSubscriptions(settings.Subscriptions...); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	cacheKey, err := metrics.ProbeCacheKey("scheduledevents", &settings)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	probe, err := newSubscriptionProbe(w, r, contextLogger, config.ProbeVmScheduledEventsUrl, startTime, cacheKey, settings.Cache)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := metrics.ScheduledEventsResult{}
+	if !probe.fromCache(&result) {
+		if settings.Fleet {
+			// Spot virtual machines of the subscriptions (Resource Graph)
+			azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+			if err != nil {
+				contextLogger.Warnln(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			result.SpotVms, result.EvictionRates, err = metrics.ListSpotVms(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), settings.Subscriptions, Opts.Azure.ResourceGraph.MaxRows)
+			if err != nil {
+				probe.fail(err)
+				return
+			}
+		} else {
+			// virtual machine of the exporter (IMDS)
+			client := metrics.NewScheduledEventsClient(Opts.Azure.Imds.Endpoint)
+			if result.Instance, err = client.Instance(ctx); err != nil {
+				probe.fail(err)
+				return
+			}
+
+			if result.Events, err = client.ScheduledEvents(ctx); err != nil {
+				probe.fail(err)
+				return
+			}
+		}
+
+		probe.finish(result)
+	}
+
+	metrics.PublishScheduledEventsResult(registry, &result)
+	probe.publishErrors(registry)
+
+	writeProbeResponse(w, r, format, probeGatherer(r, registry))
+}
@@ -0,0 +1,157 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	RetryBackoffMin = 1 * time.Second
+	RetryBackoffMax = 30 * time.Second
+)
+
+type (
+	// Client sends payloads to push receivers (remote-write, OTLP) with retries of recoverable errors
+	Client struct {
+		name    string
+		url     string
+		headers http.Header
+		retries int
+
+		userAgent string
+
+		// token for the Authorization header of every request (eg. Azure AD token of the Azure Monitor workspace)
+		tokenSource TokenSource
+
+		// status codes which are retried
+		recoverable func(statusCode int) bool
+
+		httpClient *http.Client
+	}
+
+	// TokenSource returns the bearer token for push requests
+	TokenSource func(ctx context.Context) (string, error)
+
+	// RequestError is returned for failed push requests, recoverable errors will be retried
+	RequestError struct {
+		name        string
+		StatusCode  int
+		Message     string
+		Recoverable bool
+	}
+)
+
+// NewClient creates a client for the receiver url, recoverable returns if a failed request is retried
+func NewClient(name, url string, timeout time.Duration, retries int, recoverable func(statusCode int) bool) *Client {
+	client := Client{}
+	client.name = name
+	client.url = url
+	client.retries = retries
+	client.recoverable = recoverable
+	client.headers = http.Header{}
+	client.httpClient = &http.Client{
+		Timeout: timeout,
+	}
+	return &client
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.name, e.StatusCode, e.Message)
+}
+
+func (c *Client) SetUserAgent(value string) {
+	c.userAgent = value
+}
+
+func (c *Client) SetHeader(name, value string) {
+	c.headers.Set(name, value)
+}
+
+// SetTokenSource sets the bearer token source, the token is requested for every request (token sources cache tokens)
+func (c *Client) SetTokenSource(tokenSource TokenSource) {
+	c.tokenSource = tokenSource
+}
+
+// Post sends the payload with the content headers, recoverable errors are retried with exponential backoff
+func (c *Client) Post(ctx context.Context, payload []byte, contentHeaders http.Header) error {
+	backoff := RetryBackoffMin
+	for try := 0; ; try++ {
+		err := c.send(ctx, payload, contentHeaders)
+		if err == nil {
+			return nil
+		}
+
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) && !reqErr.Recoverable {
+			return err
+		}
+
+		if try >= c.retries {
+			return fmt.Errorf("giving up after %d retries: %w", try, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > RetryBackoffMax {
+			backoff = RetryBackoffMax
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, payload []byte, contentHeaders http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range c.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	for name, values := range contentHeaders {
+		req.Header.Del(name)
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.tokenSource != nil {
+		token, err := c.tokenSource(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to get %s token: %w", c.name, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &RequestError{
+		name:        c.name,
+		StatusCode:  resp.StatusCode,
+		Message:     string(bytes.TrimSpace(body)),
+		Recoverable: c.recoverable(resp.StatusCode),
+	}
+}
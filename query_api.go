@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+)
+
+const (
+	QueryApiSubscriptionsUrl = "/query/api/subscriptions"
+	QueryApiResourceTypesUrl = "/query/api/resourcetypes"
+
+	QueryApiTimeout = 60 * time.Second
+)
+
+// queryApiSubscriptionsHandler lists the subscriptions of the credential for the query webui
+func queryApiSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryApiTimeout)
+	defer cancel()
+
+	credential := r.URL.Query().Get("credential")
+	tenant := strings.ToLower(r.URL.Query().Get("tenant"))
+	azureCredential, err := getAzureCredential(credential, tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cacheKey := strings.ToLower(credential)
+	if tenant != "" {
+		cacheKey = "tenant:" + tenant
+	}
+
+	subscriptionList, err := subscriptionDiscovery.List(ctx, azureCredential, metrics.NewArmClientOptions(AzureClient), cacheKey)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), metrics.AzureErrorStatusCode(err))
+		return
+	}
+
+	writeQueryApiResponse(w, contextLogger, subscriptionList)
+}
+
+// queryApiResourceTypesHandler lists the resource types of the subscriptions for the query webui
+func queryApiResourceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryApiTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var settings metrics.RequestMetricSettings
+	if settings, err = metrics.NewRequestMetricSettings(r, Opts); err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	azureCredential, err := getAzureCredential(settings.Credential, settings.Tenant)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = discoverSubscriptions(ctx, azureCredential, &settings); err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), subscriptionDiscoveryStatusCode(err))
+		return
+	}
+
+	prober := metrics.NewMetricProber(ctx, contextLogger, w, &settings, Opts)
+	prober.SetUserAgent(UserAgent + gitTag)
+	prober.SetAzureClient(AzureClient)
+	prober.SetAzureCredential(azureCredential)
+
+	resourceTypeList, err := prober.ServiceDiscovery.ListResourceTypes(ctx, settings.Subscriptions)
+	if err != nil {
+		contextLogger.Errorln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeQueryApiResponse(w, contextLogger, resourceTypeList)
+}
+
+func writeQueryApiResponse(w http.ResponseWriter, contextLogger *zap.SugaredLogger, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		contextLogger.Error(err)
+	}
+}
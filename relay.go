@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/webdevops/azure-metrics-exporter/config"
+	"github.com/webdevops/azure-metrics-exporter/metrics"
+	"github.com/webdevops/azure-metrics-exporter/remotewrite"
+)
+
+const (
+	// max size of remote-write requests (compressed)
+	RelayMaxBodySize = 32 * 1024 * 1024
+)
+
+var (
+	customMetricsRelay *metrics.CustomMetricsRelay
+)
+
+// initRelay starts the Azure Monitor custom metrics relay (remote-write receiver and scraping of the relay target)
+func initRelay(mux *http.ServeMux) {
+	if Opts.Relay.ResourceId == "" {
+		return
+	}
+
+	if Opts.Relay.Region == "" {
+		logger.Fatal("--relay.region is required for the custom metrics relay")
+	}
+	if len(Opts.Relay.Match) == 0 {
+		logger.Warn("no metrics are published by the custom metrics relay, set --relay.match")
+	}
+
+	prometheus.MustRegister(metrics.PrometheusRelaySamples)
+	prometheus.MustRegister(metrics.PrometheusRelayRequests)
+
+	customMetricsRelay = metrics.NewCustomMetricsRelay(logger, AzureCredential, metrics.NewArmClientOptions(AzureClient).ClientOptions, Opts)
+	customMetricsRelay.Start(context.Background(), Opts.Relay.Interval)
+	logger.Infof(`publishing relayed metrics to "%s" (namespace %s)`, Opts.Relay.ResourceId, Opts.Relay.Namespace)
+
+	mux.HandleFunc(config.RelayRemoteWriteUrl, relayRemoteWriteHandler)
+
+	if Opts.Relay.Scrape.Url != "" {
+		logger.Infof(`scraping relay target "%s" (every %s)`, Opts.Relay.Scrape.Url, Opts.Relay.Scrape.Interval.String())
+		go relayScrapeLoop(Opts.Relay.Scrape.Url, Opts.Relay.Scrape.Interval, Opts.Relay.Scrape.Timeout)
+	}
+}
+
+// relayRemoteWriteHandler receives Prometheus remote-write requests (remote_write of Prometheus, Grafana Agent, ...)
+func relayRemoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, RelayMaxBodySize+1))
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > RelayMaxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	series, err := remotewrite.DecodeWriteRequest(body)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	customMetricsRelay.Add(series)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// relayScrapeLoop scrapes the relay target every interval
+func relayScrapeLoop(url string, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := relayScrape(url, timeout); err != nil {
+			logger.Warnf(`unable to scrape relay target "%s": %v`, url, err)
+		}
+		<-ticker.C
+	}
+}
+
+func relayScrape(url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	req.Header.Set("User-Agent", UserAgent+gitTag)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("scrape failed with status %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	familyMap, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(familyMap))
+	for _, family := range familyMap {
+		families = append(families, family)
+	}
+
+	customMetricsRelay.Add(remotewrite.FromMetricFamilies(families, nil, time.Now()))
+	return nil
+}
@@ -0,0 +1,50 @@
+package remotewrite
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+
+	"github.com/webdevops/azure-metrics-exporter/push"
+)
+
+type (
+	// Client sends series to a Prometheus remote-write receiver
+	Client struct {
+		*push.Client
+	}
+
+	// TokenSource returns the bearer token for remote-write requests
+	TokenSource = push.TokenSource
+)
+
+var (
+	remoteWriteHeaders = http.Header{
+		"Content-Encoding":                  {"snappy"},
+		"Content-Type":                      {"application/x-protobuf"},
+		"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+	}
+)
+
+func NewClient(url string, timeout time.Duration, retries int) *Client {
+	return &Client{
+		Client: push.NewClient("remote-write", url, timeout, retries, recoverableStatus),
+	}
+}
+
+// recoverableStatus returns if the remote-write request is retried (5xx, 429)
+func recoverableStatus(statusCode int) bool {
+	return statusCode/100 == 5 || statusCode == http.StatusTooManyRequests
+}
+
+// Write sends the series to the remote-write receiver, recoverable errors (5xx, 429) are retried with exponential backoff
+func (c *Client) Write(ctx context.Context, series []TimeSeries) error {
+	if len(series) == 0 {
+		return nil
+	}
+
+	payload := s2.EncodeSnappy(nil, marshalWriteRequest(series))
+	return c.Post(ctx, payload, remoteWriteHeaders)
+}
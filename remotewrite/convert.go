@@ -0,0 +1,95 @@
+package remotewrite
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// FromMetricFamilies converts gauge, counter, untyped and summary metrics to remote-write series
+// (summaries as quantile, _sum and _count series), extraLabels are added if the series doesn't have the label already
+func FromMetricFamilies(families []*dto.MetricFamily, extraLabels map[string]string, timestamp time.Time) (series []TimeSeries) {
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			sampleTime := timestamp
+			if metric.TimestampMs != nil {
+				sampleTime = time.UnixMilli(metric.GetTimestampMs())
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				series = append(series, buildTimeSeries(family.GetName(), metric, nil, extraLabels, metric.GetGauge().GetValue(), sampleTime))
+			case dto.MetricType_COUNTER:
+				series = append(series, buildTimeSeries(family.GetName(), metric, nil, extraLabels, metric.GetCounter().GetValue(), sampleTime))
+			case dto.MetricType_UNTYPED:
+				series = append(series, buildTimeSeries(family.GetName(), metric, nil, extraLabels, metric.GetUntyped().GetValue(), sampleTime))
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					quantileLabel := Label{Name: "quantile", Value: strconv.FormatFloat(quantile.GetQuantile(), 'f', -1, 64)}
+					series = append(series, buildTimeSeries(family.GetName(), metric, &quantileLabel, extraLabels, quantile.GetValue(), sampleTime))
+				}
+				series = append(series, buildTimeSeries(family.GetName()+"_sum", metric, nil, extraLabels, summary.GetSampleSum(), sampleTime))
+				series = append(series, buildTimeSeries(family.GetName()+"_count", metric, nil, extraLabels, float64(summary.GetSampleCount()), sampleTime))
+			}
+		}
+	}
+
+	return
+}
+
+func buildTimeSeries(name string, metric *dto.Metric, additionalLabel *Label, extraLabels map[string]string, value float64, sampleTime time.Time) TimeSeries {
+	labels := []Label{{Name: "__name__", Value: name}}
+	existingLabels := map[string]bool{}
+	for _, label := range metric.GetLabel() {
+		labels = append(labels, Label{Name: label.GetName(), Value: label.GetValue()})
+		existingLabels[label.GetName()] = true
+	}
+	if additionalLabel != nil {
+		labels = append(labels, *additionalLabel)
+		existingLabels[additionalLabel.Name] = true
+	}
+	for labelName, labelValue := range extraLabels {
+		if !existingLabels[labelName] {
+			labels = append(labels, Label{Name: labelName, Value: labelValue})
+		}
+	}
+
+	// remote-write requires sorted labels
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	return TimeSeries{
+		Labels:  labels,
+		Samples: []Sample{{Value: value, Timestamp: sampleTime.UnixMilli()}},
+	}
+}
+
+// DecodeWriteRequest decodes a snappy compressed remote-write request body (prometheus.WriteRequest)
+func DecodeWriteRequest(body []byte) ([]TimeSeries, error) {
+	payload, err := s2.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress remote-write request: %w", err)
+	}
+
+	series, err := unmarshalWriteRequest(payload)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse remote-write request: %w", err)
+	}
+	return series, nil
+}
+
+// MetricName returns the metric name (__name__ label) of the series
+func (ts TimeSeries) MetricName() string {
+	for _, label := range ts.Labels {
+		if label.Name == "__name__" {
+			return label.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type (
+	// TimeSeries is the remote-write representation of one series (prometheus.WriteRequest)
+	TimeSeries struct {
+		Labels  []Label
+		Samples []Sample
+	}
+
+	Label struct {
+		Name  string
+		Value string
+	}
+
+	Sample struct {
+		Value     float64
+		Timestamp int64
+	}
+)
+
+// marshalWriteRequest encodes the series as prometheus.WriteRequest protobuf message
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var buf []byte
+	for _, ts := range series {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, marshalTimeSeries(ts))
+	}
+	return buf
+}
+
+func marshalTimeSeries(ts TimeSeries) []byte {
+	var buf []byte
+	for _, label := range ts.Labels {
+		var labelBuf []byte
+		labelBuf = protowire.AppendTag(labelBuf, 1, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, label.Name)
+		labelBuf = protowire.AppendTag(labelBuf, 2, protowire.BytesType)
+		labelBuf = protowire.AppendString(labelBuf, label.Value)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, labelBuf)
+	}
+
+	for _, sample := range ts.Samples {
+		var sampleBuf []byte
+		sampleBuf = protowire.AppendTag(sampleBuf, 1, protowire.Fixed64Type)
+		sampleBuf = protowire.AppendFixed64(sampleBuf, math.Float64bits(sample.Value))
+		sampleBuf = protowire.AppendTag(sampleBuf, 2, protowire.VarintType)
+		sampleBuf = protowire.AppendVarint(sampleBuf, uint64(sample.Timestamp)) // #nosec G115
+
+		buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, sampleBuf)
+	}
+	return buf
+}
+
+// unmarshalWriteRequest decodes the series of a prometheus.WriteRequest protobuf message,
+// metadata, exemplars and native histograms are skipped
+func unmarshalWriteRequest(buf []byte) (series []TimeSeries, err error) {
+	err = consumeFields(buf, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+
+		ts, err := unmarshalTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	return
+}
+
+func unmarshalTimeSeries(buf []byte) (ts TimeSeries, err error) {
+	err = consumeFields(buf, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case 1:
+			label := Label{}
+			err := consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					label.Name = string(value)
+				case 2:
+					label.Value = string(value)
+				}
+				return nil
+			})
+			ts.Labels = append(ts.Labels, label)
+			return err
+		case 2:
+			sample := Sample{}
+			err := consumeFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					val, _ := protowire.ConsumeFixed64(value)
+					sample.Value = math.Float64frombits(val)
+				case num == 2 && typ == protowire.VarintType:
+					val, _ := protowire.ConsumeVarint(value)
+					sample.Timestamp = int64(val) // #nosec G115
+				}
+				return nil
+			})
+			ts.Samples = append(ts.Samples, sample)
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// consumeFields calls fn for every field of the message, value contains the raw field value
+// (length-delimited fields without length prefix)
+func consumeFields(buf []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		buf = buf[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			val, m := protowire.ConsumeBytes(buf)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			value, n = val, m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, buf)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = buf[:n]
+		}
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
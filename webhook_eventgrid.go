@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	EventGridEventTypeValidation     = "Microsoft.EventGrid.SubscriptionValidationEvent"
+	EventGridEventTypeResourceWrite  = "Microsoft.Resources.ResourceWriteSuccess"
+	EventGridEventTypeResourceDelete = "Microsoft.Resources.ResourceDeleteSuccess"
+
+	// max size of Event Grid deliveries (batches up to 1 MB)
+	EventGridMaxBodySize = 1024 * 1024
+)
+
+type (
+	// eventGridEvent is an event of the Event Grid schema or the CloudEvents schema
+	eventGridEvent struct {
+		// Event Grid schema
+		EventType string `json:"eventType"`
+		Topic     string `json:"topic"`
+
+		// CloudEvents schema
+		Type   string `json:"type"`
+		Source string `json:"source"`
+
+		Subject string          `json:"subject"`
+		Data    json.RawMessage `json:"data"`
+	}
+
+	eventGridEventData struct {
+		ValidationCode string `json:"validationCode"`
+		SubscriptionId string `json:"subscriptionId"`
+		ResourceUri    string `json:"resourceUri"`
+	}
+)
+
+var (
+	eventGridSubscriptionRegexp = regexp.MustCompile(`(?i)/subscriptions/([^/]+)`)
+
+	prometheusEventGridEvents *prometheus.CounterVec
+)
+
+func initEventGridWebhookMetrics() {
+	prometheusEventGridEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azurerm_stats_eventgrid_events",
+			Help: "Azure metrics exporter received Event Grid events by result (invalidated, ignored)",
+		},
+		[]string{
+			"eventType",
+			"result",
+		},
+	)
+	prometheus.MustRegister(prometheusEventGridEvents)
+}
+
+// webhookEventGridHandler receives Azure Event Grid resource events (Event Grid or CloudEvents schema)
+// and invalidates the cached resource lists of the subscription
+func webhookEventGridHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	switch r.Method {
+	case http.MethodOptions:
+		// CloudEvents webhook validation
+		if origin := r.Header.Get("WebHook-Request-Origin"); origin != "" {
+			w.Header().Set("WebHook-Allowed-Origin", origin)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, EventGridMaxBodySize+1))
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > EventGridMaxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	eventList, err := parseEventGridEvents(body)
+	if err != nil {
+		contextLogger.Warnln(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range eventList {
+		eventType := event.EventType
+		if eventType == "" {
+			eventType = event.Type
+		}
+
+		data := eventGridEventData{}
+		if len(event.Data) > 0 {
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				contextLogger.Debugf(`unable to parse data of event "%s": %v`, eventType, err)
+			}
+		}
+
+		switch eventType {
+		case EventGridEventTypeValidation:
+			// Event Grid schema webhook validation
+			contextLogger.Infof("validating Event Grid subscription")
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(map[string]string{"validationResponse": data.ValidationCode}); err != nil {
+				contextLogger.Error(err)
+			}
+			return
+		case EventGridEventTypeResourceWrite, EventGridEventTypeResourceDelete:
+			subscriptionId := eventGridSubscriptionId(event, data)
+			if subscriptionId == "" {
+				prometheusEventGridEvents.WithLabelValues(eventType, "ignored").Inc()
+				continue
+			}
+
+			contextLogger.Debugf(`invalidating resources of subscription "%s" (%s %s)`, subscriptionId, eventType, data.ResourceUri)
+			resourceInvalidations.Invalidate(subscriptionId)
+			prometheusEventGridEvents.WithLabelValues(eventType, "invalidated").Inc()
+		default:
+			// other event types are not used as label (cardinality)
+			prometheusEventGridEvents.WithLabelValues("other", "ignored").Inc()
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseEventGridEvents parses a batch (Event Grid schema, CloudEvents batch) or a single event (CloudEvents)
+func parseEventGridEvents(body []byte) ([]eventGridEvent, error) {
+	body = bytes.TrimSpace(body)
+
+	var eventList []eventGridEvent
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &eventList); err != nil {
+			return nil, fmt.Errorf("unable to parse events: %w", err)
+		}
+		return eventList, nil
+	}
+
+	event := eventGridEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("unable to parse event: %w", err)
+	}
+	return append(eventList, event), nil
+}
+
+// eventGridSubscriptionId returns the subscription of the resource event (data, resource uri, subject or topic)
+func eventGridSubscriptionId(event eventGridEvent, data eventGridEventData) string {
+	if data.SubscriptionId != "" {
+		return strings.ToLower(data.SubscriptionId)
+	}
+
+	for _, val := range []string{data.ResourceUri, event.Subject, event.Topic, event.Source} {
+		if match := eventGridSubscriptionRegexp.FindStringSubmatch(val); match != nil {
+			return strings.ToLower(match[1])
+		}
+	}
+	return ""
+}